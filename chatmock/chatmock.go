@@ -0,0 +1,336 @@
+// Package chatmock is a scriptable stand-in for a chat server, for
+// testing clients deterministically. Each connection the server accepts
+// plays the next Script, a list of steps such as "expect a JOIN and reply
+// OK", "send this MSG" or "drop the connection":
+//
+//	srv := chatmock.New(t, chatmock.Script{
+//		chatmock.Join(),
+//		chatmock.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"}),
+//		chatmock.Expect(protocol.TypeSend),
+//		chatmock.Drop(),
+//	})
+//	c, err := client.New(srv.Addr(), "alice")
+//
+// A step that fails, such as Expect seeing the wrong message, fails the
+// test and closes the connection. Once a script runs out, the connection
+// stays open, discarding what the client sends, until the client hangs
+// up or the test ends. Connections beyond the last script are closed as
+// soon as they are accepted.
+package chatmock
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Script is what the server does with one connection, step by step.
+type Script []Step
+
+// Step is one action in a Script. It returns an error to fail the test,
+// or ErrStop to end the script quietly.
+type Step func(c *Conn) error
+
+// Server accepts connections on a loopback port and plays one Script to
+// each. It is shut down when the test ends.
+type Server struct {
+	t  testing.TB
+	ln net.Listener
+
+	mu      sync.Mutex
+	conns   []*Conn
+	closing bool
+	done    chan struct{} // closed when the test ends
+	wg      sync.WaitGroup
+}
+
+// New starts a server that plays scripts, in order, to the connections
+// it accepts.
+func New(t testing.TB, scripts ...Script) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("chatmock: failed to listen: %v", err)
+	}
+	s := &Server{t: t, ln: ln, done: make(chan struct{})}
+	t.Cleanup(s.close)
+
+	s.wg.Add(1)
+	go s.serve(scripts)
+	return s
+}
+
+// Addr returns the address clients should dial.
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+func (s *Server) serve(scripts []Script) {
+	defer s.wg.Done()
+	for n := 1; ; n++ {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		if n > len(scripts) {
+			nc.Close()
+			continue
+		}
+		c := &Conn{conn: nc, r: bufio.NewReader(nc), done: s.done}
+		s.mu.Lock()
+		if s.closing {
+			s.mu.Unlock()
+			nc.Close()
+			return
+		}
+		s.conns = append(s.conns, c)
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go s.play(n, c, scripts[n-1])
+	}
+}
+
+func (s *Server) play(n int, c *Conn, script Script) {
+	defer s.wg.Done()
+	defer c.Close()
+	for i, step := range script {
+		if err := step(c); err != nil {
+			if err != ErrStop && !s.isClosing() {
+				s.t.Errorf("chatmock: connection %d, step %d: %v", n, i+1, err)
+			}
+			return
+		}
+	}
+	for {
+		if _, err := c.ReadLine(); err != nil {
+			return
+		}
+	}
+}
+
+func (s *Server) isClosing() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closing
+}
+
+func (s *Server) close() {
+	s.mu.Lock()
+	s.closing = true
+	close(s.done)
+	s.ln.Close()
+	for _, c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Conn is the server's side of one connection, for use in Do steps.
+type Conn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	done <-chan struct{}
+}
+
+// ReadLine returns the next line from the client without its newline.
+func (c *Conn) ReadLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Receive returns the next message from the client.
+func (c *Conn) Receive() (protocol.Message, error) {
+	line, err := c.ReadLine()
+	if err != nil {
+		return protocol.Message{}, err
+	}
+	msg, err := protocol.Decode(line)
+	if err != nil {
+		return protocol.Message{}, fmt.Errorf("decoding %q: %w", line, err)
+	}
+	return msg, nil
+}
+
+// Send writes msg to the client.
+func (c *Conn) Send(msg protocol.Message) error {
+	return c.WriteLine(protocol.Encode(msg))
+}
+
+// WriteLine writes line, which needn't be a valid message, to the client.
+func (c *Conn) WriteLine(line string) error {
+	_, err := fmt.Fprintf(c.conn, "%s\n", line)
+	return err
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ErrStop ends a script early, closing the connection without failing
+// the test. Do steps can return it when the client hangs up as expected.
+var ErrStop = errors.New("chatmock: script stopped")
+
+// Join expects a JOIN from any username and replies OK.
+func Join() Step {
+	return func(c *Conn) error {
+		if _, err := expect(c, protocol.TypeJoin); err != nil {
+			return err
+		}
+		return c.Send(protocol.Message{Type: protocol.TypeOK})
+	}
+}
+
+// ExpectJoin expects a JOIN as username and replies OK.
+func ExpectJoin(username string) Step {
+	return func(c *Conn) error {
+		msg, err := expect(c, protocol.TypeJoin)
+		if err != nil {
+			return err
+		}
+		if msg.Username != username {
+			return fmt.Errorf("got JOIN as %q, want %q", msg.Username, username)
+		}
+		return c.Send(protocol.Message{Type: protocol.TypeOK})
+	}
+}
+
+// Expect expects the next message from the client to be of type typ.
+func Expect(typ string) Step {
+	return func(c *Conn) error {
+		_, err := expect(c, typ)
+		return err
+	}
+}
+
+// ExpectMessage expects the next message from the client to be want.
+func ExpectMessage(want protocol.Message) Step {
+	return func(c *Conn) error {
+		got, err := c.Receive()
+		if err != nil {
+			return fmt.Errorf("expecting %s: %w", want.Type, err)
+		}
+		if got != want {
+			return fmt.Errorf("got %+v, want %+v", got, want)
+		}
+		return nil
+	}
+}
+
+func expect(c *Conn, typ string) (protocol.Message, error) {
+	msg, err := c.Receive()
+	if err != nil {
+		return msg, fmt.Errorf("expecting %s: %w", typ, err)
+	}
+	if msg.Type != typ {
+		return msg, fmt.Errorf("got %s, want %s", msg.Type, typ)
+	}
+	return msg, nil
+}
+
+// Send sends msgs to the client.
+func Send(msgs ...protocol.Message) Step {
+	return func(c *Conn) error {
+		for _, msg := range msgs {
+			if err := c.Send(msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// SendLine sends line as is, e.g. to test how a client copes with
+// garbage.
+func SendLine(line string) Step {
+	return func(c *Conn) error {
+		return c.WriteLine(line)
+	}
+}
+
+// Drop closes the connection, ending the script.
+func Drop() Step {
+	return func(c *Conn) error {
+		return ErrStop
+	}
+}
+
+// Pause waits for d.
+func Pause(d time.Duration) Step {
+	return func(c *Conn) error {
+		select {
+		case <-time.After(d):
+			return nil
+		case <-c.done:
+			return ErrStop
+		}
+	}
+}
+
+// Wait waits until ch is closed or receives, so the test decides when the
+// script carries on.
+func Wait(ch <-chan struct{}) Step {
+	return func(c *Conn) error {
+		select {
+		case <-ch:
+			return nil
+		case <-c.done:
+			return ErrStop
+		}
+	}
+}
+
+// Lines sends every remaining line from the client to ch until the client
+// hangs up.
+func Lines(ch chan<- string) Step {
+	return func(c *Conn) error {
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return ErrStop
+			}
+			select {
+			case ch <- line:
+			case <-c.done:
+				return ErrStop
+			}
+		}
+	}
+}
+
+// Each calls fn with every remaining message from the client until the
+// client hangs up or fn returns an error. Lines that aren't valid
+// messages are skipped.
+func Each(fn func(c *Conn, msg protocol.Message) error) Step {
+	return func(c *Conn) error {
+		for {
+			line, err := c.ReadLine()
+			if err != nil {
+				return ErrStop
+			}
+			msg, err := protocol.Decode(line)
+			if err != nil {
+				continue
+			}
+			if err := fn(c, msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Do runs fn, for anything the other steps don't cover.
+func Do(fn func(c *Conn) error) Step {
+	return Step(fn)
+}
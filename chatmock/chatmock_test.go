@@ -0,0 +1,74 @@
+package chatmock_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestScripts(t *testing.T) {
+	got := make(chan string, 1)
+	srv := chatmock.New(t,
+		chatmock.Script{
+			chatmock.ExpectJoin("alice"),
+			chatmock.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"}),
+			chatmock.Drop(),
+		},
+		chatmock.Script{
+			chatmock.Join(),
+			chatmock.Expect(protocol.TypeSend),
+			chatmock.Lines(got),
+		},
+	)
+
+	dial := func() (net.Conn, *bufio.Scanner) {
+		t.Helper()
+		conn, err := net.Dial("tcp", srv.Addr())
+		if err != nil {
+			t.Fatalf("Dial() error = %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(2 * time.Second))
+		return conn, bufio.NewScanner(conn)
+	}
+	readAll := func(s *bufio.Scanner) []string {
+		var lines []string
+		for s.Scan() {
+			lines = append(lines, s.Text())
+		}
+		return lines
+	}
+
+	// The first connection is dropped after the MSG.
+	conn, s := dial()
+	fmt.Fprint(conn, "JOIN|alice\n")
+	if lines := readAll(s); len(lines) != 2 || lines[0] != "OK" || lines[1] != "MSG|bob|hi" {
+		t.Errorf("first connection got %q", lines)
+	}
+
+	// The second plays the next script.
+	conn, s = dial()
+	fmt.Fprint(conn, "JOIN|alice\nSEND|hello\nWHO\n")
+	if !s.Scan() || s.Text() != "OK" {
+		t.Errorf("second connection got %q, want OK", s.Text())
+	}
+	select {
+	case line := <-got:
+		if line != "WHO" {
+			t.Errorf("Lines got %q, want WHO", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for WHO")
+	}
+
+	// There's no script for a third.
+	_, s = dial()
+	if lines := readAll(s); len(lines) != 0 || s.Err() != nil {
+		t.Errorf("third connection got %q (%v), want it closed", lines, s.Err())
+	}
+}
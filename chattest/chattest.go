@@ -0,0 +1,195 @@
+// Package chattest runs chat servers and clients for integration tests,
+// such as tests of bots and bridges built on the client package.
+//
+//	srv := chattest.NewServer(t)
+//	alice := chattest.Join(t, srv.Addr().String(), "alice")
+//	alice.SendMessage("hello")
+//
+// Helpers fail the test with t.Fatal, so call them from the test's own
+// goroutine; Conn.Receive reports errors instead for use elsewhere.
+package chattest
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+	"github.com/pankaj/simple-chat/server"
+)
+
+// Timeout is how long helpers wait for a server to respond.
+const Timeout = 5 * time.Second
+
+// NewServer starts a server configured by opts on a free loopback port
+// and shuts it down when the test ends.
+func NewServer(t testing.TB, opts ...server.Option) *server.ChatServer {
+	t.Helper()
+	srv := server.New(opts...)
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	return srv
+}
+
+// NewClient connects a client.ChatClient as username and closes it when
+// the test ends.
+func NewClient(t testing.TB, addr, username string, opts ...client.Option) *client.ChatClient {
+	t.Helper()
+	c, err := client.New(addr, username, opts...)
+	if err != nil {
+		t.Fatalf("failed to join as %s: %v", username, err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// WaitFor reads from c until a message matches, failing the test if none
+// does within Timeout. Messages read along the way are discarded.
+func WaitFor(t testing.TB, c *client.ChatClient, match func(protocol.Message) bool) protocol.Message {
+	t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case msg, ok := <-c.Messages():
+			if !ok {
+				t.Fatal("client closed while waiting for a message")
+			}
+			if match(msg) {
+				return msg
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for a message")
+		}
+	}
+}
+
+// Msg matches a chat message with the given body, from username or from
+// anyone if username is empty.
+func Msg(username, body string) func(protocol.Message) bool {
+	return func(m protocol.Message) bool {
+		return m.Type == protocol.TypeMsg && m.Body == body && (username == "" || m.Username == username)
+	}
+}
+
+// Conn is a raw protocol connection, for tests that need to see or send
+// exactly what goes over the wire.
+type Conn struct {
+	t    testing.TB
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// Dial connects to addr without joining and closes the connection when
+// the test ends.
+func Dial(t testing.TB, addr string) *Conn {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, Timeout)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &Conn{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+// Join connects to addr and joins as username, failing the test unless
+// the server replies OK.
+func Join(t testing.TB, addr, username string) *Conn {
+	t.Helper()
+	c := Dial(t, addr)
+	c.Send(protocol.Message{Type: protocol.TypeJoin, Username: username})
+	if msg := c.Next(); msg.Type != protocol.TypeOK {
+		t.Fatalf("expected OK for %s, got %s: %s", username, msg.Type, msg.Body)
+	}
+	return c
+}
+
+// Send writes msg to the server.
+func (c *Conn) Send(msg protocol.Message) {
+	c.t.Helper()
+	if _, err := fmt.Fprintf(c.conn, "%s\n", protocol.Encode(msg)); err != nil {
+		c.t.Fatalf("failed to send %s: %v", msg.Type, err)
+	}
+}
+
+// SendMessage sends a chat message.
+func (c *Conn) SendMessage(body string) {
+	c.t.Helper()
+	c.Send(protocol.Message{Type: protocol.TypeSend, Body: body})
+}
+
+// Leave sends LEAVE.
+func (c *Conn) Leave() {
+	c.t.Helper()
+	c.Send(protocol.Message{Type: protocol.TypeLeave})
+}
+
+// ReadLine returns the next line from the server without its newline,
+// failing the test if none arrives within Timeout.
+func (c *Conn) ReadLine() string {
+	c.t.Helper()
+	line, err := c.readLine()
+	if err != nil {
+		c.t.Fatalf("failed to read line: %v", err)
+	}
+	return line
+}
+
+// Next returns the next message from the server, failing the test if
+// none arrives within Timeout or it can't be decoded.
+func (c *Conn) Next() protocol.Message {
+	c.t.Helper()
+	msg, err := c.Receive()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// Expect reads until a message of type typ arrives and returns it,
+// discarding others, and fails the test if none does within Timeout.
+func (c *Conn) Expect(typ string) protocol.Message {
+	c.t.Helper()
+	deadline := time.Now().Add(Timeout)
+	for time.Now().Before(deadline) {
+		if msg := c.Next(); msg.Type == typ {
+			return msg
+		}
+	}
+	c.t.Fatalf("timed out waiting for %s", typ)
+	return protocol.Message{}
+}
+
+// Receive is Next for use off the test's goroutine: it returns an error
+// rather than failing the test.
+func (c *Conn) Receive() (protocol.Message, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return protocol.Message{}, fmt.Errorf("failed to read line: %w", err)
+	}
+	msg, err := protocol.Decode(line)
+	if err != nil {
+		return protocol.Message{}, fmt.Errorf("failed to decode %q: %w", line, err)
+	}
+	return msg, nil
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *Conn) readLine() (string, error) {
+	c.conn.SetReadDeadline(time.Now().Add(Timeout))
+	defer c.conn.SetReadDeadline(time.Time{})
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\n"), nil
+}
@@ -0,0 +1,31 @@
+package chattest_test
+
+import (
+	"testing"
+
+	"github.com/pankaj/simple-chat/chattest"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestHelpers(t *testing.T) {
+	addr := chattest.NewServer(t).Addr().String()
+	alice := chattest.Join(t, addr, "alice")
+	bob := chattest.NewClient(t, addr, "bob")
+
+	if msg := alice.Expect(protocol.TypeJoined); msg.Username != "bob" {
+		t.Errorf("expected JOINED|bob, got %+v", msg)
+	}
+
+	alice.SendMessage("hi bob")
+	chattest.WaitFor(t, bob, chattest.Msg("alice", "hi bob"))
+
+	bob.SendMessage("hi alice")
+	if msg := alice.Expect(protocol.TypeMsg); msg.Username != "bob" || msg.Body != "hi alice" {
+		t.Errorf("expected MSG|bob|hi alice, got %+v", msg)
+	}
+
+	alice.Leave()
+	if _, err := alice.Receive(); err == nil {
+		t.Error("expected the connection to close after LEAVE")
+	}
+}
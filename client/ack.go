@@ -0,0 +1,135 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+var (
+	// ErrNotAcknowledged is returned by SendAcked when the server never
+	// confirmed the message.
+	ErrNotAcknowledged = errors.New("message not acknowledged by server")
+
+	// ErrRejected is wrapped by the error SendAcked returns when the server
+	// refused the message.
+	ErrRejected = errors.New("message rejected by server")
+)
+
+// Defaults for WithAckTimeout.
+const (
+	defaultAckTimeout = 5 * time.Second
+	defaultAckRetries = 3
+)
+
+// SendAcked sends body and blocks until the server confirms it was
+// broadcast. If no confirmation arrives within the ack timeout, for example
+// because the connection dropped, the message is sent again, up to the
+// configured number of retries. Retried messages may occasionally be
+// delivered twice. Like SendMessage, it waits first if the server limits
+// how fast messages may be sent.
+//
+// It returns nil once the message is acknowledged, an error wrapping
+// ErrRejected if the server refused it, ErrNotAcknowledged when the retries
+// are used up, ErrEncrypt if end-to-end encryption is on and the message
+// can't be encrypted, or ctx's error.
+func (c *ChatClient) SendAcked(ctx context.Context, body string) error {
+	if body == "" || !protocol.ValidText(body) {
+		return ErrInvalidBody
+	}
+
+	id := strconv.FormatUint(c.nextID.Add(1), 10)
+	reply := make(chan protocol.Message, 1)
+	c.mu.Lock()
+	c.pending[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	for attempt := 0; attempt <= c.ackRetries; attempt++ {
+		if err := c.wait(ctx); err != nil {
+			return err
+		}
+		// While reconnecting this fails with ErrNotConnected; waiting out
+		// the timeout and retrying covers that case too.
+		err := c.send(protocol.Message{Type: protocol.TypeSendID, ID: id, Body: body})
+		if errors.Is(err, ErrEncrypt) {
+			return err
+		}
+
+		timer := time.NewTimer(c.ackTimeout)
+		select {
+		case msg := <-reply:
+			timer.Stop()
+			if msg.Type == protocol.TypeNack {
+				return fmt.Errorf("%w: %s", ErrRejected, msg.Body)
+			}
+			c.record(protocol.Message{Type: protocol.TypeMsg, Username: c.username, Body: body, Received: time.Now()})
+			return nil
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-c.done:
+			timer.Stop()
+			return ErrClosed
+		}
+	}
+	return ErrNotAcknowledged
+}
+
+// SendWithReceipt sends body and asks the server for delivery receipts.
+// It blocks until the server accepts the message and returns its ID. The
+// receipts arrive later on Messages as protocol.TypeDelivered messages
+// with that ID; parse their Body with protocol.ParseReceipt. A receipt
+// for a busy server may be split over several messages.
+//
+// Unlike SendAcked the message is sent only once, so a dropped connection
+// can't cause duplicates; it returns ErrNotAcknowledged if the server
+// doesn't answer within the ack timeout.
+func (c *ChatClient) SendWithReceipt(ctx context.Context, body string) (string, error) {
+	if body == "" || !protocol.ValidText(body) {
+		return "", ErrInvalidBody
+	}
+
+	id := strconv.FormatUint(c.nextID.Add(1), 10)
+	reply := make(chan protocol.Message, 1)
+	c.mu.Lock()
+	c.pending[id] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}()
+
+	if err := c.wait(ctx); err != nil {
+		return "", err
+	}
+	if err := c.send(protocol.Message{Type: protocol.TypeSendReceipt, ID: id, Body: body}); err != nil {
+		return "", err
+	}
+	timer := time.NewTimer(c.ackTimeout)
+	defer timer.Stop()
+	select {
+	case msg := <-reply:
+		if msg.Type == protocol.TypeNack {
+			return "", fmt.Errorf("%w: %s", ErrRejected, msg.Body)
+		}
+		c.record(protocol.Message{Type: protocol.TypeMsg, Username: c.username, Body: body, Received: time.Now()})
+		return id, nil
+	case <-timer.C:
+		return "", ErrNotAcknowledged
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case <-c.done:
+		return "", ErrClosed
+	}
+}
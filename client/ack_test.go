@@ -0,0 +1,136 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ackServer answers SENDIDs with reply, skipping the first ignore of them.
+func ackServer(t *testing.T, ignore int, reply func(id string) protocol.Message, received chan<- string) string {
+	return chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Each(func(c *chatmock.Conn, msg protocol.Message) error {
+			if msg.Type != protocol.TypeSendID {
+				return nil
+			}
+			if received != nil {
+				received <- protocol.Encode(msg)
+			}
+			if ignore > 0 {
+				ignore--
+				return nil
+			}
+			return c.Send(reply(msg.ID))
+		}),
+	}).Addr()
+}
+
+func ack(id string) protocol.Message { return protocol.Message{Type: protocol.TypeAck, ID: id} }
+
+func TestSendAcked(t *testing.T) {
+	addr := ackServer(t, 0, ack, nil)
+	c, err := New(addr, "bot")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	for i := 0; i < 3; i++ {
+		if err := c.SendAcked(context.Background(), "deploy finished"); err != nil {
+			t.Fatalf("SendAcked() error = %v", err)
+		}
+	}
+}
+
+func TestSendAckedRejected(t *testing.T) {
+	addr := ackServer(t, 0, func(id string) protocol.Message {
+		return protocol.Message{Type: protocol.TypeNack, ID: id, Body: "muted"}
+	}, nil)
+	c, err := New(addr, "bot")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	err = c.SendAcked(context.Background(), "hello")
+	if !errors.Is(err, ErrRejected) {
+		t.Errorf("SendAcked() error = %v, want ErrRejected", err)
+	}
+}
+
+func TestSendAckedRetries(t *testing.T) {
+	received := make(chan string, 4)
+	addr := ackServer(t, 1, ack, received)
+	c, err := New(addr, "bot", WithAckTimeout(50*time.Millisecond, 2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SendAcked(context.Background(), "hello"); err != nil {
+		t.Fatalf("SendAcked() error = %v", err)
+	}
+	first, second := <-received, <-received
+	if first != second {
+		t.Errorf("retry changed the message: %q then %q", first, second)
+	}
+}
+
+func TestSendAckedGivesUp(t *testing.T) {
+	addr := ackServer(t, 100, ack, nil)
+	c, err := New(addr, "bot", WithAckTimeout(20*time.Millisecond, 1))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SendAcked(context.Background(), "hello"); !errors.Is(err, ErrNotAcknowledged) {
+		t.Errorf("SendAcked() error = %v, want ErrNotAcknowledged", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.SendAcked(ctx, "hello"); !errors.Is(err, context.Canceled) {
+		t.Errorf("SendAcked() with cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+func TestSendWithReceipt(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Each(func(c *chatmock.Conn, msg protocol.Message) error {
+			if msg.Type != protocol.TypeSendReceipt {
+				return nil
+			}
+			return c.WriteLine(fmt.Sprintf("ACK|%s\nDELIVERED|%s|+alice|-bob", msg.ID, msg.ID))
+		}),
+	}).Addr()
+	c, err := New(addr, "bot")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	id, err := c.SendWithReceipt(context.Background(), "fire drill at noon")
+	if err != nil {
+		t.Fatalf("SendWithReceipt() error = %v", err)
+	}
+	select {
+	case msg := <-c.Messages():
+		r, err := protocol.ParseReceipt(msg.Body)
+		if msg.Type != protocol.TypeDelivered || msg.ID != id || err != nil {
+			t.Fatalf("got %+v, want a receipt for %s", msg, id)
+		}
+		if len(r.Delivered) != 1 || r.Delivered[0] != "alice" || len(r.Missed) != 1 || r.Missed[0] != "bob" {
+			t.Errorf("receipt = %+v", r)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the receipt")
+	}
+}
@@ -0,0 +1,157 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// AlertAction says how the user is alerted when an AlertRule matches.
+type AlertAction int
+
+const (
+	// AlertBell rings the terminal bell.
+	AlertBell AlertAction = 1 << iota
+	// AlertHighlight shows the message line highlighted.
+	AlertHighlight
+)
+
+// AlertRule alerts the user about messages from other users. A rule with
+// a nil Pattern matches messages that mention the local username;
+// otherwise it matches message bodies against Pattern.
+type AlertRule struct {
+	Pattern *regexp.Regexp
+	Action  AlertAction
+}
+
+// String returns the rule in the form accepted by ParseAlertRule.
+func (r AlertRule) String() string {
+	s := "mention"
+	if r.Pattern != nil {
+		s = "keyword:" + strings.TrimPrefix(r.Pattern.String(), "(?i)")
+	}
+	var actions []string
+	if r.Action&AlertBell != 0 {
+		actions = append(actions, "bell")
+	}
+	if r.Action&AlertHighlight != 0 {
+		actions = append(actions, "highlight")
+	}
+	return s + "=" + strings.Join(actions, "+")
+}
+
+// ParseAlertRule reads a rule of the form "mention=<actions>" or
+// "keyword:<regexp>=<actions>", where actions is "bell", "highlight" or
+// "bell+highlight". Without "=<actions>" the rule rings the bell.
+// Keywords match case-insensitively.
+func ParseAlertRule(s string) (AlertRule, error) {
+	s = strings.TrimSpace(s)
+	rule := AlertRule{Action: AlertBell}
+
+	// The actions come after the last "=", which keeps "=" usable in
+	// keyword patterns as long as the actions are spelled out.
+	if i := strings.LastIndex(s, "="); i >= 0 {
+		spec := s[i+1:]
+		rule.Action = 0
+		for _, action := range strings.Split(spec, "+") {
+			switch strings.TrimSpace(action) {
+			case "bell":
+				rule.Action |= AlertBell
+			case "highlight":
+				rule.Action |= AlertHighlight
+			default:
+				return AlertRule{}, fmt.Errorf("alert %q: unknown action %q", s, action)
+			}
+		}
+		s = s[:i]
+	}
+
+	kind, pattern, _ := strings.Cut(s, ":")
+	switch kind {
+	case "mention":
+		if pattern != "" {
+			return AlertRule{}, fmt.Errorf("alert %q: mention takes no pattern", s)
+		}
+	case "keyword":
+		if pattern == "" {
+			return AlertRule{}, fmt.Errorf("alert %q: keyword needs a pattern", s)
+		}
+		re, err := regexp.Compile("(?i)" + pattern)
+		if err != nil {
+			return AlertRule{}, fmt.Errorf("alert %q: %w", s, err)
+		}
+		rule.Pattern = re
+	default:
+		return AlertRule{}, fmt.Errorf("alert %q: unknown kind %q (want mention or keyword)", s, kind)
+	}
+	return rule, nil
+}
+
+// ParseAlertRules reads a ";"-separated list of rules.
+func ParseAlertRules(s string) ([]AlertRule, error) {
+	var rules []AlertRule
+	for _, spec := range strings.Split(s, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		rule, err := ParseAlertRule(spec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Alerts returns the current alert rules.
+func (c *ChatClient) Alerts() []AlertRule {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]AlertRule(nil), c.alerts...)
+}
+
+// AddAlert appends rule to the alert rules.
+func (c *ChatClient) AddAlert(rule AlertRule) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.alerts = append(c.alerts, rule)
+}
+
+// RemoveAlert deletes the i'th alert rule, counting from zero. It reports
+// whether there was such a rule.
+func (c *ChatClient) RemoveAlert(i int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if i < 0 || i >= len(c.alerts) {
+		return false
+	}
+	c.alerts = append(c.alerts[:i], c.alerts[i+1:]...)
+	return true
+}
+
+// Alert returns the combined actions of the rules matching msg. Only chat
+// messages from other users raise alerts.
+func (c *ChatClient) Alert(msg protocol.Message) AlertAction {
+	if (msg.Type != protocol.TypeMsg && msg.Type != protocol.TypeAttached) || msg.Username == c.username {
+		return 0
+	}
+
+	var action AlertAction
+	for _, rule := range c.Alerts() {
+		re := rule.Pattern
+		if re == nil {
+			re = mentionPattern(c.username)
+		}
+		if re.MatchString(msg.Body) {
+			action |= rule.Action
+		}
+	}
+	return action
+}
+
+// mentionPattern matches name, optionally prefixed with "@", as a word.
+func mentionPattern(name string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)@?\b` + regexp.QuoteMeta(name) + `\b`)
+}
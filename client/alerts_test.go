@@ -0,0 +1,105 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"mention", "mention=bell"},
+		{"mention=highlight", "mention=highlight"},
+		{"keyword:deploy|outage=bell+highlight", "keyword:deploy|outage=bell+highlight"},
+		{"keyword:a=b=bell", "keyword:a=b=bell"},
+	}
+	for _, tt := range tests {
+		rule, err := ParseAlertRule(tt.spec)
+		if err != nil {
+			t.Errorf("ParseAlertRule(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if got := rule.String(); got != tt.want {
+			t.Errorf("ParseAlertRule(%q) = %q, want %q", tt.spec, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "dm", "mention:x", "keyword", "keyword:(?i=bell", "mention=beep"} {
+		if _, err := ParseAlertRule(bad); err == nil {
+			t.Errorf("ParseAlertRule(%q) expected error", bad)
+		}
+	}
+
+	rules, err := ParseAlertRules("mention; keyword:go=highlight ;")
+	if err != nil || len(rules) != 2 {
+		t.Errorf("ParseAlertRules() = %v, %v; want 2 rules", rules, err)
+	}
+}
+
+func TestAlert(t *testing.T) {
+	mention, _ := ParseAlertRule("mention=bell")
+	keyword, _ := ParseAlertRule("keyword:outage=highlight")
+	c := &ChatClient{username: "alice", alerts: []AlertRule{mention, keyword}}
+
+	msg := func(from, body string) protocol.Message {
+		return protocol.Message{Type: protocol.TypeMsg, Username: from, Body: body}
+	}
+	tests := []struct {
+		msg  protocol.Message
+		want AlertAction
+	}{
+		{msg("bob", "hi @alice"), AlertBell},
+		{msg("bob", "Outage in eu-west"), AlertHighlight},
+		{msg("bob", "alice: OUTAGE"), AlertBell | AlertHighlight},
+		{msg("bob", "malice aforethought"), 0},
+		{msg("alice", "outage, says alice"), 0},
+		{protocol.Message{Type: protocol.TypeJoined, Username: "alice"}, 0},
+	}
+	for _, tt := range tests {
+		if got := c.Alert(tt.msg); got != tt.want {
+			t.Errorf("Alert(%q) = %v, want %v", tt.msg.Body, got, tt.want)
+		}
+	}
+
+	if text, _ := c.render(msg("bob", "outage, alice")); text != "\a! [bob]: outage, alice" {
+		t.Errorf("render() = %q", text)
+	}
+	c.theme = &DefaultTheme
+	if text, _ := c.render(msg("bob", "outage")); text != "\x1b[7m[bob]: outage\x1b[0m" {
+		t.Errorf("themed render() = %q", text)
+	}
+}
+
+func TestAlertsCommand(t *testing.T) {
+	c := &ChatClient{username: "alice", commands: NewCommands()}
+
+	var out strings.Builder
+	for _, line := range []string{
+		"/alerts add mention",
+		"/alerts add keyword:release notes=highlight",
+		"/alerts",
+		"/alerts del 1",
+		"/alerts",
+		"/alerts del 5",
+		"/alerts clear",
+		"/alerts",
+	} {
+		c.HandleInput(&out, line)
+	}
+	want := "Added alert mention=bell.\n" +
+		"Added alert keyword:release notes=highlight.\n" +
+		"  1. mention=bell\n" +
+		"  2. keyword:release notes=highlight\n" +
+		"Removed alert 1.\n" +
+		"  1. keyword:release notes=highlight\n" +
+		"Error: no alert rule \"5\"\n" +
+		"Removed all alerts.\n" +
+		"No alert rules.\n"
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrNoUploadURL is returned by /attach when the client wasn't given the
+// server's attachment endpoint with WithUploadURL.
+var ErrNoUploadURL = errors.New("no upload URL; files can't be attached")
+
+// Attach shares an uploaded file, identified by the ID the server's
+// attachment endpoint returned, with an optional caption. Everyone,
+// including this client, receives an ATTACHED message with the file's
+// URL; the server answers with an ERR if it doesn't know the ID.
+func (c *ChatClient) Attach(id, caption string) error {
+	if id == "" || strings.Contains(id, "|") || !protocol.ValidText(id) || !protocol.ValidText(caption) {
+		return ErrInvalidBody
+	}
+	if err := c.wait(c.ctx); err != nil {
+		return err
+	}
+	return c.send(protocol.Message{Type: protocol.TypeAttach, Attachment: id, Body: caption})
+}
+
+// UploadAttachment uploads the file at path to the attachment endpoint of
+// the server whose web address is baseURL, such as
+// "https://chat.example.com", and returns the ID to pass to Attach.
+func UploadAttachment(ctx context.Context, baseURL, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	endpoint := strings.TrimSuffix(baseURL, "/") + "/attachments?name=" + url.QueryEscape(filepath.Base(path))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, f)
+	if err != nil {
+		return "", err
+	}
+	typ := mime.TypeByExtension(filepath.Ext(path))
+	if typ == "" {
+		typ = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", typ)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("uploading %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+	var reply struct {
+		ID    string `json:"id"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil || resp.StatusCode != http.StatusCreated {
+		if reply.Error == "" {
+			reply.Error = resp.Status
+		}
+		return "", fmt.Errorf("uploading %s: %s", path, reply.Error)
+	}
+	return reply.ID, nil
+}
+
+// attachText is the visible text of an ATTACHED message: the caption
+// followed by the file's URL.
+func attachText(msg protocol.Message) string {
+	if msg.Body == "" {
+		return "[attachment: " + msg.Attachment + "]"
+	}
+	return msg.Body + " [attachment: " + msg.Attachment + "]"
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestAttach(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeAttach, Attachment: "f00d", Body: "our cat"}),
+		chatmock.Send(protocol.Message{Type: protocol.TypeAttached, Username: "alice", Attachment: "/attachments/f00d", Body: "our cat"}),
+	}).Addr()
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.Attach("f|00d", ""); err != ErrInvalidBody {
+		t.Errorf("Attach() with a separator in the ID = %v, want ErrInvalidBody", err)
+	}
+	if err := c.Attach("f00d", "our cat"); err != nil {
+		t.Fatalf("Attach() error = %v", err)
+	}
+	if text, ok := FormatMessage(<-c.Messages()); !ok || text != "[alice]: our cat [attachment: /attachments/f00d]" {
+		t.Errorf("FormatMessage() = %q, %v", text, ok)
+	}
+}
+
+func TestUploadAttachment(t *testing.T) {
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path != "/attachments" || r.URL.Query().Get("name") != "cat.png" ||
+			r.Header.Get("Content-Type") != "image/png" || string(body) != "PNG" {
+			http.Error(w, `{"error": "unexpected upload"}`, http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"id": "f00d", "url": "/attachments/f00d"}`)
+	}))
+	defer web.Close()
+
+	path := filepath.Join(t.TempDir(), "cat.png")
+	if err := os.WriteFile(path, []byte("PNG"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	id, err := UploadAttachment(context.Background(), web.URL+"/", path)
+	if err != nil || id != "f00d" {
+		t.Fatalf("UploadAttachment() = %q, %v; want f00d", id, err)
+	}
+
+	os.WriteFile(path, []byte("GIF"), 0o600)
+	if _, err := UploadAttachment(context.Background(), web.URL, path); err == nil {
+		t.Error("UploadAttachment() of a refused upload succeeded")
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrChallenge is wrapped by the error New returns when the client can't
+// answer a challenge the server sent before accepting the JOIN.
+var ErrChallenge = errors.New("cannot answer the server's join challenge")
+
+// challengeTimeout bounds each challenge in the handshake.
+const challengeTimeout = time.Minute
+
+// answerFunc answers a join challenge.
+type answerFunc func(protocol.Challenge) (string, error)
+
+// answerChallenge solves proofs of work and answers questions with the
+// WithChallengeAnswer function.
+func (c *ChatClient) answerChallenge(ch protocol.Challenge) (string, error) {
+	switch ch.Kind {
+	case protocol.ChallengeWork:
+		if ch.Bits > protocol.MaxWorkBits {
+			return "", fmt.Errorf("%w: proof of work of %d bits is too hard", ErrChallenge, ch.Bits)
+		}
+		return protocol.SolveWork(ch.Nonce, ch.Bits), nil
+	case protocol.ChallengeQuestion:
+		if a, ok := c.answers[ch.Question]; ok {
+			return a, nil
+		}
+		if c.ask == nil {
+			return "", fmt.Errorf("%w: the server asks %q", ErrChallenge, ch.Question)
+		}
+		a, err := c.ask(ch.Question)
+		if err != nil {
+			return "", fmt.Errorf("%w: %w", ErrChallenge, err)
+		}
+		if a == "" || !protocol.ValidText(a) {
+			return "", fmt.Errorf("%w: the answer must be a single non-empty line", ErrChallenge)
+		}
+		c.answers[ch.Question] = a
+		return a, nil
+	default:
+		return "", fmt.Errorf("%w: unknown kind %q", ErrChallenge, ch.Kind)
+	}
+}
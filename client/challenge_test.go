@@ -0,0 +1,62 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// challengeServer asks a proof of work and a question before accepting
+// the JOIN, and sends what it received on answers.
+func challengeServer(t *testing.T, answers chan<- string) string {
+	script := chatmock.Script{chatmock.Expect(protocol.TypeJoin)}
+	for _, ch := range []protocol.Challenge{
+		{Kind: protocol.ChallengeWork, Bits: 8, Nonce: "abc"},
+		{Kind: protocol.ChallengeQuestion, Question: "Favourite colour?"},
+	} {
+		script = append(script,
+			chatmock.Send(protocol.Message{Type: protocol.TypeChallenge, Body: protocol.EncodeChallenge(ch)}),
+			chatmock.Do(func(c *chatmock.Conn) error {
+				line, err := c.ReadLine()
+				if err != nil {
+					return chatmock.ErrStop // gave up on the challenge
+				}
+				answers <- line
+				return nil
+			}),
+		)
+	}
+	script = append(script, chatmock.Send(protocol.Message{Type: protocol.TypeOK}))
+	return chatmock.New(t, script).Addr()
+}
+
+func TestJoinChallenge(t *testing.T) {
+	answers := make(chan string, 2)
+	var asked string
+	c, err := New(challengeServer(t, answers), "alice", WithChallengeAnswer(func(q string) (string, error) {
+		asked = q
+		return "blue", nil
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	work, _ := protocol.Decode(<-answers)
+	if work.Type != protocol.TypeAnswer || !protocol.CheckWork("abc", 8, work.Body) {
+		t.Errorf("proof of work answer %+v doesn't check out", work)
+	}
+	if got := <-answers; got != "ANSWER|blue" || asked != "Favourite colour?" {
+		t.Errorf("question answer = %q after asking %q", got, asked)
+	}
+}
+
+func TestJoinChallengeUnanswered(t *testing.T) {
+	answers := make(chan string, 2)
+	_, err := New(challengeServer(t, answers), "alice")
+	if !errors.Is(err, ErrChallenge) {
+		t.Errorf("New() without WithChallengeAnswer = %v, want ErrChallenge", err)
+	}
+}
@@ -2,137 +2,687 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/pankaj/simple-chat/protocol"
+	"github.com/pankaj/simple-chat/ratelimit"
 )
 
-// ChatClient manages the connection to the chat server.
+// messageBuffer is how many received messages are buffered before the
+// receive loop waits for the consumer.
+const messageBuffer = 64
+
+var (
+	// ErrInvalidBody is returned by SendMessage for empty bodies and ones
+	// that aren't a single line of text (see protocol.ValidText).
+	ErrInvalidBody = errors.New("message body must be a single non-empty line of text")
+
+	// ErrClosed is returned by Receive after the client was closed locally.
+	ErrClosed = errors.New("client closed")
+
+	// ErrNoMessage is returned by TryReceive when no message is pending.
+	ErrNoMessage = errors.New("no message available")
+
+	// ErrJoinRejected is wrapped by the error New returns when the server
+	// refuses the JOIN, for example because the username is taken.
+	ErrJoinRejected = errors.New("server rejected join")
+
+	// ErrNameReserved is wrapped, with ErrJoinRejected, by the error New
+	// returns when the username is reserved for a registered user.
+	ErrNameReserved = errors.New("username is reserved for a registered user")
+
+	// ErrKicked is wrapped by the error that ends the client when the
+	// server disconnected it on purpose. The client does not reconnect.
+	ErrKicked = errors.New("disconnected by server")
+
+	// ErrNotConnected is returned for requests made while the client is
+	// reconnecting.
+	ErrNotConnected = errors.New("not connected")
+
+	// ErrQueued is returned by SendMessage while the client is reconnecting,
+	// or sending what it queued then. The message is sent once the
+	// connection is restored, after those already queued.
+	ErrQueued = errors.New("not connected; message queued")
+
+	// ErrQueueFull is returned by SendMessage while the client is
+	// reconnecting and the outbound queue has reached its limit.
+	ErrQueueFull = errors.New("not connected and the outbound queue is full")
+
+	// ErrInvalidNotifyLevel is returned by SetNotify for levels other than
+	// the protocol.Notify* constants.
+	ErrInvalidNotifyLevel = errors.New(`notification level must be "all", "mentions" or "none"`)
+
+	// ErrNoServerCommands is returned by Command when the server doesn't
+	// list protocol.CapCommands.
+	ErrNoServerCommands = errors.New("the server doesn't run commands")
+)
+
+// ChatClient manages the connection to the chat server. Received messages
+// are delivered on Messages; Run layers an interactive prompt on top.
 type ChatClient struct {
-	username string
-	conn     net.Conn
-	reader   *bufio.Reader
-	done     chan struct{}
+	username   string
+	ctx        context.Context
+	cancel     context.CancelFunc
+	commands   *Commands
+	theme      *Theme
+	templates  *Templates
+	plugins    []Plugin
+	timeFmt    string
+	transcript atomic.Pointer[Transcript]
+	reconnect  *ReconnectPolicy
+	heartbeat  *heartbeat
+	transport  transport
+	e2e        *e2e
+	scrollback scrollback
+	saveIgnore func([]string) error
+	ackTimeout time.Duration
+	ackRetries int
+	nextID     atomic.Uint64
+	queueLimit int
+	uploadURL  string
+	messages   chan protocol.Message
+	errors     chan error
+	done       chan struct{}
+	closeOnce  sync.Once
+	err        error // why the connection ended; set before messages is closed
+
+	// mu guards the fields below and is held while writing to conn.
+	mu        sync.Mutex
+	addr      string
+	conn      net.Conn
+	reader    *bufio.Reader
+	connected bool
+	queue     []string                         // bodies sent while disconnected
+	pending   map[string]chan protocol.Message // SendAcked and Ping calls waiting for a reply
+	rtts      []time.Duration                  // recent round trips, oldest first
+	roster    map[string]bool                  // users known to be online
+	ignored   map[string]bool                  // users whose messages are hidden
+	presence  map[string]string                // away users and their notes
+	statuses  map[string]string                // users' status texts
+	away      *string                          // our own away note, nil when present
+	status    protocol.Status                  // our own status, restored after reconnects
+	statusEnd time.Time                        // when our status expires; zero if it doesn't
+	dnd       bool                             // do-not-disturb is on
+	notify    string                           // notification level, "" until set
+	alerts    []AlertRule
+	kicked    string            // reason from a KICKED notice
+	caps      []string          // capabilities from the server's OK
+	pace      *ratelimit.Bucket // paces chat messages to the server's limit; nil if it has none
+	members   int               // room size from OK, JOINED and LEFT; 0 if unknown
+	migrate   string            // token from a MIGRATE, for the next reconnect
+	locale    string            // from WithLocale, sent with every JOIN
+	rename    bool              // from WithRename, sent with the first JOIN
+
+	// answers caches answers to join questions so reconnects don't ask
+	// again. Only dial uses it, which never runs concurrently.
+	answers map[string]string
+	ask     func(question string) (string, error) // from WithChallengeAnswer
 }
 
-// New creates a ChatClient and connects to the server at addr.
-// It sends a JOIN message and waits for OK or ERR.
-func New(addr, username string) (*ChatClient, error) {
-	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+// handshakeTimeout bounds dialing and the JOIN handshake.
+const handshakeTimeout = 5 * time.Second
+
+// New creates a ChatClient and connects to the server at addr, which is
+// either host:port or a ws://, wss:// or quic:// URL. It sends a JOIN message and
+// waits for OK or ERR.
+func New(addr, username string, opts ...Option) (*ChatClient, error) {
+	return NewContext(context.Background(), addr, username, opts...)
+}
+
+// NewContext is like New but dials and joins under ctx. The client stays
+// tied to ctx: cancelling it after a successful join closes the client.
+func NewContext(ctx context.Context, addr, username string, opts ...Option) (*ChatClient, error) {
+	username = protocol.NormalizeUsername(username)
+	c := &ChatClient{
+		username:   username,
+		commands:   NewCommands(),
+		queueLimit: defaultQueueLimit,
+		ackTimeout: defaultAckTimeout,
+		ackRetries: defaultAckRetries,
+		messages:   make(chan protocol.Message, messageBuffer),
+		errors:     make(chan error, messageBuffer),
+		done:       make(chan struct{}),
+		addr:       addr,
+		pending:    make(map[string]chan protocol.Message),
+		roster:     make(map[string]bool),
+		ignored:    make(map[string]bool),
+		presence:   make(map[string]string),
+		statuses:   make(map[string]string),
+		answers:    make(map[string]string),
+		scrollback: scrollback{limit: defaultScrollback},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.locale != "" && !protocol.ValidLocale(c.locale) {
+		return nil, fmt.Errorf("invalid locale %q", c.locale)
+	}
+	join := protocol.Message{Type: protocol.TypeJoin, Username: username, Locale: c.locale, Rename: c.rename}
+	conn, reader, ok, err := dial(ctx, addr, join, c.transport, c.answerChallenge)
 	if err != nil {
-		return nil, fmt.Errorf("connecting to server: %w", err)
+		return nil, err
+	}
+	if c.rename && ok.Username != "" {
+		c.username = ok.Username
+	}
+	c.conn, c.reader, c.connected = conn, reader, true
+	c.caps, c.members = protocol.ParseCapabilities(ok.Body), ok.Members
+	c.pace = pacer(c.caps)
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.ctx = ctx
+
+	if c.e2e != nil {
+		if err := c.announceKey(); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if err := c.startPlugins(); err != nil {
+		c.Close()
+		return nil, err
+	}
+	go c.receiveLoop()
+	if c.heartbeat != nil {
+		go c.heartbeatLoop()
 	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-c.done:
+		}
+	}()
+	return c, nil
+}
 
-	// Send JOIN.
-	_, err = fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{
-		Type:     protocol.TypeJoin,
-		Username: username,
-	}))
+// dial connects to addr using t and joins by sending join, a JOIN or
+// RESUME. It returns the server's OK, which lists its capabilities.
+// Cancelling ctx aborts a handshake in progress.
+func dial(ctx context.Context, addr string, join protocol.Message, t transport, answer answerFunc) (net.Conn, *bufio.Reader, protocol.Message, error) {
+	dialCtx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	conn, err := dialTransport(dialCtx, addr, t)
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("sending JOIN: %w", err)
+		return nil, nil, protocol.Message{}, fmt.Errorf("connecting to server: %w", err)
 	}
 
-	// Wait for response.
-	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	reader := bufio.NewReader(conn)
-	line, err := reader.ReadString('\n')
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	reader, ok, err := handshake(conn, join, answer)
+	if !stop() {
+		conn.Close()
+		return nil, nil, protocol.Message{}, fmt.Errorf("joining: %w", ctx.Err())
+	}
 	if err != nil {
 		conn.Close()
-		return nil, fmt.Errorf("reading server response: %v", err)
+		return nil, nil, protocol.Message{}, err
+	}
+	return conn, reader, ok, nil
+}
+
+// handshake sends join on conn, answers any challenges and waits for the
+// server's OK, which it returns.
+func handshake(conn net.Conn, join protocol.Message, answer answerFunc) (*bufio.Reader, protocol.Message, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(conn, "%s\n", protocol.Encode(join)); err != nil {
+		return nil, protocol.Message{}, fmt.Errorf("sending %s: %w", join.Type, err)
+	}
+
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			return nil, protocol.Message{}, fmt.Errorf("reading server response: %w", err)
+		}
+
+		msg, err := protocol.Decode(strings.TrimRight(line, "\n"))
+		if err != nil {
+			return nil, protocol.Message{}, fmt.Errorf("decoding server response: %w", err)
+		}
+
+		switch msg.Type {
+		case protocol.TypeOK:
+			return reader, msg, nil
+		case protocol.TypeErr:
+			if msg.Body == protocol.ReasonNameReserved {
+				return nil, protocol.Message{}, fmt.Errorf("%w: %w", ErrJoinRejected, ErrNameReserved)
+			}
+			return nil, protocol.Message{}, fmt.Errorf("%w: %s", ErrJoinRejected, msg.Body)
+		case protocol.TypeChallenge:
+			// The server allows more time for challenges, which may need
+			// a person to answer them.
+			conn.SetDeadline(time.Now().Add(challengeTimeout))
+			ch, err := protocol.ParseChallenge(msg.Body)
+			if err != nil {
+				return nil, protocol.Message{}, fmt.Errorf("decoding challenge: %w", err)
+			}
+			a, err := answer(ch)
+			if err != nil {
+				return nil, protocol.Message{}, err
+			}
+			_, err = fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeAnswer, Body: a}))
+			if err != nil {
+				return nil, protocol.Message{}, fmt.Errorf("sending ANSWER: %w", err)
+			}
+			conn.SetDeadline(time.Now().Add(handshakeTimeout))
+		default:
+			return nil, protocol.Message{}, fmt.Errorf("unexpected response: %s", msg.Type)
+		}
+	}
+}
+
+// maxLineLength bounds the lines read from the server, so a hostile or
+// broken server can't make the client buffer without limit.
+const maxLineLength = 64 << 10
+
+// errLineTooLong ends a connection whose server sent an overlong line.
+var errLineTooLong = fmt.Errorf("line from server longer than %d bytes", maxLineLength)
+
+// readLine is like ReadString('\n') but fails with errLineTooLong after
+// maxLineLength bytes.
+func readLine(r *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		if len(line)+len(chunk) > maxLineLength {
+			return "", errLineTooLong
+		}
+		line = append(line, chunk...)
+		if err != bufio.ErrBufferFull {
+			return string(line), err
+		}
+	}
+}
+
+// Username returns the name the client joined with, which differs from
+// the one passed to New if WithRename had the server pick another.
+func (c *ChatClient) Username() string {
+	return c.username
+}
+
+// Capable reports whether the server listed capability, one of the
+// protocol.Cap* constants, when the client last joined.
+func (c *ChatClient) Capable(capability string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return slices.Contains(c.caps, capability)
+}
+
+// SendMessage sends body to the room. While the client is reconnecting the
+// message is queued instead and ErrQueued is returned; queued messages are
+// sent in order once the connection is restored. If the server limits how
+// fast messages may be sent, SendMessage waits until another is allowed.
+func (c *ChatClient) SendMessage(body string) error {
+	if body == "" || !protocol.ValidText(body) {
+		return ErrInvalidBody
+	}
+	if err := c.wait(c.ctx); err != nil {
+		return err
 	}
-	conn.SetReadDeadline(time.Time{})
 
-	msg, err := protocol.Decode(strings.TrimRight(line, "\n"))
+	c.mu.Lock()
+	if !c.connected || len(c.queue) > 0 {
+		defer c.mu.Unlock()
+		return c.enqueue(body)
+	}
+	err := c.write(protocol.Message{Type: protocol.TypeSend, Body: body})
+	if err != nil && c.reconnect != nil && !errors.Is(err, ErrEncrypt) {
+		// The connection is failing; the receive loop will notice and
+		// reconnect, so keep the message for then.
+		defer c.mu.Unlock()
+		return c.enqueue(body)
+	}
+	c.mu.Unlock()
 	if err != nil {
-		conn.Close()
-		return nil, fmt.Errorf("decoding server response: %w", err)
+		return err
 	}
 
-	if msg.Type == protocol.TypeErr {
-		conn.Close()
-		return nil, fmt.Errorf("server rejected join: %s", msg.Body)
+	c.record(protocol.Message{Type: protocol.TypeMsg, Username: c.username, Body: body, Received: time.Now()})
+	return nil
+}
+
+// Queued returns the number of messages waiting to be sent after a
+// reconnect.
+func (c *ChatClient) Queued() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.queue)
+}
+
+// RequestStats asks the server for its statistics. The reply arrives on
+// Messages as a STATS message.
+func (c *ChatClient) RequestStats() error {
+	return c.send(protocol.Message{Type: protocol.TypeStats})
+}
+
+// RequestWho asks the server for the connected users. The reply arrives on
+// Messages as a WHO message.
+func (c *ChatClient) RequestWho() error {
+	return c.send(protocol.Message{Type: protocol.TypeWho})
+}
+
+// Report flags author's message text for the server operators. The text
+// must match a recent message exactly; the server answers with a NOTICE,
+// or an ERR if it can't find the message.
+func (c *ChatClient) Report(author, text string) error {
+	if author == "" || text == "" || !protocol.ValidText(text) {
+		return ErrInvalidBody
 	}
+	return c.send(protocol.Message{Type: protocol.TypeReport, Username: author, Body: text})
+}
 
-	if msg.Type != protocol.TypeOK {
-		conn.Close()
-		return nil, fmt.Errorf("unexpected response: %s", msg.Type)
+// SetTheme enables colored REPL output using t. A nil theme, the default,
+// prints plain text. It must be called before Run.
+func (c *ChatClient) SetTheme(t *Theme) {
+	c.theme = t
+}
+
+// SetTimeFormat prefixes displayed messages with their arrival time,
+// formatted with the time.Format layout, and separates days with a date
+// line. An empty layout, the default, disables timestamps. It must be
+// called before Run.
+func (c *ChatClient) SetTimeFormat(layout string) {
+	c.timeFmt = layout
+}
+
+// TimeFormat returns the layout set by SetTimeFormat.
+func (c *ChatClient) TimeFormat() string {
+	return c.timeFmt
+}
+
+// SetTranscript starts logging every sent and received message to t. Pass
+// nil to stop. The caller remains responsible for closing t.
+func (c *ChatClient) SetTranscript(t *Transcript) {
+	c.transcript.Store(t)
+}
+
+// Commands returns the client's slash-command registry. Embedders can
+// Register their own commands before starting the prompt.
+func (c *ChatClient) Commands() *Commands {
+	return c.commands
+}
+
+// Messages returns the channel of messages received from the server. It is
+// closed when the connection ends.
+func (c *ChatClient) Messages() <-chan protocol.Message {
+	return c.messages
+}
+
+// Receive blocks until the next message arrives. Once the connection has
+// ended and all buffered messages are consumed it returns ErrClosed or the
+// error that ended the connection.
+func (c *ChatClient) Receive() (protocol.Message, error) {
+	msg, ok := <-c.messages
+	if !ok {
+		return protocol.Message{}, c.err
 	}
+	return msg, nil
+}
 
-	return &ChatClient{
-		username: username,
-		conn:     conn,
-		reader:   reader,
-		done:     make(chan struct{}),
-	}, nil
+// TryReceive is the non-blocking form of Receive. It returns ErrNoMessage
+// if no message is pending.
+func (c *ChatClient) TryReceive() (protocol.Message, error) {
+	select {
+	case msg, ok := <-c.messages:
+		if !ok {
+			return protocol.Message{}, c.err
+		}
+		return msg, nil
+	default:
+		return protocol.Message{}, ErrNoMessage
+	}
 }
 
-// Run starts the interactive REPL. Blocks until the user types "leave"
-// or the server disconnects.
-func (c *ChatClient) Run() {
-	go c.receiveLoop()
+// Errors returns the channel of errors encountered while receiving, such
+// as malformed server messages or the connection failing. Errors are
+// dropped if nobody keeps up with the channel. It is closed when the
+// connection ends.
+func (c *ChatClient) Errors() <-chan error {
+	return c.errors
+}
 
-	scanner := bufio.NewScanner(os.Stdin)
-	fmt.Print("> ")
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			fmt.Print("> ")
-			continue
+// Close sends a LEAVE message and closes the connection. Messages still
+// queued for a reconnect are discarded. It is safe to call more than once.
+func (c *ChatClient) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.cancel()
+		for _, p := range c.plugins {
+			p.Stop()
 		}
 
-		if line == "leave" {
-			c.Close()
-			return
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.connected {
+			c.write(protocol.Message{Type: protocol.TypeLeave})
 		}
+		err = c.conn.Close()
+	})
+	return err
+}
+
+// send writes a single protocol message to the server.
+func (c *ChatClient) send(m protocol.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return ErrNotConnected
+	}
+	return c.write(m)
+}
 
-		if strings.HasPrefix(line, "send ") {
-			msg := strings.TrimPrefix(line, "send ")
-			encoded := protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: msg})
-			fmt.Fprintf(c.conn, "%s\n", encoded)
-		} else {
-			fmt.Println("Unknown command. Use 'send <message>' or 'leave'.")
+// write encodes m onto the current connection, encrypting chat messages
+// when end-to-end encryption is on and escaping a leading "/" when the
+// server runs commands. c.mu must be held.
+func (c *ChatClient) write(m protocol.Message) error {
+	if c.e2e != nil && isSend(m.Type) {
+		body, err := c.e2e.seal(m.Body)
+		if err != nil {
+			return err
 		}
+		m.Body = body
+	}
+	if isSend(m.Type) && strings.HasPrefix(m.Body, "/") && slices.Contains(c.caps, protocol.CapCommands) {
+		// Escape the slash so the server doesn't take it for a command.
+		m.Body = "/" + m.Body
+	}
+	return c.writePlain(m)
+}
 
-		fmt.Print("> ")
+// Command sends line, such as "/roll 20", for the server to run as a
+// command. The server answers only this client, with a NOTICE or an ERR.
+func (c *ChatClient) Command(line string) error {
+	if !strings.HasPrefix(line, "/") || strings.HasPrefix(line, "//") || !protocol.ValidText(line) {
+		return ErrInvalidBody
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !slices.Contains(c.caps, protocol.CapCommands) {
+		return ErrNoServerCommands
 	}
+	if !c.connected {
+		return ErrNotConnected
+	}
+	return c.writePlain(protocol.Message{Type: protocol.TypeSend, Body: line})
+}
+
+// isSend reports whether typ carries a chat message body.
+func isSend(typ string) bool {
+	return typ == protocol.TypeSend || typ == protocol.TypeSendID || typ == protocol.TypeSendReceipt
 }
 
-// Close sends a LEAVE message and closes the connection.
-func (c *ChatClient) Close() {
-	fmt.Fprintf(c.conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeLeave}))
-	c.conn.Close()
+// writePlain is write without encryption. c.mu must be held.
+func (c *ChatClient) writePlain(m protocol.Message) error {
+	_, err := fmt.Fprintf(c.conn, "%s\n", protocol.Encode(m))
+	return err
 }
 
-// receiveLoop reads messages from the server and prints them.
+// receiveLoop decodes messages from the server onto the messages channel
+// until the connection ends, reconnecting first if enabled.
 func (c *ChatClient) receiveLoop() {
+	defer close(c.errors)
+	defer close(c.messages)
+
 	for {
-		line, err := c.reader.ReadString('\n')
+		err := c.readMessages()
+		select {
+		case <-c.done:
+			// Closed locally; the read error is expected.
+			c.err = ErrClosed
+			return
+		default:
+		}
+
+		c.mu.Lock()
+		kicked := c.kicked
+		c.mu.Unlock()
+
+		err = fmt.Errorf("connection lost: %w", err)
+		if kicked != "" {
+			err = fmt.Errorf("%w: %s", ErrKicked, kicked)
+		} else if c.reconnect != nil {
+			err = c.redial(err)
+			if err == nil {
+				continue
+			}
+		}
+		c.err = err
+		if !errors.Is(err, ErrClosed) {
+			c.reportError(err)
+		}
+		return
+	}
+}
+
+// readMessages delivers messages from the current connection until
+// reading fails or the client is closed.
+func (c *ChatClient) readMessages() error {
+	c.mu.Lock()
+	conn, reader := c.conn, c.reader
+	c.mu.Unlock()
+
+	for {
+		if c.heartbeat != nil {
+			conn.SetReadDeadline(time.Now().Add(c.heartbeat.timeout))
+		}
+		line, err := readLine(reader)
 		if err != nil {
-			break
+			if errors.Is(err, os.ErrDeadlineExceeded) {
+				return fmt.Errorf("no response from server for %s", c.heartbeat.timeout)
+			}
+			return err
 		}
-		msg, err := protocol.Decode(strings.TrimRight(line, "\n"))
+
+		msg, err := protocol.Decode(strings.TrimRight(line, "\r\n"))
 		if err != nil {
+			c.reportError(fmt.Errorf("decoding %q: %w", strings.TrimSpace(line), err))
 			continue
 		}
 		switch msg.Type {
-		case protocol.TypeMsg:
-			fmt.Printf("\n[%s]: %s\n> ", msg.Username, msg.Body)
-		case protocol.TypeJoined:
-			fmt.Printf("\n* %s has joined the chat *\n> ", msg.Username)
-		case protocol.TypeLeft:
-			fmt.Printf("\n* %s has left the chat *\n> ", msg.Username)
-		case protocol.TypeErr:
-			fmt.Printf("\nError: %s\n> ", msg.Body)
+		case protocol.TypePong:
+			c.handlePong(msg)
+			continue
+		case protocol.TypeAck, protocol.TypeNack:
+			c.resolve(msg.ID, msg)
+			continue
+		}
+		if (msg.Type == protocol.TypeMsg || msg.Type == protocol.TypeAttached) && c.isIgnored(msg.Username) {
+			continue
+		}
+		if c.e2e != nil {
+			var ok bool
+			if msg, ok = c.handleE2E(msg); !ok {
+				continue
+			}
+		}
+		msg.Received = time.Now()
+		c.record(msg)
+		c.updateRoster(msg)
+
+		if msg.Type == protocol.TypeKicked {
+			c.mu.Lock()
+			c.kicked = msg.Body
+			c.mu.Unlock()
+		}
+		if msg.Type == protocol.TypeReconnect && msg.Body != "" && c.reconnect != nil {
+			// The server is going away and named a replacement.
+			c.mu.Lock()
+			c.addr = msg.Body
+			c.mu.Unlock()
+		}
+		if msg.Type == protocol.TypeMigrate && c.reconnect != nil {
+			// The server is moving us; it closes the connection next.
+			c.mu.Lock()
+			c.addr, c.migrate = msg.Body, msg.ID
+			c.mu.Unlock()
+		}
+
+		for _, p := range c.plugins {
+			p.HandleMessage(msg)
+		}
+		if !c.deliver(msg) {
+			return ErrClosed
 		}
 	}
+}
 
-	// Server disconnected.
-	close(c.done)
-	fmt.Println("\nDisconnected from server.")
-	os.Exit(0)
+// resolve hands msg to the call registered in pending under key. Replies
+// nobody is waiting for, such as duplicates after a retry, are dropped.
+func (c *ChatClient) resolve(key string, msg protocol.Message) {
+	c.mu.Lock()
+	reply, ok := c.pending[key]
+	c.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case reply <- msg:
+	default:
+	}
+}
+
+// deliver hands msg to the consumer. It returns false if the client was
+// closed first.
+func (c *ChatClient) deliver(msg protocol.Message) bool {
+	select {
+	case c.messages <- msg:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// record adds msg to the scrollback and, if one is set, the transcript.
+func (c *ChatClient) record(msg protocol.Message) {
+	c.scrollback.add(msg)
+	t := c.transcript.Load()
+	if t == nil {
+		return
+	}
+	if err := t.Record(msg.Received, msg); err != nil {
+		c.reportError(fmt.Errorf("writing transcript: %w", err))
+	}
+}
+
+// reportError delivers err without blocking the receive loop.
+func (c *ChatClient) reportError(err error) {
+	select {
+	case c.errors <- err:
+	default:
+	}
 }
@@ -2,75 +2,82 @@ package client
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
-	"net"
+	"io"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/pankaj/simple-chat/chatmock"
 	"github.com/pankaj/simple-chat/protocol"
 )
 
-// mockServer creates a TCP listener that handles one connection with a
-// custom handler function. Returns the listener address.
-func mockServer(t *testing.T, handler func(net.Conn)) string {
-	t.Helper()
-	ln, err := net.Listen("tcp", ":0")
+func TestNewConnectsAndJoins(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{chatmock.ExpectJoin("testuser")}).Addr()
+
+	c, err := New(addr, "testuser")
 	if err != nil {
-		t.Fatalf("failed to listen: %v", err)
+		t.Fatalf("New() error = %v", err)
 	}
-	t.Cleanup(func() { ln.Close() })
+	c.conn.Close()
+}
 
-	go func() {
-		conn, err := ln.Accept()
-		if err != nil {
-			return
-		}
-		defer conn.Close()
-		handler(conn)
-	}()
+func TestNewSendsLocale(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeJoin, Username: "testuser", Locale: "pt-BR"}),
+		chatmock.Send(protocol.Message{Type: protocol.TypeOK}),
+	}).Addr()
 
-	return ln.Addr().String()
+	c, err := New(addr, "testuser", WithLocale("pt-BR"))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	c.conn.Close()
+
+	if _, err := New(addr, "testuser", WithLocale("pt_BR")); err == nil {
+		t.Error("New() with locale pt_BR expected error, got nil")
+	}
 }
 
-func TestNewConnectsAndJoins(t *testing.T) {
-	addr := mockServer(t, func(conn net.Conn) {
-		scanner := bufio.NewScanner(conn)
-		if !scanner.Scan() {
-			return
-		}
-		msg, err := protocol.Decode(scanner.Text())
-		if err != nil {
-			t.Errorf("mock server decode error: %v", err)
-			return
-		}
-		if msg.Type != protocol.TypeJoin {
-			t.Errorf("expected JOIN, got %s", msg.Type)
-			return
-		}
-		if msg.Username != "testuser" {
-			t.Errorf("expected username 'testuser', got %q", msg.Username)
-		}
-		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
-		// Keep connection alive briefly.
-		time.Sleep(100 * time.Millisecond)
-	})
+func TestNewNormalizesUsername(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeJoin, Username: "jos\u00e9"}),
+		chatmock.Send(protocol.Message{Type: protocol.TypeOK}),
+	}).Addr()
 
-	c, err := New(addr, "testuser")
+	c, err := New(addr, "jose\u0301")
 	if err != nil {
 		t.Fatalf("New() error = %v", err)
 	}
-	c.conn.Close()
+	defer c.conn.Close()
+	if got := c.Username(); got != "jos\u00e9" {
+		t.Errorf("Username() = %q, want the composed form", got)
+	}
+}
+
+func TestNewRename(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeJoin, Username: "alice", Rename: true}),
+		chatmock.Send(protocol.Message{Type: protocol.TypeOK, Members: 2, Username: "alice_2"}),
+	}).Addr()
+
+	c, err := New(addr, "alice", WithRename())
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.conn.Close()
+	if got := c.Username(); got != "alice_2" {
+		t.Errorf("Username() = %q, want alice_2", got)
+	}
 }
 
 func TestNewRejectsOnError(t *testing.T) {
-	addr := mockServer(t, func(conn net.Conn) {
-		scanner := bufio.NewScanner(conn)
-		scanner.Scan()
-		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{
-			Type: protocol.TypeErr,
-			Body: "username taken",
-		}))
-	})
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Expect(protocol.TypeJoin),
+		chatmock.Send(protocol.Message{Type: protocol.TypeErr, Body: "username taken"}),
+	}).Addr()
 
 	_, err := New(addr, "testuser")
 	if err == nil {
@@ -79,21 +86,26 @@ func TestNewRejectsOnError(t *testing.T) {
 	if got := err.Error(); got != "server rejected join: username taken" {
 		t.Errorf("unexpected error: %s", got)
 	}
+	if !errors.Is(err, ErrJoinRejected) {
+		t.Errorf("error %v does not wrap ErrJoinRejected", err)
+	}
+}
+
+func TestNewNameReserved(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Expect(protocol.TypeJoin),
+		chatmock.Send(protocol.Message{Type: protocol.TypeErr, Body: protocol.ReasonNameReserved}),
+	}).Addr()
+
+	_, err := New(addr, "alice")
+	if !errors.Is(err, ErrNameReserved) || !errors.Is(err, ErrJoinRejected) {
+		t.Errorf("New() error = %v, want ErrNameReserved and ErrJoinRejected", err)
+	}
 }
 
 func TestCloseSendsLeave(t *testing.T) {
 	received := make(chan string, 1)
-
-	addr := mockServer(t, func(conn net.Conn) {
-		scanner := bufio.NewScanner(conn)
-		// Read JOIN.
-		scanner.Scan()
-		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
-		// Read LEAVE.
-		if scanner.Scan() {
-			received <- scanner.Text()
-		}
-	})
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(received)}).Addr()
 
 	c, err := New(addr, "testuser")
 	if err != nil {
@@ -114,3 +126,438 @@ func TestCloseSendsLeave(t *testing.T) {
 		t.Fatal("timed out waiting for LEAVE message")
 	}
 }
+
+func TestSendMessage(t *testing.T) {
+	received := make(chan string, 1)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(received)}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SendMessage("hello there"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	select {
+	case line := <-received:
+		if line != "SEND|hello there" {
+			t.Errorf("server received %q, want SEND|hello there", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for SEND")
+	}
+}
+
+func TestSendMessageRejectsInvalidBodies(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join()}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	for _, body := range []string{"", "two\nlines", "LEAVE\r"} {
+		if err := c.SendMessage(body); err != ErrInvalidBody {
+			t.Errorf("SendMessage(%q) error = %v, want ErrInvalidBody", body, err)
+		}
+	}
+}
+
+func TestMessagesAndErrors(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.SendLine("GARBAGE"),
+		chatmock.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"}),
+		chatmock.Drop(),
+	}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	select {
+	case msg := <-c.Messages():
+		if msg.Type != protocol.TypeMsg || msg.Username != "bob" || msg.Body != "hi" {
+			t.Errorf("unexpected message: %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for message")
+	}
+
+	// Messages is closed once the server hangs up.
+	select {
+	case msg, ok := <-c.Messages():
+		if ok {
+			t.Errorf("expected closed channel, got %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Messages was not closed after disconnect")
+	}
+
+	var errs []error
+	for err := range c.Errors() {
+		errs = append(errs, err)
+	}
+	if len(errs) != 2 {
+		t.Errorf("expected a decode error and a connection error, got %v", errs)
+	}
+}
+
+func TestRunIO(t *testing.T) {
+	received := make(chan string, 3)
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Send(protocol.Message{Type: protocol.TypeJoined, Username: "bob"}),
+		chatmock.Lines(received),
+	}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	// Give the JOINED notice a moment to arrive before the input is read.
+	select {
+	case msg := <-c.Messages():
+		if msg.Type != protocol.TypeJoined {
+			t.Fatalf("expected JOINED, got %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for JOINED")
+	}
+
+	var out strings.Builder
+	c.RunIO(strings.NewReader("send hi all\nbogus\nleave\n"), &out)
+
+	for _, want := range []string{"SEND|hi all", "LEAVE"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("server received %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	if !strings.Contains(out.String(), "Unknown command") {
+		t.Errorf("expected unknown command notice, got %q", out.String())
+	}
+}
+
+func TestFormatMessage(t *testing.T) {
+	tests := []struct {
+		msg  protocol.Message
+		want string
+	}{
+		{protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"}, "[bob]: hi"},
+		{protocol.Message{Type: protocol.TypeJoined, Username: "bob"}, "* bob has joined the chat *"},
+		{protocol.Message{Type: protocol.TypeLeft, Username: "bob"}, "* bob has left the chat *"},
+		{protocol.Message{Type: protocol.TypeJoined, Username: "bob", Members: 42}, "* bob has joined the chat (42 online) *"},
+		{protocol.Message{Type: protocol.TypeLeft, Username: "bob", Members: 41}, "* bob has left the chat (41 online) *"},
+		{protocol.Message{Type: protocol.TypeErr, Body: "nope"}, "Error: nope"},
+		{protocol.Message{Type: protocol.TypeWho, Body: "alice|bob"}, "Online (2): alice, bob"},
+		{protocol.Message{Type: TypeDisconnected, Body: "connection lost: EOF"}, "* connection lost: EOF; reconnecting... *"},
+		{protocol.Message{Type: protocol.TypeNotice, Body: "welcome"}, "* Notice: welcome *"},
+		{protocol.Message{Type: protocol.TypeMode, Body: "slow=30"}, "* Slow mode: you can post once every 30s *"},
+		{protocol.Message{Type: protocol.TypeMode, Body: "announce"}, "* Announcement mode: only operators can post *"},
+		{protocol.Message{Type: protocol.TypeDelivered, ID: "3", Body: "+bob|+carol|-dave"}, "* Message 3 delivered to bob, carol; not delivered to dave *"},
+		{protocol.Message{Type: protocol.TypeDelivered, ID: "3", Body: "-dave"}, "* Message 3 not delivered to dave *"},
+		{protocol.Message{Type: protocol.TypeDelivered, ID: "3"}, "* Message 3 had no recipients *"},
+		{protocol.Message{Type: TypeReconnected, Body: "0"}, "* Reconnected *"},
+		{protocol.Message{Type: TypeReconnected, Body: "3"}, "* Reconnected; sent 3 queued message(s) *"},
+		{protocol.Message{Type: protocol.TypePresence, Username: "bob", Body: "away"}, "* bob is away *"},
+		{protocol.Message{Type: protocol.TypePresence, Username: "bob", Body: "here"}, "* bob is back *"},
+	}
+	for _, tt := range tests {
+		got, ok := FormatMessage(tt.msg)
+		if !ok || got != tt.want {
+			t.Errorf("FormatMessage(%+v) = %q, %v; want %q", tt.msg, got, ok, tt.want)
+		}
+	}
+	if _, ok := FormatMessage(protocol.Message{Type: protocol.TypeOK}); ok {
+		t.Error("FormatMessage(OK) should not be displayed")
+	}
+}
+
+func TestNewContextCancelledDuringHandshake(t *testing.T) {
+	// Never answer the JOIN.
+	addr := chatmock.New(t, chatmock.Script{chatmock.Expect(protocol.TypeJoin)}).Addr()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err := NewContext(ctx, addr, "testuser")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("NewContext() error = %v, want context.Canceled", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("NewContext() took %s after cancellation", elapsed)
+	}
+}
+
+func TestNewContextAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := NewContext(ctx, "127.0.0.1:1", "testuser"); err == nil {
+		t.Fatal("NewContext() with a cancelled context should fail")
+	}
+}
+
+func TestContextCancelClosesClient(t *testing.T) {
+	received := make(chan string, 1)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(received)}).Addr()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c, err := NewContext(ctx, addr, "testuser")
+	if err != nil {
+		t.Fatalf("NewContext() error = %v", err)
+	}
+	cancel()
+
+	select {
+	case _, ok := <-c.Messages():
+		if ok {
+			t.Fatal("expected Messages to be closed")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Messages was not closed after cancellation")
+	}
+
+	select {
+	case line := <-received:
+		if line != "LEAVE" {
+			t.Errorf("server received %q, want LEAVE", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for LEAVE")
+	}
+}
+
+func TestReceiveAndTryReceive(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Send(
+			protocol.Message{Type: protocol.TypeJoined, Username: "bob"},
+			protocol.Message{Type: protocol.TypeLeft, Username: "bob"},
+		),
+		chatmock.Drop(),
+	}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	msg, err := c.Receive()
+	if err != nil || msg.Type != protocol.TypeJoined || msg.Username != "bob" {
+		t.Fatalf("Receive() = %+v, %v; want JOINED|bob", msg, err)
+	}
+
+	// Wait for the server to hang up so the LEFT notice is buffered.
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		msg, err = c.TryReceive()
+		if err != ErrNoMessage || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil || msg.Type != protocol.TypeLeft {
+		t.Fatalf("TryReceive() = %+v, %v; want LEFT|bob", msg, err)
+	}
+
+	if _, err := c.Receive(); err == nil || errors.Is(err, ErrClosed) {
+		t.Errorf("Receive() after disconnect error = %v, want connection error", err)
+	}
+}
+
+func TestReceiveAfterClose(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join()}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if _, err := c.TryReceive(); err != ErrNoMessage {
+		t.Errorf("TryReceive() error = %v, want ErrNoMessage", err)
+	}
+
+	c.Close()
+	if _, err := c.Receive(); err != ErrClosed {
+		t.Errorf("Receive() error = %v, want ErrClosed", err)
+	}
+}
+
+func TestSlashCommands(t *testing.T) {
+	received := make(chan string, 5)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(received)}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var shouted string
+	err = c.Commands().Register(Command{
+		Name: "shout",
+		Args: "<message>",
+		Help: "Send a message in capitals",
+		Run: func(c *ChatClient, out io.Writer, args string) error {
+			shouted = args
+			return c.SendMessage(strings.ToUpper(args))
+		},
+	})
+	if err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := c.Commands().Register(Command{Name: "msg", Run: func(*ChatClient, io.Writer, string) error { return nil }}); err == nil {
+		t.Error("Register() should reject a duplicate name")
+	}
+	if err := c.Commands().Register(Command{Name: "two words", Run: func(*ChatClient, io.Writer, string) error { return nil }}); err == nil {
+		t.Error("Register() should reject a name with spaces")
+	}
+
+	var out strings.Builder
+	for _, line := range []string{"/msg hello", "/who", "/shout hey there", "/report @bob buy now", "/nope", "/help"} {
+		if !c.HandleInput(&out, line) {
+			t.Fatalf("HandleInput(%q) ended the session", line)
+		}
+	}
+
+	for _, want := range []string{"SEND|hello", "WHO", "SEND|HEY THERE", "REPORT|bob|buy now"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("server received %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+	if shouted != "hey there" {
+		t.Errorf("custom command got args %q, want %q", shouted, "hey there")
+	}
+	for _, want := range []string{"Unknown command /nope", "/shout <message>", "/msg <message>"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output missing %q:\n%s", want, out.String())
+		}
+	}
+
+	if c.HandleInput(&out, "/quit") {
+		t.Error("/quit should end the session")
+	}
+}
+
+// notifyWriter forwards each write to a channel.
+type notifyWriter chan string
+
+func (w notifyWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestPipe(t *testing.T) {
+	received := make(chan string, 3)
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Each(func(c *chatmock.Conn, msg protocol.Message) error {
+			received <- protocol.Encode(msg)
+			if msg.Type == protocol.TypeSend {
+				return c.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "ack"})
+			}
+			return nil
+		}),
+	}).Addr()
+
+	c, err := New(addr, "testuser")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	in, stdin := io.Pipe()
+	out := make(notifyWriter, 1)
+	done := make(chan error, 1)
+	go func() { done <- c.Pipe(in, out) }()
+
+	fmt.Fprint(stdin, "build started\n\n")
+	select {
+	case got := <-out:
+		if got != "[bob]: ack\n" {
+			t.Errorf("output = %q, want %q", got, "[bob]: ack\n")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for output")
+	}
+	stdin.Close()
+
+	if err := <-done; err != nil {
+		t.Fatalf("Pipe() error = %v", err)
+	}
+	for _, want := range []string{"SEND|build started", "LEAVE"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("server received %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestReadLineLimit(t *testing.T) {
+	r := bufio.NewReaderSize(strings.NewReader("short\n"+strings.Repeat("x", maxLineLength+1)+"\n"), 16)
+	if line, err := readLine(r); line != "short\n" || err != nil {
+		t.Fatalf("readLine = %q, %v", line, err)
+	}
+	if _, err := readLine(r); err != errLineTooLong {
+		t.Errorf("overlong line: got %v, want errLineTooLong", err)
+	}
+}
+
+func TestServerCommands(t *testing.T) {
+	for _, caps := range []string{"", protocol.CapCommands} {
+		got := make(chan string, 2)
+		addr := chatmock.New(t, chatmock.Script{
+			chatmock.Expect(protocol.TypeJoin),
+			chatmock.Send(protocol.Message{Type: protocol.TypeOK, Body: caps}),
+			chatmock.Lines(got),
+		}).Addr()
+		c, err := New(addr, "alice")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		defer c.Close()
+
+		var out strings.Builder
+		c.HandleInput(&out, "/roll 20")
+		if err := c.SendMessage("/shrug"); err != nil {
+			t.Fatalf("SendMessage() error = %v", err)
+		}
+
+		want := []string{"SEND|/shrug"}
+		if caps != "" {
+			want = []string{"SEND|/roll 20", "SEND|//shrug"}
+		} else if !strings.Contains(out.String(), "Unknown command /roll") {
+			t.Errorf("output = %q, want an unknown command error", out.String())
+		}
+		for _, w := range want {
+			if line := <-got; line != w {
+				t.Errorf("caps %q: server got %q, want %q", caps, line, w)
+			}
+		}
+		if err := c.Command("/roll"); caps == "" && err != ErrNoServerCommands {
+			t.Errorf("Command() without the capability = %v, want ErrNoServerCommands", err)
+		}
+	}
+}
@@ -0,0 +1,474 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrQuit is returned by a command handler to end the session.
+var ErrQuit = errors.New("quit")
+
+// pingTimeout bounds how long /ping waits for the server.
+const pingTimeout = 5 * time.Second
+
+// Command is a slash command typed at the prompt as "/name args".
+type Command struct {
+	// Name is the word after the slash, e.g. "msg".
+	Name string
+	// Args describes the arguments for /help, e.g. "<message>".
+	Args string
+	// Help is a one-line description shown by /help.
+	Help string
+	// Run executes the command. args is the rest of the line with
+	// surrounding whitespace removed. Returning ErrQuit ends the session;
+	// any other error is reported to the user.
+	Run func(c *ChatClient, out io.Writer, args string) error
+}
+
+// Commands is a registry of slash commands. It is safe for concurrent use.
+type Commands struct {
+	mu   sync.RWMutex
+	cmds map[string]Command
+}
+
+// NewCommands returns a registry holding the built-in commands.
+func NewCommands() *Commands {
+	r := &Commands{cmds: make(map[string]Command)}
+	for _, cmd := range builtinCommands() {
+		r.cmds[cmd.Name] = cmd
+	}
+	return r
+}
+
+// Register adds cmd to the registry. It fails if the name is empty,
+// contains whitespace or is already registered.
+func (r *Commands) Register(cmd Command) error {
+	if cmd.Name == "" || strings.ContainsAny(cmd.Name, " \t/") {
+		return fmt.Errorf("invalid command name %q", cmd.Name)
+	}
+	if cmd.Run == nil {
+		return fmt.Errorf("command /%s has no handler", cmd.Name)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.cmds[cmd.Name]; ok {
+		return fmt.Errorf("command /%s already registered", cmd.Name)
+	}
+	r.cmds[cmd.Name] = cmd
+	return nil
+}
+
+// Lookup returns the command registered under name.
+func (r *Commands) Lookup(name string) (Command, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	cmd, ok := r.cmds[name]
+	return cmd, ok
+}
+
+// List returns the registered commands sorted by name.
+func (r *Commands) List() []Command {
+	r.mu.RLock()
+	cmds := make([]Command, 0, len(r.cmds))
+	for _, cmd := range r.cmds {
+		cmds = append(cmds, cmd)
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+func builtinCommands() []Command {
+	leave := func(c *ChatClient, out io.Writer, args string) error {
+		c.Close()
+		return ErrQuit
+	}
+	return []Command{
+		{
+			Name: "msg",
+			Args: "<message>",
+			Help: "Send a message to the chat",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				err := c.SendMessage(args)
+				if errors.Is(err, ErrQueued) {
+					fmt.Fprintf(out, "Not connected; message queued (%d waiting).\n", c.Queued())
+					return nil
+				}
+				return err
+			},
+		},
+		{
+			Name: "receipt",
+			Args: "<message>",
+			Help: "Send a message and report who it was delivered to",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				id, err := c.SendWithReceipt(context.Background(), args)
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Sent as message %s; a delivery receipt will follow.\n", id)
+				return nil
+			},
+		},
+		{
+			Name: "who",
+			Help: "List connected users",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				return c.RequestWho()
+			},
+		},
+		{
+			Name: "ping",
+			Help: "Measure the round trip to the server",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+				defer cancel()
+				rtt, err := c.Ping(ctx)
+				if err != nil {
+					return err
+				}
+				l := c.Latency()
+				fmt.Fprintf(out, "Pong in %s (last %d: min %s, avg %s, max %s)\n",
+					rtt.Round(time.Microsecond), l.Samples,
+					l.Min.Round(time.Microsecond), l.Avg.Round(time.Microsecond), l.Max.Round(time.Microsecond))
+				return nil
+			},
+		},
+		{
+			Name: "stats",
+			Help: "Show server statistics",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				return c.RequestStats()
+			},
+		},
+		{
+			Name: "help",
+			Help: "List available commands",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				for _, cmd := range c.commands.List() {
+					usage := "/" + cmd.Name
+					if cmd.Args != "" {
+						usage += " " + cmd.Args
+					}
+					fmt.Fprintf(out, "  %-20s %s\n", usage, cmd.Help)
+				}
+				return nil
+			},
+		},
+		{
+			Name: "away",
+			Args: "[note]",
+			Help: "Mark yourself as away",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				return c.Away(args)
+			},
+		},
+		{
+			Name: "back",
+			Help: "Mark yourself as present again",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				return c.Back()
+			},
+		},
+		{
+			Name: "status",
+			Args: "[+<duration>] [text]",
+			Help: "Set your status, optionally for a while, or clear it",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				var expiry time.Duration
+				if first, rest, _ := strings.Cut(args, " "); strings.HasPrefix(first, "+") {
+					d, err := time.ParseDuration(first[1:])
+					if err != nil || d <= 0 || rest == "" {
+						return errors.New("usage: /status [+<duration>] [text]")
+					}
+					expiry, args = d, rest
+				}
+				return c.SetStatus(args, expiry)
+			},
+		},
+		{
+			Name: "whois",
+			Args: "[user]",
+			Help: "Show your own or another user's presence and status",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				name := strings.TrimPrefix(args, "@")
+				if name == "" {
+					name = c.username
+				}
+				if tag := c.presenceTag(name); tag != "" {
+					fmt.Fprintf(out, "%s is %s.\n", name, strings.Trim(tag, "()"))
+				} else {
+					fmt.Fprintf(out, "%s is here.\n", name)
+				}
+				if status := c.Status(name); status != "" {
+					fmt.Fprintf(out, "Status: %s\n", status)
+				}
+				if name == c.username && c.DND() {
+					fmt.Fprintln(out, "Do-not-disturb is on.")
+				}
+				if name == c.username && c.Notify() != protocol.NotifyAll {
+					fmt.Fprintf(out, "Notifications: %s.\n", c.Notify())
+				}
+				return nil
+			},
+		},
+		{
+			Name: "seen",
+			Args: "<user>",
+			Help: "Show when a user was last here",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				if args == "" {
+					return errors.New("usage: /seen <user>")
+				}
+				return c.RequestSeen(strings.TrimPrefix(args, "@"))
+			},
+		},
+		{
+			Name: "hideseen",
+			Args: "on|off",
+			Help: "Stop or allow telling others when you were last here",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				if args != "on" && args != "off" {
+					return errors.New("usage: /hideseen on|off")
+				}
+				if err := c.SetHideSeen(args == "on"); err != nil {
+					return err
+				}
+				if args == "on" {
+					fmt.Fprintln(out, "Others won't be told when you were last here.")
+				} else {
+					fmt.Fprintln(out, "Others can see when you were last here.")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "dnd",
+			Args: "[on|off]",
+			Help: "Hold back messages that don't mention you, or toggle",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				on := !c.DND()
+				switch args {
+				case "":
+				case "on":
+					on = true
+				case "off":
+					on = false
+				default:
+					return errors.New("usage: /dnd [on|off]")
+				}
+				if err := c.SetDND(on); err != nil {
+					return err
+				}
+				if on {
+					fmt.Fprintln(out, "Do-not-disturb on: only mentions will be shown until /dnd off.")
+				} else {
+					fmt.Fprintln(out, "Do-not-disturb off.")
+				}
+				return nil
+			},
+		},
+		{
+			Name: "notify",
+			Args: "[all|mentions|none]",
+			Help: "Choose which messages the server sends you, or show the setting",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				if args == "" {
+					fmt.Fprintf(out, "Notifications: %s.\n", c.Notify())
+					return nil
+				}
+				if err := c.SetNotify(args); err != nil {
+					if errors.Is(err, ErrInvalidNotifyLevel) {
+						return errors.New("usage: /notify [all|mentions|none]")
+					}
+					return err
+				}
+				fmt.Fprintf(out, "Notifications: %s.\n", args)
+				return nil
+			},
+		},
+		{
+			Name: "report",
+			Args: "<user> <message>",
+			Help: "Flag a user's message for the server operators",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				name, text, ok := strings.Cut(args, " ")
+				if !ok {
+					return errors.New("usage: /report <user> <message>")
+				}
+				return c.Report(strings.TrimPrefix(name, "@"), text)
+			},
+		},
+		{
+			Name: "attach",
+			Args: "<file> [caption]",
+			Help: "Upload a file and share it",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				path, caption, _ := strings.Cut(args, " ")
+				if path == "" {
+					return errors.New("usage: /attach <file> [caption]")
+				}
+				if c.uploadURL == "" {
+					return ErrNoUploadURL
+				}
+				id, err := UploadAttachment(c.ctx, c.uploadURL, path)
+				if err != nil {
+					return err
+				}
+				return c.Attach(id, caption)
+			},
+		},
+		{
+			Name: "ignore",
+			Args: "[user]",
+			Help: "Hide messages from a user, or list ignored users",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				if args == "" {
+					if names := c.Ignored(); len(names) > 0 {
+						fmt.Fprintf(out, "Ignoring: %s\n", strings.Join(names, ", "))
+					} else {
+						fmt.Fprintln(out, "Not ignoring anyone.")
+					}
+					return nil
+				}
+				name := strings.TrimPrefix(args, "@")
+				if !c.Ignore(name) {
+					fmt.Fprintf(out, "Already ignoring %s.\n", name)
+					return nil
+				}
+				fmt.Fprintf(out, "Ignoring %s.\n", name)
+				return nil
+			},
+		},
+		{
+			Name: "unignore",
+			Args: "<user>",
+			Help: "Show messages from a user again",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				name := strings.TrimPrefix(args, "@")
+				if name == "" {
+					return errors.New("usage: /unignore <user>")
+				}
+				if !c.Unignore(name) {
+					fmt.Fprintf(out, "Not ignoring %s.\n", name)
+					return nil
+				}
+				fmt.Fprintf(out, "No longer ignoring %s.\n", name)
+				return nil
+			},
+		},
+		{
+			Name: "alerts",
+			Args: "[add <rule> | del <n> | clear]",
+			Help: "List or change alert rules",
+			Run:  alerts,
+		},
+		{
+			Name: "fingerprint",
+			Args: "[user]",
+			Help: "Show the encryption key fingerprint of yourself or a user",
+			Run:  fingerprint,
+		},
+		{
+			Name: "verify",
+			Args: "<user> <fingerprint>",
+			Help: "Mark a user's key as verified after comparing fingerprints",
+			Run: func(c *ChatClient, out io.Writer, args string) error {
+				if c.e2e == nil {
+					return errE2EOff
+				}
+				name, fp, ok := strings.Cut(args, " ")
+				if !ok {
+					return errors.New("usage: /verify <user> <fingerprint>")
+				}
+				name = strings.TrimPrefix(name, "@")
+				if err := c.e2e.verify(name, fp); err != nil {
+					return err
+				}
+				fmt.Fprintf(out, "Verified %s's key.\n", name)
+				return nil
+			},
+		},
+		{
+			Name: "scroll",
+			Args: "[up | down | end | <count>]",
+			Help: "Page through earlier messages",
+			Run:  scroll,
+		},
+		{Name: "leave", Help: "Leave the chat", Run: leave},
+		{Name: "quit", Help: "Leave the chat", Run: leave},
+	}
+}
+
+// alerts implements /alerts. Rules are written as for ParseAlertRule and
+// numbered from one in listings.
+func alerts(c *ChatClient, out io.Writer, args string) error {
+	sub, rest, _ := strings.Cut(args, " ")
+	rest = strings.TrimSpace(rest)
+	switch sub {
+	case "":
+		rules := c.Alerts()
+		if len(rules) == 0 {
+			fmt.Fprintln(out, "No alert rules.")
+		}
+		for i, rule := range rules {
+			fmt.Fprintf(out, "  %d. %s\n", i+1, rule)
+		}
+	case "add":
+		rule, err := ParseAlertRule(rest)
+		if err != nil {
+			return err
+		}
+		c.AddAlert(rule)
+		fmt.Fprintf(out, "Added alert %s.\n", rule)
+	case "del":
+		n, err := strconv.Atoi(rest)
+		if err != nil || !c.RemoveAlert(n-1) {
+			return fmt.Errorf("no alert rule %q", rest)
+		}
+		fmt.Fprintf(out, "Removed alert %d.\n", n)
+	case "clear":
+		for c.RemoveAlert(0) {
+		}
+		fmt.Fprintln(out, "Removed all alerts.")
+	default:
+		return errors.New("usage: /alerts [add <rule> | del <n> | clear]")
+	}
+	return nil
+}
+
+// errE2EOff is reported by encryption commands when encryption is off.
+var errE2EOff = errors.New("end-to-end encryption is off")
+
+// fingerprint implements /fingerprint.
+func fingerprint(c *ChatClient, out io.Writer, args string) error {
+	if c.e2e == nil {
+		return errE2EOff
+	}
+	name := strings.TrimPrefix(args, "@")
+	if name == "" {
+		fmt.Fprintf(out, "Your key: %s\n", c.Fingerprint())
+		return nil
+	}
+	fp, verified, ok := c.e2e.fingerprint(name)
+	if !ok {
+		return fmt.Errorf("no encryption key known for %s", name)
+	}
+	state := "not verified"
+	if verified {
+		state = "verified"
+	}
+	fmt.Fprintf(out, "%s: %s (%s)\n", name, fp, state)
+	return nil
+}
@@ -0,0 +1,132 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"sync"
+)
+
+// daemonBuffer is how many events a connection to Serve may fall behind
+// by before it is dropped.
+const daemonBuffer = 256
+
+// Serve shares the client's session with other programs, such as short
+// commands run from a shell, through connections accepted on ln, usually
+// a unix socket. Each connection speaks the protocol of JSON: it writes
+// JSONInput lines, and reads a JSONEvent line for every message received
+// from when it connected, and for the output of its own commands. A
+// connection that falls behind by daemonBuffer events is dropped.
+//
+// Anyone who can connect to ln can chat as the client, so a unix socket
+// should only be accessible to its owner.
+//
+// Serve closes ln when it returns. It returns nil when ctx is cancelled,
+// the client is closed or a connection runs /leave; otherwise it returns
+// the error that ended the connection to the server, like Headless.
+func (c *ChatClient) Serve(ctx context.Context, ln net.Listener) error {
+	defer ln.Close()
+
+	var (
+		mu    sync.Mutex
+		conns = make(map[net.Conn]chan JSONEvent)
+	)
+	defer func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for conn := range conns {
+			conn.Close()
+		}
+	}()
+	// send queues e for conn, dropping conn if it has fallen behind.
+	// mu must be held.
+	send := func(conn net.Conn, e JSONEvent) {
+		events, ok := conns[conn]
+		if !ok {
+			return
+		}
+		select {
+		case events <- e:
+		default:
+			delete(conns, conn)
+			close(events)
+		}
+	}
+
+	quit := make(chan struct{})
+	var quitOnce sync.Once
+	var inputMu sync.Mutex // commands run one at a time
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			events := make(chan JSONEvent, daemonBuffer)
+			mu.Lock()
+			conns[conn] = events
+			mu.Unlock()
+
+			go func() {
+				defer conn.Close()
+				enc := json.NewEncoder(conn)
+				enc.SetEscapeHTML(false)
+				for e := range events {
+					if enc.Encode(e) != nil {
+						return
+					}
+				}
+			}()
+			go func() {
+				scanner := bufio.NewScanner(conn)
+				for scanner.Scan() {
+					inputMu.Lock()
+					out, running := c.runJSONInput(scanner.Text())
+					inputMu.Unlock()
+					mu.Lock()
+					for _, e := range out {
+						send(conn, e)
+					}
+					mu.Unlock()
+					if !running {
+						quitOnce.Do(func() { close(quit) })
+						return
+					}
+				}
+				mu.Lock()
+				if events, ok := conns[conn]; ok {
+					delete(conns, conn)
+					close(events)
+				}
+				mu.Unlock()
+			}()
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-c.Messages():
+			if !ok {
+				if errors.Is(c.err, ErrClosed) {
+					return nil
+				}
+				return c.err
+			}
+			e := jsonEvent(msg)
+			mu.Lock()
+			for conn := range conns {
+				send(conn, e)
+			}
+			mu.Unlock()
+
+		case <-quit:
+			return nil
+
+		case <-ctx.Done():
+			c.Close()
+			return nil
+		}
+	}
+}
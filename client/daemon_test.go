@@ -0,0 +1,91 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestServe(t *testing.T) {
+	proceed := make(chan struct{})
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Wait(proceed),
+		chatmock.SendLine("MSG|bob|hi all"),
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeSend, Body: "hello"}),
+		chatmock.Expect(protocol.TypeLeave),
+	}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	ln, err := net.Listen("unix", filepath.Join(t.TempDir(), "chat.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Serve(context.Background(), ln) }()
+
+	type tool struct {
+		conn   net.Conn
+		events *bufio.Scanner
+	}
+	dial := func() tool {
+		conn, err := net.Dial("unix", ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		return tool{conn, bufio.NewScanner(conn)}
+	}
+	event := func(tl tool) JSONEvent {
+		t.Helper()
+		if !tl.events.Scan() {
+			t.Fatalf("no more events: %v", tl.events.Err())
+		}
+		var e JSONEvent
+		if err := json.Unmarshal(tl.events.Bytes(), &e); err != nil {
+			t.Fatalf("event %s: %v", tl.events.Text(), err)
+		}
+		return e
+	}
+
+	// Once a tool's command has been answered, it is receiving events.
+	tools := []tool{dial(), dial()}
+	for _, tl := range tools {
+		fmt.Fprintln(tl.conn, `{"command": "/whois"}`)
+		if e := event(tl); e.Type != TypeOutput || e.Body != "alice is here." {
+			t.Fatalf("/whois event = %+v", e)
+		}
+	}
+
+	close(proceed)
+	for i, tl := range tools {
+		if e := event(tl); e.Type != protocol.TypeMsg || e.User != "bob" || e.Body != "hi all" {
+			t.Errorf("tool %d: event = %+v, want bob's message", i, e)
+		}
+	}
+
+	fmt.Fprintln(tools[0].conn, `{"send": "hello"}`)
+	fmt.Fprintln(tools[1].conn, `{"command": "/leave"}`)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Serve() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Serve didn't return after /leave")
+	}
+}
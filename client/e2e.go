@@ -0,0 +1,299 @@
+package client
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pankaj/simple-chat/protocol"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+// End-to-end encryption rides on ordinary chat messages, so the server
+// needs no support and only ever relays ciphertext. Each client announces
+// its X25519 public key with a message of the form
+//
+//	!e2e-key <base64 key>
+//
+// when it joins and whenever someone else joins. An encrypted message
+// carries one NaCl box per recipient:
+//
+//	!e2e alice:<base64 nonce+box>|bob:<base64 nonce+box>
+const (
+	e2eKeyPrefix = "!e2e-key "
+	e2ePrefix    = "!e2e "
+)
+
+// maxSealedBody keeps encrypted messages within the server's 4096 byte
+// line limit.
+const maxSealedBody = 4000
+
+// TypeKeyChanged is a client-local message type delivered when a user
+// announces a different encryption key than before. Username names the
+// user and Body holds the new fingerprint. A server that swaps keys to
+// read messages shows up this way.
+const TypeKeyChanged = "KEYCHANGED"
+
+// ErrEncrypt is returned when a message can't be encrypted, for example
+// because no one online has announced a key.
+var ErrEncrypt = errors.New("cannot encrypt message")
+
+// KeyPair is an X25519 key pair used for end-to-end encryption.
+type KeyPair struct {
+	Public  [32]byte
+	Private [32]byte
+}
+
+// GenerateKeyPair returns a new random key pair.
+func GenerateKeyPair() (*KeyPair, error) {
+	pub, priv, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyPair{Public: *pub, Private: *priv}, nil
+}
+
+// LoadKeyPair reads the private key stored at path, generating and saving
+// a new one if the file doesn't exist. Keeping the key makes the
+// fingerprint other users verify stable across sessions.
+func LoadKeyPair(path string) (*KeyPair, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		kp, err := GenerateKeyPair()
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(kp.Private[:]) + "\n"
+		return kp, os.WriteFile(path, []byte(encoded), 0o600)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(priv) != 32 {
+		return nil, fmt.Errorf("%s: not a base64 X25519 private key", path)
+	}
+	kp := &KeyPair{}
+	copy(kp.Private[:], priv)
+	pub, err := curve25519.X25519(kp.Private[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	copy(kp.Public[:], pub)
+	return kp, nil
+}
+
+// Fingerprint returns a short, human-comparable digest of a public key,
+// e.g. "3f2a 9c01 ...". Users compare fingerprints over another channel
+// to make sure the server hasn't substituted keys.
+func Fingerprint(pub [32]byte) string {
+	sum := sha256.Sum256(pub[:])
+	digits := hex.EncodeToString(sum[:16])
+	var groups []string
+	for i := 0; i < len(digits); i += 4 {
+		groups = append(groups, digits[i:i+4])
+	}
+	return strings.Join(groups, " ")
+}
+
+// e2e holds the local key pair and the keys announced by other users.
+type e2e struct {
+	keys *KeyPair
+
+	mu       sync.Mutex
+	peers    map[string]*[32]byte // keys of users currently online
+	seen     map[string][32]byte  // every key announced this session
+	verified map[string]bool      // users whose fingerprint was checked
+}
+
+func newE2E(keys *KeyPair) *e2e {
+	return &e2e{
+		keys:     keys,
+		peers:    make(map[string]*[32]byte),
+		seen:     make(map[string][32]byte),
+		verified: make(map[string]bool),
+	}
+}
+
+// announcement returns the message body announcing the local public key.
+func (e *e2e) announcement() string {
+	return e2eKeyPrefix + base64.StdEncoding.EncodeToString(e.keys.Public[:])
+}
+
+// seal encrypts body for every user with a known key.
+func (e *e2e) seal(body string) (string, error) {
+	e.mu.Lock()
+	names := make([]string, 0, len(e.peers))
+	for name := range e.peers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	boxes := make([]string, 0, len(names))
+	for _, name := range names {
+		var nonce [24]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			e.mu.Unlock()
+			return "", err
+		}
+		sealed := box.Seal(nonce[:], []byte(body), &nonce, e.peers[name], &e.keys.Private)
+		boxes = append(boxes, name+":"+base64.RawStdEncoding.EncodeToString(sealed))
+	}
+	e.mu.Unlock()
+
+	if len(boxes) == 0 {
+		return "", fmt.Errorf("%w: no one online has announced an encryption key", ErrEncrypt)
+	}
+	sealed := e2ePrefix + strings.Join(boxes, "|")
+	if len(sealed) > maxSealedBody {
+		return "", fmt.Errorf("%w: too long once encrypted for %d recipients", ErrEncrypt, len(boxes))
+	}
+	return sealed, nil
+}
+
+// open decrypts the box addressed to self in an encrypted message from
+// sender. It reports false if there is no such box or it doesn't open.
+func (e *e2e) open(self, sender, body string) (string, bool) {
+	e.mu.Lock()
+	peer := e.peers[sender]
+	e.mu.Unlock()
+	if peer == nil {
+		return "", false
+	}
+
+	for _, entry := range strings.Split(strings.TrimPrefix(body, e2ePrefix), "|") {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 || entry[:i] != self {
+			continue
+		}
+		sealed, err := base64.RawStdEncoding.DecodeString(entry[i+1:])
+		if err != nil || len(sealed) < 24 {
+			return "", false
+		}
+		var nonce [24]byte
+		copy(nonce[:], sealed)
+		plain, ok := box.Open(nil, sealed[24:], &nonce, peer, &e.keys.Private)
+		return string(plain), ok
+	}
+	return "", false
+}
+
+// learn records the key announced by name. It reports whether the key
+// differs from one name announced earlier in the session.
+func (e *e2e) learn(name, body string) (changed bool) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(body, e2eKeyPrefix))
+	if err != nil || len(raw) != 32 {
+		return false
+	}
+	var key [32]byte
+	copy(key[:], raw)
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if old, ok := e.seen[name]; ok && old != key {
+		changed = true
+		delete(e.verified, name)
+	}
+	e.seen[name] = key
+	e.peers[name] = &key
+	return changed
+}
+
+// forget drops the key of a user who left.
+func (e *e2e) forget(name string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.peers, name)
+}
+
+// fingerprint returns the fingerprint of name's current key and whether
+// it has been verified.
+func (e *e2e) fingerprint(name string) (fp string, verified, ok bool) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	key, ok := e.seen[name]
+	if !ok {
+		return "", false, false
+	}
+	return Fingerprint(key), e.verified[name], true
+}
+
+// verify marks name as verified if fp matches its key. Spaces and case
+// in fp are ignored.
+func (e *e2e) verify(name, fp string) error {
+	known, _, ok := e.fingerprint(name)
+	if !ok {
+		return fmt.Errorf("no encryption key known for %s", name)
+	}
+	normalize := func(s string) string { return strings.ToLower(strings.ReplaceAll(s, " ", "")) }
+	if normalize(fp) != normalize(known) {
+		return fmt.Errorf("fingerprint does not match %s's key; it may have been replaced", name)
+	}
+	e.mu.Lock()
+	e.verified[name] = true
+	e.mu.Unlock()
+	return nil
+}
+
+// Fingerprint returns the fingerprint of the local encryption key, or ""
+// if end-to-end encryption is off.
+func (c *ChatClient) Fingerprint() string {
+	if c.e2e == nil {
+		return ""
+	}
+	return Fingerprint(c.e2e.keys.Public)
+}
+
+// announceKey sends the local public key to everyone online.
+func (c *ChatClient) announceKey() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.connected {
+		return ErrNotConnected
+	}
+	return c.writePlain(protocol.Message{Type: protocol.TypeSend, Body: c.e2e.announcement()})
+}
+
+// handleE2E processes key announcements and encrypted messages before
+// delivery. It returns the message to deliver, if any: encrypted messages
+// are replaced by their plaintext and announcements are only shown when a
+// key changed.
+func (c *ChatClient) handleE2E(msg protocol.Message) (protocol.Message, bool) {
+	switch {
+	case msg.Type == protocol.TypeJoined:
+		// Newcomers only hear keys announced after they joined.
+		if err := c.announceKey(); err != nil {
+			c.reportError(fmt.Errorf("announcing encryption key: %w", err))
+		}
+	case msg.Type == protocol.TypeLeft:
+		c.e2e.forget(msg.Username)
+	case msg.Type != protocol.TypeMsg:
+	case strings.HasPrefix(msg.Body, e2eKeyPrefix):
+		if !c.e2e.learn(msg.Username, msg.Body) {
+			return msg, false
+		}
+		fp, _, _ := c.e2e.fingerprint(msg.Username)
+		return protocol.Message{Type: TypeKeyChanged, Username: msg.Username, Body: fp}, true
+	case strings.HasPrefix(msg.Body, e2ePrefix):
+		plain, ok := c.e2e.open(c.username, msg.Username, msg.Body)
+		if !ok {
+			return msg, false
+		}
+		msg.Body = plain
+	}
+	return msg, true
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"encoding/base64"
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestLoadKeyPair(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys", "e2e.key")
+	first, err := LoadKeyPair(path)
+	if err != nil {
+		t.Fatalf("LoadKeyPair() creating error = %v", err)
+	}
+	second, err := LoadKeyPair(path)
+	if err != nil {
+		t.Fatalf("LoadKeyPair() loading error = %v", err)
+	}
+	if *first != *second {
+		t.Error("reloaded key pair differs from the one created")
+	}
+
+	fp := Fingerprint(first.Public)
+	if !regexp.MustCompile(`^([0-9a-f]{4} ){7}[0-9a-f]{4}$`).MatchString(fp) {
+		t.Errorf("Fingerprint() = %q, want 8 groups of 4 hex digits", fp)
+	}
+}
+
+func TestSealAndOpen(t *testing.T) {
+	newPeer := func() *e2e {
+		keys, err := GenerateKeyPair()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return newE2E(keys)
+	}
+	alice, bob, carol := newPeer(), newPeer(), newPeer()
+
+	if _, err := alice.seal("hi"); !errors.Is(err, ErrEncrypt) {
+		t.Errorf("seal() with no peers error = %v, want ErrEncrypt", err)
+	}
+
+	alice.learn("bob", bob.announcement())
+	bob.learn("alice", alice.announcement())
+	carol.learn("alice", alice.announcement())
+
+	sealed, err := alice.seal("secret|plans")
+	if err != nil {
+		t.Fatalf("seal() error = %v", err)
+	}
+	if strings.Contains(sealed, "secret") {
+		t.Fatalf("sealed body contains plaintext: %q", sealed)
+	}
+	if got, ok := bob.open("bob", "alice", sealed); !ok || got != "secret|plans" {
+		t.Errorf("bob.open() = %q, %v", got, ok)
+	}
+	if _, ok := carol.open("carol", "alice", sealed); ok {
+		t.Error("carol opened a message not addressed to her")
+	}
+	if _, ok := bob.open("bob", "mallory", sealed); ok {
+		t.Error("opened a message from a sender with no known key")
+	}
+
+	if _, err := alice.seal(strings.Repeat("x", maxSealedBody)); !errors.Is(err, ErrEncrypt) {
+		t.Errorf("seal() of an oversized body error = %v, want ErrEncrypt", err)
+	}
+}
+
+func TestKeyChangeAndVerify(t *testing.T) {
+	keys, _ := GenerateKeyPair()
+	c := &ChatClient{username: "alice", commands: NewCommands(), e2e: newE2E(keys)}
+
+	announce := func() protocol.Message {
+		other, _ := GenerateKeyPair()
+		return protocol.Message{Type: protocol.TypeMsg, Username: "bob",
+			Body: e2eKeyPrefix + base64.StdEncoding.EncodeToString(other.Public[:])}
+	}
+	if _, ok := c.handleE2E(announce()); ok {
+		t.Error("first key announcement should not be delivered")
+	}
+	fp, _, _ := c.e2e.fingerprint("bob")
+
+	var out strings.Builder
+	c.HandleInput(&out, "/verify bob 0000")
+	c.HandleInput(&out, "/verify @bob "+strings.ToUpper(fp))
+	c.HandleInput(&out, "/fingerprint bob")
+	want := "Error: fingerprint does not match bob's key; it may have been replaced\n" +
+		"Verified bob's key.\n" +
+		"bob: " + fp + " (verified)\n"
+	if out.String() != want {
+		t.Errorf("output =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	msg, ok := c.handleE2E(announce())
+	if !ok || msg.Type != TypeKeyChanged || msg.Username != "bob" {
+		t.Fatalf("changed key gave %+v, %v; want a KEYCHANGED notice", msg, ok)
+	}
+	if _, verified, _ := c.e2e.fingerprint("bob"); verified {
+		t.Error("bob is still verified after changing keys")
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Headless runs the client for scripts and services: there is no prompt
+// and no echo. Lines read from in that start with "/" run as commands and
+// other non-empty lines are sent as messages; received messages are
+// written to out one per line. Unlike Pipe, reaching EOF on in does not
+// end the session, so a bot can simply listen.
+//
+// Headless returns nil after a clean shutdown: ctx was cancelled, the
+// client was closed, or /leave was entered. Otherwise it returns the error
+// that ended the connection, which wraps ErrKicked if the server
+// disconnected the client on purpose.
+func (c *ChatClient) Headless(ctx context.Context, in io.Reader, out io.Writer) error {
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+		// Leave lines open: EOF is not a reason to stop.
+	}()
+
+	for {
+		select {
+		case line := <-lines:
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if !strings.HasPrefix(line, "/") {
+				line = "/msg " + line
+			}
+			if !c.HandleInput(out, line) {
+				return nil
+			}
+
+		case msg, ok := <-c.Messages():
+			if !ok {
+				if errors.Is(c.err, ErrClosed) {
+					return nil
+				}
+				return c.err
+			}
+			if text, ok := FormatMessage(msg); ok {
+				fmt.Fprintln(out, text)
+			}
+
+		case <-ctx.Done():
+			c.Close()
+			return nil
+		}
+	}
+}
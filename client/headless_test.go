@@ -0,0 +1,84 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestHeadlessKicked(t *testing.T) {
+	received := make(chan string, 2)
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Do(func(c *chatmock.Conn) error {
+			line, err := c.ReadLine()
+			received <- line
+			return err
+		}),
+		chatmock.Send(protocol.Message{Type: protocol.TypeKicked, Body: "flooding"}),
+		chatmock.Drop(),
+	}).Addr()
+
+	// Being kicked must end the session even with reconnects enabled.
+	c, err := New(addr, "bot", WithReconnect(ReconnectPolicy{MinDelay: time.Millisecond}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var out strings.Builder
+	err = c.Headless(context.Background(), strings.NewReader("hello\n"), &out)
+	if !errors.Is(err, ErrKicked) || !strings.Contains(err.Error(), "flooding") {
+		t.Errorf("Headless() error = %v, want ErrKicked", err)
+	}
+	if got := <-received; got != "SEND|hello" {
+		t.Errorf("server received %q, want SEND|hello", got)
+	}
+	if !strings.Contains(out.String(), "Disconnected by server: flooding") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestHeadlessShutdown(t *testing.T) {
+	received := make(chan string, 2)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(received)}).Addr()
+
+	c, err := New(addr, "bot")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	// EOF on input straight away must not end the session.
+	go func() { done <- c.Headless(ctx, strings.NewReader(""), &strings.Builder{}) }()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Headless() returned early: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Headless() error = %v, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Headless() did not return after cancel")
+	}
+	select {
+	case got := <-received:
+		if got != "LEAVE" {
+			t.Errorf("server received %q, want LEAVE", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("server never received LEAVE")
+	}
+}
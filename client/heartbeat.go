@@ -0,0 +1,31 @@
+package client
+
+import (
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+type heartbeat struct {
+	interval time.Duration
+	timeout  time.Duration
+}
+
+// heartbeatLoop sends a PING every interval until the client is closed.
+// Any traffic from the server, including the PONGs, pushes back the read
+// deadline set by readMessages; a silent server trips it. The PONGs also
+// feed Latency.
+func (c *ChatClient) heartbeatLoop() {
+	ticker := time.NewTicker(c.heartbeat.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			// Fails with ErrNotConnected while reconnecting; the next
+			// tick tries again.
+			c.send(protocol.Message{Type: protocol.TypePing, Body: pingBody(time.Now())})
+		case <-c.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+)
+
+func TestHeartbeatDetectsSilentServer(t *testing.T) {
+	pings := make(chan string, 16)
+	// Read pings but never answer them.
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(pings)}).Addr()
+
+	c, err := New(addr, "alice", WithHeartbeat(20*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Receive()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "no response from server") {
+			t.Errorf("Receive() error = %v, want heartbeat timeout", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("client never noticed the silent server")
+	}
+
+	select {
+	case ping := <-pings:
+		if !strings.HasPrefix(ping, "PING|") {
+			t.Errorf("server received %q, want heartbeat PING", ping)
+		}
+	default:
+		t.Error("server received no PING")
+	}
+}
+
+func TestHeartbeatKeepsConnectionAlive(t *testing.T) {
+	addr := pongServer(t)
+
+	c, err := New(addr, "alice", WithHeartbeat(20*time.Millisecond, 100*time.Millisecond))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	// Several timeouts pass; heartbeat PONGs keep the connection up and are
+	// not delivered.
+	time.Sleep(400 * time.Millisecond)
+	if msg, err := c.TryReceive(); err != ErrNoMessage {
+		t.Errorf("TryReceive() = %+v, %v; want ErrNoMessage", msg, err)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Ignore hides future messages from name. It reports whether name was
+// newly added.
+func (c *ChatClient) Ignore(name string) bool {
+	c.mu.Lock()
+	if c.ignored[name] {
+		c.mu.Unlock()
+		return false
+	}
+	c.ignored[name] = true
+	c.mu.Unlock()
+	c.saveIgnored()
+	return true
+}
+
+// Unignore shows messages from name again. It reports whether name was
+// being ignored.
+func (c *ChatClient) Unignore(name string) bool {
+	c.mu.Lock()
+	if !c.ignored[name] {
+		c.mu.Unlock()
+		return false
+	}
+	delete(c.ignored, name)
+	c.mu.Unlock()
+	c.saveIgnored()
+	return true
+}
+
+// Ignored returns the ignored usernames, sorted.
+func (c *ChatClient) Ignored() []string {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.ignored))
+	for name := range c.ignored {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+func (c *ChatClient) isIgnored(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ignored[name]
+}
+
+// saveIgnored hands the current list to the saver set by WithIgnored.
+func (c *ChatClient) saveIgnored() {
+	if c.saveIgnore == nil {
+		return
+	}
+	if err := c.saveIgnore(c.Ignored()); err != nil {
+		c.reportError(fmt.Errorf("saving ignore list: %w", err))
+	}
+}
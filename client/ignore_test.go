@@ -0,0 +1,53 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestIgnore(t *testing.T) {
+	next := make(chan struct{})
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Send(
+			protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"},
+			protocol.Message{Type: protocol.TypeMsg, Username: "carol", Body: "hi"},
+		),
+		chatmock.Wait(next),
+		chatmock.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "back"}),
+	}).Addr()
+
+	var saved []string
+	c, err := New(addr, "alice", WithIgnored([]string{"bob"}, func(names []string) error {
+		saved = names
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if msg := expectMessage(t, c, protocol.TypeMsg); msg.Username != "carol" {
+		t.Errorf("first message from %q, want carol (bob is ignored)", msg.Username)
+	}
+
+	var out strings.Builder
+	c.HandleInput(&out, "/ignore @dave")
+	c.HandleInput(&out, "/unignore bob")
+	c.HandleInput(&out, "/ignore")
+	want := "Ignoring dave.\nNo longer ignoring bob.\nIgnoring: dave\n"
+	if out.String() != want {
+		t.Errorf("output = %q, want %q", out.String(), want)
+	}
+	if got := strings.Join(saved, ","); got != "dave" {
+		t.Errorf("saved ignore list = %q, want dave", got)
+	}
+
+	close(next)
+	if msg := expectMessage(t, c, protocol.TypeMsg); msg.Body != "back" {
+		t.Errorf("message body = %q, want back", msg.Body)
+	}
+}
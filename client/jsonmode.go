@@ -0,0 +1,148 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// TypeOutput is the JSONEvent type of a line of output from a command
+// run by JSON, such as a /who listing. Body holds the line.
+const TypeOutput = "OUTPUT"
+
+// JSONEvent is a message as JSON writes it, one object per line.
+type JSONEvent struct {
+	Type       string    `json:"type"` // a protocol.Type* or client Type* constant
+	User       string    `json:"user,omitempty"`
+	Body       string    `json:"body,omitempty"`
+	Attachment string    `json:"attachment,omitempty"`
+	ID         string    `json:"id,omitempty"`
+	Members    int       `json:"members,omitempty"`
+	Time       time.Time `json:"timestamp"`
+}
+
+// JSONInput is a line JSON reads: {"send": "text"} sends a chat message
+// and {"command": "/away lunch"} runs a command.
+type JSONInput struct {
+	Send    string `json:"send,omitempty"`
+	Command string `json:"command,omitempty"`
+}
+
+// JSON is Headless for programs: it reads JSONInput objects from in and
+// writes every message received, and every line of command output, to out
+// as a JSONEvent, one per line. Lines that aren't a valid JSONInput are
+// answered with an event of type protocol.TypeErr. Like Headless, EOF on
+// in doesn't end the session, and the return value is the same.
+func (c *ChatClient) JSON(ctx context.Context, in io.Reader, out io.Writer) error {
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+		// Leave lines open: EOF is not a reason to stop.
+	}()
+
+	enc := json.NewEncoder(out)
+	enc.SetEscapeHTML(false)
+
+	for {
+		select {
+		case line := <-lines:
+			events, running := c.runJSONInput(line)
+			for _, e := range events {
+				enc.Encode(e)
+			}
+			if !running {
+				return nil
+			}
+
+		case msg, ok := <-c.Messages():
+			if !ok {
+				if errors.Is(c.err, ErrClosed) {
+					return nil
+				}
+				return c.err
+			}
+			enc.Encode(jsonEvent(msg))
+
+		case <-ctx.Done():
+			c.Close()
+			return nil
+		}
+	}
+}
+
+// jsonEvent returns msg as JSON writes it.
+func jsonEvent(msg protocol.Message) JSONEvent {
+	e := JSONEvent{
+		Type:       msg.Type,
+		User:       msg.Username,
+		Body:       msg.Body,
+		Attachment: msg.Attachment,
+		ID:         msg.ID,
+		Members:    msg.Members,
+		Time:       msg.Received,
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	return e
+}
+
+// runJSONInput acts on a line of JSONInput and returns the events it
+// produced: the command's output, or an error if the line is invalid. It
+// returns false when the session should end.
+func (c *ChatClient) runJSONInput(line string) ([]JSONEvent, bool) {
+	if strings.TrimSpace(line) == "" {
+		return nil, true
+	}
+	input, err := parseJSONInput(line)
+	if err != nil {
+		return []JSONEvent{{Type: protocol.TypeErr, Body: err.Error(), Time: time.Now()}}, true
+	}
+	var output strings.Builder
+	running := c.HandleInput(&output, input)
+	var events []JSONEvent
+	for _, text := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if text != "" {
+			events = append(events, JSONEvent{Type: TypeOutput, Body: text, Time: time.Now()})
+		}
+	}
+	return events, running
+}
+
+// parseJSONInput returns the prompt line that a JSONInput line stands for.
+func parseJSONInput(line string) (string, error) {
+	var input JSONInput
+	dec := json.NewDecoder(strings.NewReader(line))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&input); err != nil {
+		return "", fmt.Errorf("invalid input %q: %w", line, err)
+	}
+	switch {
+	case input.Send != "" && input.Command == "":
+		return "/msg " + input.Send, nil
+	case input.Command != "" && input.Send == "":
+		if !strings.HasPrefix(input.Command, "/") {
+			input.Command = "/" + input.Command
+		}
+		return strings.TrimSpace(input.Command), nil
+	default:
+		return "", fmt.Errorf(`invalid input %q: want one of "send" or "command"`, line)
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestJSON(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.SendLine("MSG|bob|<hi> there"),
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeSend, Body: "hello"}),
+		chatmock.Expect(protocol.TypeLeave),
+	}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- c.JSON(context.Background(), inR, outW) }()
+	events := bufio.NewScanner(outR)
+	next := func() JSONEvent {
+		t.Helper()
+		if !events.Scan() {
+			t.Fatal("no more events")
+		}
+		var e JSONEvent
+		if err := json.Unmarshal(events.Bytes(), &e); err != nil {
+			t.Fatalf("event %s: %v", events.Text(), err)
+		}
+		if e.Time.IsZero() {
+			t.Errorf("event %s has no timestamp", events.Text())
+		}
+		return e
+	}
+
+	if e := next(); e.Type != protocol.TypeMsg || e.User != "bob" || e.Body != "<hi> there" {
+		t.Errorf("first event = %+v, want bob's message", e)
+	}
+	io.WriteString(inW, `{"send": "hello"}`+"\n")
+	io.WriteString(inW, `{"sned": "typo"}`+"\n")
+	if e := next(); e.Type != protocol.TypeErr {
+		t.Errorf("event for a bad line = %+v, want ERR", e)
+	}
+	io.WriteString(inW, `{"command": "/help"}`+"\n")
+	if e := next(); e.Type != TypeOutput || e.Body == "" {
+		t.Errorf("event for /help = %+v, want its output", e)
+	}
+	go io.Copy(io.Discard, outR)
+	io.WriteString(inW, `{"command": "leave"}`+"\n")
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("JSON() error = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("JSON didn't return after leave")
+	}
+}
+
+func TestParseJSONInput(t *testing.T) {
+	for line, want := range map[string]string{
+		`{"send":"hi | there"}`:  "/msg hi | there",
+		`{"command":"/who"}`:     "/who",
+		`{"command":"away brb"}`: "/away brb",
+	} {
+		if got, err := parseJSONInput(line); err != nil || got != want {
+			t.Errorf("parseJSONInput(%s) = %q, %v; want %q", line, got, err, want)
+		}
+	}
+	for _, line := range []string{`{}`, `{"send":"a","command":"/who"}`, `not json`, `{"send":1}`} {
+		if _, err := parseJSONInput(line); err == nil {
+			t.Errorf("parseJSONInput(%s) succeeded, want an error", line)
+		}
+	}
+}
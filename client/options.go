@@ -0,0 +1,176 @@
+package client
+
+import (
+	"crypto/tls"
+	"time"
+
+	"github.com/pankaj/simple-chat/netopt"
+)
+
+// Option configures a ChatClient.
+type Option func(*ChatClient)
+
+// WithReconnect makes the client reconnect and rejoin after the connection
+// is lost instead of ending. Messages sent in the meantime are queued and
+// flushed once the connection is back. A server that is going away or
+// moving the client with RECONNECT or MIGRATE is followed to the address
+// it names. Without this option a lost connection closes Messages.
+func WithReconnect(p ReconnectPolicy) Option {
+	return func(c *ChatClient) {
+		if p.MinDelay <= 0 {
+			p.MinDelay = DefaultReconnectPolicy.MinDelay
+		}
+		if p.MaxDelay < p.MinDelay {
+			p.MaxDelay = max(p.MinDelay, DefaultReconnectPolicy.MaxDelay)
+		}
+		c.reconnect = &p
+	}
+}
+
+// WithQueueLimit sets how many outbound messages are kept while
+// reconnecting. The default is 100.
+func WithQueueLimit(n int) Option {
+	return func(c *ChatClient) {
+		c.queueLimit = n
+	}
+}
+
+// WithHeartbeat makes the client send a PING every interval and treat the
+// connection as dead when nothing at all has been received for timeout,
+// which defaults to three intervals. A dead connection is reconnected if
+// WithReconnect is set and otherwise ends the client.
+func WithHeartbeat(interval, timeout time.Duration) Option {
+	return func(c *ChatClient) {
+		if interval <= 0 {
+			return
+		}
+		if timeout <= 0 {
+			timeout = 3 * interval
+		}
+		c.heartbeat = &heartbeat{interval: interval, timeout: timeout}
+	}
+}
+
+// WithAckTimeout sets how long SendAcked waits for the server to confirm a
+// message before sending it again, and how many times it retries. The
+// defaults are five seconds and three retries.
+func WithAckTimeout(timeout time.Duration, retries int) Option {
+	return func(c *ChatClient) {
+		if timeout > 0 {
+			c.ackTimeout = timeout
+		}
+		c.ackRetries = max(retries, 0)
+	}
+}
+
+// WithIgnored starts the client ignoring messages from names. If save is
+// not nil it is called with the full list whenever /ignore or /unignore
+// changes it, so the list can be persisted.
+func WithIgnored(names []string, save func(ignored []string) error) Option {
+	return func(c *ChatClient) {
+		for _, name := range names {
+			c.ignored[name] = true
+		}
+		c.saveIgnore = save
+	}
+}
+
+// WithAlerts sets the initial alert rules. They can be changed later with
+// AddAlert, RemoveAlert or the /alerts command.
+func WithAlerts(rules ...AlertRule) Option {
+	return func(c *ChatClient) {
+		c.alerts = append(c.alerts, rules...)
+	}
+}
+
+// WithTemplates formats chat messages, joins and leaves with the
+// templates in t instead of the built-in formats.
+func WithTemplates(t Templates) Option {
+	return func(c *ChatClient) {
+		c.templates = &t
+	}
+}
+
+// WithPlugins adds plugins to the client. They are started in order once
+// it has joined and stopped when it is closed.
+func WithPlugins(plugins ...Plugin) Option {
+	return func(c *ChatClient) {
+		c.plugins = append(c.plugins, plugins...)
+	}
+}
+
+// WithE2E turns on end-to-end encryption with keys. Chat messages are
+// encrypted for every user who has announced a key and messages that
+// can't be decrypted are dropped, so the server only relays ciphertext.
+// Plain messages from users without encryption are still delivered.
+func WithE2E(keys *KeyPair) Option {
+	return func(c *ChatClient) {
+		c.e2e = newE2E(keys)
+	}
+}
+
+// WithScrollback sets how many messages are kept for review with /scroll
+// and History. The default is 500; zero keeps none.
+func WithScrollback(n int) Option {
+	return func(c *ChatClient) {
+		c.scrollback.limit = n
+	}
+}
+
+// WithChallengeAnswer sets how the client answers questions a server asks
+// before accepting its JOIN (see protocol.ChallengeQuestion), typically
+// by prompting the user. Answers are remembered for reconnects. Proofs of
+// work are solved automatically; without this option, joining a server
+// that asks a question fails with ErrChallenge.
+func WithChallengeAnswer(ask func(question string) (string, error)) Option {
+	return func(c *ChatClient) {
+		c.ask = ask
+	}
+}
+
+// WithTLSConfig sets the TLS configuration used by the quic:// transport,
+// for instance to trust a private CA. By default the system roots are
+// trusted and the server name is taken from the address.
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *ChatClient) {
+		c.transport.tls = cfg
+	}
+}
+
+// WithTCPOptions tunes the connection to the server when it is plain TCP:
+// keep-alives, Nagle's algorithm, socket buffers and linger. It has no
+// effect on the WebSocket and QUIC transports.
+func WithTCPOptions(o netopt.TCP) Option {
+	return func(c *ChatClient) {
+		c.transport.tcp = o
+	}
+}
+
+// WithUploadURL lets /attach upload files to the attachment endpoint of
+// the server whose web address is baseURL, such as
+// "https://chat.example.com".
+func WithUploadURL(baseURL string) Option {
+	return func(c *ChatClient) {
+		c.uploadURL = baseURL
+	}
+}
+
+// WithLocale asks the server to send its errors and notices in the
+// language named by the BCP 47 tag locale, such as "de" or "pt-BR".
+// Servers without a translation answer in English.
+func WithLocale(locale string) Option {
+	return func(c *ChatClient) {
+		c.locale = locale
+	}
+}
+
+// WithRename asks the server to admit the client under a derived name,
+// such as "alice_2", if the username is taken, instead of refusing the
+// JOIN. Username reports the name granted. Reconnects ask for that name
+// again without renaming, so it doesn't change once New returns. Servers
+// that predate renaming refuse the JOIN as an invalid username.
+func WithRename() Option {
+	return func(c *ChatClient) {
+		c.rename = true
+	}
+}
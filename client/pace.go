@@ -0,0 +1,76 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+	"github.com/pankaj/simple-chat/ratelimit"
+)
+
+// paceMargin is the share of the server's advertised rate the client
+// sends at, leaving room for messages that bunch up in transit.
+const paceMargin = 0.9
+
+// pacer returns a bucket that keeps chat messages within the rate limit
+// listed in caps, or nil if caps lists none. It starts full, like the
+// server's bucket for a new connection.
+func pacer(caps []string) *ratelimit.Bucket {
+	rate, burst, ok := protocol.ParseRateCapability(caps)
+	if !ok {
+		return nil
+	}
+	return ratelimit.NewBucket(rate*paceMargin, burst)
+}
+
+// wait blocks until the server's rate limit allows another chat message,
+// so that bursts are spread out rather than refused. It returns ErrClosed
+// or ctx's error if either ends the wait first.
+func (c *ChatClient) wait(ctx context.Context) error {
+	c.mu.Lock()
+	pace := c.pace
+	c.mu.Unlock()
+	if pace == nil {
+		return nil
+	}
+	if err := pace.Wait(ctx); err != nil {
+		if errors.Is(err, context.Canceled) && c.ctx.Err() != nil {
+			return ErrClosed
+		}
+		return err
+	}
+	return nil
+}
+
+// drainQueue sends, at the pace of the server's rate limit, the queued
+// messages that resume left over because they would have gone over it.
+// It stops once the queue is empty or the connection is lost, in which
+// case the next resume carries on.
+func (c *ChatClient) drainQueue(pace *ratelimit.Bucket) {
+	for {
+		if pace.Wait(c.ctx) != nil {
+			return
+		}
+		c.mu.Lock()
+		if !c.connected || c.pace != pace || len(c.queue) == 0 {
+			c.mu.Unlock()
+			return
+		}
+		body := c.queue[0]
+		err := c.write(protocol.Message{Type: protocol.TypeSend, Body: body})
+		switch {
+		case errors.Is(err, ErrEncrypt):
+			c.reportError(fmt.Errorf("dropping queued message: %w", err))
+		case err != nil:
+			// The receive loop notices too and reconnects.
+			c.mu.Unlock()
+			return
+		default:
+			c.record(protocol.Message{Type: protocol.TypeMsg, Username: c.username, Body: body, Received: time.Now()})
+		}
+		c.queue = c.queue[1:]
+		c.mu.Unlock()
+	}
+}
@@ -0,0 +1,90 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// rateOK is an OK advertising a limit of 10 messages a second in bursts
+// of 2.
+var rateOK = protocol.Message{Type: protocol.TypeOK, Body: protocol.RateCapability(10, 2)}
+
+func TestSendMessagePaces(t *testing.T) {
+	received := make(chan string, 10)
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Expect(protocol.TypeJoin),
+		chatmock.Send(rateOK),
+		chatmock.Lines(received),
+	}).Addr()
+
+	c, err := New(addr, "bot")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	start := time.Now()
+	for _, body := range []string{"one", "two", "three", "four"} {
+		if err := c.SendMessage(body); err != nil {
+			t.Fatalf("SendMessage(%q) error = %v", body, err)
+		}
+	}
+	// The burst goes at once; the other two wait about 1/9s each.
+	if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+		t.Errorf("four messages sent in %v, want at least 200ms", elapsed)
+	}
+	for _, want := range []string{"SEND|one", "SEND|two", "SEND|three", "SEND|four"} {
+		if got := <-received; got != want {
+			t.Errorf("server got %q, want %q", got, want)
+		}
+	}
+}
+
+func TestResumePacesQueue(t *testing.T) {
+	received := make(chan string, 10)
+	rejoin := make(chan struct{})
+	addr := chatmock.New(t,
+		chatmock.Script{chatmock.Expect(protocol.TypeJoin), chatmock.Send(rateOK), chatmock.Drop()},
+		chatmock.Script{
+			chatmock.Wait(rejoin),
+			chatmock.Expect(protocol.TypeJoin),
+			chatmock.Send(rateOK),
+			chatmock.Lines(received),
+		},
+	).Addr()
+
+	c, err := New(addr, "bot", WithReconnect(ReconnectPolicy{MinDelay: 10 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	expectMessage(t, c, TypeDisconnected)
+	for _, body := range []string{"one", "two", "three", "four"} {
+		if err := c.SendMessage(body); !errors.Is(err, ErrQueued) {
+			t.Fatalf("SendMessage(%q) while offline = %v, want ErrQueued", body, err)
+		}
+	}
+	close(rejoin)
+	if msg := expectMessage(t, c, TypeReconnected); msg.Body != "2" {
+		t.Errorf("reconnected having sent %s queued messages, want the burst of 2", msg.Body)
+	}
+	// Sent after the ones still queued, not before.
+	if err := c.SendMessage("five"); !errors.Is(err, ErrQueued) {
+		t.Errorf("SendMessage() while draining the queue = %v, want ErrQueued", err)
+	}
+	for _, want := range []string{"SEND|one", "SEND|two", "SEND|three", "SEND|four", "SEND|five"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("server got %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// latencySamples is how many recent round trips Latency summarizes.
+const latencySamples = 10
+
+// Latency summarizes recent round-trip times to the server, measured by
+// Ping and by heartbeats.
+type Latency struct {
+	Last, Min, Avg, Max time.Duration
+	Samples             int
+}
+
+// pingBody stamps a PING with its send time so the PONG, which echoes the
+// body, can be timed without keeping state.
+func pingBody(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Ping sends a PING and waits for the server's PONG, returning the round
+// trip time.
+func (c *ChatClient) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	body := pingBody(start)
+	key := "pong:" + body
+
+	reply := make(chan protocol.Message, 1)
+	c.mu.Lock()
+	c.pending[key] = reply
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		delete(c.pending, key)
+		c.mu.Unlock()
+	}()
+
+	if err := c.send(protocol.Message{Type: protocol.TypePing, Body: body}); err != nil {
+		return 0, err
+	}
+	select {
+	case <-reply:
+		return time.Since(start), nil
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-c.done:
+		return 0, ErrClosed
+	}
+}
+
+// Latency returns statistics over the most recent round trips. Samples is
+// zero until the first PONG arrives.
+func (c *ChatClient) Latency() Latency {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var l Latency
+	if len(c.rtts) == 0 {
+		return l
+	}
+	var total time.Duration
+	l.Min = c.rtts[0]
+	for _, rtt := range c.rtts {
+		total += rtt
+		l.Min = min(l.Min, rtt)
+		l.Max = max(l.Max, rtt)
+	}
+	l.Last = c.rtts[len(c.rtts)-1]
+	l.Avg = total / time.Duration(len(c.rtts))
+	l.Samples = len(c.rtts)
+	return l
+}
+
+// handlePong records the round trip a PONG completes and wakes the Ping
+// call waiting for it, if any. PONGs are never delivered on Messages.
+func (c *ChatClient) handlePong(msg protocol.Message) {
+	if sent, err := strconv.ParseInt(msg.Body, 10, 64); err == nil {
+		rtt := time.Since(time.Unix(0, sent))
+		c.mu.Lock()
+		if len(c.rtts) == latencySamples {
+			c.rtts = c.rtts[1:]
+		}
+		c.rtts = append(c.rtts, rtt)
+		c.mu.Unlock()
+	}
+	c.resolve("pong:"+msg.Body, msg)
+}
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// pongServer answers every PING with a PONG echoing its body.
+func pongServer(t *testing.T) string {
+	return chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Each(func(c *chatmock.Conn, msg protocol.Message) error {
+			if msg.Type != protocol.TypePing {
+				return nil
+			}
+			return c.Send(protocol.Message{Type: protocol.TypePong, Body: msg.Body})
+		}),
+	}).Addr()
+}
+
+func TestPing(t *testing.T) {
+	c, err := New(pongServer(t), "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if l := c.Latency(); l.Samples != 0 {
+		t.Errorf("Latency() before any ping = %+v", l)
+	}
+
+	for i := 0; i < latencySamples+2; i++ {
+		rtt, err := c.Ping(context.Background())
+		if err != nil {
+			t.Fatalf("Ping() error = %v", err)
+		}
+		if rtt <= 0 {
+			t.Errorf("Ping() = %v, want a positive duration", rtt)
+		}
+	}
+
+	l := c.Latency()
+	if l.Samples != latencySamples {
+		t.Errorf("Latency().Samples = %d, want %d", l.Samples, latencySamples)
+	}
+	if l.Min > l.Avg || l.Avg > l.Max || l.Last <= 0 {
+		t.Errorf("inconsistent latency stats: %+v", l)
+	}
+	if msg, err := c.TryReceive(); err != ErrNoMessage {
+		t.Errorf("PONG leaked onto Messages: %+v, %v", msg, err)
+	}
+}
+
+func TestPingCommand(t *testing.T) {
+	c, err := New(pongServer(t), "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var out strings.Builder
+	c.HandleInput(&out, "/ping")
+	if !strings.HasPrefix(out.String(), "Pong in ") || !strings.Contains(out.String(), "last 1:") {
+		t.Errorf("/ping output = %q", out.String())
+	}
+}
+
+func TestPingTimeout(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join()}).Addr()
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := c.Ping(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Ping() error = %v, want context.DeadlineExceeded", err)
+	}
+}
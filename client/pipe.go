@@ -0,0 +1,58 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Pipe runs the client non-interactively: every non-empty line read from in
+// is sent verbatim as a message, and every received message is written to
+// out on its own line. Lines are queued while the client reconnects. At
+// EOF on in the client leaves the chat and Pipe returns nil. It returns an
+// error if a message can't be sent or the connection is lost.
+func (c *ChatClient) Pipe(in io.Reader, out io.Writer) error {
+	lines := make(chan string)
+	readErr := make(chan error, 1)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				c.Close()
+				return <-readErr
+			}
+			if line == "" {
+				continue
+			}
+			if err := c.SendMessage(line); err != nil && !errors.Is(err, ErrQueued) {
+				c.Close()
+				return fmt.Errorf("sending %q: %w", line, err)
+			}
+
+		case msg, ok := <-c.Messages():
+			if !ok {
+				return c.err
+			}
+			if text, ok := FormatMessage(msg); ok {
+				fmt.Fprintln(out, text)
+			}
+		}
+	}
+}
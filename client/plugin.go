@@ -0,0 +1,186 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Plugin adds a feature to a ChatClient, such as an auto-responder or a
+// logger, from outside this package. Plugins are passed to WithPlugins.
+type Plugin interface {
+	// Start is called once the client has joined, before any message is
+	// received. It may register commands or start goroutines that use c.
+	// An error closes the client and is returned by New.
+	Start(c *ChatClient) error
+
+	// HandleMessage is called from the receive loop with every message
+	// from the server, before it is delivered on Messages. It must return
+	// quickly, so slow work such as replying belongs in a goroutine:
+	// SendAcked, for one, waits on the receive loop and would never return.
+	HandleMessage(msg protocol.Message)
+
+	// Stop is called when the client is closed.
+	Stop()
+}
+
+// startPlugins starts c's plugins in order. If one fails, only those
+// started before it are kept, for Close to stop.
+func (c *ChatClient) startPlugins() error {
+	for i, p := range c.plugins {
+		if err := p.Start(c); err != nil {
+			c.plugins = c.plugins[:i]
+			return fmt.Errorf("starting plugin: %w", err)
+		}
+	}
+	return nil
+}
+
+const (
+	// pluginQueue is how many messages a ProcessPlugin holds for its
+	// program before it drops them.
+	pluginQueue = 100
+
+	// pluginStopTimeout is how long a ProcessPlugin's program has to exit
+	// once its stdin is closed.
+	pluginStopTimeout = 2 * time.Second
+)
+
+// ProcessPlugin is a Plugin that runs an external program, talking to it
+// over its standard input and output in the chat protocol:
+//
+//   - every message from the server is written to the program's stdin on
+//     a line of its own, as the server sent it, such as "MSG|bob|hi";
+//   - every "SEND|text" line the program writes to stdout is sent as a
+//     chat message. Other lines are reported as errors and ignored.
+//
+// Closing the client closes the program's stdin. It should exit then,
+// and is killed if it hasn't within two seconds.
+type ProcessPlugin struct {
+	Path   string
+	Args   []string
+	Stderr io.Writer // receives the program's stderr; discarded if nil
+
+	c    *ChatClient
+	cmd  *exec.Cmd
+	done chan struct{} // closed once the program has exited
+
+	mu      sync.Mutex
+	lines   chan string // to the program's stdin
+	stopped bool
+}
+
+// NewProcessPlugin returns a plugin that runs the program at path with
+// args, looked up in PATH if path has no slashes.
+func NewProcessPlugin(path string, args ...string) *ProcessPlugin {
+	return &ProcessPlugin{Path: path, Args: args}
+}
+
+// name identifies the plugin in errors.
+func (p *ProcessPlugin) name() string {
+	return filepath.Base(p.Path)
+}
+
+// Start runs the program.
+func (p *ProcessPlugin) Start(c *ChatClient) error {
+	p.c = c
+	p.cmd = exec.Command(p.Path, p.Args...)
+	p.cmd.Stderr = p.Stderr
+	stdin, err := p.cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	stdout, err := p.cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := p.cmd.Start(); err != nil {
+		return err
+	}
+	p.lines = make(chan string, pluginQueue)
+	p.done = make(chan struct{})
+
+	go func() {
+		for line := range p.lines {
+			// Once the program exits, writes fail; keep draining.
+			fmt.Fprintln(stdin, line)
+		}
+		stdin.Close()
+	}()
+	go func() {
+		defer close(p.done)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			p.handleLine(scanner.Text())
+		}
+		err := p.cmd.Wait()
+		p.mu.Lock()
+		stopped := p.stopped
+		p.mu.Unlock()
+		if !stopped {
+			c.reportError(fmt.Errorf("plugin %s exited: %v", p.name(), err))
+		}
+	}()
+	return nil
+}
+
+// handleLine acts on a line the program wrote to stdout.
+func (p *ProcessPlugin) handleLine(line string) {
+	msg, err := protocol.Decode(line)
+	if err == nil && msg.Type != protocol.TypeSend {
+		err = fmt.Errorf("%s isn't supported", msg.Type)
+	}
+	if err != nil {
+		p.c.reportError(fmt.Errorf("plugin %s: %q: %w", p.name(), line, err))
+		return
+	}
+	if err := p.c.SendMessage(msg.Body); err != nil && !errors.Is(err, ErrQueued) && !errors.Is(err, ErrClosed) {
+		p.c.reportError(fmt.Errorf("plugin %s: %w", p.name(), err))
+	}
+}
+
+// HandleMessage passes msg to the program. Messages that only exist in
+// the client, such as TypeDisconnected, are not passed on, and nor are
+// messages that arrive while the program is behind by pluginQueue.
+func (p *ProcessPlugin) HandleMessage(msg protocol.Message) {
+	line := protocol.Encode(msg)
+	if line == "" {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return
+	}
+	select {
+	case p.lines <- line:
+	default:
+		p.c.reportError(fmt.Errorf("plugin %s is falling behind; dropped %s", p.name(), msg.Type))
+	}
+}
+
+// Stop closes the program's stdin and waits for it to exit.
+func (p *ProcessPlugin) Stop() {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return
+	}
+	p.stopped = true
+	close(p.lines)
+	p.mu.Unlock()
+
+	select {
+	case <-p.done:
+	case <-time.After(pluginStopTimeout):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
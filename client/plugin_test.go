@@ -0,0 +1,120 @@
+package client
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// echoPlugin answers "ping" with "pong" and registers /pings.
+type echoPlugin struct {
+	pings   int
+	stopped chan struct{}
+}
+
+func (p *echoPlugin) Start(c *ChatClient) error {
+	p.stopped = make(chan struct{})
+	return c.Commands().Register(Command{Name: "pings", Run: func(c *ChatClient, out io.Writer, args string) error {
+		fmt.Fprintln(out, p.pings)
+		return nil
+	}})
+}
+
+func (p *echoPlugin) HandleMessage(msg protocol.Message) {
+	if msg.Type == protocol.TypeMsg && msg.Body == "ping" {
+		p.pings++
+	}
+}
+
+func (p *echoPlugin) Stop() { close(p.stopped) }
+
+func TestPlugins(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.SendLine("MSG|bob|ping"),
+		chatmock.Expect(protocol.TypeLeave),
+	}).Addr()
+
+	p := &echoPlugin{}
+	c, err := New(addr, "alice", WithPlugins(p))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if msg, err := c.Receive(); err != nil || msg.Body != "ping" {
+		t.Fatalf("Receive() = %+v, %v; want the ping", msg, err)
+	}
+	var out strings.Builder
+	c.HandleInput(&out, "/pings")
+	if out.String() != "1\n" {
+		t.Errorf("/pings output = %q, want 1", out.String())
+	}
+
+	c.Close()
+	select {
+	case <-p.stopped:
+	default:
+		t.Error("Close didn't stop the plugin")
+	}
+}
+
+// TestPluginProcess isn't a real test: TestProcessPlugin runs the test
+// binary with it as a plugin program, which answers "ping" with "pong".
+func TestPluginProcess(t *testing.T) {
+	if os.Getenv("CHAT_TEST_PLUGIN") == "" {
+		t.Skip("run by TestProcessPlugin")
+	}
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if scanner.Text() == "MSG|bob|ping" {
+			fmt.Println("SEND|pong")
+			fmt.Println("LEAVE")
+		}
+	}
+	os.Exit(0)
+}
+
+func TestProcessPlugin(t *testing.T) {
+	t.Setenv("CHAT_TEST_PLUGIN", "1")
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.SendLine("MSG|bob|ping"),
+		chatmock.ExpectMessage(protocol.Message{Type: protocol.TypeSend, Body: "pong"}),
+		chatmock.Expect(protocol.TypeLeave),
+	}).Addr()
+
+	p := NewProcessPlugin(os.Args[0], "-test.run=^TestPluginProcess$")
+	c, err := New(addr, "alice", WithPlugins(p))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	// The program can only send chat messages.
+	select {
+	case err := <-c.Errors():
+		if !strings.Contains(err.Error(), "LEAVE isn't supported") {
+			t.Errorf("error = %v, want LEAVE refused", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the plugin")
+	}
+}
+
+func TestProcessPluginMissing(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Expect(protocol.TypeLeave),
+	}).Addr()
+
+	_, err := New(addr, "alice", WithPlugins(NewProcessPlugin("/nonexistent/plugin")))
+	if err == nil || !strings.Contains(err.Error(), "starting plugin") {
+		t.Errorf("New() error = %v, want the plugin failing to start", err)
+	}
+}
@@ -0,0 +1,181 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Away marks the user as away, with an optional note shown to others. The
+// status is restored after a reconnect until Back is called.
+func (c *ChatClient) Away(note string) error {
+	if !protocol.ValidText(note) {
+		return ErrInvalidBody
+	}
+	if err := c.send(protocol.Message{Type: protocol.TypeAway, Body: note}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.away = &note
+	c.mu.Unlock()
+	return nil
+}
+
+// Back marks the user as present again.
+func (c *ChatClient) Back() error {
+	if err := c.send(protocol.Message{Type: protocol.TypeBack}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.away = nil
+	c.mu.Unlock()
+	return nil
+}
+
+// SetStatus sets the user's status text, such as "in a meeting", which
+// others see in WHO listings and /whois; an empty text clears it. With a
+// non-zero expiry the server clears the status once that much time has
+// passed. The status is restored after a reconnect until it's cleared or
+// expires.
+func (c *ChatClient) SetStatus(text string, expiry time.Duration) error {
+	if !protocol.ValidText(text) || expiry < 0 {
+		return ErrInvalidBody
+	}
+	st := protocol.Status{Text: text, Expiry: expiry}
+	if err := c.send(protocol.Message{Type: protocol.TypeStatus, Body: protocol.EncodeStatus(st)}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.status, c.statusEnd = st, time.Time{}
+	if text != "" && expiry > 0 {
+		c.statusEnd = time.Now().Add(expiry)
+	}
+	c.mu.Unlock()
+	return nil
+}
+
+// Status returns the status text name last set, or "" if they have none.
+func (c *ChatClient) Status(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.statuses[name]
+}
+
+// SetDND turns do-not-disturb on or off. While it's on the server holds
+// back chat messages that don't mention the user, along with joins, leaves
+// and presence changes, and replays them when it's turned off. The
+// setting is restored after a reconnect, but anything held back when the
+// connection dropped is lost.
+func (c *ChatClient) SetDND(on bool) error {
+	body := "off"
+	if on {
+		body = "on"
+	}
+	if err := c.send(protocol.Message{Type: protocol.TypeDND, Body: body}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.dnd = on
+	c.mu.Unlock()
+	return nil
+}
+
+// DND reports whether do-not-disturb is on.
+func (c *ChatClient) DND() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.dnd
+}
+
+// SetNotify tells the server which chat messages to push to this client:
+// protocol.NotifyAll, NotifyMentions or NotifyNone. Bots that only answer
+// messages addressed to them can use NotifyMentions. Joins, leaves,
+// presence changes and notices are always delivered. The level is
+// restored after a reconnect.
+func (c *ChatClient) SetNotify(level string) error {
+	switch level {
+	case protocol.NotifyAll, protocol.NotifyMentions, protocol.NotifyNone:
+	default:
+		return ErrInvalidNotifyLevel
+	}
+	if err := c.send(protocol.Message{Type: protocol.TypeNotify, Body: level}); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.notify = level
+	c.mu.Unlock()
+	return nil
+}
+
+// Notify returns the notification level set with SetNotify, which is
+// protocol.NotifyAll until changed.
+func (c *ChatClient) Notify() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.notify == "" {
+		return protocol.NotifyAll
+	}
+	return c.notify
+}
+
+// Presence returns the last known presence of name. Users the client has
+// heard nothing about are reported as present.
+func (c *ChatClient) Presence(name string) protocol.Presence {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if note, ok := c.presence[name]; ok {
+		return protocol.Presence{Away: true, Note: note}
+	}
+	return protocol.Presence{}
+}
+
+// Format is like FormatMessage, but also shows what the client knows
+// about presence: away users are marked in WHO replies and next to the
+// author of a message, and WHO replies show users' status texts. Messages
+// with one of the client's Templates are formatted with it instead.
+func (c *ChatClient) Format(msg protocol.Message) (string, bool) {
+	if text, ok := c.formatTemplate(msg); ok {
+		return text, true
+	}
+	switch msg.Type {
+	case protocol.TypeMsg:
+		if tag := c.presenceTag(msg.Username); tag != "" {
+			return fmt.Sprintf("[%s %s]: %s", msg.Username, tag, msg.Body), true
+		}
+	case protocol.TypeAttached:
+		if tag := c.presenceTag(msg.Username); tag != "" {
+			return fmt.Sprintf("[%s %s]: %s", msg.Username, tag, attachText(msg)), true
+		}
+	case protocol.TypeWho:
+		var names []string
+		if msg.Body != "" {
+			names = strings.Split(msg.Body, "|")
+		}
+		for i, name := range names {
+			if tag := c.presenceTag(name); tag != "" {
+				names[i] += " " + tag
+			}
+			if status := c.Status(name); status != "" {
+				names[i] += fmt.Sprintf(" %q", status)
+			}
+		}
+		return fmt.Sprintf("Online (%d): %s", len(names), strings.Join(names, ", ")), true
+	}
+	return FormatMessage(msg)
+}
+
+// presenceTag returns "(away)" or "(away: note)" for away users and ""
+// otherwise.
+func (c *ChatClient) presenceTag(name string) string {
+	p := c.Presence(name)
+	switch {
+	case !p.Away:
+		return ""
+	case p.Note == "":
+		return "(away)"
+	default:
+		return "(away: " + p.Note + ")"
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestPresence(t *testing.T) {
+	got := make(chan string, 2)
+	next := make(chan struct{})
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.SendLine("PRESENCE|bob|away|lunch"),
+		chatmock.SendLine("WHO|alice|bob|carol"),
+		chatmock.SendLine("MSG|bob|back in 5"),
+		chatmock.Wait(next),
+		chatmock.SendLine("PRESENCE|bob|here"),
+		chatmock.Lines(got),
+	}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var lines []string
+	for i := 0; i < 3; i++ {
+		msg, err := c.Receive()
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		text, _ := c.Format(msg)
+		lines = append(lines, text)
+	}
+	want := []string{
+		"* bob is away: lunch *",
+		"Online (3): alice, bob (away: lunch), carol",
+		"[bob (away: lunch)]: back in 5",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatted =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	var out strings.Builder
+	c.HandleInput(&out, "/whois @bob")
+	close(next)
+	if msg, _ := c.Receive(); msg.Type != protocol.TypePresence {
+		t.Fatalf("expected PRESENCE, got %+v", msg)
+	}
+	c.HandleInput(&out, "/whois bob")
+	c.HandleInput(&out, "/whois")
+	if want := "bob is away: lunch.\nbob is here.\nalice is here.\n"; out.String() != want {
+		t.Errorf("/whois output = %q, want %q", out.String(), want)
+	}
+
+	c.HandleInput(&out, "/away brb")
+	c.HandleInput(&out, "/back")
+	for _, want := range []string{"AWAY|brb", "BACK"} {
+		if line := <-got; line != want {
+			t.Errorf("server got %q, want %q", line, want)
+		}
+	}
+}
+
+func TestStatus(t *testing.T) {
+	got := make(chan string, 3)
+	next := make(chan struct{})
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.SendLine("USERSTATUS|bob|in a meeting"),
+		chatmock.SendLine("WHO|alice|bob"),
+		chatmock.Wait(next),
+		chatmock.SendLine("USERSTATUS|bob"),
+		chatmock.Lines(got),
+	}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var lines []string
+	for i := 0; i < 2; i++ {
+		msg, err := c.Receive()
+		if err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+		text, _ := c.Format(msg)
+		lines = append(lines, text)
+	}
+	want := []string{"* bob's status: in a meeting *", `Online (2): alice, bob "in a meeting"`}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("formatted =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+
+	var out strings.Builder
+	c.HandleInput(&out, "/whois bob")
+	close(next)
+	if msg, _ := c.Receive(); msg.Type != protocol.TypeUserStatus || c.Status("bob") != "" {
+		t.Fatalf("expected bob's status to be cleared, got %+v", msg)
+	}
+	if want := "bob is here.\nStatus: in a meeting\n"; out.String() != want {
+		t.Errorf("/whois output = %q, want %q", out.String(), want)
+	}
+
+	c.HandleInput(&out, "/status +1h on call")
+	c.HandleInput(&out, "/status")
+	c.HandleInput(&out, "/status +soon on call")
+	for _, want := range []string{"STATUS|3600|on call", "STATUS"} {
+		if line := <-got; line != want {
+			t.Errorf("server got %q, want %q", line, want)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "usage: /status [+<duration>] [text]\n") {
+		t.Errorf("output = %q, want a usage error", out.String())
+	}
+}
+
+func TestDND(t *testing.T) {
+	got := make(chan string, 3)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(got)}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var out strings.Builder
+	c.HandleInput(&out, "/dnd")
+	c.HandleInput(&out, "/whois")
+	c.HandleInput(&out, "/dnd off")
+	for _, want := range []string{"DND|on", "DND|off"} {
+		if line := <-got; line != want {
+			t.Errorf("server got %q, want %q", line, want)
+		}
+	}
+	if !strings.Contains(out.String(), "alice is here.\nDo-not-disturb is on.\n") || c.DND() {
+		t.Errorf("output = %q, DND() = %v", out.String(), c.DND())
+	}
+}
+
+func TestNotify(t *testing.T) {
+	got := make(chan string, 2)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(got)}).Addr()
+
+	c, err := New(addr, "bot")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	if err := c.SetNotify("loud"); !errors.Is(err, ErrInvalidNotifyLevel) {
+		t.Errorf("SetNotify(loud) error = %v, want ErrInvalidNotifyLevel", err)
+	}
+	var out strings.Builder
+	c.HandleInput(&out, "/notify mentions")
+	c.HandleInput(&out, "/whois")
+	if line := <-got; line != "NOTIFY|mentions" {
+		t.Errorf("server got %q, want NOTIFY|mentions", line)
+	}
+	if want := "bot is here.\nNotifications: mentions.\n"; !strings.Contains(out.String(), want) {
+		t.Errorf("output = %q, want it to contain %q", out.String(), want)
+	}
+	if c.Notify() != protocol.NotifyMentions {
+		t.Errorf("Notify() = %q, want mentions", c.Notify())
+	}
+}
@@ -0,0 +1,200 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Local message types are generated by the client itself and delivered on
+// Messages alongside server messages. They never appear on the wire.
+const (
+	// TypeDisconnected reports that the connection was lost and the client
+	// is reconnecting. Body holds the reason.
+	TypeDisconnected = "DISCONNECTED"
+
+	// TypeReconnected reports that the connection was restored. Body holds
+	// the number of queued messages that were sent.
+	TypeReconnected = "RECONNECTED"
+)
+
+// ReconnectPolicy controls how a client recovers from a lost connection.
+type ReconnectPolicy struct {
+	// MinDelay is the wait before the first attempt. It doubles after
+	// every failed attempt, up to MaxDelay.
+	MinDelay time.Duration
+	MaxDelay time.Duration
+	// MaxAttempts is how many attempts to make before giving up; 0 keeps
+	// trying until the client is closed.
+	MaxAttempts int
+}
+
+// DefaultReconnectPolicy retries forever, backing off from half a second
+// to thirty seconds.
+var DefaultReconnectPolicy = ReconnectPolicy{
+	MinDelay: 500 * time.Millisecond,
+	MaxDelay: 30 * time.Second,
+}
+
+// defaultQueueLimit bounds how many outbound messages are kept while
+// reconnecting.
+const defaultQueueLimit = 100
+
+// enqueue keeps body for sending after a reconnect. c.mu must be held.
+func (c *ChatClient) enqueue(body string) error {
+	if len(c.queue) >= c.queueLimit {
+		return ErrQueueFull
+	}
+	c.queue = append(c.queue, body)
+	return ErrQueued
+}
+
+// redial marks the client offline and reconnects according to the policy.
+// cause is why the previous connection ended. It returns nil once a new
+// connection is in place, or the error to end the client with.
+func (c *ChatClient) redial(cause error) error {
+	c.mu.Lock()
+	c.connected = false
+	c.conn.Close()
+	c.mu.Unlock()
+
+	if !c.deliver(protocol.Message{Type: TypeDisconnected, Body: cause.Error(), Received: time.Now()}) {
+		return ErrClosed
+	}
+
+	delay := c.reconnect.MinDelay
+	c.mu.Lock()
+	if c.migrate != "" {
+		delay = 0 // the server moved us on purpose; go straight away
+	}
+	c.mu.Unlock()
+	for attempt := 1; ; attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-c.done:
+			timer.Stop()
+			return ErrClosed
+		}
+
+		c.mu.Lock()
+		addr := c.addr
+		join := protocol.Message{Type: protocol.TypeJoin, Username: c.username, Locale: c.locale}
+		if c.migrate != "" {
+			// Try the token once; if it's refused, join as usual.
+			join = protocol.Message{Type: protocol.TypeResume, Username: c.username, ID: c.migrate}
+			c.migrate = ""
+		}
+		c.mu.Unlock()
+
+		conn, reader, ok, err := dial(c.ctx, addr, join, c.transport, c.answerChallenge)
+		if err == nil {
+			var flushed int
+			flushed, err = c.resume(conn, reader, ok)
+			if err == nil {
+				if !c.deliver(protocol.Message{Type: TypeReconnected, Body: strconv.Itoa(flushed), Received: time.Now()}) {
+					return ErrClosed
+				}
+				return nil
+			}
+			conn.Close()
+		}
+		if errors.Is(err, ErrJoinRejected) {
+			// Perhaps the operators changed the join question.
+			clear(c.answers)
+		}
+
+		select {
+		case <-c.done:
+			return ErrClosed
+		default:
+		}
+		if c.reconnect.MaxAttempts > 0 && attempt >= c.reconnect.MaxAttempts {
+			return fmt.Errorf("%w (gave up after %d reconnect attempts: %v)", cause, attempt, err)
+		}
+		delay = min(max(2*delay, c.reconnect.MinDelay), c.reconnect.MaxDelay)
+	}
+}
+
+// resume switches the client to a freshly joined connection and sends the
+// queued messages in order. It returns how many were sent; if the server
+// limits the rate of messages, those over its burst are left to
+// drainQueue.
+func (c *ChatClient) resume(conn net.Conn, reader *bufio.Reader, ok protocol.Message) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	select {
+	case <-c.done:
+		return 0, ErrClosed
+	default:
+	}
+
+	c.conn, c.reader = conn, reader
+	c.caps, c.members = protocol.ParseCapabilities(ok.Body), ok.Members
+	c.pace = pacer(c.caps)
+	// The server forgets presence with the old connection and sends a
+	// fresh snapshot after the rejoin.
+	c.presence = make(map[string]string)
+	c.statuses = make(map[string]string)
+	if c.away != nil {
+		if err := c.write(protocol.Message{Type: protocol.TypeAway, Body: *c.away}); err != nil {
+			return 0, err
+		}
+	}
+	if !c.statusEnd.IsZero() {
+		c.status.Expiry = time.Until(c.statusEnd)
+		if c.status.Expiry <= 0 {
+			c.status, c.statusEnd = protocol.Status{}, time.Time{}
+		}
+	}
+	if c.status.Text != "" {
+		if err := c.write(protocol.Message{Type: protocol.TypeStatus, Body: protocol.EncodeStatus(c.status)}); err != nil {
+			return 0, err
+		}
+	}
+	if c.dnd {
+		if err := c.write(protocol.Message{Type: protocol.TypeDND, Body: "on"}); err != nil {
+			return 0, err
+		}
+	}
+	if c.notify != "" && c.notify != protocol.NotifyAll {
+		if err := c.write(protocol.Message{Type: protocol.TypeNotify, Body: c.notify}); err != nil {
+			return 0, err
+		}
+	}
+	if c.e2e != nil {
+		if err := c.writePlain(protocol.Message{Type: protocol.TypeSend, Body: c.e2e.announcement()}); err != nil {
+			return 0, err
+		}
+	}
+	flushed := 0
+	for i, body := range c.queue {
+		if c.pace != nil && !c.pace.Allow() {
+			// Send the rest as the server's rate limit allows.
+			c.queue = c.queue[i:]
+			c.connected = true
+			go c.drainQueue(c.pace)
+			return flushed, nil
+		}
+		err := c.write(protocol.Message{Type: protocol.TypeSend, Body: body})
+		if errors.Is(err, ErrEncrypt) {
+			c.reportError(fmt.Errorf("dropping queued message: %w", err))
+			continue
+		}
+		if err != nil {
+			c.queue = c.queue[i:]
+			return 0, err
+		}
+		c.record(protocol.Message{Type: protocol.TypeMsg, Username: c.username, Body: body, Received: time.Now()})
+		flushed++
+	}
+	c.queue = nil
+	c.connected = true
+	return flushed, nil
+}
@@ -0,0 +1,244 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// acceptJoin accepts one connection on ln, answers its JOIN with OK and
+// returns the connection with a scanner positioned after the JOIN.
+func acceptJoin(t *testing.T, ln net.Listener) (net.Conn, *bufio.Scanner) {
+	t.Helper()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Errorf("accept: %v", err)
+		return nil, nil
+	}
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() || !strings.HasPrefix(scanner.Text(), protocol.TypeJoin) {
+		t.Errorf("expected JOIN, got %q", scanner.Text())
+	}
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
+	return conn, scanner
+}
+
+func expectMessage(t *testing.T, c *ChatClient, typ string) protocol.Message {
+	t.Helper()
+	select {
+	case msg, ok := <-c.Messages():
+		if !ok {
+			t.Fatalf("messages closed while waiting for %s: %v", typ, c.err)
+		}
+		if msg.Type != typ {
+			t.Fatalf("expected %s, got %+v", typ, msg)
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %s", typ)
+	}
+	return protocol.Message{}
+}
+
+func TestReconnectFlushesQueue(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	acceptSecond := make(chan struct{})
+	received := make(chan string, 4)
+	go func() {
+		first, _ := acceptJoin(t, ln)
+		if first == nil {
+			return
+		}
+		first.Close()
+
+		<-acceptSecond
+		second, scanner := acceptJoin(t, ln)
+		if second == nil {
+			return
+		}
+		defer second.Close()
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	c, err := New(ln.Addr().String(), "alice",
+		WithReconnect(ReconnectPolicy{MinDelay: 10 * time.Millisecond}),
+		WithQueueLimit(2))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	expectMessage(t, c, TypeDisconnected)
+	for _, body := range []string{"one", "two"} {
+		if err := c.SendMessage(body); !errors.Is(err, ErrQueued) {
+			t.Fatalf("SendMessage(%q) while offline = %v, want ErrQueued", body, err)
+		}
+	}
+	if err := c.SendMessage("three"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("SendMessage() with full queue = %v, want ErrQueueFull", err)
+	}
+	if err := c.RequestStats(); !errors.Is(err, ErrNotConnected) {
+		t.Errorf("RequestStats() while offline = %v, want ErrNotConnected", err)
+	}
+	if n := c.Queued(); n != 2 {
+		t.Errorf("Queued() = %d, want 2", n)
+	}
+
+	close(acceptSecond)
+	if msg := expectMessage(t, c, TypeReconnected); msg.Body != "2" {
+		t.Errorf("RECONNECTED body = %q, want 2", msg.Body)
+	}
+	if err := c.SendMessage("live"); err != nil {
+		t.Errorf("SendMessage() after reconnect = %v", err)
+	}
+
+	for _, want := range []string{"SEND|one", "SEND|two", "SEND|live"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("server received %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestReconnectCountsOnlySentMessages(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	acceptSecond := make(chan struct{})
+	go func() {
+		first, _ := acceptJoin(t, ln)
+		if first == nil {
+			return
+		}
+		first.Close()
+
+		<-acceptSecond
+		second, scanner := acceptJoin(t, ln)
+		if second == nil {
+			return
+		}
+		defer second.Close()
+		for scanner.Scan() {
+		}
+	}()
+
+	keys, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(ln.Addr().String(), "alice",
+		WithReconnect(ReconnectPolicy{MinDelay: 10 * time.Millisecond}),
+		WithE2E(keys))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	expectMessage(t, c, TypeDisconnected)
+	if err := c.SendMessage("secret"); !errors.Is(err, ErrQueued) {
+		t.Fatalf("SendMessage() while offline = %v, want ErrQueued", err)
+	}
+
+	// No one has announced a key, so the queued message can't be
+	// encrypted and is dropped rather than flushed.
+	close(acceptSecond)
+	if msg := expectMessage(t, c, TypeReconnected); msg.Body != "0" {
+		t.Errorf("RECONNECTED body = %q, want 0", msg.Body)
+	}
+}
+
+func TestReconnectGivesUp(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	go func() {
+		conn, _ := acceptJoin(t, ln)
+		ln.Close()
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	c, err := New(ln.Addr().String(), "alice",
+		WithReconnect(ReconnectPolicy{MinDelay: time.Millisecond, MaxAttempts: 2}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	expectMessage(t, c, TypeDisconnected)
+	_, err = c.Receive()
+	if err == nil || !strings.Contains(err.Error(), "gave up after 2 reconnect attempts") {
+		t.Errorf("Receive() error = %v, want give-up error", err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	from, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer from.Close()
+	to, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer to.Close()
+
+	go func() {
+		conn, _ := acceptJoin(t, from)
+		if conn == nil {
+			return
+		}
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeMigrate, ID: "tok", Body: to.Addr().String()}))
+		conn.Close()
+	}()
+	resumed := make(chan string, 1)
+	go func() {
+		conn, err := to.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		scanner.Scan()
+		resumed <- scanner.Text()
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
+		scanner.Scan() // until the client closes
+	}()
+
+	// A long delay shows that a migration isn't made to wait for it.
+	c, err := New(from.Addr().String(), "alice", WithReconnect(ReconnectPolicy{MinDelay: time.Minute}))
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	expectMessage(t, c, protocol.TypeMigrate)
+	expectMessage(t, c, TypeDisconnected)
+	expectMessage(t, c, TypeReconnected)
+	if got := <-resumed; got != "RESUME|alice|tok" {
+		t.Errorf("target got %q, want RESUME|alice|tok", got)
+	}
+}
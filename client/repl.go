@@ -0,0 +1,240 @@
+package client
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Run starts the interactive REPL on stdin and stdout. Blocks until the
+// user types "/leave", stdin is closed or the server disconnects.
+func (c *ChatClient) Run() {
+	c.RunIO(os.Stdin, os.Stdout)
+}
+
+// RunIO runs the interactive REPL reading commands from in and writing
+// output to out. All output is written from the calling goroutine.
+func (c *ChatClient) RunIO(in io.Reader, out io.Writer) {
+	lines := make(chan string)
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(in)
+		for scanner.Scan() {
+			select {
+			case lines <- scanner.Text():
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	stamps := Timestamper{Layout: c.timeFmt}
+	fmt.Fprint(out, "> ")
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				return
+			}
+			if !c.HandleInput(out, strings.TrimSpace(line)) {
+				return
+			}
+			fmt.Fprint(out, "> ")
+
+		case msg, ok := <-c.Messages():
+			if !ok {
+				fmt.Fprintln(out, "\nDisconnected from server.")
+				return
+			}
+			if text, ok := c.render(msg); ok {
+				sep, line := c.Stamp(&stamps, msg, text)
+				if sep != "" {
+					fmt.Fprintf(out, "\n%s", sep)
+				}
+				fmt.Fprintf(out, "\n%s\n> ", line)
+			}
+		}
+	}
+}
+
+// HandleInput executes one line typed at the prompt. Lines starting with
+// "/" are dispatched through Commands; the older "send <message>", "stats"
+// and "leave" forms are still accepted. It returns false when the prompt
+// should exit.
+func (c *ChatClient) HandleInput(out io.Writer, line string) bool {
+	switch {
+	case line == "":
+		return true
+	case strings.HasPrefix(line, "/"):
+	case line == "leave", line == "stats":
+		line = "/" + line
+	case strings.HasPrefix(line, "send "):
+		line = "/msg " + strings.TrimPrefix(line, "send ")
+	default:
+		fmt.Fprintln(out, "Unknown command. Type /help for a list of commands.")
+		return true
+	}
+
+	name, args, _ := strings.Cut(line[1:], " ")
+	cmd, ok := c.commands.Lookup(name)
+	if !ok && c.Capable(protocol.CapCommands) {
+		// The server may know it; it answers unknown commands itself.
+		if err := c.Command(line); err != nil {
+			fmt.Fprintf(out, "Error: %v\n", err)
+		}
+		return true
+	}
+	if !ok {
+		fmt.Fprintf(out, "Unknown command /%s. Type /help for a list of commands.\n", name)
+		return true
+	}
+	if err := cmd.Run(c, out, strings.TrimSpace(args)); err != nil {
+		if errors.Is(err, ErrQuit) {
+			return false
+		}
+		fmt.Fprintf(out, "Error: %v\n", err)
+	}
+	return true
+}
+
+// render formats msg for the REPL, colored when a theme is set and
+// prefixed with a bell when an alert rule asks for one.
+func (c *ChatClient) render(msg protocol.Message) (string, bool) {
+	alert := c.Alert(msg)
+
+	var text string
+	var ok bool
+	switch {
+	case alert&AlertHighlight != 0 && c.theme != nil:
+		text, ok = c.Format(msg)
+		text = paint(c.theme.Alert, text)
+	case alert&AlertHighlight != 0:
+		text, ok = c.Format(msg)
+		text = "! " + text
+	case c.theme != nil && (msg.Type == protocol.TypeWho || c.templates.template(msg.Type) != nil):
+		text, ok = c.Format(msg)
+	case c.theme != nil:
+		text, ok = c.theme.render(msg, c.username, c.presenceTag(msg.Username), c.Capable(protocol.CapFormat))
+	default:
+		text, ok = c.Format(msg)
+	}
+	if ok && alert&AlertBell != 0 {
+		text = "\a" + text
+	}
+	return text, ok
+}
+
+// FormatMessage renders a server message for the terminal. It returns
+// false for messages that have no visible representation.
+func FormatMessage(msg protocol.Message) (string, bool) {
+	switch msg.Type {
+	case protocol.TypeMsg:
+		return fmt.Sprintf("[%s]: %s", msg.Username, msg.Body), true
+	case protocol.TypeAttached:
+		return fmt.Sprintf("[%s]: %s", msg.Username, attachText(msg)), true
+	case protocol.TypeJoined:
+		return fmt.Sprintf("* %s has joined the chat%s *", msg.Username, online(msg.Members)), true
+	case protocol.TypeLeft:
+		return fmt.Sprintf("* %s has left the chat%s *", msg.Username, online(msg.Members)), true
+	case protocol.TypeErr:
+		return fmt.Sprintf("Error: %s", msg.Body), true
+	case protocol.TypeKicked:
+		return fmt.Sprintf("* Disconnected by server: %s *", msg.Body), true
+	case protocol.TypeNotice:
+		return fmt.Sprintf("* Notice: %s *", msg.Body), true
+	case protocol.TypeDelivered:
+		r, err := protocol.ParseReceipt(msg.Body)
+		if err != nil {
+			return "", false
+		}
+		text := fmt.Sprintf("* Message %s delivered to %s", msg.ID, strings.Join(r.Delivered, ", "))
+		switch {
+		case len(r.Delivered) == 0 && len(r.Missed) == 0:
+			text = fmt.Sprintf("* Message %s had no recipients", msg.ID)
+		case len(r.Delivered) == 0:
+			text = fmt.Sprintf("* Message %s not delivered to %s", msg.ID, strings.Join(r.Missed, ", "))
+		case len(r.Missed) > 0:
+			text += "; not delivered to " + strings.Join(r.Missed, ", ")
+		}
+		return text + " *", true
+	case protocol.TypeReconnect:
+		if msg.Body != "" {
+			return fmt.Sprintf("* Server is restarting, please reconnect to %s *", msg.Body), true
+		}
+		return "* Server is restarting, please reconnect *", true
+	case protocol.TypeMigrate:
+		return fmt.Sprintf("* Server is moving you to %s *", msg.Body), true
+	case protocol.TypeMode:
+		m, err := protocol.ParseRoomMode(msg.Body)
+		switch {
+		case err != nil:
+			return "", false
+		case m.Announce:
+			return "* Announcement mode: only operators can post *", true
+		case m.Slow > 0:
+			return fmt.Sprintf("* Slow mode: you can post once every %s *", m.Slow), true
+		default:
+			return "* Everyone can post freely again *", true
+		}
+	case TypeDisconnected:
+		return fmt.Sprintf("* %s; reconnecting... *", msg.Body), true
+	case TypeReconnected:
+		if msg.Body != "" && msg.Body != "0" {
+			return fmt.Sprintf("* Reconnected; sent %s queued message(s) *", msg.Body), true
+		}
+		return "* Reconnected *", true
+	case protocol.TypeWho:
+		var names []string
+		if msg.Body != "" {
+			names = strings.Split(msg.Body, "|")
+		}
+		return fmt.Sprintf("Online (%d): %s", len(names), strings.Join(names, ", ")), true
+	case TypeKeyChanged:
+		return fmt.Sprintf("* Warning: %s's encryption key changed to %s; verify it with /verify *", msg.Username, msg.Body), true
+	case protocol.TypeSeen:
+		return seenText(msg), true
+	case protocol.TypeUserStatus:
+		if msg.Body == "" {
+			return fmt.Sprintf("* %s cleared their status *", msg.Username), true
+		}
+		return fmt.Sprintf("* %s's status: %s *", msg.Username, msg.Body), true
+	case protocol.TypePresence:
+		p, err := protocol.ParsePresence(msg.Body)
+		switch {
+		case err != nil:
+			return "", false
+		case !p.Away:
+			return fmt.Sprintf("* %s is back *", msg.Username), true
+		case p.Note != "":
+			return fmt.Sprintf("* %s is away: %s *", msg.Username, p.Note), true
+		default:
+			return fmt.Sprintf("* %s is away *", msg.Username), true
+		}
+	case protocol.TypeStats:
+		st, err := protocol.ParseStats(msg.Body)
+		if err != nil {
+			return "", false
+		}
+		return fmt.Sprintf("Server stats: up %s, %d user(s), %d room(s), %d message(s) (%.2f msg/s)",
+			st.Uptime, st.Users, st.Rooms, st.Messages, st.Rate), true
+	default:
+		return "", false
+	}
+}
+
+// online describes a room of members users, as sent in JOINED and LEFT,
+// or returns "" if the server didn't say.
+func online(members int) string {
+	if members == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d online)", members)
+}
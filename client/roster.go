@@ -0,0 +1,107 @@
+package client
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// updateRoster tracks who is online, and how many, from WHO replies and
+// JOINED/LEFT notices, who is away from PRESENCE notices and users'
+// status texts from USERSTATUS notices.
+func (c *ChatClient) updateRoster(msg protocol.Message) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch msg.Type {
+	case protocol.TypeWho:
+		c.roster = make(map[string]bool)
+		if msg.Body != "" {
+			for _, name := range strings.Split(msg.Body, "|") {
+				c.roster[name] = true
+			}
+		}
+		c.members = len(c.roster)
+		for name := range c.presence {
+			if !c.roster[name] {
+				delete(c.presence, name)
+			}
+		}
+		for name := range c.statuses {
+			if !c.roster[name] {
+				delete(c.statuses, name)
+			}
+		}
+	case protocol.TypeJoined:
+		c.roster[msg.Username] = true
+		c.members = msg.Members
+	case protocol.TypeLeft:
+		delete(c.roster, msg.Username)
+		c.members = msg.Members
+		delete(c.presence, msg.Username)
+		delete(c.statuses, msg.Username)
+	case protocol.TypePresence:
+		p, err := protocol.ParsePresence(msg.Body)
+		switch {
+		case err != nil:
+		case p.Away:
+			c.presence[msg.Username] = p.Note
+		default:
+			delete(c.presence, msg.Username)
+		}
+	case protocol.TypeUserStatus:
+		if msg.Body == "" {
+			delete(c.statuses, msg.Username)
+		} else {
+			c.statuses[msg.Username] = msg.Body
+		}
+	}
+}
+
+// Roster returns the users known to be online, sorted. It is seeded by
+// RequestWho and kept current from join and leave notices.
+func (c *ChatClient) Roster() []string {
+	c.mu.Lock()
+	names := make([]string, 0, len(c.roster))
+	for name := range c.roster {
+		names = append(names, name)
+	}
+	c.mu.Unlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Members returns how many users the server last reported in the room,
+// including this client, or 0 if it doesn't report member counts.
+func (c *ChatClient) Members() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.members
+}
+
+// Complete returns the candidates for completing word, sorted: command
+// names for words starting with "/" and online usernames for words
+// starting with "@". Each candidate keeps the leading character.
+func (c *ChatClient) Complete(word string) []string {
+	var names []string
+	switch {
+	case strings.HasPrefix(word, "/"):
+		for _, cmd := range c.commands.List() {
+			names = append(names, cmd.Name)
+		}
+	case strings.HasPrefix(word, "@"):
+		names = c.Roster()
+	default:
+		return nil
+	}
+
+	var matches []string
+	for _, name := range names {
+		if strings.HasPrefix(strings.ToLower(name), strings.ToLower(word[1:])) {
+			matches = append(matches, word[:1]+name)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,87 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestRosterAndComplete(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Send(
+			protocol.Message{Type: protocol.TypeWho, Body: "alice|bob|Bert"},
+			protocol.Message{Type: protocol.TypeJoined, Username: "carol"},
+			protocol.Message{Type: protocol.TypeLeft, Username: "bob"},
+		),
+	}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+	for i := 0; i < 3; i++ {
+		if _, err := c.Receive(); err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+	}
+
+	if got := strings.Join(c.Roster(), ","); got != "Bert,alice,carol" {
+		t.Errorf("Roster() = %q, want Bert,alice,carol", got)
+	}
+
+	tests := []struct {
+		word string
+		want string
+	}{
+		{"@b", "@Bert"},
+		{"@", "@Bert,@alice,@carol"},
+		{"@zed", ""},
+		{"/he", "/help"},
+		{"plain", ""},
+	}
+	for _, tt := range tests {
+		if got := strings.Join(c.Complete(tt.word), ","); got != tt.want {
+			t.Errorf("Complete(%q) = %q, want %q", tt.word, got, tt.want)
+		}
+	}
+	if n := len(c.Complete("/")); n != len(c.Commands().List()) {
+		t.Errorf("Complete(\"/\") returned %d commands, want %d", n, len(c.Commands().List()))
+	}
+}
+
+func TestMembers(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Expect(protocol.TypeJoin),
+		chatmock.Send(protocol.Message{Type: protocol.TypeOK, Members: 2}),
+		chatmock.Expect(protocol.TypeWho),
+		chatmock.Send(
+			protocol.Message{Type: protocol.TypeJoined, Username: "carol", Members: 3},
+			protocol.Message{Type: protocol.TypeLeft, Username: "bob", Members: 2},
+			protocol.Message{Type: protocol.TypeJoined, Username: "dave", Members: 3},
+		),
+	}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+	if n := c.Members(); n != 2 {
+		t.Errorf("Members() after joining = %d, want 2", n)
+	}
+	if err := c.RequestWho(); err != nil {
+		t.Fatalf("RequestWho() error = %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if _, err := c.Receive(); err != nil {
+			t.Fatalf("Receive() error = %v", err)
+		}
+	}
+	if n := c.Members(); n != 3 {
+		t.Errorf("Members() = %d, want 3", n)
+	}
+}
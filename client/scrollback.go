@@ -0,0 +1,112 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// defaultScrollback is how many messages are kept for /scroll unless
+// WithScrollback says otherwise.
+const defaultScrollback = 500
+
+// scrollPage is how many messages /scroll shows at a time.
+const scrollPage = 20
+
+// scrollback keeps the most recent messages for review after they have
+// scrolled off screen.
+type scrollback struct {
+	mu    sync.Mutex
+	limit int
+	msgs  []protocol.Message
+	// start and end bound the page /scroll showed last. Both are
+	// len(msgs) when not scrolled back.
+	start, end int
+}
+
+func (s *scrollback) add(msg protocol.Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.limit <= 0 {
+		return
+	}
+	atEnd := s.start == len(s.msgs)
+	s.msgs = append(s.msgs, msg)
+	if over := len(s.msgs) - s.limit; over > 0 {
+		s.msgs = append(s.msgs[:0], s.msgs[over:]...)
+		s.start, s.end = max(s.start-over, 0), max(s.end-over, 0)
+	}
+	if atEnd {
+		s.start, s.end = len(s.msgs), len(s.msgs)
+	}
+}
+
+// History returns the messages kept in the scrollback, oldest first.
+func (c *ChatClient) History() []protocol.Message {
+	c.scrollback.mu.Lock()
+	defer c.scrollback.mu.Unlock()
+	return append([]protocol.Message(nil), c.scrollback.msgs...)
+}
+
+// scroll implements /scroll. "up", the default, shows the page before the
+// one shown last, "down" the page after it and "end" returns to the
+// newest messages. A number shows that many of the newest messages.
+func scroll(c *ChatClient, out io.Writer, args string) error {
+	s := &c.scrollback
+	s.mu.Lock()
+	var page []protocol.Message
+	switch args {
+	case "", "up":
+		s.end = s.start
+		s.start = max(s.end-scrollPage, 0)
+		page = s.msgs[s.start:s.end]
+	case "down":
+		if s.end >= len(s.msgs) {
+			s.start, s.end = len(s.msgs), len(s.msgs)
+			s.mu.Unlock()
+			fmt.Fprintln(out, "-- end of scrollback --")
+			return nil
+		}
+		s.start = s.end
+		s.end = min(s.start+scrollPage, len(s.msgs))
+		page = s.msgs[s.start:s.end]
+	case "end":
+		s.start, s.end = len(s.msgs), len(s.msgs)
+	default:
+		n, err := strconv.Atoi(args)
+		if err != nil || n <= 0 {
+			s.mu.Unlock()
+			return fmt.Errorf("usage: /scroll [up | down | end | <count>]")
+		}
+		page = s.msgs[max(len(s.msgs)-n, 0):]
+		s.start, s.end = len(s.msgs), len(s.msgs)
+	}
+	page = append([]protocol.Message(nil), page...)
+	s.mu.Unlock()
+
+	if len(page) == 0 {
+		if args != "end" {
+			fmt.Fprintln(out, "-- no earlier messages --")
+		}
+		return nil
+	}
+	var lines []string
+	stamps := Timestamper{Layout: c.timeFmt}
+	for _, msg := range page {
+		text, ok := c.Format(msg)
+		if !ok {
+			continue
+		}
+		sep, line := c.Stamp(&stamps, msg, text)
+		if sep != "" {
+			lines = append(lines, sep)
+		}
+		lines = append(lines, line)
+	}
+	fmt.Fprintf(out, "-- scrollback --\n%s\n-- /scroll up, /scroll down or /scroll end --\n", strings.Join(lines, "\n"))
+	return nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestScroll(t *testing.T) {
+	c := &ChatClient{username: "alice", commands: NewCommands(), scrollback: scrollback{limit: 30}}
+	for i := 1; i <= 35; i++ {
+		c.record(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: strconv.Itoa(i)})
+	}
+	if n := len(c.History()); n != 30 {
+		t.Fatalf("History() kept %d messages, want 30", n)
+	}
+
+	scroll := func(args string) []string {
+		var out strings.Builder
+		c.HandleInput(&out, strings.TrimSpace("/scroll "+args))
+		var bodies []string
+		for _, line := range strings.Split(out.String(), "\n") {
+			if body, ok := strings.CutPrefix(line, "[bob]: "); ok {
+				bodies = append(bodies, body)
+			} else if strings.HasPrefix(line, "-- no") || strings.HasPrefix(line, "-- end") {
+				bodies = append(bodies, line)
+			}
+		}
+		return bodies
+	}
+	first := func(lines []string) string {
+		if len(lines) == 0 {
+			return ""
+		}
+		return lines[0] + ".." + lines[len(lines)-1]
+	}
+
+	steps := []struct {
+		args string
+		want string
+	}{
+		{"", "16..35"},
+		{"up", "6..15"},
+		{"up", "-- no earlier messages --..-- no earlier messages --"},
+		{"down", "6..25"},
+		{"down", "26..35"},
+		{"down", "-- end of scrollback --..-- end of scrollback --"},
+		{"3", "33..35"},
+	}
+	for _, s := range steps {
+		if got := first(scroll(s.args)); got != s.want {
+			t.Errorf("/scroll %s showed %s, want %s", s.args, got, s.want)
+		}
+	}
+
+	// New messages while scrolled back don't move the page.
+	scroll("up")
+	c.record(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "36"})
+	if got := first(scroll("up")); got != "7..15" {
+		t.Errorf("/scroll up after a new message showed %s, want 7..15", got)
+	}
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// RequestSeen asks the server when name was last connected. The reply
+// arrives on Messages as a SEEN message.
+func (c *ChatClient) RequestSeen(name string) error {
+	if name == "" || len(name) > protocol.MaxUsernameLength || strings.Contains(name, "|") || !protocol.ValidText(name) {
+		return ErrInvalidBody
+	}
+	return c.send(protocol.Message{Type: protocol.TypeSeen, Username: name})
+}
+
+// SetHideSeen turns last-seen privacy on or off. While it's on the server
+// doesn't tell anyone when the user was last connected. The server keeps
+// the setting for the username, so it isn't restored after a reconnect.
+func (c *ChatClient) SetHideSeen(on bool) error {
+	body := "off"
+	if on {
+		body = "on"
+	}
+	return c.send(protocol.Message{Type: protocol.TypeHideSeen, Body: body})
+}
+
+// seenText is the visible text of a SEEN reply.
+func seenText(msg protocol.Message) string {
+	seen, err := protocol.ParseSeen(msg.Body)
+	switch {
+	case err != nil:
+		return fmt.Sprintf("* %s hasn't been seen *", msg.Username)
+	case seen.Online:
+		return fmt.Sprintf("* %s is online *", msg.Username)
+	default:
+		return fmt.Sprintf("* %s was last seen %s *", msg.Username, ago(time.Since(seen.At)))
+	}
+}
+
+// ago describes a time d in the past, roughly, as in "2h ago".
+func ago(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+	}
+}
@@ -0,0 +1,54 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestSeen(t *testing.T) {
+	got := make(chan string, 3)
+	addr := chatmock.New(t, chatmock.Script{chatmock.Join(), chatmock.Lines(got)}).Addr()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+
+	var out strings.Builder
+	c.HandleInput(&out, "/seen @bob")
+	c.HandleInput(&out, "/hideseen on")
+	c.HandleInput(&out, "/hideseen")
+	for _, want := range []string{"SEEN|bob", "HIDESEEN|on"} {
+		if line := <-got; line != want {
+			t.Errorf("server got %q, want %q", line, want)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "usage: /hideseen on|off\n") {
+		t.Errorf("output = %q, want a usage error", out.String())
+	}
+	if err := c.RequestSeen("a|b"); err != ErrInvalidBody {
+		t.Errorf("RequestSeen() with a separator = %v, want ErrInvalidBody", err)
+	}
+
+	twoHours := strconv.FormatInt(time.Now().Add(-2*time.Hour).Unix(), 10)
+	tests := []struct {
+		body string
+		want string
+	}{
+		{"online", "* bob is online *"},
+		{twoHours, "* bob was last seen 2h ago *"},
+		{"", "* bob hasn't been seen *"},
+	}
+	for _, tt := range tests {
+		msg := protocol.Message{Type: protocol.TypeSeen, Username: "bob", Body: tt.body}
+		if text, ok := FormatMessage(msg); !ok || text != tt.want {
+			t.Errorf("FormatMessage(%q) = %q, want %q", tt.body, text, tt.want)
+		}
+	}
+}
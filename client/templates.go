@@ -0,0 +1,105 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Templates replace the built-in formats of chat messages, joins and
+// leaves with text/template templates, such as
+//
+//	{{.Time}} <{{.User}}> {{.Body}}
+//
+// executed with a TemplateData. A nil template keeps the built-in format.
+// A templated line gets no timestamp prefix; the template places .Time
+// itself, if it wants it.
+type Templates struct {
+	Message *template.Template // chat messages and attachments
+	Join    *template.Template
+	Leave   *template.Template
+}
+
+// TemplateData is what a template is executed with.
+type TemplateData struct {
+	Time    string    // arrival time in the client's time format; empty if timestamps are off
+	When    time.Time // arrival time, for other layouts: {{.When.Format "Jan 2 15:04"}}
+	User    string    // author, or who joined or left
+	Away    string    // "(away)" or "(away: note)" if User is away, else empty
+	Body    string    // message text, with the attachment for attachments
+	Members int       // users online after a join or leave; 0 if the server didn't say
+}
+
+// ParseTemplate parses text as a template for Templates. Templates are
+// tried out on sample data too, so that a misspelled field is reported
+// here rather than when the first message arrives.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	sample := TemplateData{Time: "15:04", When: time.Now(), User: "alice", Body: "hello", Members: 2}
+	if err := tmpl.Execute(io.Discard, sample); err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// template returns the template for messages of type typ, or nil if it
+// has the built-in format.
+func (t *Templates) template(typ string) *template.Template {
+	if t == nil {
+		return nil
+	}
+	switch typ {
+	case protocol.TypeMsg, protocol.TypeAttached:
+		return t.Message
+	case protocol.TypeJoined:
+		return t.Join
+	case protocol.TypeLeft:
+		return t.Leave
+	}
+	return nil
+}
+
+// formatTemplate renders msg with its template. It returns false if there
+// is no template for msg or the template fails, in which case the message
+// keeps its built-in format.
+func (c *ChatClient) formatTemplate(msg protocol.Message) (string, bool) {
+	tmpl := c.templates.template(msg.Type)
+	if tmpl == nil {
+		return "", false
+	}
+	when := msg.Received
+	if when.IsZero() {
+		when = time.Now()
+	}
+	data := TemplateData{When: when.Local(), User: msg.Username, Away: c.presenceTag(msg.Username), Body: msg.Body, Members: msg.Members}
+	if c.timeFmt != "" {
+		data.Time = data.When.Format(c.timeFmt)
+	}
+	if msg.Type == protocol.TypeAttached {
+		data.Body = attachText(msg)
+	}
+
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		c.reportError(fmt.Errorf("template %s: %w", tmpl.Name(), err))
+		return "", false
+	}
+	return b.String(), true
+}
+
+// Stamp is s.Stamp for a line formatted from msg, except that a line
+// made by one of the client's Templates is left without a timestamp
+// prefix. The date separator is still reported.
+func (c *ChatClient) Stamp(s *Timestamper, msg protocol.Message, text string) (sep, line string) {
+	if c.templates.template(msg.Type) == nil {
+		return s.Stamp(msg.Received, text)
+	}
+	return s.Separator(msg.Received), text
+}
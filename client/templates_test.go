@@ -0,0 +1,59 @@
+package client
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestParseTemplate(t *testing.T) {
+	for _, text := range []string{"{{.Time}} <{{.User}}> {{.Body}}", "{{.When.Format \"Jan 2\"}} {{.User}} left ({{.Members}})"} {
+		if _, err := ParseTemplate("msg", text); err != nil {
+			t.Errorf("ParseTemplate(%q) error = %v", text, err)
+		}
+	}
+	for _, text := range []string{"{{.Time", "{{.Usr}}"} {
+		if _, err := ParseTemplate("msg", text); err == nil {
+			t.Errorf("ParseTemplate(%q) succeeded, want an error", text)
+		}
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	parse := func(text string) *Templates {
+		tmpl, err := ParseTemplate("msg", text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Templates{Message: tmpl}
+	}
+	c := &ChatClient{username: "alice", timeFmt: "15:04", templates: parse("{{.Time}} <{{.User}}> {{.Body}}")}
+	at := time.Date(2024, 3, 9, 9, 30, 0, 0, time.Local)
+
+	msg := protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi", Received: at}
+	text, ok := c.Format(msg)
+	if !ok || text != "09:30 <bob> hi" {
+		t.Errorf("Format() = %q, %v; want the template", text, ok)
+	}
+	stamps := Timestamper{Layout: c.timeFmt}
+	if sep, line := c.Stamp(&stamps, msg, text); sep != "--- Saturday, 9 March 2024 ---" || line != text {
+		t.Errorf("Stamp() = %q, %q; want the separator and no prefix", sep, line)
+	}
+
+	// Joins have no template here, so they keep the built-in format.
+	joined := protocol.Message{Type: protocol.TypeJoined, Username: "carol", Received: at}
+	text, _ = c.Format(joined)
+	if text != "* carol has joined the chat *" {
+		t.Errorf("Format(JOINED) = %q, want the built-in format", text)
+	}
+	if _, line := c.Stamp(&stamps, joined, text); line != "[09:30] "+text {
+		t.Errorf("Stamp(JOINED) = %q, want a timestamp prefix", line)
+	}
+
+	// A template that fails falls back to the built-in format.
+	c.templates = parse("{{index .User 4}}")
+	if text, _ := c.Format(msg); text != "[bob]: hi" {
+		t.Errorf("Format() with a failing template = %q, want the built-in format", text)
+	}
+}
@@ -0,0 +1,186 @@
+package client
+
+import (
+	"fmt"
+	"hash/fnv"
+	"regexp"
+	"strings"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Theme colors REPL output with ANSI escape sequences. Each field holds
+// SGR parameters such as "31" (red) or "1;33" (bold yellow); an empty
+// field leaves that part uncolored.
+type Theme struct {
+	// Usernames is the palette senders are colored from. A given name
+	// always maps to the same entry.
+	Usernames []string
+	// Notice colors join, leave and reconnect notices.
+	Notice string
+	// Error colors errors reported by the server.
+	Error string
+	// Mention highlights the local user's name inside message bodies.
+	Mention string
+	// Alert colors messages matched by a highlight alert rule.
+	Alert string
+	// Bold, Italic and Code style the *bold*, _italic_ and `code` spans
+	// of message bodies when the server supports formatting.
+	Bold   string
+	Italic string
+	Code   string
+}
+
+// DefaultTheme is used when color output is enabled without a custom theme.
+var DefaultTheme = Theme{
+	Usernames: []string{"31", "32", "33", "34", "35", "36"},
+	Notice:    "2",
+	Error:     "31",
+	Mention:   "1;33",
+	Alert:     "7",
+	Bold:      "1",
+	Italic:    "3",
+	Code:      "36",
+}
+
+// ParseTheme reads a theme from a comma-separated list of key=value pairs,
+// starting from DefaultTheme. Keys are users, notice, error, mention,
+// alert, bold, italic and code; users takes a colon-separated palette, e.g.
+//
+//	users=91:92:94,notice=90,mention=1;4
+func ParseTheme(s string) (Theme, error) {
+	t := DefaultTheme
+	if strings.TrimSpace(s) == "" {
+		return t, nil
+	}
+	for _, entry := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return Theme{}, fmt.Errorf("theme entry %q: expected key=value", entry)
+		}
+		codes := []string{value}
+		if key == "users" {
+			codes = strings.Split(value, ":")
+		}
+		for _, code := range codes {
+			if code != "" && !sgrPattern.MatchString(code) {
+				return Theme{}, fmt.Errorf("theme entry %q: invalid color %q", entry, code)
+			}
+		}
+		switch key {
+		case "users":
+			t.Usernames = codes
+		case "notice":
+			t.Notice = value
+		case "error":
+			t.Error = value
+		case "mention":
+			t.Mention = value
+		case "alert":
+			t.Alert = value
+		case "bold":
+			t.Bold = value
+		case "italic":
+			t.Italic = value
+		case "code":
+			t.Code = value
+		default:
+			return Theme{}, fmt.Errorf("theme entry %q: unknown key %q", entry, key)
+		}
+	}
+	return t, nil
+}
+
+// sgrPattern matches the parameter part of an SGR escape sequence.
+var sgrPattern = regexp.MustCompile(`^[0-9]+(;[0-9]+)*$`)
+
+// Render formats msg like FormatMessage and colors it for a terminal,
+// styling the formatting in chat message bodies. self is the local
+// username, whose mentions are highlighted.
+func (t *Theme) Render(msg protocol.Message, self string) (string, bool) {
+	return t.render(msg, self, "", true)
+}
+
+// render is Render with tag, if not empty, shown after the author of a
+// chat message. Formatting is styled only if format is set.
+func (t *Theme) render(msg protocol.Message, self, tag string, format bool) (string, bool) {
+	text, ok := FormatMessage(msg)
+	if !ok {
+		return "", false
+	}
+
+	switch msg.Type {
+	case protocol.TypeMsg, protocol.TypeAttached:
+		var body string
+		if format {
+			body = t.format(msg.Body, self)
+		} else {
+			body = t.highlight(msg.Body, self, "")
+		}
+		if msg.Type == protocol.TypeAttached {
+			body = strings.TrimPrefix(body+" "+paint(t.Notice, "[attachment: "+msg.Attachment+"]"), " ")
+		}
+		author := paint(t.userColor(msg.Username), msg.Username)
+		if tag != "" {
+			author += " " + paint(t.Notice, tag)
+		}
+		return fmt.Sprintf("[%s]: %s", author, body), true
+	case protocol.TypeJoined, protocol.TypeLeft, protocol.TypeReconnect, protocol.TypeMigrate,
+		protocol.TypePresence, protocol.TypeUserStatus, protocol.TypeSeen, protocol.TypeNotice, protocol.TypeDelivered, protocol.TypeMode:
+		return paint(t.Notice, text), true
+	case protocol.TypeErr, protocol.TypeKicked, TypeKeyChanged:
+		return paint(t.Error, text), true
+	default:
+		return text, true
+	}
+}
+
+// highlight paints mentions of self in text, which is styled with code.
+func (t *Theme) highlight(text, self, code string) string {
+	if self == "" || t.Mention == "" {
+		return text
+	}
+	return mentionPattern(self).ReplaceAllStringFunc(text, func(m string) string {
+		if code == "" {
+			return paint(t.Mention, m)
+		}
+		// Restore the span's style after the mention's reset.
+		return paint(code+";"+t.Mention, m) + "\x1b[" + code + "m"
+	})
+}
+
+// format styles the formatting subset in a chat message body and
+// highlights mentions of self outside code spans.
+func (t *Theme) format(body, self string) string {
+	var b strings.Builder
+	for _, span := range protocol.ParseFormat(body) {
+		switch span.Style {
+		case protocol.Bold:
+			b.WriteString(paint(t.Bold, t.highlight(span.Text, self, t.Bold)))
+		case protocol.Italic:
+			b.WriteString(paint(t.Italic, t.highlight(span.Text, self, t.Italic)))
+		case protocol.Code:
+			b.WriteString(paint(t.Code, span.Text))
+		default:
+			b.WriteString(t.highlight(span.Text, self, ""))
+		}
+	}
+	return b.String()
+}
+
+func (t *Theme) userColor(name string) string {
+	if len(t.Usernames) == 0 {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return t.Usernames[h.Sum32()%uint32(len(t.Usernames))]
+}
+
+// paint wraps s in the SGR sequence for code and a reset.
+func paint(code, s string) string {
+	if code == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
@@ -0,0 +1,121 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestThemeRender(t *testing.T) {
+	theme := Theme{Usernames: []string{"32"}, Notice: "2", Error: "31", Mention: "1", Bold: "1", Italic: "3", Code: "36"}
+
+	tests := []struct {
+		name string
+		msg  protocol.Message
+		want string
+	}{
+		{
+			"message",
+			protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"},
+			"[\x1b[32mbob\x1b[0m]: hi",
+		},
+		{
+			"mention",
+			protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hey @Alice, and alice"},
+			"[\x1b[32mbob\x1b[0m]: hey \x1b[1m@Alice\x1b[0m, and \x1b[1malice\x1b[0m",
+		},
+		{
+			"not a mention",
+			protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "malice"},
+			"[\x1b[32mbob\x1b[0m]: malice",
+		},
+		{
+			"formatting",
+			protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "*really* _do_ run `make` on snake_case"},
+			"[\x1b[32mbob\x1b[0m]: \x1b[1mreally\x1b[0m \x1b[3mdo\x1b[0m run \x1b[36mmake\x1b[0m on snake_case",
+		},
+		{
+			"mention in formatting",
+			protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "_ask alice now_ `alice`"},
+			"[\x1b[32mbob\x1b[0m]: \x1b[3mask \x1b[3;1malice\x1b[0m\x1b[3m now\x1b[0m \x1b[36malice\x1b[0m",
+		},
+		{
+			"notice",
+			protocol.Message{Type: protocol.TypeJoined, Username: "bob"},
+			"\x1b[2m* bob has joined the chat *\x1b[0m",
+		},
+		{
+			"error",
+			protocol.Message{Type: protocol.TypeErr, Body: "nope"},
+			"\x1b[31mError: nope\x1b[0m",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := theme.Render(tt.msg, "alice")
+			if !ok || got != tt.want {
+				t.Errorf("Render() = %q, %v; want %q", got, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestThemeUsernameColorsAreStable(t *testing.T) {
+	if a, b := DefaultTheme.userColor("carol"), DefaultTheme.userColor("carol"); a != b {
+		t.Errorf("userColor changed between calls: %q vs %q", a, b)
+	}
+	seen := make(map[string]bool)
+	for _, name := range []string{"alice", "bob", "carol", "dave", "erin", "frank", "grace"} {
+		seen[DefaultTheme.userColor(name)] = true
+	}
+	if len(seen) < 2 {
+		t.Error("expected different users to get different colors")
+	}
+}
+
+func TestParseTheme(t *testing.T) {
+	theme, err := ParseTheme("users=91:92, notice=90,mention=1;4,error=,code=7")
+	if err != nil {
+		t.Fatalf("ParseTheme() error = %v", err)
+	}
+	if strings.Join(theme.Usernames, ":") != "91:92" || theme.Notice != "90" || theme.Mention != "1;4" || theme.Error != "" || theme.Code != "7" {
+		t.Errorf("ParseTheme() = %+v", theme)
+	}
+
+	if theme, err := ParseTheme(""); err != nil || theme.Notice != DefaultTheme.Notice {
+		t.Errorf("ParseTheme(\"\") = %+v, %v; want DefaultTheme", theme, err)
+	}
+
+	for _, bad := range []string{"notice", "colour=31", "notice=red", "users=31:x"} {
+		if _, err := ParseTheme(bad); err == nil {
+			t.Errorf("ParseTheme(%q) expected error", bad)
+		}
+	}
+}
+
+func TestFormattingNeedsCapability(t *testing.T) {
+	msg := chatmock.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "*hi*"})
+	for _, caps := range []string{"", protocol.CapFormat} {
+		addr := chatmock.New(t, chatmock.Script{
+			chatmock.Expect(protocol.TypeJoin),
+			chatmock.Send(protocol.Message{Type: protocol.TypeOK, Body: caps}),
+			msg,
+		}).Addr()
+		c, err := New(addr, "alice")
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		defer c.Close()
+		c.SetTheme(&Theme{Bold: "1"})
+
+		want := "[bob]: *hi*"
+		if caps != "" {
+			want = "[bob]: \x1b[1mhi\x1b[0m"
+		}
+		if got, _ := c.render(<-c.Messages()); got != want || c.Capable(protocol.CapFormat) != (caps != "") {
+			t.Errorf("with capabilities %q: render() = %q, Capable() = %v", caps, got, c.Capable(protocol.CapFormat))
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package client
+
+import "time"
+
+// dateLayout is used for the separator printed when the day changes.
+const dateLayout = "Monday, 2 January 2006"
+
+// Timestamper prefixes displayed messages with their arrival time and
+// notices when the local date changes between messages.
+type Timestamper struct {
+	// Layout is the time.Format layout for the prefix. If empty, Stamp
+	// returns text unchanged and never reports a separator.
+	Layout string
+
+	lastDay time.Time
+}
+
+// Stamp returns text prefixed with t. If t falls on a different local day
+// than the previous call, sep holds a date separator line to display
+// first; otherwise it is empty.
+func (s *Timestamper) Stamp(t time.Time, text string) (sep, line string) {
+	if s.Layout == "" {
+		return "", text
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	return s.Separator(t), "[" + t.Local().Format(s.Layout) + "] " + text
+}
+
+// Separator is Stamp without the prefix: it returns the date separator
+// line to display before something that arrived at t, or an empty string
+// if t falls on the same day as the previous call.
+func (s *Timestamper) Separator(t time.Time) string {
+	if s.Layout == "" {
+		return ""
+	}
+	if t.IsZero() {
+		t = time.Now()
+	}
+	y, m, d := t.Local().Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+	if day.Equal(s.lastDay) {
+		return ""
+	}
+	s.lastDay = day
+	return "--- " + day.Format(dateLayout) + " ---"
+}
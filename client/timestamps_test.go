@@ -0,0 +1,35 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTimestamperStamp(t *testing.T) {
+	s := Timestamper{Layout: "15:04"}
+	day1 := time.Date(2024, 3, 9, 23, 58, 0, 0, time.Local)
+
+	sep, line := s.Stamp(day1, "[bob]: hi")
+	if sep != "--- Saturday, 9 March 2024 ---" {
+		t.Errorf("first separator = %q", sep)
+	}
+	if line != "[23:58] [bob]: hi" {
+		t.Errorf("line = %q", line)
+	}
+
+	if sep, _ := s.Stamp(day1.Add(time.Minute), "again"); sep != "" {
+		t.Errorf("same day produced separator %q", sep)
+	}
+
+	sep, line = s.Stamp(day1.Add(3*time.Minute), "later")
+	if sep != "--- Sunday, 10 March 2024 ---" || line != "[00:01] later" {
+		t.Errorf("after midnight = %q, %q", sep, line)
+	}
+}
+
+func TestTimestamperDisabled(t *testing.T) {
+	var s Timestamper
+	if sep, line := s.Stamp(time.Now(), "text"); sep != "" || line != "text" {
+		t.Errorf("Stamp() = %q, %q; want text unchanged", sep, line)
+	}
+}
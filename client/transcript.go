@@ -0,0 +1,101 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// transcriptBackups is how many rotated transcript files are kept, named
+// path.1 (newest) through path.N.
+const transcriptBackups = 3
+
+// Transcript appends chat messages to a file with timestamps. When the file
+// would grow past its size limit it is rotated. It is safe for concurrent
+// use.
+type Transcript struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	f       *os.File
+	size    int64
+}
+
+// OpenTranscript opens path for appending, creating it if needed. The file
+// is rotated once it reaches maxSize bytes; maxSize <= 0 disables rotation.
+func OpenTranscript(path string, maxSize int64) (*Transcript, error) {
+	t := &Transcript{path: path, maxSize: maxSize}
+	if err := t.open(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Transcript) open() error {
+	f, err := os.OpenFile(t.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return fmt.Errorf("opening transcript: %w", err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("opening transcript: %w", err)
+	}
+	t.f, t.size = f, fi.Size()
+	return nil
+}
+
+// Record writes msg, as shown by FormatMessage, stamped with when. Messages
+// with no visible representation are skipped.
+func (t *Transcript) Record(when time.Time, msg protocol.Message) error {
+	text, ok := FormatMessage(msg)
+	if !ok {
+		return nil
+	}
+	line := when.Format("2006-01-02 15:04:05") + " " + text + "\n"
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return ErrClosed
+	}
+	if t.maxSize > 0 && t.size > 0 && t.size+int64(len(line)) > t.maxSize {
+		if err := t.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := t.f.WriteString(line)
+	t.size += int64(n)
+	return err
+}
+
+// rotate shifts path.N-1 to path.N and so on, moves the current file to
+// path.1 and starts a new one.
+func (t *Transcript) rotate() error {
+	if err := t.f.Close(); err != nil {
+		return fmt.Errorf("rotating transcript: %w", err)
+	}
+	t.f = nil
+	for i := transcriptBackups - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", t.path, i), fmt.Sprintf("%s.%d", t.path, i+1))
+	}
+	if err := os.Rename(t.path, t.path+".1"); err != nil {
+		return fmt.Errorf("rotating transcript: %w", err)
+	}
+	return t.open()
+}
+
+// Close closes the transcript file.
+func (t *Transcript) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return nil
+	}
+	err := t.f.Close()
+	t.f = nil
+	return err
+}
@@ -0,0 +1,103 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestTranscriptRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.log")
+	tr, err := OpenTranscript(path, 0)
+	if err != nil {
+		t.Fatalf("OpenTranscript() error = %v", err)
+	}
+
+	when := time.Date(2024, 3, 9, 12, 30, 5, 0, time.Local)
+	tr.Record(when, protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hi"})
+	tr.Record(when, protocol.Message{Type: protocol.TypeOK})
+	tr.Record(when, protocol.Message{Type: protocol.TypeLeft, Username: "bob"})
+	tr.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	want := "2024-03-09 12:30:05 [bob]: hi\n2024-03-09 12:30:05 * bob has left the chat *\n"
+	if string(data) != want {
+		t.Errorf("transcript = %q, want %q", data, want)
+	}
+
+	if err := tr.Record(when, protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "late"}); err != ErrClosed {
+		t.Errorf("Record() after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestTranscriptRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.log")
+	tr, err := OpenTranscript(path, 64)
+	if err != nil {
+		t.Fatalf("OpenTranscript() error = %v", err)
+	}
+	defer tr.Close()
+
+	// Each line is 37 bytes, so every record after the first rotates.
+	msg := protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "0123456789"}
+	for i := 0; i < transcriptBackups+3; i++ {
+		if err := tr.Record(time.Now(), msg); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	for i := 1; i <= transcriptBackups; i++ {
+		if _, err := os.Stat(fmt.Sprintf("%s.%d", path, i)); err != nil {
+			t.Errorf("backup %d missing: %v", i, err)
+		}
+	}
+	if _, err := os.Stat(fmt.Sprintf("%s.%d", path, transcriptBackups+1)); !os.IsNotExist(err) {
+		t.Errorf("expected at most %d backups, stat error = %v", transcriptBackups, err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Size() > 64 {
+		t.Errorf("current transcript = %v, %v; want at most 64 bytes", fi, err)
+	}
+}
+
+func TestClientRecordsTranscript(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Expect(protocol.TypeSend),
+		chatmock.Send(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hey"}),
+	}).Addr()
+
+	path := filepath.Join(t.TempDir(), "chat.log")
+	tr, err := OpenTranscript(path, 0)
+	if err != nil {
+		t.Fatalf("OpenTranscript() error = %v", err)
+	}
+	defer tr.Close()
+
+	c, err := New(addr, "alice")
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer c.Close()
+	c.SetTranscript(tr)
+
+	if err := c.SendMessage("hello"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	if _, err := c.Receive(); err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+
+	data, _ := os.ReadFile(path)
+	if !strings.Contains(string(data), "[alice]: hello\n") || !strings.Contains(string(data), "[bob]: hey\n") {
+		t.Errorf("transcript missing messages:\n%s", data)
+	}
+}
@@ -0,0 +1,110 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pankaj/simple-chat/netopt"
+	"github.com/pankaj/simple-chat/protocol"
+	"github.com/quic-go/quic-go"
+)
+
+// isWebSocketURL reports whether addr selects the WebSocket transport.
+func isWebSocketURL(addr string) bool {
+	return strings.HasPrefix(addr, "ws://") || strings.HasPrefix(addr, "wss://")
+}
+
+// quicLinger is how long a closed QUIC connection waits for the server to
+// read what was last written, such as LEAVE, before it is torn down.
+const quicLinger = 2 * time.Second
+
+// transport holds the settings dialTransport uses.
+type transport struct {
+	tls *tls.Config // replaces the default TLS settings for QUIC if not nil
+	tcp netopt.TCP  // applied to plain TCP connections
+}
+
+// dialTransport opens the connection the protocol runs over. addr is either
+// host:port for plain TCP, a ws:// or wss:// URL, or an experimental
+// quic://host:port address. Over WebSocket the same newline-terminated
+// protocol lines are carried in text messages, and over QUIC on a single
+// stream.
+func dialTransport(ctx context.Context, addr string, t transport) (net.Conn, error) {
+	if hostport, ok := strings.CutPrefix(addr, "quic://"); ok {
+		return dialQUIC(ctx, hostport, t.tls)
+	}
+	if !isWebSocketURL(addr) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return nil, err
+		}
+		if err := t.tcp.Apply(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return conn, nil
+	}
+
+	ws, _, err := websocket.Dial(ctx, addr, nil)
+	if err != nil {
+		return nil, err
+	}
+	// The connection outlives ctx, which only bounds the dial; Close ends
+	// it.
+	return websocket.NetConn(context.Background(), ws, websocket.MessageText), nil
+}
+
+// dialQUIC connects over QUIC and opens the stream the protocol runs on.
+// QUIC keeps the connection alive and carries it across changes of the
+// client's address, such as moving between networks.
+func dialQUIC(ctx context.Context, addr string, tlsConf *tls.Config) (net.Conn, error) {
+	if tlsConf == nil {
+		tlsConf = &tls.Config{}
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{protocol.QUICProtocol}
+
+	qc, err := quic.DialAddr(ctx, addr, tlsConf, &quic.Config{
+		KeepAlivePeriod: 15 * time.Second,
+		MaxIdleTimeout:  time.Minute,
+	})
+	if err != nil {
+		return nil, err
+	}
+	stream, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		qc.CloseWithError(0, "")
+		return nil, fmt.Errorf("opening stream: %w", err)
+	}
+	return &quicConn{Stream: stream, conn: qc}, nil
+}
+
+// quicConn presents a QUIC connection's stream as a net.Conn.
+type quicConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Close ends the stream, unblocking any Read, and closes the connection
+// once the server has closed its side or quicLinger has passed.
+func (c *quicConn) Close() error {
+	c.Stream.CancelRead(0)
+	err := c.Stream.Close()
+	go func() {
+		select {
+		case <-c.conn.Context().Done():
+		case <-time.After(quicLinger):
+		}
+		c.conn.CloseWithError(0, "")
+	}()
+	return err
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pankaj/simple-chat/protocol"
+	"github.com/quic-go/quic-go"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			t.Errorf("accept: %v", err)
+			return
+		}
+		conn := websocket.NetConn(context.Background(), ws, websocket.MessageText)
+		defer conn.Close()
+
+		scanner := bufio.NewScanner(conn)
+		scanner.Scan() // JOIN
+		join, _ := protocol.Decode(scanner.Text())
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
+		for scanner.Scan() {
+			msg, err := protocol.Decode(scanner.Text())
+			if err != nil || msg.Type != protocol.TypeSend {
+				continue
+			}
+			fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeMsg, Username: join.Username, Body: msg.Body}))
+		}
+	}))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+	c, err := New(url, "alice")
+	if err != nil {
+		t.Fatalf("New(%q) error = %v", url, err)
+	}
+	defer c.Close()
+
+	if err := c.SendMessage("over websocket"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	msg, err := c.Receive()
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if msg.Username != "alice" || msg.Body != "over websocket" {
+		t.Errorf("Receive() = %+v", msg)
+	}
+}
+
+func TestWebSocketDialFailure(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	if _, err := New("ws"+strings.TrimPrefix(srv.URL, "http"), "alice"); err == nil {
+		t.Error("New() against a non-WebSocket endpoint expected error")
+	}
+}
+
+func TestQUICTransport(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		NextProtos:   []string{protocol.QUICProtocol},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	go func() {
+		qc, err := ln.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		stream, err := qc.AcceptStream(context.Background())
+		if err != nil {
+			return
+		}
+		scanner := bufio.NewScanner(stream)
+		scanner.Scan() // JOIN
+		join, _ := protocol.Decode(scanner.Text())
+		fmt.Fprintf(stream, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
+		for scanner.Scan() {
+			msg, err := protocol.Decode(scanner.Text())
+			if err != nil || msg.Type != protocol.TypeSend {
+				continue
+			}
+			fmt.Fprintf(stream, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeMsg, Username: join.Username, Body: msg.Body}))
+		}
+	}()
+
+	pool := x509.NewCertPool()
+	cert, _ := x509.ParseCertificate(der)
+	pool.AddCert(cert)
+	addr := "quic://" + ln.Addr().String()
+	c, err := New(addr, "alice", WithTLSConfig(&tls.Config{RootCAs: pool, ServerName: "localhost"}))
+	if err != nil {
+		t.Fatalf("New(%q) error = %v", addr, err)
+	}
+	defer c.Close()
+
+	if err := c.SendMessage("over quic"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+	msg, err := c.Receive()
+	if err != nil {
+		t.Fatalf("Receive() error = %v", err)
+	}
+	if msg.Username != "alice" || msg.Body != "over quic" {
+		t.Errorf("Receive() = %+v", msg)
+	}
+}
@@ -0,0 +1,574 @@
+// Package tui implements a full-screen terminal interface for the chat
+// client: a scrolling message pane, a list of who is online beside it, a
+// status bar and an input line that incoming messages never clobber.
+package tui
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/pankaj/simple-chat/client"
+)
+
+// maxLines bounds the number of lines kept in the message pane.
+const maxLines = 1000
+
+// prompt precedes the input line and echoed commands.
+const prompt = "> "
+
+// usersWidth is the width of the user list, not counting the line that
+// separates it from the message pane. It is shown on screens at least
+// minUsersScreen wide.
+const (
+	usersWidth     = 18
+	minUsersScreen = 60
+)
+
+// UI is the state of the terminal interface.
+type UI struct {
+	screen tcell.Screen
+	client *client.ChatClient
+	title  string
+
+	lines  []string
+	marked []bool // lines highlighted by an alert rule, parallel to lines
+	stamps client.Timestamper
+	input  []rune
+	cursor int
+	status string
+	scroll int  // rows the message pane is scrolled back from the bottom
+	users  bool // show the user list; toggled with F2
+
+	vim     bool // vim-style modal keys; see WithVimKeys
+	normal  bool // in vim's normal mode rather than insert mode
+	pending rune // first key of a two-key normal mode command, such as "gg"
+}
+
+// Option configures a UI.
+type Option func(*UI)
+
+// WithVimKeys makes the keys modal, as in vim. The UI starts in insert
+// mode, where keys type as usual; Esc switches to normal mode, where
+//
+//	i a I A        insert before or after the cursor, or at the start or end
+//	h l 0 $ w b    move the cursor
+//	x D dd         delete a character, to the end of the line, or the line
+//	j k            scroll the messages by a line
+//	Ctrl-D Ctrl-U  scroll by half a page
+//	Ctrl-F Ctrl-B  scroll by a page
+//	gg G           scroll to the oldest or newest message
+//	:              start a command: insert mode with "/" typed
+//
+// and Enter sends the line in either mode.
+func WithVimKeys() Option {
+	return func(u *UI) {
+		u.vim = true
+	}
+}
+
+// Run takes over the terminal and runs the chat UI until the user leaves
+// or the connection ends. title is shown in the status bar.
+func Run(c *client.ChatClient, title string, opts ...Option) error {
+	screen, err := tcell.NewScreen()
+	if err != nil {
+		return fmt.Errorf("creating screen: %w", err)
+	}
+	if err := screen.Init(); err != nil {
+		return fmt.Errorf("initializing screen: %w", err)
+	}
+	defer screen.Fini()
+
+	// Seed the roster used for the user list and @username completion.
+	c.RequestWho()
+	New(screen, c, title, opts...).Loop()
+	return nil
+}
+
+// New returns a UI drawing on screen, which must already be initialized.
+func New(screen tcell.Screen, c *client.ChatClient, title string, opts ...Option) *UI {
+	u := &UI{
+		screen: screen,
+		client: c,
+		title:  title,
+		status: "connected",
+		stamps: client.Timestamper{Layout: c.TimeFormat()},
+		users:  true,
+	}
+	for _, opt := range opts {
+		opt(u)
+	}
+	return u
+}
+
+// Loop processes keyboard and server events until the user leaves or the
+// server disconnects.
+func (u *UI) Loop() {
+	events := make(chan tcell.Event)
+	quit := make(chan struct{})
+	defer close(quit)
+	go u.screen.ChannelEvents(events, quit)
+
+	u.Draw()
+	for {
+		select {
+		case ev := <-events:
+			if !u.HandleEvent(ev) {
+				return
+			}
+		case msg, ok := <-u.client.Messages():
+			if !ok {
+				return
+			}
+			switch msg.Type {
+			case client.TypeDisconnected:
+				u.status = "reconnecting"
+			case client.TypeReconnected:
+				u.status = "connected"
+			}
+			if text, ok := u.client.Format(msg); ok {
+				sep, line := u.client.Stamp(&u.stamps, msg, text)
+				if sep != "" {
+					u.AddLine(sep)
+				}
+				alert := u.client.Alert(msg)
+				if alert&client.AlertBell != 0 {
+					u.screen.Beep()
+				}
+				u.addLine(line, alert&client.AlertHighlight != 0)
+			}
+		}
+		u.Draw()
+	}
+}
+
+// HandleEvent applies a terminal event. It returns false when the UI
+// should exit.
+func (u *UI) HandleEvent(ev tcell.Event) bool {
+	switch ev := ev.(type) {
+	case *tcell.EventResize:
+		u.screen.Sync()
+	case *tcell.EventKey:
+		return u.handleKey(ev)
+	}
+	return true
+}
+
+func (u *UI) handleKey(ev *tcell.EventKey) bool {
+	switch ev.Key() {
+	case tcell.KeyCtrlC:
+		u.client.Close()
+		return false
+	case tcell.KeyEnter:
+		return u.submit()
+	}
+	if u.vim && u.normal {
+		u.normalKey(ev)
+		return true
+	}
+
+	switch ev.Key() {
+	case tcell.KeyEscape:
+		if u.vim {
+			// Like vim, leave the cursor on the last character typed.
+			u.normal = true
+			u.cursor = max(u.cursor-1, 0)
+		}
+	case tcell.KeyPgUp:
+		u.pageUp()
+	case tcell.KeyPgDn:
+		u.pageDown()
+	case tcell.KeyTab:
+		u.complete()
+	case tcell.KeyF2:
+		u.users = !u.users
+	case tcell.KeyBackspace, tcell.KeyBackspace2:
+		if u.cursor > 0 {
+			u.input = append(u.input[:u.cursor-1], u.input[u.cursor:]...)
+			u.cursor--
+		}
+	case tcell.KeyDelete:
+		if u.cursor < len(u.input) {
+			u.input = append(u.input[:u.cursor], u.input[u.cursor+1:]...)
+		}
+	case tcell.KeyLeft:
+		if u.cursor > 0 {
+			u.cursor--
+		}
+	case tcell.KeyRight:
+		if u.cursor < len(u.input) {
+			u.cursor++
+		}
+	case tcell.KeyHome, tcell.KeyCtrlA:
+		u.cursor = 0
+	case tcell.KeyEnd, tcell.KeyCtrlE:
+		u.cursor = len(u.input)
+	case tcell.KeyRune:
+		u.input = append(u.input[:u.cursor], append([]rune{ev.Rune()}, u.input[u.cursor:]...)...)
+		u.cursor++
+	}
+	return true
+}
+
+// submit handles the input line when Enter is pressed. It returns false
+// when the UI should exit.
+func (u *UI) submit() bool {
+	line := strings.TrimSpace(string(u.input))
+	u.input, u.cursor = u.input[:0], 0
+	if line == "" {
+		return true
+	}
+	// Page the pane itself rather than printing history into it.
+	switch line {
+	case "/scroll", "/scroll up":
+		u.pageUp()
+		return true
+	case "/scroll down":
+		u.pageDown()
+		return true
+	case "/scroll end":
+		u.scroll = 0
+		return true
+	}
+	u.AddLine(prompt + line)
+	return u.client.HandleInput(paneWriter{u}, line)
+}
+
+// normalKey handles a key in vim's normal mode; see WithVimKeys.
+func (u *UI) normalKey(ev *tcell.EventKey) {
+	_, height := u.screen.Size()
+	page := max(height-3, 1)
+	switch ev.Key() {
+	case tcell.KeyCtrlD:
+		u.scroll = max(u.scroll-max(page/2, 1), 0)
+	case tcell.KeyCtrlU:
+		u.scroll += max(page/2, 1)
+	case tcell.KeyCtrlF, tcell.KeyPgDn:
+		u.pageDown()
+	case tcell.KeyCtrlB, tcell.KeyPgUp:
+		u.pageUp()
+	case tcell.KeyF2:
+		u.users = !u.users
+	}
+	if ev.Key() != tcell.KeyRune {
+		u.pending = 0
+		return
+	}
+
+	r, pending := ev.Rune(), u.pending
+	u.pending = 0
+	switch {
+	case pending == 'g' && r == 'g':
+		u.scroll = maxLines * 100 // Draw stops at the oldest line
+	case pending == 'd' && r == 'd':
+		u.input, u.cursor = u.input[:0], 0
+	case r == 'g' || r == 'd':
+		u.pending = r
+	case r == 'i':
+		u.normal = false
+	case r == 'a':
+		u.normal, u.cursor = false, min(u.cursor+1, len(u.input))
+	case r == 'I':
+		u.normal, u.cursor = false, 0
+	case r == 'A':
+		u.normal, u.cursor = false, len(u.input)
+	case r == ':':
+		u.normal = false
+		u.input, u.cursor = []rune("/"), 1
+	case r == 'h':
+		u.cursor = max(u.cursor-1, 0)
+	case r == 'l':
+		u.cursor = min(u.cursor+1, max(len(u.input)-1, 0))
+	case r == '0':
+		u.cursor = 0
+	case r == '$':
+		u.cursor = max(len(u.input)-1, 0)
+	case r == 'w':
+		u.cursor = nextWord(u.input, u.cursor)
+	case r == 'b':
+		u.cursor = prevWord(u.input, u.cursor)
+	case r == 'x':
+		if u.cursor < len(u.input) {
+			u.input = append(u.input[:u.cursor], u.input[u.cursor+1:]...)
+			u.cursor = min(u.cursor, max(len(u.input)-1, 0))
+		}
+	case r == 'D':
+		u.input = u.input[:u.cursor]
+		u.cursor = max(u.cursor-1, 0)
+	case r == 'j':
+		u.scroll = max(u.scroll-1, 0)
+	case r == 'k':
+		u.scroll++
+	case r == 'G':
+		u.scroll = 0
+	}
+}
+
+// nextWord returns the start of the word after the one at i in line, or
+// the last position if there is none, like vim's "w".
+func nextWord(line []rune, i int) int {
+	for i < len(line) && line[i] != ' ' {
+		i++
+	}
+	for i < len(line) && line[i] == ' ' {
+		i++
+	}
+	return min(i, max(len(line)-1, 0))
+}
+
+// prevWord returns the start of the word before i in line, like vim's
+// "b".
+func prevWord(line []rune, i int) int {
+	for i > 0 && line[i-1] == ' ' {
+		i--
+	}
+	for i > 0 && line[i-1] != ' ' {
+		i--
+	}
+	return i
+}
+
+// complete expands the word before the cursor: /commands at the start of
+// the line and @usernames anywhere. A unique match is inserted with a
+// trailing space; several matches are extended to their longest common
+// prefix, or listed in the pane when that adds nothing.
+func (u *UI) complete() {
+	before := u.input[:u.cursor]
+	start := u.cursor
+	for start > 0 && before[start-1] != ' ' {
+		start--
+	}
+	word := string(before[start:])
+	if strings.HasPrefix(word, "/") && start != 0 {
+		return
+	}
+
+	matches := u.client.Complete(word)
+	var replacement string
+	switch len(matches) {
+	case 0:
+		return
+	case 1:
+		replacement = matches[0] + " "
+	default:
+		replacement = commonPrefix(matches)
+		if len([]rune(replacement)) <= len([]rune(word)) {
+			u.AddLine(strings.Join(matches, "  "))
+			return
+		}
+	}
+
+	rest := append([]rune(replacement), u.input[u.cursor:]...)
+	u.input = append(u.input[:start], rest...)
+	u.cursor = start + len([]rune(replacement))
+}
+
+// commonPrefix returns the longest prefix shared by all of words.
+func commonPrefix(words []string) string {
+	prefix := []rune(words[0])
+	for _, w := range words[1:] {
+		r := []rune(w)
+		n := 0
+		for n < len(prefix) && n < len(r) && prefix[n] == r[n] {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+// AddLine appends text to the message pane.
+func (u *UI) AddLine(text string) {
+	u.addLine(text, false)
+}
+
+// addLine appends text to the message pane, highlighted if marked. While
+// the pane is scrolled back the view stays where it is.
+func (u *UI) addLine(text string, marked bool) {
+	width, _ := u.paneWidth()
+	for _, line := range strings.Split(text, "\n") {
+		u.lines = append(u.lines, line)
+		u.marked = append(u.marked, marked)
+		if u.scroll > 0 && width > 0 {
+			u.scroll += len(wrap(line, width))
+		}
+	}
+	if over := len(u.lines) - maxLines; over > 0 {
+		u.lines = u.lines[over:]
+		u.marked = u.marked[over:]
+	}
+}
+
+// pageUp scrolls the message pane back by almost a screenful.
+func (u *UI) pageUp() {
+	_, height := u.screen.Size()
+	u.scroll += max(height-3, 1)
+}
+
+// pageDown scrolls the message pane forward by almost a screenful.
+func (u *UI) pageDown() {
+	_, height := u.screen.Size()
+	u.scroll = max(u.scroll-max(height-3, 1), 0)
+}
+
+// paneWidth returns the width of the message pane and whether the user
+// list is shown beside it.
+func (u *UI) paneWidth() (int, bool) {
+	width, _ := u.screen.Size()
+	if !u.users || width < minUsersScreen {
+		return width, false
+	}
+	return width - usersWidth - 1, true
+}
+
+// Draw renders the message pane, user list, status bar and input line.
+func (u *UI) Draw() {
+	u.screen.Clear()
+	width, height := u.screen.Size()
+	if width < 1 || height < 3 {
+		u.screen.Show()
+		return
+	}
+
+	paneHeight := height - 2
+	paneWidth, users := u.paneWidth()
+	if users {
+		u.drawUsers(paneWidth, paneHeight)
+	}
+
+	// Message pane: the most recent wrapped lines that fit.
+	type row struct {
+		text  string
+		style tcell.Style
+	}
+	var rows []row
+	for i := len(u.lines) - 1; i >= 0 && len(rows) < paneHeight+u.scroll; i-- {
+		style := tcell.StyleDefault
+		if u.marked[i] {
+			style = style.Reverse(true)
+		}
+		wrapped := wrap(u.lines[i], paneWidth)
+		for j := len(wrapped) - 1; j >= 0 && len(rows) < paneHeight+u.scroll; j-- {
+			rows = append(rows, row{wrapped[j], style})
+		}
+	}
+	// Don't scroll past the oldest line.
+	u.scroll = min(u.scroll, max(len(rows)-paneHeight, 0))
+	rows = rows[u.scroll:]
+	for i, r := range rows {
+		drawText(u.screen, 0, paneHeight-1-i, r.style, r.text)
+	}
+
+	// Status bar.
+	statusStyle := tcell.StyleDefault.Reverse(true)
+	status := fmt.Sprintf(" %s | %s", u.title, u.status)
+	if l := u.client.Latency(); l.Samples > 0 {
+		status += fmt.Sprintf(" | rtt %s", l.Avg.Round(time.Microsecond))
+	}
+	if u.vim && u.normal {
+		status += " | -- NORMAL --"
+	} else if u.vim {
+		status += " | -- INSERT --"
+	}
+	if u.scroll > 0 {
+		status += " | scrolled back (PgDn)"
+	}
+	drawText(u.screen, 0, height-2, statusStyle, status+strings.Repeat(" ", max(0, width-len([]rune(status)))))
+
+	// Input line, scrolled so the cursor stays visible.
+	avail := width - len(prompt)
+	start := 0
+	if u.cursor >= avail {
+		start = u.cursor - avail + 1
+	}
+	end := min(len(u.input), start+avail)
+	drawText(u.screen, 0, height-1, tcell.StyleDefault, prompt+string(u.input[start:end]))
+	u.screen.ShowCursor(len(prompt)+u.cursor-start, height-1)
+
+	u.screen.Show()
+}
+
+// drawUsers draws the user list in the rightmost usersWidth columns, to
+// the right of a line at column x, height rows tall. Each user's status
+// text follows their name; away users are marked with a hollow dot and
+// dimmed, and this client's user is bold.
+func (u *UI) drawUsers(x, height int) {
+	for y := range height {
+		u.screen.SetContent(x, y, tcell.RuneVLine, nil, tcell.StyleDefault)
+	}
+	x++
+	names := u.client.Roster()
+	drawText(u.screen, x, 0, tcell.StyleDefault.Bold(true), clip(fmt.Sprintf("Users (%d)", len(names)), usersWidth))
+
+	rows := height - 1
+	if rows < 1 {
+		return
+	}
+	more := 0
+	if len(names) > rows {
+		// Keep the last row to say how many didn't fit.
+		more = len(names) - rows + 1
+		names = names[:rows-1]
+	}
+	for i, name := range names {
+		style, dot := tcell.StyleDefault, "●"
+		if u.client.Presence(name).Away {
+			style, dot = style.Dim(true), "○"
+		}
+		if name == u.client.Username() {
+			style = style.Bold(true)
+		}
+		label := dot + " " + name
+		if status := u.client.Status(name); status != "" {
+			label += " " + status
+		}
+		drawText(u.screen, x, 1+i, style, clip(label, usersWidth))
+	}
+	if more > 0 {
+		drawText(u.screen, x, height-1, tcell.StyleDefault.Dim(true), fmt.Sprintf("+%d more", more))
+	}
+}
+
+// clip shortens text to at most width runes, marking the cut with "…".
+func clip(text string, width int) string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return text
+	}
+	return string(runes[:width-1]) + "…"
+}
+
+// drawText writes s at (x, y), clipped to the screen width.
+func drawText(s tcell.Screen, x, y int, style tcell.Style, text string) {
+	width, _ := s.Size()
+	for _, r := range text {
+		if x >= width {
+			return
+		}
+		s.SetContent(x, y, r, nil, style)
+		x++
+	}
+}
+
+// wrap splits text into rows of at most width runes.
+func wrap(text string, width int) []string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return []string{text}
+	}
+	var rows []string
+	for len(runes) > width {
+		rows = append(rows, string(runes[:width]))
+		runes = runes[width:]
+	}
+	return append(rows, string(runes))
+}
+
+// paneWriter lets command output be written into the message pane.
+type paneWriter struct{ u *UI }
+
+func (w paneWriter) Write(p []byte) (int, error) {
+	w.u.AddLine(strings.TrimRight(string(p), "\n"))
+	return len(p), nil
+}
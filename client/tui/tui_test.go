@@ -0,0 +1,344 @@
+package tui
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/pankaj/simple-chat/chatmock"
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// newTestUI connects a client to a mock server that accepts the JOIN and
+// forwards every later line to received, and returns a UI on a simulated
+// 40x10 screen.
+func newTestUI(t *testing.T) (*UI, tcell.SimulationScreen, chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		scanner.Scan()
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
+		for scanner.Scan() {
+			received <- scanner.Text()
+		}
+	}()
+
+	c, err := client.New(ln.Addr().String(), "alice")
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("screen.Init() error = %v", err)
+	}
+	screen.SetSize(40, 10)
+	t.Cleanup(screen.Fini)
+
+	return New(screen, c, "alice@test"), screen, received
+}
+
+// screenText returns the simulated screen contents, one string per row.
+func screenText(s tcell.SimulationScreen) []string {
+	cells, width, height := s.GetContents()
+	rows := make([]string, height)
+	for y := 0; y < height; y++ {
+		var b strings.Builder
+		for x := 0; x < width; x++ {
+			if r := cells[y*width+x].Runes; len(r) > 0 {
+				b.WriteRune(r[0])
+			}
+		}
+		rows[y] = strings.TrimRight(b.String(), " ")
+	}
+	return rows
+}
+
+func typeLine(u *UI, line string) bool {
+	for _, r := range line {
+		u.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	return u.HandleEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone))
+}
+
+func TestLayout(t *testing.T) {
+	u, screen, _ := newTestUI(t)
+
+	u.AddLine("[bob]: hello")
+	for _, r := range "typing" {
+		u.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	u.Draw()
+
+	rows := screenText(screen)
+	if rows[7] != "[bob]: hello" {
+		t.Errorf("last pane row = %q, want the message", rows[7])
+	}
+	if !strings.Contains(rows[8], "alice@test") {
+		t.Errorf("status bar = %q, want it to contain the title", rows[8])
+	}
+	if rows[9] != "> typing" {
+		t.Errorf("input row = %q, want %q", rows[9], "> typing")
+	}
+
+	// New messages don't disturb the text being typed.
+	u.AddLine("[carol]: hi")
+	u.Draw()
+	rows = screenText(screen)
+	if rows[6] != "[bob]: hello" || rows[7] != "[carol]: hi" || rows[9] != "> typing" {
+		t.Errorf("unexpected screen after new message: %q", rows)
+	}
+}
+
+func TestSendAndLeave(t *testing.T) {
+	u, _, received := newTestUI(t)
+
+	if !typeLine(u, "send hi bob") {
+		t.Fatal("send should keep the UI running")
+	}
+	if typeLine(u, "leave") {
+		t.Fatal("leave should stop the UI")
+	}
+
+	for _, want := range []string{"SEND|hi bob", "LEAVE"} {
+		select {
+		case got := <-received:
+			if got != want {
+				t.Errorf("server received %q, want %q", got, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %q", want)
+		}
+	}
+}
+
+func TestEditing(t *testing.T) {
+	u, _, _ := newTestUI(t)
+
+	for _, r := range "helo" {
+		u.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	u.HandleEvent(tcell.NewEventKey(tcell.KeyLeft, 0, tcell.ModNone))
+	u.HandleEvent(tcell.NewEventKey(tcell.KeyRune, 'l', tcell.ModNone))
+	u.HandleEvent(tcell.NewEventKey(tcell.KeyEnd, 0, tcell.ModNone))
+	u.HandleEvent(tcell.NewEventKey(tcell.KeyBackspace2, 0, tcell.ModNone))
+
+	if got := string(u.input); got != "hell" {
+		t.Errorf("input = %q, want %q", got, "hell")
+	}
+}
+
+func TestWrap(t *testing.T) {
+	got := wrap("abcdefgh", 3)
+	want := []string{"abc", "def", "gh"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("wrap() = %q, want %q", got, want)
+	}
+}
+
+func TestTabCompletion(t *testing.T) {
+	u, _, _ := newTestUI(t)
+	tab := tcell.NewEventKey(tcell.KeyTab, 0, tcell.ModNone)
+
+	for _, r := range "/he" {
+		u.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+	}
+	u.HandleEvent(tab)
+	if got := string(u.input); got != "/help " {
+		t.Errorf("input after Tab = %q, want %q", got, "/help ")
+	}
+
+	// Ambiguous: /leave and /msg don't share more than "/", so the
+	// candidates are listed instead.
+	u.input, u.cursor = []rune("/"), 1
+	lines := len(u.lines)
+	u.HandleEvent(tab)
+	if string(u.input) != "/" || len(u.lines) != lines+1 || !strings.Contains(u.lines[lines], "/msg") {
+		t.Errorf("ambiguous completion: input %q, pane %q", string(u.input), u.lines)
+	}
+
+	// Commands only complete at the start of the line.
+	u.input, u.cursor = []rune("see /he"), 7
+	u.HandleEvent(tab)
+	if got := string(u.input); got != "see /he" {
+		t.Errorf("input = %q, want it unchanged", got)
+	}
+}
+
+func TestCommonPrefix(t *testing.T) {
+	if got := commonPrefix([]string{"@alice", "@alan", "@al"}); got != "@al" {
+		t.Errorf("commonPrefix() = %q, want @al", got)
+	}
+}
+
+func TestScrollback(t *testing.T) {
+	u, screen, _ := newTestUI(t)
+	for i := 1; i <= 20; i++ {
+		u.AddLine(fmt.Sprintf("line %d", i))
+	}
+
+	// The pane is 8 rows tall and pages by 7.
+	u.HandleEvent(tcell.NewEventKey(tcell.KeyPgUp, 0, tcell.ModNone))
+	u.Draw()
+	rows := screenText(screen)
+	if rows[7] != "line 13" || !strings.Contains(rows[8], "scrolled back") {
+		t.Errorf("after PgUp: last pane row %q, status %q", rows[7], rows[8])
+	}
+
+	u.AddLine("line 21")
+	u.Draw()
+	if rows = screenText(screen); rows[7] != "line 13" {
+		t.Errorf("new line moved the scrolled pane: last row %q", rows[7])
+	}
+
+	for i := 0; i < 5; i++ {
+		typeLine(u, "/scroll")
+	}
+	u.Draw()
+	if rows = screenText(screen); rows[0] != "line 1" {
+		t.Errorf("scrolled to the top, first row = %q, want line 1", rows[0])
+	}
+
+	typeLine(u, "/scroll end")
+	u.Draw()
+	if rows = screenText(screen); rows[7] != "line 21" || strings.Contains(rows[8], "scrolled") {
+		t.Errorf("after /scroll end: last pane row %q, status %q", rows[7], rows[8])
+	}
+}
+
+func TestUserList(t *testing.T) {
+	addr := chatmock.New(t, chatmock.Script{
+		chatmock.Join(),
+		chatmock.Send(
+			protocol.Message{Type: protocol.TypeWho, Body: "alice|bob"},
+			protocol.Message{Type: protocol.TypePresence, Username: "bob", Body: protocol.EncodePresence(protocol.Presence{Away: true})},
+			protocol.Message{Type: protocol.TypeJoined, Username: "carol", Members: 3},
+			protocol.Message{Type: protocol.TypeUserStatus, Username: "carol", Body: "on call"},
+		),
+	}).Addr()
+	c, err := client.New(addr, "alice")
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	for range 4 {
+		<-c.Messages()
+	}
+
+	screen := tcell.NewSimulationScreen("UTF-8")
+	if err := screen.Init(); err != nil {
+		t.Fatalf("screen.Init() error = %v", err)
+	}
+	screen.SetSize(80, 10)
+	t.Cleanup(screen.Fini)
+	u := New(screen, c, "alice@test")
+
+	u.Draw()
+	rows := screenText(screen)
+	for i, want := range []string{"│Users (3)", "│● alice", "│○ bob", "│● carol on call"} {
+		if got := rows[i][80-usersWidth-1:]; got != want {
+			t.Errorf("user list row %d = %q, want %q", i, got, want)
+		}
+	}
+
+	// Long lines wrap beside the list rather than under it.
+	u.AddLine(strings.Repeat("x", 70))
+	u.Draw()
+	rows = screenText(screen)
+	if got, want := rows[6], strings.Repeat("x", 80-usersWidth-1)+"│"; got != want {
+		t.Errorf("wrapped row = %q, want %q", got, want)
+	}
+
+	u.HandleEvent(tcell.NewEventKey(tcell.KeyF2, 0, tcell.ModNone))
+	u.Draw()
+	if rows = screenText(screen); strings.Contains(rows[0], "Users") || rows[7] != strings.Repeat("x", 70) {
+		t.Errorf("after F2 the list is still shown: %q", rows)
+	}
+}
+
+func TestVimKeys(t *testing.T) {
+	u, screen, received := newTestUI(t)
+	WithVimKeys()(u)
+	keys := func(s string) {
+		for _, r := range s {
+			u.HandleEvent(tcell.NewEventKey(tcell.KeyRune, r, tcell.ModNone))
+		}
+	}
+	esc := func() { u.HandleEvent(tcell.NewEventKey(tcell.KeyEscape, 0, tcell.ModNone)) }
+	for i := 1; i <= 20; i++ {
+		u.AddLine(fmt.Sprintf("line %d", i))
+	}
+
+	keys("send hello wrld")
+	u.Draw()
+	if rows := screenText(screen); !strings.Contains(rows[8], "-- INSERT --") {
+		t.Errorf("status = %q, want insert mode", rows[8])
+	}
+	esc()
+	u.Draw()
+	if rows := screenText(screen); !strings.Contains(rows[8], "-- NORMAL --") {
+		t.Errorf("status after Esc = %q, want normal mode", rows[8])
+	}
+
+	// Normal mode keys edit the line rather than typing.
+	keys("bli")
+	keys("o")
+	esc()
+	keys("0wD")
+	if got := string(u.input); got != "send " {
+		t.Errorf("input = %q, want %q", got, "send ")
+	}
+	keys("ddAsend hello world")
+	if !u.HandleEvent(tcell.NewEventKey(tcell.KeyEnter, 0, tcell.ModNone)) {
+		t.Fatal("send should keep the UI running")
+	}
+	select {
+	case got := <-received:
+		if got != "SEND|hello world" {
+			t.Errorf("server received %q, want SEND|hello world", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the message")
+	}
+
+	// And scroll the messages.
+	esc()
+	keys("kk")
+	u.Draw()
+	if rows := screenText(screen); rows[7] != "line 19" {
+		t.Errorf("after kk: last pane row = %q, want line 19", rows[7])
+	}
+	keys("gg")
+	u.Draw()
+	if rows := screenText(screen); rows[0] != "line 1" {
+		t.Errorf("after gg: first row = %q, want line 1", rows[0])
+	}
+	keys("G")
+	u.Draw()
+	if rows := screenText(screen); strings.Contains(rows[8], "scrolled") {
+		t.Errorf("after G: status = %q, want the pane at the bottom", rows[8])
+	}
+
+	keys(":")
+	if got := string(u.input); got != "/" || u.normal {
+		t.Errorf("after ':': input %q, normal %v; want \"/\" in insert mode", got, u.normal)
+	}
+}
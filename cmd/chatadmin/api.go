@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// api talks to a server's admin API.
+type api struct {
+	base   string
+	client *http.Client
+}
+
+// newAPI returns a client for the admin API at addr, which is either
+// unix:/path for a Unix socket or host:port (optionally with an http://
+// or https:// prefix).
+func newAPI(addr string) *api {
+	a := &api{client: &http.Client{Timeout: 10 * time.Second}}
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		// The host is ignored; every request goes to the socket.
+		a.base = "http://admin"
+		a.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+		return a
+	}
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	a.base = strings.TrimSuffix(addr, "/")
+	return a
+}
+
+// call sends a request with an optional JSON body and decodes the JSON
+// reply into out unless out is nil. Error replies become errors carrying
+// the server's message.
+func (a *api) call(method, path string, body, out any) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, a.base+path, r)
+	if err != nil {
+		return err
+	}
+	if method != http.MethodGet {
+		// The server requires it even without a body; see AdminHandler.
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&e) == nil && e.Error != "" {
+			return fmt.Errorf("%s %s: %s", method, path, e.Error)
+		}
+		return fmt.Errorf("%s %s: %s", method, path, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// userPath builds a path from a fixed prefix, a username (or other
+// user-supplied name, such as a report ID) and an optional suffix,
+// escaping the name.
+func userPath(prefix, name, suffix string) string {
+	return prefix + url.PathEscape(name) + suffix
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pankaj/simple-chat/server"
+)
+
+// errUsage reports a command used with the wrong arguments.
+var errUsage = errors.New("invalid usage")
+
+// command is one chatadmin subcommand.
+type command struct {
+	usage string
+	help  string
+	run   func(a *api, out *output, args []string) error
+}
+
+var commands = map[string]command{
+	"users":      {"users", "list connected users", listUsers},
+	"kick":       {"kick <user> [reason]", "disconnect a user", kick},
+	"migrate":    {"migrate <user> <addr>", "move a user's session to another server", migrate},
+	"ban":        {"ban <user> [reason]", "disconnect a user and stop them rejoining", ban},
+	"unban":      {"unban <user>", "lift a ban", unban},
+	"bans":       {"bans", "list bans", listBans},
+	"shadowban":  {"shadowban <user>", "hide a user's messages from everyone else, without telling them", shadowBan},
+	"unshadow":   {"unshadow <user>", "lift a shadow ban", unshadow},
+	"shadowbans": {"shadowbans", "list shadow-banned users", listShadowBans},
+	"reserve":    {"reserve <user>", "keep a username for its registered user, even when they're offline", reserve},
+	"unreserve":  {"unreserve <user>", "let anyone join under a reserved username", unreserve},
+	"reserved":   {"reserved", "list reserved usernames", listReserved},
+	"motd":       {"motd [set <text> | clear]", "show or change the message of the day", motd},
+	"announce":   {"announce <text>", "send a notice to every user", announce},
+	"mode":       {"mode [open | announce | slow <duration>]", "show or change who may post and how often", mode},
+	"stats":      {"stats", "show server statistics", stats},
+	"reports":    {"reports", "list reported messages", listReports},
+	"dismiss":    {"dismiss <report>", "drop a report without acting on it", dismiss},
+	"ban-author": {"ban-author <report> [reason]", "ban the author of a reported message", banAuthor},
+	"save":       {"save", "save bans, the MOTD and reports to the server's state file", saveState},
+}
+
+// output prints results as aligned tables, or as JSON when json is set.
+type output struct {
+	w    io.Writer
+	json bool
+}
+
+// table prints rows under headers, or v as JSON.
+func (o *output) table(v any, headers []string, rows [][]string) error {
+	if o.json {
+		return o.printJSON(v)
+	}
+	tw := tabwriter.NewWriter(o.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+// done reports a completed change. In JSON mode it prints {"ok": true} so
+// every command produces a JSON document.
+func (o *output) done(format string, args ...any) error {
+	if o.json {
+		return o.printJSON(map[string]bool{"ok": true})
+	}
+	_, err := fmt.Fprintf(o.w, format+"\n", args...)
+	return err
+}
+
+func (o *output) printJSON(v any) error {
+	enc := json.NewEncoder(o.w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func listUsers(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var users []server.UserInfo
+	if err := a.call("GET", "/users", nil, &users); err != nil {
+		return err
+	}
+	rows := make([][]string, len(users))
+	for i, u := range users {
+		status := "here"
+		if u.Away {
+			status = "away"
+			if u.Note != "" {
+				status += ": " + u.Note
+			}
+		}
+		if u.Status != "" {
+			status += fmt.Sprintf(" %q", u.Status)
+		}
+		if u.ShadowBanned {
+			status += " (shadow-banned)"
+		}
+		since := time.Since(u.JoinedAt).Round(time.Second)
+		rows[i] = []string{u.Name, u.Addr, since.String(), status}
+	}
+	return out.table(users, []string{"USER", "ADDRESS", "CONNECTED", "STATUS"}, rows)
+}
+
+func kick(a *api, out *output, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	body := map[string]string{"reason": strings.Join(args[1:], " ")}
+	if err := a.call("POST", userPath("/users/", args[0], "/kick"), body, nil); err != nil {
+		return err
+	}
+	return out.done("Kicked %s", args[0])
+}
+
+func migrate(a *api, out *output, args []string) error {
+	if len(args) != 2 {
+		return errUsage
+	}
+	body := map[string]string{"addr": args[1]}
+	if err := a.call("POST", userPath("/users/", args[0], "/migrate"), body, nil); err != nil {
+		return err
+	}
+	return out.done("Moving %s to %s", args[0], args[1])
+}
+
+func ban(a *api, out *output, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	body := map[string]string{"reason": strings.Join(args[1:], " ")}
+	if err := a.call("PUT", userPath("/bans/", args[0], ""), body, nil); err != nil {
+		return err
+	}
+	return out.done("Banned %s", args[0])
+}
+
+func unban(a *api, out *output, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := a.call("DELETE", userPath("/bans/", args[0], ""), nil, nil); err != nil {
+		return err
+	}
+	return out.done("Unbanned %s", args[0])
+}
+
+func listBans(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var bans []server.Ban
+	if err := a.call("GET", "/bans", nil, &bans); err != nil {
+		return err
+	}
+	rows := make([][]string, len(bans))
+	for i, b := range bans {
+		rows[i] = []string{b.Name, b.Reason}
+	}
+	return out.table(bans, []string{"USER", "REASON"}, rows)
+}
+
+func shadowBan(a *api, out *output, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := a.call("PUT", userPath("/shadowbans/", args[0], ""), nil, nil); err != nil {
+		return err
+	}
+	return out.done("Shadow-banned %s", args[0])
+}
+
+func unshadow(a *api, out *output, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := a.call("DELETE", userPath("/shadowbans/", args[0], ""), nil, nil); err != nil {
+		return err
+	}
+	return out.done("Lifted the shadow ban on %s", args[0])
+}
+
+func listShadowBans(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var names []string
+	if err := a.call("GET", "/shadowbans", nil, &names); err != nil {
+		return err
+	}
+	rows := make([][]string, len(names))
+	for i, name := range names {
+		rows[i] = []string{name}
+	}
+	return out.table(names, []string{"USER"}, rows)
+}
+
+func reserve(a *api, out *output, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := a.call("PUT", userPath("/reserved/", args[0], ""), nil, nil); err != nil {
+		return err
+	}
+	return out.done("Reserved %s", args[0])
+}
+
+func unreserve(a *api, out *output, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := a.call("DELETE", userPath("/reserved/", args[0], ""), nil, nil); err != nil {
+		return err
+	}
+	return out.done("Released %s", args[0])
+}
+
+func listReserved(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var names []string
+	if err := a.call("GET", "/reserved", nil, &names); err != nil {
+		return err
+	}
+	rows := make([][]string, len(names))
+	for i, name := range names {
+		rows[i] = []string{name}
+	}
+	return out.table(names, []string{"USER"}, rows)
+}
+
+func motd(a *api, out *output, args []string) error {
+	switch {
+	case len(args) == 0:
+		var m struct {
+			Text string `json:"text"`
+		}
+		if err := a.call("GET", "/motd", nil, &m); err != nil {
+			return err
+		}
+		if out.json {
+			return out.printJSON(m)
+		}
+		if m.Text == "" {
+			_, err := fmt.Fprintln(out.w, "(no message of the day)")
+			return err
+		}
+		_, err := fmt.Fprintln(out.w, m.Text)
+		return err
+	case args[0] == "set" && len(args) > 1:
+		if err := a.call("PUT", "/motd", map[string]string{"text": strings.Join(args[1:], " ")}, nil); err != nil {
+			return err
+		}
+		return out.done("Message of the day set")
+	case args[0] == "clear" && len(args) == 1:
+		if err := a.call("PUT", "/motd", map[string]string{"text": ""}, nil); err != nil {
+			return err
+		}
+		return out.done("Message of the day cleared")
+	default:
+		return errUsage
+	}
+}
+
+func announce(a *api, out *output, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	if err := a.call("POST", "/announce", map[string]string{"text": strings.Join(args, " ")}, nil); err != nil {
+		return err
+	}
+	return out.done("Announced")
+}
+
+func mode(a *api, out *output, args []string) error {
+	var m server.AdminMode
+	switch {
+	case len(args) == 0:
+		if err := a.call("GET", "/mode", nil, &m); err != nil {
+			return err
+		}
+		if out.json {
+			return out.printJSON(m)
+		}
+		_, err := fmt.Fprintln(out.w, describeMode(m))
+		return err
+	case args[0] == "open" && len(args) == 1:
+	case args[0] == "announce" && len(args) == 1:
+		m.Announce = true
+	case args[0] == "slow" && len(args) == 2:
+		d, err := time.ParseDuration(args[1])
+		if err != nil || d < time.Second {
+			return errUsage
+		}
+		m.SlowSeconds = int(d.Round(time.Second) / time.Second)
+	default:
+		return errUsage
+	}
+	if err := a.call("PUT", "/mode", m, nil); err != nil {
+		return err
+	}
+	return out.done("%s", describeMode(m))
+}
+
+// describeMode says who may post under m.
+func describeMode(m server.AdminMode) string {
+	switch {
+	case m.Announce:
+		return "Announcement mode: only operators can post"
+	case m.SlowSeconds > 0:
+		return fmt.Sprintf("Slow mode: one message per user every %s", time.Duration(m.SlowSeconds)*time.Second)
+	default:
+		return "Open: everyone can post"
+	}
+}
+
+func stats(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var st server.AdminStats
+	if err := a.call("GET", "/stats", nil, &st); err != nil {
+		return err
+	}
+	uptime := time.Duration(st.Uptime * float64(time.Second)).Round(time.Second)
+	rows := [][]string{
+		{"uptime", uptime.String()},
+		{"users", strconv.Itoa(st.Users)},
+		{"rooms", strconv.Itoa(st.Rooms)},
+		{"messages", strconv.FormatUint(st.Messages, 10)},
+		{"rate", fmt.Sprintf("%.2f msg/s", st.Rate)},
+		{"shed (rate)", strconv.FormatUint(st.AcceptsShed.Global, 10)},
+		{"shed (per IP)", strconv.FormatUint(st.AcceptsShed.PerIP, 10)},
+		{"failed joins", strconv.FormatUint(st.JoinThrottle.Failures, 10)},
+		{"joins delayed", strconv.FormatUint(st.JoinThrottle.Delayed, 10)},
+		{"addresses blocked", strconv.FormatUint(st.JoinThrottle.Blocked, 10)},
+	}
+	countries := slices.Sorted(maps.Keys(st.GeoDenied))
+	for _, country := range countries {
+		rows = append(rows, []string{"refused from " + country, strconv.FormatUint(st.GeoDenied[country], 10)})
+	}
+	return out.table(st, []string{"STAT", "VALUE"}, rows)
+}
+
+func listReports(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	var reports []server.Report
+	if err := a.call("GET", "/reports", nil, &reports); err != nil {
+		return err
+	}
+	rows := make([][]string, len(reports))
+	for i, r := range reports {
+		age := time.Since(r.Reported).Round(time.Second)
+		rows[i] = []string{strconv.Itoa(r.ID), r.Author, r.Text, strings.Join(r.Reporters, ", "), age.String()}
+	}
+	return out.table(reports, []string{"ID", "AUTHOR", "MESSAGE", "REPORTED BY", "AGE"}, rows)
+}
+
+func dismiss(a *api, out *output, args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+	if err := a.call("DELETE", userPath("/reports/", args[0], ""), nil, nil); err != nil {
+		return err
+	}
+	return out.done("Dismissed report %s", args[0])
+}
+
+func banAuthor(a *api, out *output, args []string) error {
+	if len(args) < 1 {
+		return errUsage
+	}
+	body := map[string]string{"reason": strings.Join(args[1:], " ")}
+	if err := a.call("POST", userPath("/reports/", args[0], "/ban"), body, nil); err != nil {
+		return err
+	}
+	return out.done("Banned the author of report %s", args[0])
+}
+
+func saveState(a *api, out *output, args []string) error {
+	if len(args) != 0 {
+		return errUsage
+	}
+	if err := a.call("POST", "/state", nil, nil); err != nil {
+		return err
+	}
+	return out.done("Saved")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pankaj/simple-chat/chattest"
+	"github.com/pankaj/simple-chat/server"
+)
+
+// startServer runs a chat server with "alice" connected and returns the
+// server and alice's connection.
+func startServer(t *testing.T) (*server.ChatServer, *chattest.Conn) {
+	t.Helper()
+	srv := chattest.NewServer(t)
+	return srv, chattest.Join(t, srv.Addr().String(), "alice")
+}
+
+func TestCommands(t *testing.T) {
+	srv, alice := startServer(t)
+	web := httptest.NewServer(srv.AdminHandler())
+	defer web.Close()
+	a := newAPI(strings.TrimPrefix(web.URL, "http://"))
+
+	exec := func(asJSON bool, args ...string) string {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := run(a, &output{w: &buf, json: asJSON}, args); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		return buf.String()
+	}
+
+	table := exec(false, "users")
+	if lines := strings.Split(strings.TrimSpace(table), "\n"); len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], "USER ") || !strings.HasPrefix(lines[1], "alice ") {
+		t.Errorf("users table:\n%s", table)
+	}
+	var users []server.UserInfo
+	if err := json.Unmarshal([]byte(exec(true, "users")), &users); err != nil || len(users) != 1 {
+		t.Errorf("users -json = %+v (%v)", users, err)
+	}
+
+	exec(false, "announce", "hello", "all")
+	if got := alice.ReadLine(); got != "NOTICE|hello all" {
+		t.Errorf("alice got %q, want NOTICE|hello all", got)
+	}
+
+	exec(false, "motd", "set", "be", "kind")
+	if got := exec(false, "motd"); got != "be kind\n" {
+		t.Errorf("motd = %q, want be kind", got)
+	}
+	exec(false, "motd", "clear")
+	if got := exec(true, "motd"); !strings.Contains(got, `"text": ""`) {
+		t.Errorf("motd -json after clear = %q", got)
+	}
+
+	exec(false, "mode", "slow", "30s")
+	if got := alice.ReadLine(); got != "MODE|slow=30" {
+		t.Errorf("alice got %q, want MODE|slow=30", got)
+	}
+	if got := exec(false, "mode"); got != "Slow mode: one message per user every 30s\n" {
+		t.Errorf("mode = %q", got)
+	}
+	exec(false, "mode", "open")
+	if got := alice.ReadLine(); got != "MODE|open" {
+		t.Errorf("alice got %q, want MODE|open", got)
+	}
+
+	if got := exec(true, "ban", "alice", "spamming"); !strings.Contains(got, `"ok": true`) {
+		t.Errorf("ban -json = %q", got)
+	}
+	if got := alice.ReadLine(); got != "KICKED|spamming" {
+		t.Errorf("alice got %q, want KICKED|spamming", got)
+	}
+	if got := exec(false, "bans"); !strings.Contains(got, "alice") || !strings.Contains(got, "spamming") {
+		t.Errorf("bans:\n%s", got)
+	}
+	exec(false, "unban", "alice")
+
+	exec(false, "shadowban", "mallory")
+	if got := exec(false, "shadowbans"); !strings.Contains(got, "mallory") {
+		t.Errorf("shadowbans:\n%s", got)
+	}
+	exec(false, "unshadow", "mallory")
+
+	exec(false, "reserve", "carol")
+	if got := exec(false, "reserved"); !strings.Contains(got, "carol") {
+		t.Errorf("reserved:\n%s", got)
+	}
+	exec(false, "unreserve", "carol")
+
+	if got := exec(false, "reports"); !strings.HasPrefix(got, "ID ") {
+		t.Errorf("reports:\n%s", got)
+	}
+
+	if got := exec(false, "stats"); !strings.Contains(got, "messages") {
+		t.Errorf("stats:\n%s", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	srv, _ := startServer(t)
+	web := httptest.NewServer(srv.AdminHandler())
+	defer web.Close()
+	a := newAPI(web.URL)
+	out := &output{w: new(bytes.Buffer)}
+
+	if err := run(a, out, []string{"kick"}); !errors.Is(err, errUsage) {
+		t.Errorf("kick without a user: %v, want errUsage", err)
+	}
+	if err := run(a, out, []string{"frobnicate"}); !errors.Is(err, errUsage) {
+		t.Errorf("unknown command: %v, want errUsage", err)
+	}
+	if err := run(a, out, []string{"save"}); err == nil || !strings.Contains(err.Error(), server.ErrNoStateFile.Error()) {
+		t.Errorf("save without a state file: %v, want the server's error", err)
+	}
+	err := run(a, out, []string{"kick", "nobody"})
+	if err == nil || !strings.Contains(err.Error(), server.ErrNoSuchUser.Error()) {
+		t.Errorf("kick nobody: %v, want the server's error", err)
+	}
+	err = run(a, out, []string{"dismiss", "1"})
+	if err == nil || !strings.Contains(err.Error(), server.ErrNoSuchReport.Error()) {
+		t.Errorf("dismiss 1: %v, want the server's error", err)
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	srv, _ := startServer(t)
+	path := filepath.Join(t.TempDir(), "admin.sock")
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	go http.Serve(ln, srv.AdminHandler())
+	defer ln.Close()
+
+	var buf bytes.Buffer
+	if err := run(newAPI("unix:"+path), &output{w: &buf}, []string{"users"}); err != nil {
+		t.Fatalf("users over unix socket: %v", err)
+	}
+	if !strings.Contains(buf.String(), "alice") {
+		t.Errorf("users:\n%s", buf.String())
+	}
+}
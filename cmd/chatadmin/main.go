@@ -0,0 +1,74 @@
+// Command chatadmin manages a running chat server through its admin API
+// (see the server's -admin-addr flag): list and kick users, manage bans,
+// set the message of the day, send announcements and show statistics.
+// Results print as tables, or as JSON with -json.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+func main() {
+	flag.Usage = usage
+	addr := flag.String("addr", getEnvOrDefault("CHAT_ADMIN_ADDR", "localhost:8081"), "Admin API address, host:port or unix:/path")
+	asJSON := flag.Bool("json", false, "Print results as JSON")
+	flag.Parse()
+
+	err := run(newAPI(*addr), &output{w: os.Stdout, json: *asJSON}, flag.Args())
+	switch {
+	case errors.Is(err, errUsage) && flag.NArg() == 0:
+		usage()
+		os.Exit(2)
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(os.Stderr, "chatadmin: %v\n", err)
+		os.Exit(2)
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "chatadmin: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// run executes the command named by args[0].
+func run(a *api, out *output, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("%w: unknown command %q", errUsage, args[0])
+	}
+	if err := cmd.run(a, out, args[1:]); err != nil {
+		if errors.Is(err, errUsage) {
+			return fmt.Errorf("%w; usage: chatadmin %s", errUsage, cmd.usage)
+		}
+		return err
+	}
+	return nil
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintln(w, "Usage: chatadmin [flags] <command> [args]")
+	fmt.Fprintln(w, "\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-28s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintln(w, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func getEnvOrDefault(key, fallback string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return fallback
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Limits on !roll so one command can't produce a huge reply.
+const (
+	maxDice  = 100
+	maxSides = 1000
+)
+
+// handler answers a command. args is the rest of the message after the
+// command word, trimmed.
+type handler func(args string) (string, error)
+
+// bot answers chat messages that start with its prefix, e.g. "!roll 2d6".
+type bot struct {
+	prefix   string
+	handlers map[string]handler
+	help     map[string]string
+
+	now  func() time.Time
+	roll func(sides int) int
+}
+
+func newBot(prefix string) *bot {
+	b := &bot{
+		prefix:   prefix,
+		handlers: make(map[string]handler),
+		help:     make(map[string]string),
+		now:      time.Now,
+		roll:     func(sides int) int { return rand.IntN(sides) + 1 },
+	}
+	b.handle("time", "show the bot's current time", func(string) (string, error) {
+		return b.now().Format(time.RFC1123), nil
+	})
+	b.handle("roll", "roll dice, e.g. 2d6 (default 1d6)", b.rollDice)
+	b.handle("echo", "repeat the text", func(args string) (string, error) {
+		if args == "" {
+			return "", errors.New("nothing to echo")
+		}
+		return args, nil
+	})
+	b.handle("help", "list commands", func(string) (string, error) {
+		names := make([]string, 0, len(b.help))
+		for name := range b.help {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for i, name := range names {
+			names[i] = b.prefix + name + " - " + b.help[name]
+		}
+		return strings.Join(names, "; "), nil
+	})
+	return b
+}
+
+// handle registers h for the command name.
+func (b *bot) handle(name, help string, h handler) {
+	b.handlers[name] = h
+	b.help[name] = help
+}
+
+// respond returns the reply to a chat message body, or false if the
+// message isn't a command.
+func (b *bot) respond(body string) (string, bool) {
+	rest, ok := strings.CutPrefix(body, b.prefix)
+	if !ok {
+		return "", false
+	}
+	name, args, _ := strings.Cut(rest, " ")
+	h, ok := b.handlers[name]
+	if !ok {
+		return "", false
+	}
+	reply, err := h(strings.TrimSpace(args))
+	if err != nil {
+		return fmt.Sprintf("%s%s: %v", b.prefix, name, err), true
+	}
+	return reply, true
+}
+
+// rollDice implements !roll NdM.
+func (b *bot) rollDice(args string) (string, error) {
+	if args == "" {
+		args = "1d6"
+	}
+	count, sides, ok := strings.Cut(strings.ToLower(args), "d")
+	if count == "" {
+		count = "1"
+	}
+	n, err1 := strconv.Atoi(count)
+	m, err2 := strconv.Atoi(sides)
+	if !ok || err1 != nil || err2 != nil || n < 1 || n > maxDice || m < 2 || m > maxSides {
+		return "", fmt.Errorf("want NdM with N up to %d and M from 2 to %d", maxDice, maxSides)
+	}
+
+	rolls := make([]string, n)
+	total := 0
+	for i := range rolls {
+		r := b.roll(m)
+		total += r
+		rolls[i] = strconv.Itoa(r)
+	}
+	if n == 1 {
+		return fmt.Sprintf("rolled %dd%d: %d", n, m, total), nil
+	}
+	return fmt.Sprintf("rolled %dd%d: %s = %d", n, m, strings.Join(rolls, " + "), total), nil
+}
+
+// serve answers commands from other users until the connection ends. A
+// client created with NewContext ends when its context is cancelled, in
+// which case serve returns nil.
+func (b *bot) serve(c *client.ChatClient) error {
+	for {
+		msg, err := c.Receive()
+		if errors.Is(err, client.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Type != protocol.TypeMsg {
+			continue
+		}
+		reply, ok := b.respond(msg.Body)
+		if !ok {
+			continue
+		}
+		if err := c.SendMessage(reply); err != nil && !errors.Is(err, client.ErrQueued) {
+			return fmt.Errorf("replying to %s: %w", msg.Username, err)
+		}
+	}
+}
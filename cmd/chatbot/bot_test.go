@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chattest"
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestRespond(t *testing.T) {
+	b := newBot("!")
+	b.now = func() time.Time { return time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC) }
+	b.roll = func(sides int) int { return sides }
+
+	tests := []struct {
+		body string
+		want string
+		ok   bool
+	}{
+		{"!time", "Fri, 01 Mar 2024 12:00:00 UTC", true},
+		{"!roll", "rolled 1d6: 6", true},
+		{"!roll 3d20", "rolled 3d20: 20 + 20 + 20 = 60", true},
+		{"!roll d4", "rolled 1d4: 4", true},
+		{"!roll 1000d6", "!roll: want NdM with N up to 100 and M from 2 to 1000", true},
+		{"!roll lots", "!roll: want NdM with N up to 100 and M from 2 to 1000", true},
+		{"!echo  hello there ", "hello there", true},
+		{"!echo", "!echo: nothing to echo", true},
+		{"!help", "!echo - repeat the text; !help - list commands; !roll - roll dice, e.g. 2d6 (default 1d6); !time - show the bot's current time", true},
+		{"!unknown", "", false},
+		{"just chatting", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := b.respond(tt.body)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("respond(%q) = %q, %v; want %q, %v", tt.body, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestServe runs the bot against a real server as a smoke test of the
+// client API.
+func TestServe(t *testing.T) {
+	addr := chattest.NewServer(t).Addr().String()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	botClient, err := client.NewContext(ctx, addr, "bot")
+	if err != nil {
+		t.Fatalf("client.NewContext() error = %v", err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- newBot("!").serve(botClient) }()
+
+	user := chattest.NewClient(t, addr, "alice")
+	if err := user.SendMessage("!echo ping"); err != nil {
+		t.Fatalf("SendMessage() error = %v", err)
+	}
+
+	timeout := time.After(2 * time.Second)
+	for done := false; !done; {
+		select {
+		case msg := <-user.Messages():
+			if msg.Type == protocol.TypeMsg {
+				if msg.Username != "bot" || msg.Body != "ping" {
+					t.Errorf("got %+v, want the bot echoing ping", msg)
+				}
+				done = true
+			}
+		case <-timeout:
+			t.Fatal("timed out waiting for the bot's reply")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("serve() = %v after cancel, want nil", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("serve() did not return after cancel")
+	}
+}
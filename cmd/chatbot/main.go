@@ -0,0 +1,34 @@
+// Command chatbot is an example bot built on the client package. It joins
+// a chat server and answers !time, !roll, !echo and !help.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pankaj/simple-chat/client"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "Server host:port, or a ws:// or wss:// URL")
+	username := flag.String("username", "bot", "Username to join as")
+	prefix := flag.String("prefix", "!", "Prefix that marks a message as a bot command")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c, err := client.NewContext(ctx, *addr, *username, client.WithReconnect(client.DefaultReconnectPolicy))
+	if err != nil {
+		log.Fatalf("Failed to connect: %v", err)
+	}
+	log.Printf("Joined %s as %s", *addr, *username)
+
+	if err := newBot(*prefix).serve(c); err != nil {
+		log.Fatalf("Bot stopped: %v", err)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultProfile is applied when -profile isn't given, if the config file
+// has a section with this name.
+const defaultProfile = "default"
+
+// defaultConfigPath returns the config file location, normally
+// ~/.config/simple-chat/config.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "simple-chat", "config")
+}
+
+// defaultKeyPath returns where the -e2e private key is kept, next to the
+// config file.
+func defaultKeyPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "e2e.key"
+	}
+	return filepath.Join(dir, "simple-chat", "e2e.key")
+}
+
+// parseConfig reads profiles from an INI-style file:
+//
+//	# comment
+//	[work]
+//	host = chat.example.com
+//	username = alice
+//
+// Keys are client flag names. It returns the settings of each profile.
+func parseConfig(r io.Reader) (map[string]map[string]string, error) {
+	profiles := make(map[string]map[string]string)
+	var current map[string]string
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			name := strings.TrimSpace(line[1 : len(line)-1])
+			if name == "" {
+				return nil, fmt.Errorf("line %d: empty profile name", n)
+			}
+			if profiles[name] == nil {
+				profiles[name] = make(map[string]string)
+			}
+			current = profiles[name]
+		default:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, fmt.Errorf("line %d: expected key = value", n)
+			}
+			if current == nil {
+				return nil, fmt.Errorf("line %d: setting outside of a [profile] section", n)
+			}
+			current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return profiles, scanner.Err()
+}
+
+// applyProfile loads the named profile from the config file at path and
+// sets the matching flags, except those given on the command line. A
+// missing file or profile is only an error when required is set.
+func applyProfile(fset *flag.FlagSet, path, name string, required bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) && !required {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	profiles, err := parseConfig(f)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	settings, ok := profiles[name]
+	if !ok {
+		if required {
+			return fmt.Errorf("%s: no profile %q", path, name)
+		}
+		return nil
+	}
+
+	explicit := make(map[string]bool)
+	fset.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	for key, value := range settings {
+		if key == "config" || key == "profile" || fset.Lookup(key) == nil {
+			return fmt.Errorf("%s: profile %q: unknown setting %q", path, name, key)
+		}
+		if explicit[key] {
+			continue
+		}
+		if err := fset.Set(key, value); err != nil {
+			return fmt.Errorf("%s: profile %q: %s: %w", path, name, key, err)
+		}
+	}
+	return nil
+}
+
+// saveSetting sets key to value in the named profile of the config file at
+// path, creating the file or profile if needed. Other lines, including
+// comments, are kept as they are.
+func saveSetting(path, name, key, value string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	}
+	setting := key + " = " + value
+
+	// Find the profile's section and, within it, the line holding key.
+	section, end, found := -1, len(lines), -1
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			if section >= 0 {
+				end = i
+				break
+			}
+			if strings.TrimSpace(line[1:len(line)-1]) == name {
+				section = i
+			}
+			continue
+		}
+		if k, _, ok := strings.Cut(line, "="); ok && section >= 0 && strings.TrimSpace(k) == key {
+			found = i
+		}
+	}
+
+	switch {
+	case found >= 0:
+		lines[found] = setting
+	case section >= 0:
+		// Insert after the section's last non-blank line.
+		at := end
+		for at > section+1 && strings.TrimSpace(lines[at-1]) == "" {
+			at--
+		}
+		lines = append(lines[:at], append([]string{setting}, lines[at:]...)...)
+	default:
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "["+name+"]", setting)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+}
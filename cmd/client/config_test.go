@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testConfig = `
+# Personal servers
+[default]
+host = localhost
+
+[work]
+host = chat.example.com
+port = 9000
+username = alice
+theme = users=31:32,notice=2
+`
+
+func TestParseConfig(t *testing.T) {
+	profiles, err := parseConfig(strings.NewReader(testConfig))
+	if err != nil {
+		t.Fatalf("parseConfig() error = %v", err)
+	}
+	if got := profiles["work"]["theme"]; got != "users=31:32,notice=2" {
+		t.Errorf("work theme = %q", got)
+	}
+	if got := profiles["default"]["host"]; got != "localhost" {
+		t.Errorf("default host = %q", got)
+	}
+
+	for _, bad := range []string{"host = x", "[work]\nhost", "[ ]"} {
+		if _, err := parseConfig(strings.NewReader(bad)); err == nil {
+			t.Errorf("parseConfig(%q) expected error", bad)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+	if err := os.WriteFile(path, []byte(testConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	fset := flag.NewFlagSet("test", flag.ContinueOnError)
+	host := fset.String("host", "localhost", "")
+	port := fset.String("port", "8080", "")
+	username := fset.String("username", "", "")
+	fset.String("theme", "", "")
+	if err := fset.Parse([]string{"-port", "7000"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := applyProfile(fset, path, "work", true); err != nil {
+		t.Fatalf("applyProfile() error = %v", err)
+	}
+	if *host != "chat.example.com" || *username != "alice" {
+		t.Errorf("profile not applied: host=%q username=%q", *host, *username)
+	}
+	if *port != "7000" {
+		t.Errorf("command-line port overridden by profile: %q", *port)
+	}
+
+	if err := applyProfile(fset, path, "home", true); err == nil {
+		t.Error("expected error for a missing required profile")
+	}
+	if err := applyProfile(fset, path, "home", false); err != nil {
+		t.Errorf("optional missing profile: %v", err)
+	}
+	if err := applyProfile(fset, filepath.Join(t.TempDir(), "none"), "default", false); err != nil {
+		t.Errorf("optional missing file: %v", err)
+	}
+
+	os.WriteFile(path, []byte("[work]\nbogus = 1\n"), 0o600)
+	if err := applyProfile(fset, path, "work", true); err == nil {
+		t.Error("expected error for an unknown setting")
+	}
+}
+
+func TestSaveSetting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "config")
+
+	steps := []struct {
+		profile, key, value string
+		want                string
+	}{
+		{"default", "ignore", "bob", "[default]\nignore = bob\n"},
+		{"default", "ignore", "bob,carol", "[default]\nignore = bob,carol\n"},
+		{"work", "ignore", "dave", "[default]\nignore = bob,carol\n\n[work]\nignore = dave\n"},
+		{"default", "host", "localhost", "[default]\nignore = bob,carol\nhost = localhost\n\n[work]\nignore = dave\n"},
+	}
+	for _, s := range steps {
+		if err := saveSetting(path, s.profile, s.key, s.value); err != nil {
+			t.Fatalf("saveSetting(%s, %s) error = %v", s.profile, s.key, err)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != s.want {
+			t.Fatalf("after setting %s.%s config =\n%s\nwant\n%s", s.profile, s.key, data, s.want)
+		}
+	}
+}
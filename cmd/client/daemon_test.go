@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestListenControl(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "chat.sock")
+	ln, err := listenControl(path)
+	if err != nil {
+		t.Fatalf("listenControl() error = %v", err)
+	}
+	if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Errorf("socket mode = %v, %v; want 0600", fi.Mode().Perm(), err)
+	}
+
+	if _, err := listenControl(path); err == nil || !strings.Contains(err.Error(), "already running") {
+		t.Errorf("second listenControl() error = %v, want already running", err)
+	}
+
+	// A socket nobody is listening on is stale and replaced.
+	ln.(*net.UnixListener).SetUnlinkOnClose(false)
+	ln.Close()
+	ln, err = listenControl(path)
+	if err != nil {
+		t.Fatalf("listenControl() over a stale socket: %v", err)
+	}
+	ln.Close()
+}
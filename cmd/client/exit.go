@@ -0,0 +1,30 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/pankaj/simple-chat/client"
+)
+
+// Exit codes, so scripts can tell why the client failed.
+const (
+	exitUsage   = 1 // bad flags or configuration
+	exitNetwork = 2 // could not connect, or the connection was lost
+	exitSend    = 3 // connected, but the message could not be sent
+	exitAuth    = 4 // the server refused to let us join, or we couldn't answer its challenge
+	exitKicked  = 5 // the server disconnected us on purpose
+)
+
+// exitCode maps the error that ended a session to an exit code.
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, client.ErrJoinRejected), errors.Is(err, client.ErrChallenge):
+		return exitAuth
+	case errors.Is(err, client.ErrKicked):
+		return exitKicked
+	default:
+		return exitNetwork
+	}
+}
@@ -1,40 +1,312 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
 
 	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/client/tui"
+	"github.com/pankaj/simple-chat/netopt"
 )
 
 func main() {
 	host := flag.String("host", getEnvOrDefault("CHAT_HOST", "localhost"), "Server host")
 	port := flag.String("port", getEnvOrDefault("CHAT_PORT", "8080"), "Server port")
+	serverURL := flag.String("url", getEnvOrDefault("CHAT_URL", ""), "Connect to this ws://, wss:// or quic:// (experimental) URL instead of -host and -port")
+	tlsCA := flag.String("tls-ca", getEnvOrDefault("CHAT_TLS_CA", ""), "PEM file of CA certificates to trust for quic:// instead of the system roots")
+	tcpKeepAlive := flag.Duration("tcp-keepalive", 0, "Interval between TCP keep-alive probes (0 is the default of 15s, negative disables them)")
+	tcpNoDelay := flag.Bool("tcp-nodelay", true, "Send small writes straight away (TCP_NODELAY); false lets the kernel coalesce them")
+	tcpReadBuffer := flag.Int("tcp-read-buffer", 0, "TCP receive buffer size in bytes (0 is the system default)")
+	tcpWriteBuffer := flag.Int("tcp-write-buffer", 0, "TCP send buffer size in bytes (0 is the system default)")
+	tcpLinger := flag.Duration("tcp-linger", 0, "How long closing a TCP connection waits to send unsent data (0 sends it in the background, negative discards it)")
 	username := flag.String("username", getEnvOrDefault("CHAT_USERNAME", ""), "Username")
+	fullScreen := flag.Bool("tui", false, "Use the full-screen terminal UI")
+	keys := flag.String("keys", getEnvOrDefault("CHAT_KEYS", "default"), "Key bindings for -tui: 'default', or 'vim' for modal normal and insert modes")
+	message := flag.String("m", "", "Send this message and exit")
+	wait := flag.Duration("wait", 0, "With -m, print incoming messages for this long before exiting")
+	reconnect := flag.Bool("reconnect", true, "Reconnect automatically when the connection is lost")
+	e2e := flag.Bool("e2e", false, "Encrypt messages end to end for other users running with -e2e")
+	e2eKey := flag.String("e2e-key", defaultKeyPath(), "File holding the private key used with -e2e; created if missing")
+	heartbeat := flag.Duration("heartbeat", 15*time.Second, "Interval between keepalive pings; the connection is considered dead after three missed intervals (0 disables)")
+	queueLimit := flag.Int("queue-limit", 100, "Messages to keep while reconnecting")
+	scrollbackSize := flag.Int("scrollback", 500, "Number of messages kept for /scroll")
+	headless := flag.Bool("headless", false, "Run without a prompt for scripts; exits on SIGTERM or when the session ends, with a status describing why")
+	jsonMode := flag.Bool("json", false, "Like -headless, but write every received event to stdout as a JSON object per line and read {\"send\": ...} or {\"command\": ...} objects from stdin")
+	daemon := flag.String("daemon", getEnvOrDefault("CHAT_DAEMON", ""), "Hold the session open and share it through a unix socket at this path, where each connection speaks the -json protocol (e.g. with 'socat - UNIX-CONNECT:path'); run it in the background")
+	pipe := flag.Bool("pipe", false, "Send stdin lines as messages and print received messages to stdout; exit at EOF")
+	noColor := flag.Bool("no-color", os.Getenv("NO_COLOR") != "", "Disable colored output (also set by NO_COLOR)")
+	timeFormat := flag.String("time-format", getEnvOrDefault("CHAT_TIME_FORMAT", "15:04"), "Layout for message timestamps in Go time format; empty disables them")
+	logFile := flag.String("log-file", getEnvOrDefault("CHAT_LOG_FILE", ""), "Append a transcript of the conversation to this file")
+	logMaxSize := flag.Int64("log-max-size", 10<<20, "Rotate the transcript after this many bytes; 0 disables rotation")
+	themeSpec := flag.String("theme", getEnvOrDefault("CHAT_THEME", ""), "Color theme, e.g. 'users=31:32:34,notice=2,error=31,mention=1;33'")
+	msgTemplate := flag.String("msg-template", "", "Template for chat messages in Go text/template syntax, e.g. '{{.Time}} <{{.User}}> {{.Body}}'; fields are Time, When, User, Away, Body and Members")
+	joinTemplate := flag.String("join-template", "", "Template for users joining, like -msg-template")
+	leaveTemplate := flag.String("leave-template", "", "Template for users leaving, like -msg-template")
+	alertSpec := flag.String("alerts", getEnvOrDefault("CHAT_ALERTS", ""), "Alert rules separated by ';', e.g. 'mention=bell;keyword:deploy|outage=bell+highlight'")
+	pluginSpec := flag.String("plugins", getEnvOrDefault("CHAT_PLUGINS", ""), "Programs to run as plugins, separated by ';', e.g. 'autoreply --delay 5s;chatlog'; see client.ProcessPlugin for what they read and write")
+	configPath := flag.String("config", getEnvOrDefault("CHAT_CONFIG", defaultConfigPath()), "Config file with named profiles")
+	ignore := flag.String("ignore", getEnvOrDefault("CHAT_IGNORE", ""), "Comma-separated usernames whose messages are hidden; /ignore and /unignore update it in the config file")
+	uploadURL := flag.String("upload-url", getEnvOrDefault("CHAT_UPLOAD_URL", ""), "Web address of the server, e.g. https://chat.example.com, for uploading files with /attach (disabled if empty)")
+	joinAnswer := flag.String("join-answer", getEnvOrDefault("CHAT_JOIN_ANSWER", ""), "Answer to the server's join question, if it asks one (prompted for on a terminal if unset)")
+	rename := flag.Bool("rename", os.Getenv("CHAT_RENAME") != "", "If the username is taken, join under a free variant such as alice_2 instead of failing")
+	locale := flag.String("locale", getEnvOrDefault("CHAT_LOCALE", ""), "Language for the server's errors and notices, e.g. 'de' or 'pt-BR', if the server has translations (English otherwise)")
+	profile := flag.String("profile", getEnvOrDefault("CHAT_PROFILE", ""), "Profile from the config file to use (default \"default\" if present)")
 	flag.Parse()
 
+	profileName, required := *profile, *profile != ""
+	if !required {
+		profileName = defaultProfile
+	}
+	if *configPath != "" {
+		if err := applyProfile(flag.CommandLine, *configPath, profileName, required); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load profile: %v\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+
 	if *username == "" {
 		fmt.Fprintln(os.Stderr, "Username is required. Use -username flag or CHAT_USERNAME env var.")
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+
+	theme, err := client.ParseTheme(*themeSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -theme: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	var uiOpts []tui.Option
+	switch *keys {
+	case "default":
+	case "vim":
+		uiOpts = append(uiOpts, tui.WithVimKeys())
+	default:
+		fmt.Fprintf(os.Stderr, "Invalid -keys %q: want default or vim\n", *keys)
+		os.Exit(exitUsage)
+	}
+
+	var templates client.Templates
+	for _, t := range []struct {
+		flag string
+		text string
+		tmpl **template.Template
+	}{
+		{"msg-template", *msgTemplate, &templates.Message},
+		{"join-template", *joinTemplate, &templates.Join},
+		{"leave-template", *leaveTemplate, &templates.Leave},
+	} {
+		if t.text == "" {
+			continue
+		}
+		if *t.tmpl, err = client.ParseTemplate(t.flag, t.text); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid -%s: %v\n", t.flag, err)
+			os.Exit(exitUsage)
+		}
+	}
+
+	alerts, err := client.ParseAlertRules(*alertSpec)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -alerts: %v\n", err)
+		os.Exit(exitUsage)
+	}
+
+	var transcript *client.Transcript
+	if *logFile != "" {
+		transcript, err = client.OpenTranscript(*logFile, *logMaxSize)
+		if err != nil {
+			log.Fatalf("Failed to open transcript: %v", err)
+		}
+		defer transcript.Close()
 	}
 
 	addr := fmt.Sprintf("%s:%s", *host, *port)
-	c, err := client.New(addr, *username)
+	if *serverURL != "" {
+		addr = *serverURL
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	opts := []client.Option{
+		client.WithQueueLimit(*queueLimit),
+		client.WithScrollback(*scrollbackSize),
+		client.WithHeartbeat(*heartbeat, 0),
+	}
+	if *reconnect {
+		opts = append(opts, client.WithReconnect(client.DefaultReconnectPolicy))
+	}
+	opts = append(opts, client.WithTCPOptions(netopt.TCP{
+		KeepAlive:   *tcpKeepAlive,
+		Nagle:       !*tcpNoDelay,
+		ReadBuffer:  *tcpReadBuffer,
+		WriteBuffer: *tcpWriteBuffer,
+		Linger:      *tcpLinger,
+	}))
+	if *tlsCA != "" {
+		pem, err := os.ReadFile(*tlsCA)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to read -tls-ca: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			fmt.Fprintf(os.Stderr, "No certificates found in %s\n", *tlsCA)
+			os.Exit(exitUsage)
+		}
+		opts = append(opts, client.WithTLSConfig(&tls.Config{RootCAs: pool}))
+	}
+	if *e2e {
+		keys, err := client.LoadKeyPair(*e2eKey)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load encryption key: %v\n", err)
+			os.Exit(exitUsage)
+		}
+		opts = append(opts, client.WithE2E(keys))
+	}
+	var saveIgnored func([]string) error
+	if *configPath != "" {
+		saveIgnored = func(names []string) error {
+			return saveSetting(*configPath, profileName, "ignore", strings.Join(names, ","))
+		}
+	}
+	opts = append(opts, client.WithIgnored(splitList(*ignore), saveIgnored), client.WithAlerts(alerts...), client.WithTemplates(templates))
+	for _, spec := range strings.Split(*pluginSpec, ";") {
+		if args := strings.Fields(spec); len(args) > 0 {
+			p := client.NewProcessPlugin(args[0], args[1:]...)
+			p.Stderr = os.Stderr
+			opts = append(opts, client.WithPlugins(p))
+		}
+	}
+	if *locale != "" {
+		opts = append(opts, client.WithLocale(*locale))
+	}
+	if *rename {
+		opts = append(opts, client.WithRename())
+	}
+	if *uploadURL != "" {
+		opts = append(opts, client.WithUploadURL(*uploadURL))
+	}
+	switch {
+	case *joinAnswer != "":
+		opts = append(opts, client.WithChallengeAnswer(func(string) (string, error) { return *joinAnswer, nil }))
+	case isTerminal(os.Stdin):
+		opts = append(opts, client.WithChallengeAnswer(askOnTerminal))
+	}
+	c, err := client.NewContext(ctx, addr, *username, opts...)
 	if err != nil {
-		log.Fatalf("Failed to connect: %v", err)
+		log.Printf("Failed to connect: %v", err)
+		os.Exit(exitCode(err))
 	}
 	defer c.Close()
+	c.SetTimeFormat(*timeFormat)
+	if transcript != nil {
+		c.SetTranscript(transcript)
+	}
+
+	if *message != "" {
+		os.Exit(sendOnce(ctx, c, *message, *wait, os.Stdout, os.Stderr))
+	}
+
+	if *headless {
+		err := c.Headless(ctx, os.Stdin, os.Stdout)
+		if err != nil {
+			log.Printf("Session ended: %v", err)
+		}
+		os.Exit(exitCode(err))
+	}
+
+	if *daemon != "" {
+		ln, err := listenControl(*daemon)
+		if err != nil {
+			log.Fatalf("Daemon mode failed: %v", err)
+		}
+		err = c.Serve(ctx, ln)
+		if err != nil {
+			log.Printf("Session ended: %v", err)
+		}
+		os.Exit(exitCode(err))
+	}
+
+	if *jsonMode {
+		err := c.JSON(ctx, os.Stdin, os.Stdout)
+		if err != nil {
+			log.Printf("Session ended: %v", err)
+		}
+		os.Exit(exitCode(err))
+	}
+
+	if *pipe {
+		if err := c.Pipe(os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("Pipe mode failed: %v", err)
+		}
+		return
+	}
 
-	fmt.Printf("Connected to %s as %s\n", addr, *username)
-	fmt.Println("Commands: 'send <message>' or 'leave'")
+	if *fullScreen {
+		if err := tui.Run(c, fmt.Sprintf("%s@%s", *username, addr), uiOpts...); err != nil {
+			log.Fatalf("Terminal UI failed: %v", err)
+		}
+		return
+	}
+
+	if !*noColor && isTerminal(os.Stdout) {
+		c.SetTheme(&theme)
+	}
+	fmt.Printf("Connected to %s as %s\n", addr, c.Username())
+	if fp := c.Fingerprint(); fp != "" {
+		fmt.Printf("End-to-end encryption is on; your key fingerprint is %s.\n", fp)
+	}
+	fmt.Println("Type /help for a list of commands.")
 	c.Run()
 }
 
+// askOnTerminal prompts for the answer to a join question. It reads a
+// byte at a time so that nothing meant for the session is consumed.
+func askOnTerminal(question string) (string, error) {
+	fmt.Fprintf(os.Stderr, "The server asks: %s\nAnswer: ", question)
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := os.Stdin.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return strings.TrimSpace(string(line)), nil
+		}
+		line = append(line, b[0])
+	}
+}
+
+// isTerminal reports whether f is a character device, so escape sequences
+// aren't written into files or pipes.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
 func getEnvOrDefault(key, fallback string) string {
 	if val := os.Getenv(key); val != "" {
 		return val
 	}
 	return fallback
 }
+
+// splitList splits a comma-separated flag value, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
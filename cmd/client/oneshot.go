@@ -0,0 +1,43 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pankaj/simple-chat/client"
+)
+
+// sendOnce sends body and waits for the server to acknowledge it. Then, for
+// up to wait, it prints received messages to out before leaving. It returns
+// the process exit code.
+func sendOnce(ctx context.Context, c *client.ChatClient, body string, wait time.Duration, out io.Writer, errOut io.Writer) int {
+	defer c.Close()
+
+	if err := c.SendAcked(ctx, body); err != nil {
+		fmt.Fprintf(errOut, "Failed to send message: %v\n", err)
+		return exitSend
+	}
+	if wait <= 0 {
+		return 0
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case msg, ok := <-c.Messages():
+			if !ok {
+				return 0
+			}
+			if text, ok := client.FormatMessage(msg); ok {
+				fmt.Fprintln(out, text)
+			}
+		case <-timer.C:
+			return 0
+		case <-ctx.Done():
+			return 0
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/server"
+)
+
+func TestSendOnce(t *testing.T) {
+	srv := server.New()
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	addr := srv.Addr().String()
+
+	watcher, err := client.New(addr, "watcher")
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	defer watcher.Close()
+
+	ci, err := client.New(addr, "ci")
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	if code := sendOnce(context.Background(), ci, "build passed", 0, io.Discard, io.Discard); code != 0 {
+		t.Fatalf("sendOnce() = %d, want 0", code)
+	}
+
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case msg := <-watcher.Messages():
+			if msg.Body == "build passed" && msg.Username == "ci" {
+				return
+			}
+		case <-deadline:
+			t.Fatal("message from one-shot client never arrived")
+		}
+	}
+}
+
+func TestSendOnceInvalidMessage(t *testing.T) {
+	srv := server.New()
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	c, err := client.New(srv.Addr().String(), "ci")
+	if err != nil {
+		t.Fatalf("client.New() error = %v", err)
+	}
+	var errOut strings.Builder
+	if code := sendOnce(context.Background(), c, "two\nlines", 0, io.Discard, &errOut); code != exitSend {
+		t.Errorf("sendOnce() = %d, want %d", code, exitSend)
+	}
+	if !strings.Contains(errOut.String(), "Failed to send") {
+		t.Errorf("stderr = %q", errOut.String())
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// nickMap translates names between the two sides. Names without an entry
+// are used unchanged.
+type nickMap struct {
+	toIRC  map[string]string // chat username -> IRC nick
+	toChat map[string]string // IRC nick -> chat username
+}
+
+// parseNickMap parses a comma-separated list of chatname=ircnick pairs.
+func parseNickMap(s string) (nickMap, error) {
+	m := nickMap{toIRC: make(map[string]string), toChat: make(map[string]string)}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		chat, irc, ok := strings.Cut(pair, "=")
+		chat, irc = strings.TrimSpace(chat), strings.TrimSpace(irc)
+		if !ok || chat == "" || irc == "" {
+			return nickMap{}, fmt.Errorf("invalid nick mapping %q: want chatname=ircnick", pair)
+		}
+		m.toIRC[chat] = irc
+		m.toChat[irc] = chat
+	}
+	return m, nil
+}
+
+func (m nickMap) ircNick(username string) string {
+	if nick, ok := m.toIRC[username]; ok {
+		return nick
+	}
+	return username
+}
+
+func (m nickMap) chatName(nick string) string {
+	if name, ok := m.toChat[nick]; ok {
+		return name
+	}
+	return nick
+}
+
+// bridge mirrors messages between a chat room and an IRC channel. Each
+// side sees the other's messages as "<name> text" from the bridge user.
+type bridge struct {
+	chat  *client.ChatClient
+	irc   *ircClient
+	nicks nickMap
+}
+
+// fromIRC formats an IRC PRIVMSG for the chat room. CTCP ACTIONs
+// (/me) become "* name text"; other CTCP requests are dropped.
+func (b *bridge) fromIRC(m ircMessage) (string, bool) {
+	name := b.nicks.chatName(m.nick())
+	text := stripFormatting(m.Params[1])
+	if ctcp, ok := strings.CutPrefix(text, "\x01"); ok {
+		action, ok := strings.CutPrefix(strings.TrimSuffix(ctcp, "\x01"), "ACTION ")
+		if !ok {
+			return "", false
+		}
+		return fmt.Sprintf("* %s %s", name, action), true
+	}
+	if strings.TrimSpace(text) == "" {
+		return "", false
+	}
+	return fmt.Sprintf("<%s> %s", name, text), true
+}
+
+// fromChat formats a chat message for IRC. Only MSGs are mirrored, and
+// end-to-end encrypted traffic is skipped since the bridge can't read it.
+func (b *bridge) fromChat(msg protocol.Message) (string, bool) {
+	if msg.Type != protocol.TypeMsg || strings.HasPrefix(msg.Body, "!e2e") {
+		return "", false
+	}
+	return fmt.Sprintf("<%s> %s", b.nicks.ircNick(msg.Username), msg.Body), true
+}
+
+// relayIRC is the IRC client's delivery callback.
+func (b *bridge) relayIRC(m ircMessage) {
+	text, ok := b.fromIRC(m)
+	if !ok {
+		return
+	}
+	// While the chat side reconnects, messages are queued and sent once
+	// it's back.
+	if err := b.chat.SendMessage(text); err != nil && !errors.Is(err, client.ErrQueued) {
+		log.Printf("Dropped message from IRC: %v", err)
+	}
+}
+
+// serveChat mirrors chat messages to IRC until the chat client ends. It
+// returns nil if the client was closed.
+func (b *bridge) serveChat() error {
+	for {
+		msg, err := b.chat.Receive()
+		if errors.Is(err, client.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		text, ok := b.fromChat(msg)
+		if !ok {
+			continue
+		}
+		if err := b.irc.say(text); err != nil {
+			log.Printf("Dropped message from chat: %v", err)
+		}
+	}
+}
+
+// stripFormatting removes mIRC bold, colour, italic, underline, reverse
+// and reset codes, which would show up as garbage in chat clients.
+func stripFormatting(s string) string {
+	if !strings.ContainsAny(s, "\x02\x03\x0f\x11\x16\x1d\x1e\x1f") {
+		return s
+	}
+	var sb strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\x02', '\x0f', '\x11', '\x16', '\x1d', '\x1e', '\x1f':
+		case '\x03':
+			// Colour: up to two digits, optionally ",bg" with up to two.
+			i += countDigits(s[i+1:], 2)
+			if i+2 < len(s) && s[i+1] == ',' && countDigits(s[i+2:], 2) > 0 {
+				i += 1 + countDigits(s[i+2:], 2)
+			}
+		default:
+			sb.WriteByte(s[i])
+		}
+	}
+	return sb.String()
+}
+
+// countDigits returns how many of the first max bytes of s are digits.
+func countDigits(s string, max int) int {
+	n := 0
+	for n < len(s) && n < max && s[n] >= '0' && s[n] <= '9' {
+		n++
+	}
+	return n
+}
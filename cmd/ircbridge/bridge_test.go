@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chattest"
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestParseIRC(t *testing.T) {
+	tests := []struct {
+		line string
+		want ircMessage
+	}{
+		{"PING :irc.example.net", ircMessage{Command: "PING", Params: []string{"irc.example.net"}}},
+		{":bob!b@host PRIVMSG #chat :hi there", ircMessage{Prefix: "bob!b@host", Command: "PRIVMSG", Params: []string{"#chat", "hi there"}}},
+		{"@time=x :srv 001 bridge :Welcome", ircMessage{Prefix: "srv", Command: "001", Params: []string{"bridge", "Welcome"}}},
+		{":bob JOIN  #chat", ircMessage{Prefix: "bob", Command: "JOIN", Params: []string{"#chat"}}},
+	}
+	for _, tt := range tests {
+		got, ok := parseIRC(tt.line)
+		if !ok || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseIRC(%q) = %+v, %v; want %+v", tt.line, got, ok, tt.want)
+		}
+	}
+	if _, ok := parseIRC(":prefix-only"); ok {
+		t.Error("parseIRC accepted a line without a command")
+	}
+}
+
+func TestSplitText(t *testing.T) {
+	got := splitText("aaaa bbbb cccc", 9)
+	if want := []string{"aaaa bbbb", "cccc"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("splitText = %q, want %q", got, want)
+	}
+	// Never split inside a multi-byte rune.
+	for _, chunk := range splitText(strings.Repeat("é", 10), 5) {
+		if !strings.HasPrefix(chunk, "é") || len(chunk) > 5 {
+			t.Errorf("bad chunk %q", chunk)
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	names, err := parseNickMap("Pankaj Kumar=pankaj, al=alice_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseNickMap("nobody"); err == nil {
+		t.Error("parseNickMap accepted a pair without =")
+	}
+	b := &bridge{nicks: names}
+
+	irc := []struct {
+		from, text string
+		want       string
+	}{
+		{"bob", "hello", "<bob> hello"},
+		{"alice_", "hi", "<al> hi"},
+		{"bob", "\x01ACTION waves\x01", "* bob waves"},
+		{"bob", "\x01VERSION\x01", ""},
+		{"bob", "\x02bold\x02 \x0304,12red\x03 plain", "<bob> bold red plain"},
+	}
+	for _, tt := range irc {
+		got, _ := b.fromIRC(ircMessage{Prefix: tt.from + "!u@h", Command: "PRIVMSG", Params: []string{"#chat", tt.text}})
+		if got != tt.want {
+			t.Errorf("fromIRC(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+
+	chat := []struct {
+		msg  protocol.Message
+		want string
+	}{
+		{protocol.Message{Type: protocol.TypeMsg, Username: "Pankaj Kumar", Body: "hey"}, "<pankaj> hey"},
+		{protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "!e2e bob:xyz"}, ""},
+		{protocol.Message{Type: protocol.TypeJoined, Username: "bob"}, ""},
+	}
+	for _, tt := range chat {
+		if got, _ := b.fromChat(tt.msg); got != tt.want {
+			t.Errorf("fromChat(%+v) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+// fakeIRC is a minimal IRC server for one channel. It hands each
+// connection to the test over conns after registration and JOIN.
+type fakeIRC struct {
+	ln    net.Listener
+	conns chan *ircPeer
+}
+
+type ircPeer struct {
+	conn    net.Conn
+	scanner *bufio.Scanner
+}
+
+func newFakeIRC(t *testing.T) *fakeIRC {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeIRC{ln: ln, conns: make(chan *ircPeer, 4)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+			go f.register(conn)
+		}
+	}()
+	return f
+}
+
+// register answers NICK with a nick collision first, then welcomes the
+// bridge and confirms its JOIN.
+func (f *fakeIRC) register(conn net.Conn) {
+	p := &ircPeer{conn: conn, scanner: bufio.NewScanner(conn)}
+	taken := true
+	for p.scanner.Scan() {
+		m, _ := parseIRC(p.scanner.Text())
+		switch m.Command {
+		case "NICK":
+			if taken {
+				taken = false
+				conn.Write([]byte(":srv 433 * " + m.Params[0] + " :Nickname is already in use\r\n"))
+				continue
+			}
+			conn.Write([]byte(":srv 001 " + m.Params[0] + " :Welcome\r\n"))
+			conn.Write([]byte("PING :srv\r\n"))
+		case "JOIN":
+			conn.Write([]byte(":bridge_!u@h JOIN " + m.Params[0] + "\r\n"))
+			f.conns <- p
+			return
+		}
+	}
+}
+
+// expect reads lines until one has the given prefix, skipping others
+// such as PONG.
+func (p *ircPeer) expect(t *testing.T, prefix string) string {
+	t.Helper()
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for p.scanner.Scan() {
+		if line := p.scanner.Text(); strings.HasPrefix(line, prefix) {
+			return line
+		}
+	}
+	t.Fatalf("no line starting %q: %v", prefix, p.scanner.Err())
+	return ""
+}
+
+func TestBridge(t *testing.T) {
+	addr := chattest.NewServer(t).Addr().String()
+	irc := newFakeIRC(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	chat, err := client.NewContext(ctx, addr, "irc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &bridge{
+		chat: chat,
+		irc: newIRCClient(ircConfig{
+			addr:     irc.ln.Addr().String(),
+			nick:     "bridge",
+			channel:  "#chat",
+			minDelay: 10 * time.Millisecond,
+			maxDelay: 50 * time.Millisecond,
+		}),
+	}
+	go b.irc.run(ctx, b.relayIRC)
+	go b.serveChat()
+
+	alice := chattest.NewClient(t, addr, "alice")
+
+	peer := <-irc.conns
+	peer.expect(t, "PONG")
+
+	// IRC to chat.
+	peer.conn.Write([]byte(":bob!b@host PRIVMSG #chat :hello from irc\r\n"))
+	chattest.WaitFor(t, alice, chattest.Msg("", "<bob> hello from irc"))
+
+	// Chat to IRC, after the IRC side reconnects.
+	peer.conn.Close()
+	peer = <-irc.conns
+	for {
+		alice.SendMessage("hello from chat")
+		peer.conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		if peer.scanner.Scan() && strings.HasPrefix(peer.scanner.Text(), "PRIVMSG") {
+			if got := peer.scanner.Text(); got != "PRIVMSG #chat :<alice> hello from chat" {
+				t.Fatalf("IRC got %q", got)
+			}
+			break
+		}
+		// The bridge may not have registered the new connection yet.
+		peer.scanner = bufio.NewScanner(peer.conn)
+	}
+
+	cancel()
+	peer.expect(t, "QUIT")
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxIRCLine is the longest line IRC servers accept, including CRLF.
+const maxIRCLine = 512
+
+// errNotConnected is returned by say while the IRC connection is down.
+var errNotConnected = errors.New("not connected to IRC")
+
+// ircMessage is one parsed IRC line (RFC 1459 section 2.3.1), without
+// IRCv3 tags.
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// parseIRC parses a line without its trailing CRLF.
+func parseIRC(line string) (ircMessage, bool) {
+	var m ircMessage
+	if strings.HasPrefix(line, "@") {
+		// Skip IRCv3 message tags; we never request them but some
+		// servers send them anyway.
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.Prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param == "" {
+			continue
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(param)
+		} else {
+			m.Params = append(m.Params, param)
+		}
+	}
+	return m, m.Command != ""
+}
+
+// nick returns the nickname from a nick!user@host prefix.
+func (m ircMessage) nick() string {
+	nick, _, _ := strings.Cut(m.Prefix, "!")
+	return nick
+}
+
+// ircConfig describes the IRC side of the bridge.
+type ircConfig struct {
+	addr     string // host:port
+	tls      bool
+	nick     string
+	password string // server password, optional
+	channel  string
+
+	minDelay, maxDelay time.Duration // reconnect backoff
+}
+
+// ircClient keeps one connection to an IRC channel open, reconnecting
+// when it drops.
+type ircClient struct {
+	cfg ircConfig
+
+	mu   sync.Mutex
+	conn net.Conn // nil until registered and joined
+}
+
+func newIRCClient(cfg ircConfig) *ircClient {
+	return &ircClient{cfg: cfg}
+}
+
+// run connects and delivers PRIVMSGs sent to the channel by others until
+// ctx is done. Lost connections are retried with exponential backoff.
+func (c *ircClient) run(ctx context.Context, deliver func(ircMessage)) {
+	delay := c.cfg.minDelay
+	for {
+		start := time.Now()
+		err := c.session(ctx, deliver)
+		if ctx.Err() != nil {
+			return
+		}
+		// A connection that lasted a while resets the backoff.
+		if time.Since(start) > c.cfg.maxDelay {
+			delay = c.cfg.minDelay
+		}
+		log.Printf("IRC connection lost: %v; reconnecting in %s", err, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, c.cfg.maxDelay)
+	}
+}
+
+// session runs one connection from dial to disconnect.
+func (c *ircClient) session(ctx context.Context, deliver func(ircMessage)) error {
+	d := net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.cfg.tls {
+		conn, err = (&tls.Dialer{NetDialer: &d}).DialContext(ctx, "tcp", c.cfg.addr)
+	} else {
+		conn, err = d.DialContext(ctx, "tcp", c.cfg.addr)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		fmt.Fprintf(conn, "QUIT :bridge shutting down\r\n")
+		conn.Close()
+	})
+	defer stop()
+	defer c.setConn(nil)
+
+	nick := c.cfg.nick
+	if c.cfg.password != "" {
+		fmt.Fprintf(conn, "PASS %s\r\n", c.cfg.password)
+	}
+	fmt.Fprintf(conn, "NICK %s\r\n", nick)
+	fmt.Fprintf(conn, "USER %s 0 * :simple-chat bridge\r\n", c.cfg.nick)
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), 64*1024)
+	for scanner.Scan() {
+		m, ok := parseIRC(strings.TrimRight(scanner.Text(), "\r"))
+		if !ok {
+			continue
+		}
+		switch m.Command {
+		case "PING":
+			fmt.Fprintf(conn, "PONG :%s\r\n", strings.Join(m.Params, " "))
+		case "433": // ERR_NICKNAMEINUSE
+			nick += "_"
+			fmt.Fprintf(conn, "NICK %s\r\n", nick)
+		case "001": // RPL_WELCOME: registration is done
+			fmt.Fprintf(conn, "JOIN %s\r\n", c.cfg.channel)
+		case "JOIN":
+			if m.nick() == nick && len(m.Params) > 0 && strings.EqualFold(m.Params[0], c.cfg.channel) {
+				log.Printf("Joined %s on %s as %s", c.cfg.channel, c.cfg.addr, nick)
+				c.setConn(conn)
+			}
+		case "NICK":
+			if m.nick() == nick && len(m.Params) > 0 {
+				nick = m.Params[0]
+			}
+		case "KICK":
+			if len(m.Params) > 1 && m.Params[1] == nick {
+				return fmt.Errorf("kicked from %s", c.cfg.channel)
+			}
+		case "PRIVMSG":
+			if len(m.Params) == 2 && strings.EqualFold(m.Params[0], c.cfg.channel) && m.nick() != nick {
+				deliver(m)
+			}
+		case "ERROR":
+			return fmt.Errorf("server error: %s", strings.Join(m.Params, " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return errors.New("connection closed")
+}
+
+func (c *ircClient) setConn(conn net.Conn) {
+	c.mu.Lock()
+	c.conn = conn
+	c.mu.Unlock()
+}
+
+// say sends text to the channel, splitting it into as many PRIVMSGs as
+// the line limit requires.
+func (c *ircClient) say(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return errNotConnected
+	}
+
+	// Leave room for the ":nick!user@host " prefix the server adds when
+	// relaying; 100 bytes covers typical hostmasks.
+	limit := maxIRCLine - len("PRIVMSG  :\r\n") - len(c.cfg.channel) - 100
+	for _, chunk := range splitText(text, limit) {
+		if _, err := fmt.Fprintf(c.conn, "PRIVMSG %s :%s\r\n", c.cfg.channel, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// splitText breaks text into pieces of at most limit bytes without
+// splitting UTF-8 sequences, preferring to break at spaces.
+func splitText(text string, limit int) []string {
+	var chunks []string
+	for len(text) > limit {
+		cut := limit
+		for cut > 0 && !isRuneStart(text[cut]) {
+			cut--
+		}
+		if i := strings.LastIndexByte(text[:cut], ' '); i > limit/2 {
+			cut = i
+		}
+		chunks = append(chunks, text[:cut])
+		text = strings.TrimLeft(text[cut:], " ")
+	}
+	return append(chunks, text)
+}
+
+func isRuneStart(b byte) bool {
+	return b&0xC0 != 0x80
+}
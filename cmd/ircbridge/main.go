@@ -0,0 +1,73 @@
+// Command ircbridge mirrors messages between a simple-chat room and an IRC
+// channel. It joins both as a single user and relays each side's messages
+// to the other as "<name> text", renaming people according to -nicks.
+// Both connections are re-established when they drop. An IRC server
+// password, if needed, is read from IRC_PASSWORD.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pankaj/simple-chat/client"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "Chat server host:port, or a ws:// or wss:// URL")
+	username := flag.String("username", "irc", "Username to join the chat as")
+	ircAddr := flag.String("irc-addr", "irc.libera.chat:6697", "IRC server host:port")
+	ircTLS := flag.Bool("irc-tls", true, "Connect to IRC over TLS")
+	ircNick := flag.String("irc-nick", "chatbridge", "IRC nick; _ is appended while it's taken")
+	ircChannel := flag.String("irc-channel", "", "IRC channel to mirror, e.g. #simple-chat")
+	nicks := flag.String("nicks", "", "Comma-separated chatname=ircnick renames")
+	flag.Parse()
+
+	if *ircChannel == "" {
+		log.Fatal("-irc-channel is required")
+	}
+	names, err := parseNickMap(*nicks)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	c, err := client.NewContext(ctx, *addr, *username, client.WithReconnect(client.DefaultReconnectPolicy))
+	if err != nil {
+		log.Fatalf("Failed to connect to chat: %v", err)
+	}
+	log.Printf("Joined %s as %s", *addr, *username)
+
+	b := &bridge{
+		chat: c,
+		irc: newIRCClient(ircConfig{
+			addr:     *ircAddr,
+			tls:      *ircTLS,
+			nick:     *ircNick,
+			password: os.Getenv("IRC_PASSWORD"),
+			channel:  *ircChannel,
+			minDelay: client.DefaultReconnectPolicy.MinDelay,
+			maxDelay: client.DefaultReconnectPolicy.MaxDelay,
+		}),
+		nicks: names,
+	}
+	ircCtx, stopIRC := context.WithCancel(ctx)
+	ircDone := make(chan struct{})
+	go func() {
+		b.irc.run(ircCtx, b.relayIRC)
+		close(ircDone)
+	}()
+
+	err = b.serveChat()
+	// Quit IRC whether the chat side ended or we were interrupted.
+	stopIRC()
+	<-ircDone
+	if err != nil {
+		log.Fatalf("Bridge stopped: %v", err)
+	}
+}
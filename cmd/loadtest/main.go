@@ -0,0 +1,43 @@
+// Command loadtest connects many protocol-speaking clients to a chat
+// server, has each send messages at a fixed rate and reports throughput,
+// delivery latency, drops and errors.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var cfg config
+	flag.StringVar(&cfg.addr, "addr", "localhost:8080", "Server address")
+	flag.IntVar(&cfg.clients, "clients", 50, "Number of concurrent clients")
+	flag.Float64Var(&cfg.rate, "rate", 1, "Messages per second sent by each client")
+	flag.DurationVar(&cfg.duration, "duration", 30*time.Second, "How long to send for")
+	flag.DurationVar(&cfg.grace, "grace", 2*time.Second, "How long to wait for deliveries after sending stops")
+	flag.IntVar(&cfg.size, "size", 64, "Message body size in bytes")
+	flag.StringVar(&cfg.prefix, "prefix", "load", "Username prefix; clients are named <prefix>-<n>")
+	flag.Parse()
+
+	if cfg.clients < 1 || cfg.rate <= 0 || cfg.duration <= 0 {
+		fmt.Fprintln(os.Stderr, "-clients, -rate and -duration must be positive")
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	log.Printf("Running %d client(s) against %s at %.2f msg/s each for %s",
+		cfg.clients, cfg.addr, cfg.rate, cfg.duration)
+	r, err := run(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Load test failed: %v", err)
+	}
+	r.print(os.Stdout)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"time"
+)
+
+// report summarizes a load test run.
+type report struct {
+	clients      int
+	joinFailures int64
+	elapsed      time.Duration
+
+	sent     int64 // messages written by all clients
+	expected int64 // deliveries expected: each message reaches every other client
+	received int64
+	errors   int64
+
+	p50, p90, p99, max time.Duration
+}
+
+func newReport(clients int, elapsed time.Duration, c *counters, samples []time.Duration) *report {
+	r := &report{
+		clients:      clients,
+		joinFailures: c.joinFailures.Load(),
+		elapsed:      elapsed,
+		sent:         c.sent.Load(),
+		received:     c.received.Load(),
+		errors:       c.errors.Load(),
+	}
+	r.expected = r.sent * int64(clients-1)
+
+	slices.Sort(samples)
+	r.p50 = percentile(samples, 50)
+	r.p90 = percentile(samples, 90)
+	r.p99 = percentile(samples, 99)
+	if len(samples) > 0 {
+		r.max = samples[len(samples)-1]
+	}
+	return r
+}
+
+// percentile returns the p'th percentile of sorted samples using the
+// nearest-rank method, or zero if there are none.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p/100*float64(len(sorted))+0.5) - 1
+	return sorted[min(max(rank, 0), len(sorted)-1)]
+}
+
+// dropped returns the expected deliveries that never arrived.
+func (r *report) dropped() int64 {
+	return max(r.expected-r.received, 0)
+}
+
+func (r *report) print(w io.Writer) {
+	secs := r.elapsed.Seconds()
+	fmt.Fprintf(w, "Clients:     %d joined, %d failed to join\n", r.clients, r.joinFailures)
+	fmt.Fprintf(w, "Duration:    %s\n", r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(w, "Sent:        %d (%.1f msg/s)\n", r.sent, float64(r.sent)/secs)
+	fmt.Fprintf(w, "Delivered:   %d of %d expected (%.1f msg/s)\n", r.received, r.expected, float64(r.received)/secs)
+	fmt.Fprintf(w, "Dropped:     %d (%.2f%%)\n", r.dropped(), pct(r.dropped(), r.expected))
+	fmt.Fprintf(w, "Errors:      %d (%.2f%% of sent)\n", r.errors, pct(r.errors, r.sent))
+	fmt.Fprintf(w, "Latency:     p50 %s, p90 %s, p99 %s, max %s\n",
+		r.p50.Round(time.Microsecond), r.p90.Round(time.Microsecond),
+		r.p99.Round(time.Microsecond), r.max.Round(time.Microsecond))
+}
+
+func pct(n, of int64) float64 {
+	if of == 0 {
+		return 0
+	}
+	return 100 * float64(n) / float64(of)
+}
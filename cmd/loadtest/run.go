@@ -0,0 +1,191 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// config describes one load test run.
+type config struct {
+	addr     string
+	clients  int
+	rate     float64       // messages per second per client
+	duration time.Duration // how long to send for
+	grace    time.Duration // how long to wait for stragglers afterwards
+	size     int           // body size in bytes
+	prefix   string        // username prefix
+}
+
+// stampPrefix starts every body sent by the tool. The rest of the body is
+// "<unix nanos>|" and padding, which lets receivers measure latency.
+const stampPrefix = "lt|"
+
+// counters are shared by all clients of a run.
+type counters struct {
+	sent, received, errors atomic.Int64
+	joinFailures           atomic.Int64
+}
+
+// run executes a load test and returns its report. It fails only if no
+// client could join.
+func run(ctx context.Context, cfg config) (*report, error) {
+	var c counters
+	conns := make([]net.Conn, 0, cfg.clients)
+	for i := 0; i < cfg.clients; i++ {
+		conn, err := join(ctx, cfg.addr, fmt.Sprintf("%s-%d", cfg.prefix, i))
+		if err != nil {
+			c.joinFailures.Add(1)
+			continue
+		}
+		conns = append(conns, conn)
+	}
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("no client could join %s", cfg.addr)
+	}
+
+	// Each client reads into its own sample slice to avoid contention.
+	samples := make([][]time.Duration, len(conns))
+	var readers sync.WaitGroup
+	for i, conn := range conns {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			samples[i] = receive(conn, &c)
+		}()
+	}
+
+	start := time.Now()
+	sendCtx, cancel := context.WithTimeout(ctx, cfg.duration)
+	defer cancel()
+	var senders sync.WaitGroup
+	for _, conn := range conns {
+		senders.Add(1)
+		go func() {
+			defer senders.Done()
+			send(sendCtx, conn, cfg, &c)
+		}()
+	}
+	senders.Wait()
+	elapsed := time.Since(start)
+
+	// Give in-flight messages time to arrive, then stop the readers.
+	select {
+	case <-time.After(cfg.grace):
+	case <-ctx.Done():
+	}
+	for _, conn := range conns {
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeLeave}))
+		conn.Close()
+	}
+	readers.Wait()
+
+	var all []time.Duration
+	for _, s := range samples {
+		all = append(all, s...)
+	}
+	return newReport(len(conns), elapsed, &c, all), nil
+}
+
+// join connects and completes the JOIN handshake.
+func join(ctx context.Context, addr, username string) (net.Conn, error) {
+	d := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: username}))
+
+	// Read the reply a byte at a time so nothing after it is buffered
+	// away from the receive loop.
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		line = append(line, b[0])
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	msg, err := protocol.Decode(string(line))
+	if err != nil || msg.Type != protocol.TypeOK {
+		conn.Close()
+		return nil, fmt.Errorf("join %s rejected: %q", username, line)
+	}
+	return conn, nil
+}
+
+// send writes stamped messages at cfg.rate until ctx is done.
+func send(ctx context.Context, conn net.Conn, cfg config, c *counters) {
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / cfg.rate))
+	defer ticker.Stop()
+
+	w := bufio.NewWriter(conn)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		body := stampPrefix + strconv.FormatInt(time.Now().UnixNano(), 10) + "|"
+		if pad := cfg.size - len(body); pad > 0 {
+			body += strings.Repeat("x", pad)
+		}
+		w.WriteString(protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: body}))
+		w.WriteByte('\n')
+		if err := w.Flush(); err != nil {
+			c.errors.Add(1)
+			return
+		}
+		c.sent.Add(1)
+	}
+}
+
+// receive reads until the connection closes, returning the latency of
+// every stamped message.
+func receive(conn net.Conn, c *counters) []time.Duration {
+	var samples []time.Duration
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), 64*1024)
+	for scanner.Scan() {
+		msg, err := protocol.Decode(scanner.Text())
+		if err != nil {
+			c.errors.Add(1)
+			continue
+		}
+		switch msg.Type {
+		case protocol.TypeErr, protocol.TypeKicked, protocol.TypeNack:
+			c.errors.Add(1)
+		case protocol.TypeMsg:
+			stamp, _, ok := strings.Cut(strings.TrimPrefix(msg.Body, stampPrefix), "|")
+			if !ok || !strings.HasPrefix(msg.Body, stampPrefix) {
+				continue
+			}
+			nanos, err := strconv.ParseInt(stamp, 10, 64)
+			if err != nil {
+				continue
+			}
+			c.received.Add(1)
+			samples = append(samples, time.Since(time.Unix(0, nanos)))
+		}
+	}
+	if err := scanner.Err(); err != nil && !errors.Is(err, net.ErrClosed) {
+		c.errors.Add(1)
+	}
+	return samples
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chattest"
+)
+
+func TestPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 1; i <= 100; i++ {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+		{0, time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(samples, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %s, want %s", tt.p, got, tt.want)
+		}
+	}
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile(nil) = %s, want 0", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	srv := chattest.NewServer(t)
+
+	r, err := run(context.Background(), config{
+		addr:     srv.Addr().String(),
+		clients:  4,
+		rate:     50,
+		duration: 200 * time.Millisecond,
+		grace:    300 * time.Millisecond,
+		size:     32,
+		prefix:   "lt",
+	})
+	if err != nil {
+		t.Fatalf("run() error = %v", err)
+	}
+	if r.clients != 4 || r.sent == 0 {
+		t.Fatalf("report = %+v, want 4 clients sending", r)
+	}
+	if r.expected != r.sent*3 || r.dropped() != 0 || r.errors != 0 {
+		t.Errorf("sent %d, expected %d, received %d, errors %d", r.sent, r.expected, r.received, r.errors)
+	}
+	if r.p50 <= 0 || r.max < r.p99 {
+		t.Errorf("latency p50 %s, p99 %s, max %s", r.p50, r.p99, r.max)
+	}
+
+	var out strings.Builder
+	r.print(&out)
+	if !strings.Contains(out.String(), "Dropped:     0 (0.00%)") {
+		t.Errorf("report output:\n%s", out.String())
+	}
+}
+
+func TestRunNoServer(t *testing.T) {
+	_, err := run(context.Background(), config{addr: "127.0.0.1:1", clients: 2, rate: 1, duration: time.Millisecond})
+	if err == nil {
+		t.Fatal("run() against a closed port succeeded")
+	}
+}
@@ -0,0 +1,62 @@
+// Command relay links two chat servers by joining both and mirroring each
+// room's messages to the other. Relayed messages name their author and
+// origin, e.g. "<alice@east> hi". Both connections are re-established
+// when they drop.
+//
+// Servers have a single room, so the whole room is mirrored. Run one
+// relay per pair of servers: relays don't recognise each other's
+// messages, so a cycle of relays would loop.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/pankaj/simple-chat/client"
+)
+
+func main() {
+	addrA := flag.String("a", "", "First server host:port, or a ws:// or wss:// URL")
+	addrB := flag.String("b", "", "Second server host:port, or a ws:// or wss:// URL")
+	originA := flag.String("origin-a", "a", "Label appended to usernames from the first server")
+	originB := flag.String("origin-b", "b", "Label appended to usernames from the second server")
+	username := flag.String("username", "relay", "Username to join both servers as")
+	flag.Parse()
+
+	if *addrA == "" || *addrB == "" {
+		log.Fatal("-a and -b are required")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	a, err := client.NewContext(ctx, *addrA, *username, client.WithReconnect(client.DefaultReconnectPolicy))
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *addrA, err)
+	}
+	b, err := client.NewContext(ctx, *addrB, *username, client.WithReconnect(client.DefaultReconnectPolicy))
+	if err != nil {
+		log.Fatalf("Failed to connect to %s: %v", *addrB, err)
+	}
+	log.Printf("Relaying between %s (%s) and %s (%s)", *addrA, *originA, *addrB, *originB)
+
+	sideA := side{origin: *originA, chat: a}
+	sideB := side{origin: *originB, chat: b}
+	errs := make(chan error, 2)
+	go func() { errs <- mirror(sideA, sideB) }()
+	go func() { errs <- mirror(sideB, sideA) }()
+
+	// When either side ends for good, stop the other too.
+	err = <-errs
+	stop()
+	a.Close()
+	b.Close()
+	<-errs
+	if err != nil {
+		log.Fatalf("Relay stopped: %v", err)
+	}
+}
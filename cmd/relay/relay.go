@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/pankaj/simple-chat/client"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// side is one of the two servers a relay connects.
+type side struct {
+	origin string // label appended to usernames from this server
+	chat   *client.ChatClient
+}
+
+// mirror copies messages from one side to the other until the source
+// client ends. It returns nil if the client was closed.
+func mirror(from, to side) error {
+	for {
+		msg, err := from.chat.Receive()
+		if errors.Is(err, client.ErrClosed) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", from.origin, err)
+		}
+		text, ok := format(msg, from.origin)
+		if !ok {
+			continue
+		}
+		// While the other side reconnects, messages are queued.
+		if err := to.chat.SendMessage(text); err != nil && !errors.Is(err, client.ErrQueued) {
+			log.Printf("Dropped message from %s: %v", from.origin, err)
+		}
+	}
+}
+
+// format renders a message for the other server, tagging its author with
+// the origin, e.g. "<alice@east> hi". Only MSGs are relayed; encrypted
+// traffic is skipped since peers on the other server have no keys for it.
+func format(msg protocol.Message, origin string) (string, bool) {
+	if msg.Type != protocol.TypeMsg || strings.HasPrefix(msg.Body, "!e2e") {
+		return "", false
+	}
+	return fmt.Sprintf("<%s@%s> %s", msg.Username, origin, msg.Body), true
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/chattest"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		msg  protocol.Message
+		want string
+	}{
+		{protocol.Message{Type: protocol.TypeMsg, Username: "alice", Body: "hi"}, "<alice@east> hi"},
+		{protocol.Message{Type: protocol.TypeMsg, Username: "alice", Body: "!e2e-key abc"}, ""},
+		{protocol.Message{Type: protocol.TypeJoined, Username: "alice"}, ""},
+	}
+	for _, tt := range tests {
+		if got, _ := format(tt.msg, "east"); got != tt.want {
+			t.Errorf("format(%+v) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestMirror(t *testing.T) {
+	east, west := chattest.NewServer(t).Addr().String(), chattest.NewServer(t).Addr().String()
+	a := side{origin: "east", chat: chattest.NewClient(t, east, "relay")}
+	b := side{origin: "west", chat: chattest.NewClient(t, west, "relay")}
+	go mirror(a, b)
+	go mirror(b, a)
+
+	alice := chattest.NewClient(t, east, "alice")
+	bob := chattest.NewClient(t, west, "bob")
+
+	alice.SendMessage("hello west")
+	chattest.WaitFor(t, bob, chattest.Msg("relay", "<alice@east> hello west"))
+	bob.SendMessage("hello east")
+	chattest.WaitFor(t, alice, chattest.Msg("relay", "<bob@west> hello east"))
+
+	// Relayed messages aren't echoed back to where they came from.
+	select {
+	case msg := <-alice.Messages():
+		if msg.Type == protocol.TypeMsg {
+			t.Errorf("alice got unexpected %+v", msg)
+		}
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pankaj/simple-chat/server"
+)
+
+// serveAdmin serves the admin API used by chatadmin. An address of the
+// form unix:/path listens on a Unix socket readable only by the server's
+// user; anything else is a TCP address.
+func serveAdmin(socks *sockets, addr string, srv *server.ChatServer) error {
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		ln, err = withPrivateUmask(func() (net.Listener, error) { return socks.listen("unix", path) })
+		if err == nil {
+			if err = os.Chmod(path, 0o600); err != nil {
+				ln.Close()
+			}
+		}
+	} else {
+		ln, err = socks.listen("tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		if err := http.Serve(ln, srv.AdminHandler()); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("admin endpoint error: %v", err)
+		}
+	}()
+	return nil
+}
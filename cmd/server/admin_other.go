@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "net"
+
+// withPrivateUmask runs listen; there is no umask on this platform.
+func withPrivateUmask(listen func() (net.Listener, error)) (net.Listener, error) {
+	return listen()
+}
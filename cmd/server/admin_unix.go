@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// withPrivateUmask runs listen with a umask that keeps the Unix socket it
+// creates from ever being reachable by other users, even before it is
+// chmod'ed. The umask is process-wide, so this is only for startup.
+func withPrivateUmask(listen func() (net.Listener, error)) (net.Listener, error) {
+	old := syscall.Umask(0o077)
+	defer syscall.Umask(old)
+	return listen()
+}
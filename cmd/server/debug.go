@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"expvar"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pankaj/simple-chat/server"
+)
+
+// serveDebug publishes the server's live counters under "chat" and serves
+// them, along with the runtime's memstats and cmdline, at /debug/vars.
+func serveDebug(socks *sockets, addr string, srv *server.ChatServer) error {
+	ln, err := socks.listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	expvar.Publish("chat", srv.Vars())
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("debug endpoint error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"io"
+	"net"
+
+	"github.com/oschwald/geoip2-golang"
+
+	"github.com/pankaj/simple-chat/server"
+)
+
+// openGeoIP opens a MaxMind GeoIP2 or GeoLite2 Country or City database.
+// The returned closer releases it.
+func openGeoIP(path string) (server.CountryLookup, io.Closer, error) {
+	db, err := geoip2.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	lookup := func(ip net.IP) (string, error) {
+		rec, err := db.Country(ip)
+		if err != nil {
+			return "", err
+		}
+		return rec.Country.IsoCode, nil
+	}
+	return lookup, db, nil
+}
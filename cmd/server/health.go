@@ -0,0 +1,38 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pankaj/simple-chat/server"
+)
+
+// serveHealth exposes liveness (/healthz) and readiness (/readyz) endpoints
+// for load balancers. Readiness fails once the server starts draining.
+func serveHealth(socks *sockets, addr string, srv *server.ChatServer) error {
+	ln, err := socks.listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !srv.Ready() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("health endpoint error: %v", err)
+		}
+	}()
+	return nil
+}
@@ -1,35 +1,373 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
+	"time"
 
+	"github.com/pankaj/simple-chat/netopt"
+	"github.com/pankaj/simple-chat/protocol"
 	"github.com/pankaj/simple-chat/server"
 )
 
 func main() {
 	host := flag.String("host", getEnvOrDefault("CHAT_HOST", "0.0.0.0"), "Host to listen on")
 	port := flag.String("port", getEnvOrDefault("CHAT_PORT", "8080"), "Port to listen on")
+	otlpEndpoint := flag.String("otlp-endpoint", getEnvOrDefault("CHAT_OTLP_ENDPOINT", ""), "OTLP/HTTP collector URL for tracing (disabled if empty)")
+	healthAddr := flag.String("health-addr", getEnvOrDefault("CHAT_HEALTH_ADDR", ""), "Address for /healthz and /readyz (disabled if empty)")
+	httpAddr := flag.String("http-addr", getEnvOrDefault("CHAT_HTTP_ADDR", ""), "Address for the browser client (/), WebSocket endpoint (/ws) and attachments (/attachments) (disabled if empty)")
+	ircAddr := flag.String("irc-addr", getEnvOrDefault("CHAT_IRC_ADDR", ""), "Address for IRC clients such as irssi or WeeChat (disabled if empty)")
+	quicAddr := flag.String("quic-addr", getEnvOrDefault("CHAT_QUIC_ADDR", ""), "UDP address for the experimental QUIC transport; needs -tls-cert and -tls-key (disabled if empty)")
+	tlsCert := flag.String("tls-cert", getEnvOrDefault("CHAT_TLS_CERT", ""), "PEM certificate file for -quic-addr")
+	tlsKey := flag.String("tls-key", getEnvOrDefault("CHAT_TLS_KEY", ""), "PEM private key file for -quic-addr")
+	sshAddr := flag.String("ssh-addr", getEnvOrDefault("CHAT_SSH_ADDR", ""), "Address for people connecting with ssh; needs -ssh-users (disabled if empty)")
+	sshHostKey := flag.String("ssh-host-key", getEnvOrDefault("CHAT_SSH_HOST_KEY", "ssh_host_ed25519_key"), "SSH host key file; created if missing")
+	sshUsers := flag.String("ssh-users", getEnvOrDefault("CHAT_SSH_USERS", ""), "authorized_keys-style file of SSH public keys, each followed by the username it joins as")
+	sshReserve := flag.Bool("ssh-reserve-names", false, "Reserve the usernames in -ssh-users so that nobody else can join under them on any transport")
+	debugAddr := flag.String("debug-addr", getEnvOrDefault("CHAT_DEBUG_ADDR", ""), "Address for expvar counters at /debug/vars (disabled if empty; keep it private)")
+	adminAddr := flag.String("admin-addr", getEnvOrDefault("CHAT_ADMIN_ADDR", ""), "Address for the admin API, host:port or unix:/path (disabled if empty; keep it private)")
+	motd := flag.String("motd", getEnvOrDefault("CHAT_MOTD", ""), "Message of the day sent to users when they join")
+	migrationKey := flag.String("migration-key", getEnvOrDefault("CHAT_MIGRATION_KEY", ""), "Secret shared by servers users can be moved between with chatadmin migrate (prefer setting CHAT_MIGRATION_KEY)")
+	stateFile := flag.String("state-file", getEnvOrDefault("CHAT_STATE_FILE", ""), "File bans, the MOTD and the moderation queue are restored from at startup and saved to on shutdown (disabled if empty)")
+	drainTimeout := flag.Duration("drain-timeout", 30*time.Second, "How long to wait for clients to leave on SIGTERM")
+	reconnectHint := flag.String("reconnect-hint", getEnvOrDefault("CHAT_RECONNECT_HINT", ""), "Address suggested to clients when draining")
+	proxyProtocol := flag.Bool("proxy-protocol", false, "Expect a PROXY protocol v1/v2 header on every connection")
+	plainText := flag.Bool("plain-text", false, "Let people join by typing \"join <name>\" over nc or telnet")
+	confusableNames := flag.Bool("confusable-names", false, "Treat usernames that differ only in lookalike letters, such as a Cyrillic 'а' for 'a', as the same name")
+	listen := flag.String("listen", getEnvOrDefault("CHAT_LISTEN", ""), "Comma-separated host:port list to bind (overrides -host/-port)")
+	network := flag.String("network", getEnvOrDefault("CHAT_NETWORK", "tcp"), "Address family: tcp (dual-stack), tcp4 or tcp6")
+	acceptRate := flag.Float64("accept-rate", 0, "Connections accepted per second overall before new ones are dropped (0 is unlimited)")
+	acceptBurst := flag.Int("accept-burst", 50, "Connections accepted at once before -accept-rate applies")
+	acceptIPRate := flag.Float64("accept-ip-rate", 0, "Connections accepted per second from one address (0 is unlimited; leave at 0 with -proxy-protocol)")
+	acceptIPBurst := flag.Int("accept-ip-burst", 5, "Connections accepted at once from one address before -accept-ip-rate applies")
+	tcpKeepAlive := flag.Duration("tcp-keepalive", 0, "Interval between TCP keep-alive probes (0 is the default of 15s, negative disables them)")
+	tcpNoDelay := flag.Bool("tcp-nodelay", true, "Send small writes straight away (TCP_NODELAY); false lets the kernel coalesce them")
+	tcpReadBuffer := flag.Int("tcp-read-buffer", 0, "TCP receive buffer size in bytes (0 is the system default)")
+	tcpWriteBuffer := flag.Int("tcp-write-buffer", 0, "TCP send buffer size in bytes (0 is the system default)")
+	tcpLinger := flag.Duration("tcp-linger", 0, "How long closing a TCP connection waits to send unsent data (0 sends it in the background, negative discards it)")
+	messageRate := flag.Float64("message-rate", 0, "Messages per second each client may send (0 is unlimited)")
+	messageBurst := flag.Int("message-burst", 10, "Messages a client may send at once before -message-rate applies")
+	joinLimit := flag.Int("join-limit", 0, "JOINs allowed from one address per -join-window (0 is unlimited)")
+	joinWindow := flag.Duration("join-window", time.Minute, "Period over which -join-limit counts JOINs")
+	joinFailFree := flag.Int("join-fail-free", 3, "Failed JOINs (bad answers, taken or banned names) allowed from one address before -join-fail-delay applies")
+	joinFailDelay := flag.Duration("join-fail-delay", 0, "Delay before handling JOINs from an address over -join-fail-free, doubling with each failure up to a minute (0 disables throttling)")
+	joinFailBlock := flag.Int("join-fail-block", 20, "Failed JOINs after which an address is blocked for -join-fail-block-for (0 never blocks)")
+	joinFailBlockFor := flag.Duration("join-fail-block-for", 15*time.Minute, "How long -join-fail-block blocks an address")
+	spamThreshold := flag.Int("spam-threshold", 0, "Identical messages allowed within -spam-window before acting (0 disables)")
+	spamWindow := flag.Duration("spam-window", 30*time.Second, "Window for duplicate-message detection")
+	spamAction := flag.String("spam-action", "mute", "What to do with spammers: mute or disconnect")
+	spamMute := flag.Duration("spam-mute", time.Minute, "How long spammers are muted")
+	quotas := flag.String("quotas", getEnvOrDefault("CHAT_QUOTAS", ""), "Message quotas per user class as class=perhour/perday,... (0 is unlimited); class \"default\" applies to unlisted users")
+	quotaUsers := flag.String("quota-users", getEnvOrDefault("CHAT_QUOTA_USERS", ""), "Quota class of specific users as user=class,...")
+	joinWork := flag.Int("join-work", 0, "Proof-of-work difficulty in bits that joining clients must solve (0 disables; 20 costs a fraction of a second)")
+	joinQuestion := flag.String("join-question", getEnvOrDefault("CHAT_JOIN_QUESTION", ""), "Question joining clients must answer (disabled if empty)")
+	joinAnswers := flag.String("join-answers", getEnvOrDefault("CHAT_JOIN_ANSWERS", ""), "Comma-separated accepted answers to -join-question, ignoring case")
+	geoipDB := flag.String("geoip-db", getEnvOrDefault("CHAT_GEOIP_DB", ""), "MaxMind GeoIP2/GeoLite2 Country or City database for -geo-allow and -geo-deny")
+	geoAllow := flag.String("geo-allow", getEnvOrDefault("CHAT_GEO_ALLOW", ""), "Comma-separated country codes to accept connections from; others are refused")
+	geoDeny := flag.String("geo-deny", getEnvOrDefault("CHAT_GEO_DENY", ""), "Comma-separated country codes to refuse connections from")
+	geoDenyUnknown := flag.Bool("geo-deny-unknown", false, "Refuse connections whose country can't be determined")
+	attachmentDir := flag.String("attachment-dir", getEnvOrDefault("CHAT_ATTACHMENT_DIR", ""), "Directory for files shared through the /attachments endpoint of -http-addr (disabled if empty)")
+	attachmentURL := flag.String("attachment-url", getEnvOrDefault("CHAT_ATTACHMENT_URL", ""), "Public URL of -http-addr, such as https://chat.example.com, for links to attachments (relative links if empty)")
+	attachmentMax := flag.Int64("attachment-max-size", server.DefaultMaxAttachmentSize, "Largest attachment accepted, in bytes")
+	attachmentTotal := flag.Int64("attachment-max-total", server.DefaultMaxAttachmentTotal, "Most bytes of attachments kept at once; uploads are refused beyond it")
+	attachmentAge := flag.Duration("attachment-max-age", 0, "How long attachments are kept, such as 720h (forever if 0)")
 	flag.Parse()
 
-	addr := fmt.Sprintf("%s:%s", *host, *port)
+	addrs := []string{fmt.Sprintf("%s:%s", *host, *port)}
+	if *listen != "" {
+		addrs = addrs[:0]
+		for _, a := range strings.Split(*listen, ",") {
+			addrs = append(addrs, strings.TrimSpace(a))
+		}
+	}
+
+	opts := []server.Option{
+		server.WithNetwork(*network),
+		server.WithReconnectHint(*reconnectHint),
+	}
+	if *otlpEndpoint != "" {
+		tp, err := newTracerProvider(context.Background(), *otlpEndpoint)
+		if err != nil {
+			log.Fatalf("Failed to set up tracing: %v", err)
+		}
+		defer tp.Shutdown(context.Background())
+		opts = append(opts, server.WithTracerProvider(tp))
+		log.Printf("Exporting traces to %s", *otlpEndpoint)
+	}
 
-	srv := server.New()
-	if err := srv.Listen(addr); err != nil {
+	if *spamThreshold > 0 {
+		policy := server.SpamPolicy{
+			Threshold: *spamThreshold,
+			Window:    *spamWindow,
+			MuteFor:   *spamMute,
+		}
+		switch *spamAction {
+		case "mute":
+			policy.Action = server.SpamMute
+		case "disconnect":
+			policy.Action = server.SpamDisconnect
+		default:
+			log.Fatalf("Invalid -spam-action %q: want mute or disconnect", *spamAction)
+		}
+		opts = append(opts, server.WithSpamPolicy(policy))
+	}
+	if *quotas != "" {
+		policy, err := parseQuotas(*quotas, *quotaUsers)
+		if err != nil {
+			log.Fatal(err)
+		}
+		opts = append(opts, server.WithQuotas(policy))
+	}
+	if *joinWork > 0 || *joinQuestion != "" {
+		answers := splitList(*joinAnswers)
+		if *joinQuestion != "" && len(answers) == 0 {
+			log.Fatal("-join-question needs -join-answers")
+		}
+		if *joinWork > protocol.MaxWorkBits {
+			log.Fatalf("-join-work %d is more than clients will attempt (%d)", *joinWork, protocol.MaxWorkBits)
+		}
+		opts = append(opts, server.WithJoinChallenge(server.JoinChallenge{
+			Work:     *joinWork,
+			Question: *joinQuestion,
+			Answers:  answers,
+		}))
+	}
+	if *geoipDB != "" {
+		lookup, db, err := openGeoIP(*geoipDB)
+		if err != nil {
+			log.Fatalf("Failed to open GeoIP database: %v", err)
+		}
+		defer db.Close()
+		opts = append(opts, server.WithGeoPolicy(server.GeoPolicy{
+			Lookup:      lookup,
+			Allow:       splitList(*geoAllow),
+			Deny:        splitList(*geoDeny),
+			DenyUnknown: *geoDenyUnknown,
+		}))
+	} else if *geoAllow != "" || *geoDeny != "" || *geoDenyUnknown {
+		log.Fatal("-geo-allow, -geo-deny and -geo-deny-unknown need -geoip-db")
+	}
+	if *proxyProtocol {
+		opts = append(opts, server.WithProxyProtocol())
+	}
+	if *acceptRate > 0 || *acceptIPRate > 0 {
+		opts = append(opts, server.WithAcceptLimit(server.AcceptLimit{
+			Rate:       *acceptRate,
+			Burst:      *acceptBurst,
+			PerIPRate:  *acceptIPRate,
+			PerIPBurst: *acceptIPBurst,
+		}))
+	}
+	opts = append(opts, server.WithTCPOptions(netopt.TCP{
+		KeepAlive:   *tcpKeepAlive,
+		Nagle:       !*tcpNoDelay,
+		ReadBuffer:  *tcpReadBuffer,
+		WriteBuffer: *tcpWriteBuffer,
+		Linger:      *tcpLinger,
+	}))
+	if *messageRate > 0 {
+		opts = append(opts, server.WithMessageRate(*messageRate, *messageBurst))
+	}
+	if *joinLimit > 0 {
+		opts = append(opts, server.WithJoinLimit(*joinLimit, *joinWindow))
+	}
+	if *joinFailDelay > 0 {
+		opts = append(opts, server.WithJoinThrottle(server.JoinThrottle{
+			Free:       *joinFailFree,
+			Delay:      *joinFailDelay,
+			MaxDelay:   time.Minute,
+			BlockAfter: *joinFailBlock,
+			BlockFor:   *joinFailBlockFor,
+		}))
+	}
+	if *plainText {
+		opts = append(opts, server.WithPlainText())
+	}
+	if *confusableNames {
+		opts = append(opts, server.WithConfusableNames())
+	}
+	if *attachmentDir != "" {
+		if *httpAddr == "" {
+			log.Fatal("-attachment-dir needs -http-addr")
+		}
+		opts = append(opts, server.WithAttachments(server.AttachmentPolicy{
+			Dir:      *attachmentDir,
+			MaxSize:  *attachmentMax,
+			MaxTotal: *attachmentTotal,
+			MaxAge:   *attachmentAge,
+			BaseURL:  *attachmentURL,
+		}))
+	}
+
+	if *stateFile != "" {
+		opts = append(opts, server.WithStateFile(*stateFile))
+	}
+	if *migrationKey != "" {
+		opts = append(opts, server.WithMigrationKey([]byte(*migrationKey)))
+	}
+
+	socks, err := inheritSockets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	opts = append(opts, server.WithListenFunc(socks.listen))
+
+	srv := server.New(opts...)
+	if *stateFile != "" {
+		if err := srv.LoadState(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *motd != "" {
+		srv.SetMOTD(*motd)
+	}
+	if err := srv.ListenAll(addrs); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
-	log.Printf("Chat server listening on %s", addr)
+	for _, a := range srv.Addrs() {
+		log.Printf("Chat server listening on %s (%s)", a, *network)
+	}
+
+	if *ircAddr != "" {
+		if err := srv.ListenIRC(*ircAddr); err != nil {
+			log.Fatalf("Failed to start IRC listener: %v", err)
+		}
+		log.Printf("IRC clients on %s (channel #chat)", srv.IRCAddr())
+	}
+
+	if *quicAddr != "" {
+		cert, err := tls.LoadX509KeyPair(*tlsCert, *tlsKey)
+		if err != nil {
+			log.Fatalf("Failed to load TLS certificate for QUIC: %v", err)
+		}
+		if err := srv.ListenQUIC(*quicAddr, &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+			log.Fatalf("Failed to start QUIC listener: %v", err)
+		}
+		log.Printf("QUIC clients on %s (experimental)", srv.QUICAddr())
+	}
+
+	if *sshAddr != "" {
+		cfg, err := loadSSHConfig(*sshHostKey, *sshUsers)
+		if err != nil {
+			log.Fatalf("Failed to set up SSH: %v", err)
+		}
+		cfg.ReserveNames = *sshReserve
+		if err := srv.ListenSSH(*sshAddr, cfg); err != nil {
+			log.Fatalf("Failed to start SSH listener: %v", err)
+		}
+		log.Printf("SSH on %s (%d keys)", srv.SSHAddr(), len(cfg.Users))
+	}
+
+	if *healthAddr != "" {
+		if err := serveHealth(socks, *healthAddr, srv); err != nil {
+			log.Fatalf("Failed to start health endpoint: %v", err)
+		}
+		log.Printf("Health checks on %s", *healthAddr)
+	}
+
+	if *httpAddr != "" {
+		if err := serveWeb(socks, *httpAddr, srv); err != nil {
+			log.Fatalf("Failed to start web endpoint: %v", err)
+		}
+		log.Printf("Web client and WebSocket endpoint on %s", *httpAddr)
+	}
+
+	if *debugAddr != "" {
+		if err := serveDebug(socks, *debugAddr, srv); err != nil {
+			log.Fatalf("Failed to start debug endpoint: %v", err)
+		}
+		log.Printf("Debug counters on %s/debug/vars", *debugAddr)
+	}
+
+	if *adminAddr != "" {
+		if err := serveAdmin(socks, *adminAddr, srv); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+		log.Printf("Admin API on %s", *adminAddr)
+	}
+
+	if socks.upgraded {
+		socks.closeUnused()
+		if err := signalParent(); err != nil {
+			log.Printf("Failed to tell the old process to drain: %v", err)
+		} else {
+			log.Printf("Took over listeners from process %d", os.Getppid())
+		}
+	}
 
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	<-sigCh
+	if upgradeSignal != nil {
+		signal.Notify(sigCh, upgradeSignal)
+	}
+
+	// While an upgrade is under way, the new process sends SIGTERM once
+	// it is serving, and this one stops accepting and drains.
+	var upgrading bool
+	upgradeFailed := make(chan error, 1)
+	for {
+		var sig os.Signal
+		select {
+		case sig = <-sigCh:
+		case err := <-upgradeFailed:
+			log.Printf("Upgrade failed: new process exited: %v", err)
+			upgrading = false
+			continue
+		}
+		if sig != upgradeSignal {
+			if sig == syscall.SIGTERM {
+				if upgrading {
+					socks.close()
+				}
+				log.Printf("Draining (timeout %s)...", *drainTimeout)
+				srv.Drain(*drainTimeout)
+			} else {
+				log.Println("Shutting down...")
+				srv.Shutdown()
+			}
+			break
+		}
 
-	log.Println("Shutting down...")
-	srv.Shutdown()
+		switch {
+		case upgrading:
+			log.Println("Upgrade already under way")
+			continue
+		case *quicAddr != "":
+			log.Println("Can't upgrade with -quic-addr set: QUIC sockets can't be passed on")
+			continue
+		}
+		// The new process restores state as it starts.
+		if *stateFile != "" {
+			if err := srv.SaveState(); err != nil {
+				log.Printf("Failed to save state: %v", err)
+				continue
+			}
+		}
+		cmd, err := socks.upgrade()
+		if err != nil {
+			log.Printf("Upgrade failed: %v", err)
+			continue
+		}
+		log.Printf("Upgrading: started process %d", cmd.Process.Pid)
+		upgrading = true
+		go func() {
+			upgradeFailed <- cmd.Wait()
+		}()
+	}
+
+	// After an upgrade the state file belongs to the new process.
+	if *stateFile != "" && !upgrading {
+		if err := srv.SaveState(); err != nil {
+			log.Printf("Failed to save state: %v", err)
+		}
+	}
 }
 
 func getEnvOrDefault(key, fallback string) string {
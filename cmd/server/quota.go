@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/pankaj/simple-chat/server"
+)
+
+// defaultQuotaClass applies to users not listed in -quota-users.
+const defaultQuotaClass = "default"
+
+// parseQuotas builds a QuotaPolicy from -quotas, a comma-separated list
+// of class=perhour/perday entries (0 means unlimited), and -quota-users,
+// a comma-separated list of user=class entries.
+func parseQuotas(classes, users string) (server.QuotaPolicy, error) {
+	p := server.QuotaPolicy{
+		Classes:      make(map[string]server.Quota),
+		Users:        make(map[string]string),
+		DefaultClass: defaultQuotaClass,
+	}
+	for _, entry := range splitList(classes) {
+		class, limits, ok := strings.Cut(entry, "=")
+		hour, day, ok2 := strings.Cut(limits, "/")
+		perHour, err1 := strconv.Atoi(hour)
+		perDay, err2 := strconv.Atoi(day)
+		if !ok || !ok2 || class == "" || err1 != nil || err2 != nil || perHour < 0 || perDay < 0 {
+			return p, fmt.Errorf("invalid quota %q: want class=perhour/perday", entry)
+		}
+		p.Classes[class] = server.Quota{PerHour: perHour, PerDay: perDay}
+	}
+	for _, entry := range splitList(users) {
+		user, class, ok := strings.Cut(entry, "=")
+		if !ok || user == "" {
+			return p, fmt.Errorf("invalid quota user %q: want user=class", entry)
+		}
+		if _, known := p.Classes[class]; !known {
+			return p, fmt.Errorf("quota user %q has unknown class %q", user, class)
+		}
+		p.Users[user] = class
+	}
+	return p, nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/pankaj/simple-chat/server"
+	"golang.org/x/crypto/ssh"
+)
+
+// loadSSHConfig reads the SSH host key from hostKeyPath, creating an
+// Ed25519 key there if the file doesn't exist, and the users allowed in
+// from usersPath.
+func loadSSHConfig(hostKeyPath, usersPath string) (server.SSHConfig, error) {
+	hostKey, err := loadHostKey(hostKeyPath)
+	if err != nil {
+		return server.SSHConfig{}, err
+	}
+	users, err := loadSSHUsers(usersPath)
+	if err != nil {
+		return server.SSHConfig{}, err
+	}
+	return server.SSHConfig{HostKey: hostKey, Users: users}, nil
+}
+
+func loadHostKey(path string) (ssh.Signer, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "simple-chat host key")
+		if err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(path, pem.EncodeToMemory(block), 0o600); err != nil {
+			return nil, fmt.Errorf("saving SSH host key: %w", err)
+		}
+		return ssh.NewSignerFromKey(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return signer, nil
+}
+
+// loadSSHUsers reads a file in authorized_keys format where each key's
+// comment is the username it joins as, e.g.
+//
+//	ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAA... alice
+func loadSSHUsers(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	users := make(map[string]string)
+	for len(bytes.TrimSpace(data)) > 0 {
+		key, name, _, rest, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if name == "" {
+			return nil, fmt.Errorf("%s: key %s has no username after it", path, ssh.FingerprintSHA256(key))
+		}
+		users[ssh.FingerprintSHA256(key)] = name
+		data = rest
+	}
+	return users, nil
+}
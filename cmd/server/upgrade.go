@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// inheritEnv tells a server started by an upgrade which listening sockets
+// it was passed, as a comma-separated list of the addresses they were
+// opened for. The sockets are file descriptors 3 onwards, in that order.
+const inheritEnv = "CHAT_INHERITED_LISTENERS"
+
+// sockets opens the server's listening sockets and keeps track of them so
+// a binary upgrade can pass them to the new process, which carries on
+// accepting connections on them while this one drains.
+type sockets struct {
+	mu        sync.Mutex
+	inherited map[string]net.Listener // by address, until listen takes them
+	upgraded  bool                    // started by an upgrade
+	addrs     []string
+	listeners []net.Listener
+}
+
+// inheritSockets picks up any sockets passed down by an upgrade.
+func inheritSockets() (*sockets, error) {
+	s := &sockets{inherited: make(map[string]net.Listener)}
+	env := os.Getenv(inheritEnv)
+	os.Unsetenv(inheritEnv)
+	if env == "" {
+		return s, nil
+	}
+	s.upgraded = true
+	for i, addr := range strings.Split(env, ",") {
+		f := os.NewFile(uintptr(3+i), addr)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("inheriting listener for %s: %w", addr, err)
+		}
+		s.inherited[addr] = ln
+	}
+	return s, nil
+}
+
+// listen returns the inherited socket for addr if there is one and binds
+// a new one otherwise. For the unix network, addr is the socket's path.
+func (s *sockets) listen(network, addr string) (net.Listener, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ln, ok := s.inherited[addr]
+	if ok {
+		delete(s.inherited, addr)
+	} else {
+		if network == "unix" {
+			// Remove a socket left behind by a previous run.
+			os.Remove(addr)
+		}
+		var err error
+		ln, err = net.Listen(network, addr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.addrs = append(s.addrs, addr)
+	s.listeners = append(s.listeners, ln)
+	return ln, nil
+}
+
+// closeUnused closes inherited sockets for addresses no longer configured.
+func (s *sockets) closeUnused() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for addr, ln := range s.inherited {
+		log.Printf("Closing inherited listener for %s: no longer configured", addr)
+		ln.Close()
+	}
+	clear(s.inherited)
+}
+
+// upgrade starts the server binary again with the same arguments, passing
+// it every listening socket. The new process sends this one SIGTERM once
+// it is serving.
+func (s *sockets) upgrade() (*exec.Cmd, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var files []*os.File
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for i, ln := range s.listeners {
+		fl, ok := ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("listener for %s can't be passed on", s.addrs[i])
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("passing on listener for %s: %w", s.addrs[i], err)
+		}
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), inheritEnv+"="+strings.Join(s.addrs, ","))
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// close stops accepting on every socket, leaving them to the process they
+// were passed to. Connections already accepted are unaffected.
+func (s *sockets) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ln := range s.listeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			// The new process is still listening on the path.
+			ul.SetUnlinkOnClose(false)
+		}
+		ln.Close()
+	}
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// upgradeSignal is nil where listening sockets can't be passed to a new
+// process.
+var upgradeSignal os.Signal
+
+func signalParent() error {
+	return errors.New("binary upgrades aren't supported on this platform")
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// upgradeSignal asks the server to upgrade to the binary now at its path.
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// signalParent tells the process that started this one by an upgrade
+// that it can drain and exit.
+func signalParent() error {
+	return syscall.Kill(os.Getppid(), syscall.SIGTERM)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net"
+	"net/http"
+
+	"github.com/pankaj/simple-chat/server"
+	"github.com/pankaj/simple-chat/server/webclient"
+)
+
+// serveWeb serves the browser client at /, the WebSocket endpoint it
+// uses at /ws and, when enabled, attachments at /attachments.
+func serveWeb(socks *sockets, addr string, srv *server.ChatServer) error {
+	ln, err := socks.listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/", webclient.Handler())
+	mux.Handle("/ws", srv.WebSocketHandler())
+	attachments := srv.AttachmentHandler()
+	mux.Handle("/attachments", attachments)
+	mux.Handle("/attachments/", attachments)
+
+	go func() {
+		if err := http.Serve(ln, mux); err != nil && !errors.Is(err, net.ErrClosed) {
+			log.Printf("web endpoint error: %v", err)
+		}
+	}()
+	return nil
+}
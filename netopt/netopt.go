@@ -0,0 +1,74 @@
+// Package netopt holds the TCP socket settings the chat server and client
+// can tune on their connections.
+package netopt
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// TCP tunes a TCP connection. The zero value leaves every setting at Go's
+// or the system's default.
+type TCP struct {
+	// KeepAlive is the interval between keep-alive probes on an idle
+	// connection. Zero keeps the default, 15 seconds in Go, and a negative
+	// value turns keep-alives off.
+	KeepAlive time.Duration
+
+	// Nagle turns Nagle's algorithm back on, clearing the TCP_NODELAY
+	// option Go sets by default, so that small writes are coalesced by the
+	// kernel at the cost of latency.
+	Nagle bool
+
+	// ReadBuffer and WriteBuffer set the socket's receive and send buffer
+	// sizes in bytes. Zero keeps the system default.
+	ReadBuffer  int
+	WriteBuffer int
+
+	// Linger is how long Close waits for unsent data to be sent. Zero
+	// keeps the default of sending it in the background, and a negative
+	// value discards it, resetting the connection.
+	Linger time.Duration
+}
+
+// Apply sets o on conn. Connections other than *net.TCPConn, such as
+// WebSocket or QUIC ones, are left alone.
+func (o TCP) Apply(conn net.Conn) error {
+	tc, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	var errs []error
+	switch {
+	case o.KeepAlive < 0:
+		errs = append(errs, tc.SetKeepAlive(false))
+	case o.KeepAlive > 0:
+		errs = append(errs, tc.SetKeepAliveConfig(net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     o.KeepAlive,
+			Interval: o.KeepAlive,
+			Count:    -1,
+		}))
+	}
+	if o.Nagle {
+		errs = append(errs, tc.SetNoDelay(false))
+	}
+	if o.ReadBuffer > 0 {
+		errs = append(errs, tc.SetReadBuffer(o.ReadBuffer))
+	}
+	if o.WriteBuffer > 0 {
+		errs = append(errs, tc.SetWriteBuffer(o.WriteBuffer))
+	}
+	switch {
+	case o.Linger < 0:
+		errs = append(errs, tc.SetLinger(0))
+	case o.Linger > 0:
+		errs = append(errs, tc.SetLinger(int(o.Linger.Round(time.Second)/time.Second)))
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("tuning TCP connection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package netopt
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestApply(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	for _, o := range []TCP{
+		{},
+		{KeepAlive: 30 * time.Second, Nagle: true, ReadBuffer: 64 << 10, WriteBuffer: 64 << 10, Linger: 5 * time.Second},
+		{KeepAlive: -1, Linger: -1},
+	} {
+		if err := o.Apply(conn); err != nil {
+			t.Errorf("Apply(%+v) error = %v", o, err)
+		}
+	}
+}
+
+func TestApplyOtherConn(t *testing.T) {
+	a, b := net.Pipe()
+	defer a.Close()
+	defer b.Close()
+	if err := (TCP{Nagle: true, ReadBuffer: 1024}).Apply(a); err != nil {
+		t.Errorf("Apply on a pipe error = %v, want nil", err)
+	}
+}
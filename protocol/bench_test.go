@@ -0,0 +1,42 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+)
+
+var benchMessages = []struct {
+	name string
+	msg  Message
+}{
+	{"Join", Message{Type: TypeJoin, Username: "alice"}},
+	{"Msg", Message{Type: TypeMsg, Username: "alice", Body: "hello, how is everyone doing today?"}},
+	{"MsgLarge", Message{Type: TypeMsg, Username: "alice", Body: strings.Repeat("lorem ipsum ", 300)}},
+	{"Presence", Message{Type: TypePresence, Username: "alice", Body: "away|lunch"}},
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, bm := range benchMessages {
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				Encode(bm.msg)
+			}
+		})
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, bm := range benchMessages {
+		line := Encode(bm.msg)
+		b.Run(bm.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(line)))
+			for b.Loop() {
+				if _, err := Decode(line); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
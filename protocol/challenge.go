@@ -0,0 +1,92 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Challenge kinds carried in a CHALLENGE message.
+const (
+	// ChallengeWork asks for a proof of work: an answer such that the
+	// SHA-256 hash of Nonce followed by the answer starts with at least
+	// Bits zero bits.
+	ChallengeWork = "work"
+
+	// ChallengeQuestion asks a question chosen by the server operators.
+	ChallengeQuestion = "question"
+)
+
+// MaxWorkBits is the hardest proof of work clients should attempt. At
+// this difficulty a solution takes hundreds of millions of hashes.
+const MaxWorkBits = 28
+
+// Challenge is the structured payload of a CHALLENGE message.
+type Challenge struct {
+	Kind     string // ChallengeWork or ChallengeQuestion
+	Bits     int    // difficulty, for ChallengeWork
+	Nonce    string // for ChallengeWork; must not contain "|"
+	Question string // for ChallengeQuestion
+}
+
+// EncodeChallenge serializes c into the Body of a CHALLENGE message:
+// "work|<bits>|<nonce>" or "question|<text>".
+func EncodeChallenge(c Challenge) string {
+	if c.Kind == ChallengeWork {
+		return ChallengeWork + "|" + strconv.Itoa(c.Bits) + "|" + c.Nonce
+	}
+	return c.Kind + "|" + c.Question
+}
+
+// ParseChallenge parses the Body of a CHALLENGE message.
+func ParseChallenge(body string) (Challenge, error) {
+	kind, rest, _ := strings.Cut(body, "|")
+	switch kind {
+	case ChallengeWork:
+		n, nonce, ok := strings.Cut(rest, "|")
+		b, err := strconv.Atoi(n)
+		if !ok || err != nil || b < 0 || nonce == "" {
+			return Challenge{}, ErrInvalidMessage
+		}
+		return Challenge{Kind: ChallengeWork, Bits: b, Nonce: nonce}, nil
+	case ChallengeQuestion:
+		if rest == "" {
+			return Challenge{}, ErrInvalidMessage
+		}
+		return Challenge{Kind: ChallengeQuestion, Question: rest}, nil
+	default:
+		return Challenge{}, ErrInvalidMessage
+	}
+}
+
+// CheckWork reports whether answer solves a ChallengeWork with the given
+// nonce and difficulty.
+func CheckWork(nonce string, difficulty int, answer string) bool {
+	sum := sha256.Sum256([]byte(nonce + answer))
+	return leadingZeros(sum[:]) >= difficulty
+}
+
+// SolveWork finds an answer to a ChallengeWork by trying decimal
+// counters. It takes about 2^difficulty hashes.
+func SolveWork(nonce string, difficulty int) string {
+	buf := []byte(nonce)
+	for i := uint64(0); ; i++ {
+		buf = strconv.AppendUint(buf[:len(nonce)], i, 10)
+		sum := sha256.Sum256(buf)
+		if leadingZeros(sum[:]) >= difficulty {
+			return string(buf[len(nonce):])
+		}
+	}
+}
+
+func leadingZeros(b []byte) int {
+	n := 0
+	for _, x := range b {
+		if x != 0 {
+			return n + bits.LeadingZeros8(x)
+		}
+		n += 8
+	}
+	return n
+}
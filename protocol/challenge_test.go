@@ -0,0 +1,36 @@
+package protocol
+
+import "testing"
+
+func TestChallengeRoundTrip(t *testing.T) {
+	for _, c := range []Challenge{
+		{Kind: ChallengeWork, Bits: 20, Nonce: "3f9a"},
+		{Kind: ChallengeQuestion, Question: "What colour is the sky? (one word|lowercase)"},
+	} {
+		got, err := ParseChallenge(EncodeChallenge(c))
+		if err != nil {
+			t.Fatalf("ParseChallenge(%q) error = %v", EncodeChallenge(c), err)
+		}
+		if got != c {
+			t.Errorf("round trip of %+v = %+v", c, got)
+		}
+	}
+	for _, body := range []string{"", "work|20", "work|x|nonce", "work|-1|nonce", "question|", "riddle|what"} {
+		if _, err := ParseChallenge(body); err == nil {
+			t.Errorf("ParseChallenge(%q) expected error, got nil", body)
+		}
+	}
+}
+
+func TestWork(t *testing.T) {
+	answer := SolveWork("nonce", 12)
+	if !CheckWork("nonce", 12, answer) {
+		t.Fatalf("SolveWork answer %q doesn't pass CheckWork", answer)
+	}
+	if CheckWork("other nonce", 12, answer) && CheckWork("third nonce", 12, answer) {
+		t.Error("the answer shouldn't carry over to other nonces")
+	}
+	if !CheckWork("nonce", 0, "anything") {
+		t.Error("difficulty 0 should accept any answer")
+	}
+}
@@ -0,0 +1,95 @@
+package protocol
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Style is the formatting of a Span of a chat message body.
+type Style int
+
+// Styles of the formatting subset. Markers don't nest.
+const (
+	Plain  Style = iota
+	Bold         // *bold*
+	Italic       // _italic_
+	Code         // `code`
+)
+
+// Span is a run of a chat message body in a single Style. Text excludes
+// the markers.
+type Span struct {
+	Style Style
+	Text  string
+}
+
+// formatMarkers maps each marker to the style it turns on.
+var formatMarkers = map[byte]Style{'*': Bold, '_': Italic, '`': Code}
+
+// ParseFormat splits a chat message body into styled spans. A marker
+// opens a span when it starts a word and is followed by a non-space, and
+// closes it at the next matching marker that ends a word and follows a
+// non-space, so "2*3*4" and snake_case_names stay plain. Anything else,
+// including unmatched markers, is plain text. Adjacent plain spans are
+// merged.
+func ParseFormat(body string) []Span {
+	var spans []Span
+	var plain strings.Builder
+	flush := func() {
+		if plain.Len() > 0 {
+			spans = append(spans, Span{Plain, plain.String()})
+			plain.Reset()
+		}
+	}
+
+	for i := 0; i < len(body); {
+		style, ok := formatMarkers[body[i]]
+		if ok && opensAt(body, i) {
+			if end := closeAt(body, i); end > 0 {
+				flush()
+				spans = append(spans, Span{style, body[i+1 : end]})
+				i = end + 1
+				continue
+			}
+		}
+		plain.WriteByte(body[i])
+		i++
+	}
+	flush()
+	return spans
+}
+
+// opensAt reports whether the marker at body[i] may open a span.
+func opensAt(body string, i int) bool {
+	if i+1 >= len(body) || body[i+1] == ' ' || body[i+1] == body[i] {
+		return false
+	}
+	if i == 0 {
+		return true
+	}
+	r, _ := utf8.DecodeLastRuneInString(body[:i])
+	return !isWordRune(r)
+}
+
+// closeAt returns the index of the marker closing the span opened at
+// body[i], or -1 if there isn't one.
+func closeAt(body string, i int) int {
+	marker := body[i]
+	for j := i + 2; j < len(body); j++ {
+		if body[j] != marker || body[j-1] == ' ' {
+			continue
+		}
+		if j+1 == len(body) {
+			return j
+		}
+		if r, _ := utf8.DecodeRuneInString(body[j+1:]); !isWordRune(r) {
+			return j
+		}
+	}
+	return -1
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,31 @@
+package protocol
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		body string
+		want []Span
+	}{
+		{"plain", []Span{{Plain, "plain"}}},
+		{"*bold* and _italic_", []Span{{Bold, "bold"}, {Plain, " and "}, {Italic, "italic"}}},
+		{"run `go test ./...` now", []Span{{Plain, "run "}, {Code, "go test ./..."}, {Plain, " now"}}},
+		{"`*not bold*`", []Span{{Code, "*not bold*"}}},
+		{"(*a b*)!", []Span{{Plain, "("}, {Bold, "a b"}, {Plain, ")!"}}},
+		{"snake_case_name", []Span{{Plain, "snake_case_name"}}},
+		{"2*3*4", []Span{{Plain, "2*3*4"}}},
+		{"* not a list *", []Span{{Plain, "* not a list *"}}},
+		{"**", []Span{{Plain, "**"}}},
+		{"*unclosed", []Span{{Plain, "*unclosed"}}},
+		{"_é_", []Span{{Italic, "é"}}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		if got := ParseFormat(tt.body); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseFormat(%q) = %+v, want %+v", tt.body, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// FuzzDecode checks that Decode never panics, that everything it accepts
+// is well-formed, and that accepted messages survive an Encode/Decode
+// round trip unchanged.
+func FuzzDecode(f *testing.F) {
+	for _, seed := range []string{
+		"JOIN|alice", "SEND|hello|world", "LEAVE", "SENDID|7|hi", "ACK|7",
+		"NACK|7|muted", "STATS", "WHO|alice|bob", "PING|1", "AWAY|lunch", "BACK",
+		"OK", "ERR|taken", "KICKED|spam", "NOTICE|hi", "MSG|bob|hi", "JOINED|bob",
+		"LEFT|bob", "RECONNECT|host:1", "PRESENCE|bob|away|out",
+		"ATTACH|f00d|cat", "JOINED|bob|3", "OK|format|2", "ATTACHED|bob|/attachments/f00d|cat",
+		"STATUS|60|busy", "USERSTATUS|bob|busy", "USERSTATUS|bob", "SEEN|bob|1700000000", "HIDESEEN|on",
+		"JOIN|alice|locale=de", "JOIN|alice|locale=",
+		"JOIN|a|b", "MSG|bob|\x00", "SEND|\xff\xfe", "JOIN|" + strings.Repeat("a", 100),
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, line string) {
+		m, err := Decode(line)
+		if err != nil {
+			return
+		}
+		for _, field := range []string{m.Username, m.ID, m.Attachment, m.Body, m.Locale} {
+			if !utf8.ValidString(field) || strings.ContainsFunc(field, isControl) {
+				t.Fatalf("Decode(%q) accepted invalid text: %+v", line, m)
+			}
+		}
+		if strings.Contains(m.Username, "|") || strings.Contains(m.ID, "|") || strings.Contains(m.Attachment, "|") {
+			t.Fatalf("Decode(%q) = %+v: username, ID or attachment contains a separator", line, m)
+		}
+		if len(m.Username) > MaxUsernameLength {
+			t.Fatalf("Decode(%q) accepted a %d-byte username", line, len(m.Username))
+		}
+
+		again, err := Decode(Encode(m))
+		if err != nil || again != m {
+			t.Fatalf("round trip of %q: %+v became %q, then %+v (%v)", line, m, Encode(m), again, err)
+		}
+	})
+}
@@ -1,8 +1,16 @@
 package protocol
 
 import (
+	"bufio"
 	"errors"
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // Message types sent from client to server.
@@ -10,54 +18,359 @@ const (
 	TypeJoin  = "JOIN"
 	TypeSend  = "SEND"
 	TypeLeave = "LEAVE"
+
+	// TypeSendID is SEND with a client-chosen ID. The server answers with
+	// TypeAck or TypeNack carrying the same ID.
+	TypeSendID = "SENDID"
+
+	// TypeSendReceipt is TypeSendID that also asks for TypeDelivered
+	// receipts once the message has reached each recipient.
+	TypeSendReceipt = "SENDRCPT"
+
+	// TypeAway marks the sender as away. The optional Body is a note such
+	// as "lunch". TypeBack marks the sender as present again.
+	TypeAway = "AWAY"
+	TypeBack = "BACK"
+
+	// TypeStatus sets the sender's status text, such as "in a meeting".
+	// Body is the encoded Status; an empty Body clears it.
+	TypeStatus = "STATUS"
+
+	// TypeDND turns do-not-disturb on (Body "on") or off (Body "off").
+	// While it's on the server holds back routine broadcasts, except
+	// messages that mention the user, and replays them when it's
+	// turned off.
+	TypeDND = "DND"
+
+	// TypeSeen asks when Username was last connected. The reply is a
+	// TypeSeen for the same Username whose Body is the encoded Seen, or
+	// empty if the server doesn't know.
+	TypeSeen = "SEEN"
+
+	// TypeHideSeen turns the sender's last-seen privacy on (Body "on"),
+	// which stops the server recording when they were last connected and
+	// forgets it, or off again (Body "off").
+	TypeHideSeen = "HIDESEEN"
+
+	// TypeNotify sets which chat messages the server pushes to the
+	// sender: Body is one of the Notify* levels. It applies until changed
+	// and doesn't affect joins, leaves, presence changes or notices.
+	TypeNotify = "NOTIFY"
+
+	// TypeReport flags a chat message for the server operators. Username
+	// and Body quote the MSG being reported.
+	TypeReport = "REPORT"
+
+	// TypeAnswer answers a TypeChallenge during the handshake. Body holds
+	// the answer.
+	TypeAnswer = "ANSWER"
+
+	// TypeResume joins as Username in place of TypeJoin, presenting the
+	// token ID from a TypeMigrate to the server it named.
+	TypeResume = "RESUME"
+
+	// TypeAttach shares a file uploaded to the server's attachment
+	// endpoint. Attachment holds the ID the upload returned and the
+	// optional Body a caption.
+	TypeAttach = "ATTACH"
+)
+
+// Notification levels carried by TypeNotify.
+const (
+	NotifyAll      = "all"      // every message (the default)
+	NotifyMentions = "mentions" // only messages that mention the user
+	NotifyNone     = "none"     // no messages
+)
+
+// Query types are sent by a client without a payload. The server replies
+// with a message of the same type whose Body carries the answer.
+const (
+	// TypeStats requests server statistics; the reply Body is the encoded
+	// Stats.
+	TypeStats = "STATS"
+
+	// TypeWho requests the list of connected users; the reply Body holds
+	// the usernames separated by "|".
+	TypeWho = "WHO"
+
+	// TypePing checks that the connection is alive. The server answers
+	// with TypePong, echoing the optional Body.
+	TypePing = "PING"
+	TypePong = "PONG"
 )
 
 // Message types sent from server to client.
 const (
-	TypeOK     = "OK"
-	TypeErr    = "ERR"
-	TypeMsg    = "MSG"
+	// TypeOK accepts a JOIN or RESUME. The optional Body lists the
+	// server's capabilities, the Cap* constants, separated by spaces,
+	// and Members counts the users now in the room. Username is set
+	// when a JOIN with Rename was admitted under a derived name.
+	TypeOK = "OK"
+
+	TypeErr = "ERR"
+	TypeMsg = "MSG"
+
+	// TypeJoined and TypeLeft report that Username joined or left the
+	// room. Members, if the server sends it, counts the users in the room
+	// afterwards.
 	TypeJoined = "JOINED"
 	TypeLeft   = "LEFT"
+
+	// TypeReconnect tells clients the server is about to go away and that
+	// they should reconnect. The optional Body carries a hint such as an
+	// alternative address.
+	TypeReconnect = "RECONNECT"
+
+	// TypeKicked tells a client the server is closing its connection and
+	// that it should not reconnect. Body holds the reason.
+	TypeKicked = "KICKED"
+
+	// TypeAck confirms that the SENDID with the given ID was broadcast.
+	TypeAck = "ACK"
+
+	// TypeNack reports that the SENDID with the given ID was refused; Body
+	// holds the reason.
+	TypeNack = "NACK"
+
+	// TypePresence reports that Username went away or came back. Body is
+	// the encoded Presence.
+	TypePresence = "PRESENCE"
+
+	// TypeUserStatus reports that Username set their status text to Body,
+	// or cleared it when Body is empty.
+	TypeUserStatus = "USERSTATUS"
+
+	// TypeNotice carries text from the server operators, such as the
+	// message of the day or an announcement. Body holds the text.
+	TypeNotice = "NOTICE"
+
+	// TypeDelivered reports, for the SENDRCPT with the given ID, which
+	// recipients the message was written to and which it never reached.
+	// Body is the encoded Receipt. Large receipts are split over several
+	// messages with the same ID.
+	TypeDelivered = "DELIVERED"
+
+	// TypeChallenge asks a joining client to prove it's not a bot before
+	// its JOIN is accepted. Body is the encoded Challenge; the client
+	// replies with TypeAnswer and the server then sends the next
+	// challenge, OK or ERR.
+	TypeChallenge = "CHALLENGE"
+
+	// TypeMode tells clients the room's posting rules changed, and a
+	// joining client the rules in force unless the room is open. Body is
+	// the encoded RoomMode.
+	TypeMode = "MODE"
+
+	// TypeAttached relays a TypeAttach from Username. Attachment holds
+	// the URL to download the file from and the optional Body a caption.
+	TypeAttached = "ATTACHED"
+
+	// TypeMigrate asks a client to move its session to another server,
+	// for instance to rebalance load, before the connection is closed.
+	// Body is the address to connect to and ID a token to join there
+	// with TypeResume.
+	TypeMigrate = "MIGRATE"
+)
+
+// Capabilities a server may list in its OK. Clients ignore the ones they
+// don't know.
+const (
+	// CapFormat says chat message bodies may use the formatting subset
+	// parsed by ParseFormat. Bodies are still carried verbatim.
+	CapFormat = "format"
+
+	// CapCommands says the server runs chat messages starting with "/"
+	// as commands, answering only the sender. A message meant to start
+	// with "/" is sent with "//" instead.
+	CapCommands = "commands"
+
+	// CapRate, as "rate=R/B" with R a number and B an integer, says the
+	// server refuses chat messages, commands aside, beyond R a second in
+	// bursts of up to B. See RateCapability.
+	CapRate = "rate"
 )
 
 // Message represents a parsed protocol message.
 type Message struct {
 	Type     string // One of the Type* constants
-	Username string // Populated for JOIN, MSG, JOINED, LEFT
-	Body     string // Populated for SEND, MSG, ERR
+	Username string // Populated for JOIN, MSG, JOINED, LEFT, PRESENCE, REPORT
+	Body     string // Populated for SEND, MSG, OK, ERR, RECONNECT, AWAY, PRESENCE and query replies
+	ID       string // Populated for SENDID, SENDRCPT, ACK, NACK, DELIVERED, MIGRATE and RESUME; must not contain "|"
+
+	// Attachment is the attachment ID in ATTACH and its URL in ATTACHED.
+	// It must not contain "|".
+	Attachment string
+
+	// Members is how many users are in the room, carried by OK, JOINED
+	// and LEFT. Zero means the server didn't say; it isn't encoded.
+	Members int
+
+	// Locale is the language a JOIN asks the server to answer in, as a
+	// BCP 47 tag such as "de" or "pt-BR". Empty means English.
+	Locale string
+
+	// Rename, in a JOIN, asks the server to admit the client under a
+	// derived name such as "alice_2" if Username is taken, rather than
+	// refusing it. The OK then carries the name granted in Username.
+	Rename bool
+
+	// Received is when the message arrived, set by the receiving side.
+	// It is not part of the wire format.
+	Received time.Time
 }
 
-// ErrInvalidMessage is returned when a message cannot be parsed.
+// ReasonNameReserved is the Body of the ERR rejecting a JOIN for a
+// username that a registered user has reserved. It is a code rather than
+// text so clients can recognize it and explain it in their own words.
+const ReasonNameReserved = "NAME_RESERVED"
+
+// ErrInvalidMessage is returned when a message cannot be parsed. The more
+// specific errors below wrap it, so errors.Is(err, ErrInvalidMessage)
+// holds for every error Decode returns.
 var ErrInvalidMessage = errors.New("invalid message format")
 
+var (
+	// ErrUnknownType is returned for a line whose type isn't one of the
+	// Type constants.
+	ErrUnknownType = fmt.Errorf("%w: unknown message type", ErrInvalidMessage)
+
+	// ErrMissingUsername is returned when a message that names a user,
+	// such as JOIN or MSG, has an empty username.
+	ErrMissingUsername = fmt.Errorf("%w: missing username", ErrInvalidMessage)
+
+	// ErrEmptyBody is returned when a message that carries text, such as
+	// SEND or NOTICE, has none.
+	ErrEmptyBody = fmt.Errorf("%w: empty body", ErrInvalidMessage)
+
+	// ErrLineTooLong is returned by ScanLines for a line longer than
+	// MaxLineLength. Decode itself accepts long lines.
+	ErrLineTooLong = fmt.Errorf("%w: line too long", ErrInvalidMessage)
+
+	// ErrInvalidUsername is returned when a message names a user that
+	// couldn't be carried in other messages: one that is too long or
+	// contains "|".
+	ErrInvalidUsername = fmt.Errorf("%w: invalid username", ErrInvalidMessage)
+
+	// ErrInvalidLocale is returned when a JOIN asks for a locale that
+	// isn't a language tag, such as "de_DE".
+	ErrInvalidLocale = fmt.Errorf("%w: invalid locale", ErrInvalidMessage)
+)
+
+// MaxLineLength bounds the lines, including the newline, that servers
+// read from clients. Clients accept longer lines from servers, since MSG
+// adds the author to the body the sender was limited to.
+const MaxLineLength = 4096
+
+// MaxUsernameLength is the longest username, in bytes, a JOIN may carry.
+const MaxUsernameLength = 64
+
+// QUICProtocol is the TLS application protocol (ALPN) negotiated by QUIC
+// connections. Each connection carries the usual lines on one
+// bidirectional stream opened by the client.
+const QUICProtocol = "simple-chat"
+
 // Encode serializes a Message into a wire-format string (without trailing newline).
 func Encode(m Message) string {
 	switch m.Type {
 	case TypeJoin:
-		return TypeJoin + "|" + m.Username
+		line := TypeJoin + "|" + m.Username
+		if m.Locale != "" {
+			line += "|locale=" + m.Locale
+		}
+		if m.Rename {
+			line += "|rename"
+		}
+		return line
 	case TypeSend:
 		return TypeSend + "|" + m.Body
 	case TypeLeave:
 		return TypeLeave
+	case TypeSendID, TypeSendReceipt:
+		return m.Type + "|" + m.ID + "|" + m.Body
+	case TypeAck:
+		return TypeAck + "|" + m.ID
+	case TypeDelivered:
+		if m.Body == "" {
+			return TypeDelivered + "|" + m.ID
+		}
+		return TypeDelivered + "|" + m.ID + "|" + m.Body
+	case TypeNack:
+		return TypeNack + "|" + m.ID + "|" + m.Body
+	case TypeStats, TypeWho, TypePing, TypePong, TypeAway, TypeDND, TypeStatus, TypeHideSeen:
+		if m.Body == "" {
+			return m.Type
+		}
+		return m.Type + "|" + m.Body
+	case TypeBack:
+		return TypeBack
+	case TypePresence:
+		return TypePresence + "|" + m.Username + "|" + m.Body
+	case TypeUserStatus, TypeSeen:
+		if m.Body == "" {
+			return m.Type + "|" + m.Username
+		}
+		return m.Type + "|" + m.Username + "|" + m.Body
 	case TypeOK:
-		return TypeOK
-	case TypeErr:
-		return TypeErr + "|" + m.Body
-	case TypeMsg:
-		return TypeMsg + "|" + m.Username + "|" + m.Body
-	case TypeJoined:
-		return TypeJoined + "|" + m.Username
-	case TypeLeft:
-		return TypeLeft + "|" + m.Username
+		switch {
+		case m.Username != "":
+			return TypeOK + "|" + m.Body + "|" + strconv.Itoa(m.Members) + "|" + m.Username
+		case m.Members > 0:
+			return TypeOK + "|" + m.Body + "|" + strconv.Itoa(m.Members)
+		case m.Body != "":
+			return TypeOK + "|" + m.Body
+		default:
+			return TypeOK
+		}
+	case TypeErr, TypeKicked, TypeNotice, TypeNotify, TypeChallenge, TypeAnswer, TypeMode:
+		return m.Type + "|" + m.Body
+	case TypeMsg, TypeReport:
+		return m.Type + "|" + m.Username + "|" + m.Body
+	case TypeJoined, TypeLeft:
+		if m.Members > 0 {
+			return m.Type + "|" + m.Username + "|" + strconv.Itoa(m.Members)
+		}
+		return m.Type + "|" + m.Username
+	case TypeReconnect:
+		if m.Body == "" {
+			return TypeReconnect
+		}
+		return TypeReconnect + "|" + m.Body
+	case TypeMigrate:
+		return TypeMigrate + "|" + m.ID + "|" + m.Body
+	case TypeResume:
+		return TypeResume + "|" + m.Username + "|" + m.ID
+	case TypeAttach:
+		if m.Body == "" {
+			return TypeAttach + "|" + m.Attachment
+		}
+		return TypeAttach + "|" + m.Attachment + "|" + m.Body
+	case TypeAttached:
+		return TypeAttached + "|" + m.Username + "|" + m.Attachment + "|" + m.Body
 	default:
 		return ""
 	}
 }
 
 // Decode parses a single wire-format line (without trailing newline) into a Message.
+// Usernames come back normalized; see NormalizeUsername.
 func Decode(line string) (Message, error) {
-	if line == "" {
+	m, err := decode(line)
+	if err != nil || norm.NFC.IsNormalString(m.Username) {
+		return m, err
+	}
+	m.Username = NormalizeUsername(m.Username)
+	if !validUsername(m.Username) {
+		// NFC lengthens a few characters, such as U+0958, by decomposing them.
+		return Message{}, ErrInvalidUsername
+	}
+	return m, nil
+}
+
+// decode is Decode without the normalization.
+func decode(line string) (Message, error) {
+	// Lines must be UTF-8 text. Rejecting control characters keeps NULs
+	// and terminal escape sequences out of other users' screens.
+	if line == "" || !ValidText(line) {
 		return Message{}, ErrInvalidMessage
 	}
 
@@ -67,52 +380,529 @@ func Decode(line string) (Message, error) {
 	switch msgType {
 	case TypeJoin:
 		if len(parts) < 2 || parts[1] == "" {
-			return Message{}, ErrInvalidMessage
+			return Message{}, ErrMissingUsername
+		}
+		name, locale := parts[1], ""
+		name, rename := strings.CutSuffix(name, "|rename")
+		if i := strings.LastIndex(name, "|locale="); i >= 0 {
+			name, locale = name[:i], name[i+len("|locale="):]
+			if !ValidLocale(locale) {
+				return Message{}, ErrInvalidLocale
+			}
 		}
-		return Message{Type: TypeJoin, Username: parts[1]}, nil
+		if name == "" {
+			return Message{Type: TypeJoin, Locale: locale}, ErrMissingUsername
+		}
+		if !validUsername(name) {
+			// The locale is kept so the rejection can be translated.
+			return Message{Type: TypeJoin, Locale: locale}, ErrInvalidUsername
+		}
+		return Message{Type: TypeJoin, Username: name, Locale: locale, Rename: rename}, nil
 
 	case TypeSend:
 		if len(parts) < 2 || parts[1] == "" {
-			return Message{}, ErrInvalidMessage
+			return Message{}, ErrEmptyBody
 		}
 		return Message{Type: TypeSend, Body: parts[1]}, nil
 
 	case TypeLeave:
 		return Message{Type: TypeLeave}, nil
 
-	case TypeOK:
-		return Message{Type: TypeOK}, nil
+	case TypeSendID, TypeSendReceipt, TypeNack, TypeMigrate:
+		if len(parts) < 2 {
+			return Message{}, ErrInvalidMessage
+		}
+		subParts := strings.SplitN(parts[1], "|", 2)
+		if len(subParts) < 2 || subParts[0] == "" {
+			return Message{}, ErrInvalidMessage
+		}
+		if subParts[1] == "" {
+			return Message{}, ErrEmptyBody
+		}
+		return Message{Type: msgType, ID: subParts[0], Body: subParts[1]}, nil
 
-	case TypeErr:
-		if len(parts) < 2 || parts[1] == "" {
+	case TypeAck:
+		if len(parts) < 2 || parts[1] == "" || strings.Contains(parts[1], "|") {
+			return Message{}, ErrInvalidMessage
+		}
+		return Message{Type: TypeAck, ID: parts[1]}, nil
+
+	case TypeDelivered:
+		if len(parts) < 2 {
+			return Message{}, ErrInvalidMessage
+		}
+		id, body, _ := strings.Cut(parts[1], "|")
+		if id == "" {
 			return Message{}, ErrInvalidMessage
 		}
-		return Message{Type: TypeErr, Body: parts[1]}, nil
+		return Message{Type: TypeDelivered, ID: id, Body: body}, nil
+
+	case TypeStats, TypeWho, TypePing, TypePong, TypeAway, TypeDND, TypeStatus, TypeHideSeen:
+		if len(parts) < 2 {
+			return Message{Type: msgType}, nil
+		}
+		return Message{Type: msgType, Body: parts[1]}, nil
 
-	case TypeMsg:
+	case TypeBack:
+		return Message{Type: TypeBack}, nil
+
+	case TypeOK:
 		if len(parts) < 2 {
+			return Message{Type: TypeOK}, nil
+		}
+		caps, count, hasCount := strings.Cut(parts[1], "|")
+		count, name, renamed := strings.Cut(count, "|")
+		members, ok := parseMembers(count, hasCount)
+		if !ok || (renamed && !validUsername(name)) {
 			return Message{}, ErrInvalidMessage
 		}
+		return Message{Type: TypeOK, Body: caps, Members: members, Username: name}, nil
+
+	case TypeErr, TypeKicked, TypeNotice, TypeNotify, TypeChallenge, TypeAnswer, TypeMode:
+		if len(parts) < 2 || parts[1] == "" {
+			return Message{}, ErrEmptyBody
+		}
+		return Message{Type: msgType, Body: parts[1]}, nil
+
+	case TypeMsg, TypePresence, TypeReport:
+		if len(parts) < 2 || parts[1] == "" {
+			return Message{}, ErrMissingUsername
+		}
 		// Split the payload further to get username and body
 		subParts := strings.SplitN(parts[1], "|", 2)
-		if len(subParts) < 2 || subParts[0] == "" || subParts[1] == "" {
+		if subParts[0] == "" {
+			return Message{}, ErrMissingUsername
+		}
+		if len(subParts) < 2 || subParts[1] == "" {
+			return Message{}, ErrEmptyBody
+		}
+		return Message{Type: msgType, Username: subParts[0], Body: subParts[1]}, nil
+
+	case TypeUserStatus, TypeSeen:
+		if len(parts) < 2 {
+			return Message{}, ErrMissingUsername
+		}
+		name, text, _ := strings.Cut(parts[1], "|")
+		if err := checkUsername(name); err != nil {
+			return Message{}, err
+		}
+		return Message{Type: msgType, Username: name, Body: text}, nil
+
+	case TypeJoined, TypeLeft:
+		if len(parts) < 2 {
+			return Message{}, ErrMissingUsername
+		}
+		name, count, hasCount := strings.Cut(parts[1], "|")
+		if err := checkUsername(name); err != nil {
+			return Message{}, err
+		}
+		members, ok := parseMembers(count, hasCount)
+		if !ok {
 			return Message{}, ErrInvalidMessage
 		}
-		return Message{Type: TypeMsg, Username: subParts[0], Body: subParts[1]}, nil
+		return Message{Type: msgType, Username: name, Members: members}, nil
 
-	case TypeJoined:
-		if len(parts) < 2 || parts[1] == "" {
+	case TypeReconnect:
+		if len(parts) < 2 {
+			return Message{Type: TypeReconnect}, nil
+		}
+		return Message{Type: TypeReconnect, Body: parts[1]}, nil
+
+	case TypeResume:
+		if len(parts) < 2 {
 			return Message{}, ErrInvalidMessage
 		}
-		return Message{Type: TypeJoined, Username: parts[1]}, nil
+		name, token, _ := strings.Cut(parts[1], "|")
+		if token == "" || strings.Contains(token, "|") {
+			return Message{}, ErrInvalidMessage
+		}
+		if err := checkUsername(name); err != nil {
+			return Message{}, err
+		}
+		return Message{Type: TypeResume, Username: name, ID: token}, nil
 
-	case TypeLeft:
-		if len(parts) < 2 || parts[1] == "" {
+	case TypeAttach:
+		if len(parts) < 2 {
 			return Message{}, ErrInvalidMessage
 		}
-		return Message{Type: TypeLeft, Username: parts[1]}, nil
+		attachment, caption, _ := strings.Cut(parts[1], "|")
+		if attachment == "" {
+			return Message{}, ErrInvalidMessage
+		}
+		return Message{Type: TypeAttach, Attachment: attachment, Body: caption}, nil
+
+	case TypeAttached:
+		if len(parts) < 2 {
+			return Message{}, ErrInvalidMessage
+		}
+		subParts := strings.SplitN(parts[1], "|", 3)
+		if err := checkUsername(subParts[0]); err != nil {
+			return Message{}, err
+		}
+		if len(subParts) < 3 || subParts[1] == "" {
+			return Message{}, ErrInvalidMessage
+		}
+		return Message{Type: TypeAttached, Username: subParts[0], Attachment: subParts[1], Body: subParts[2]}, nil
 
 	default:
-		return Message{}, ErrInvalidMessage
+		return Message{}, ErrUnknownType
+	}
+}
+
+// parseMembers parses the optional member count ending OK, JOINED and
+// LEFT messages. present says whether the field was there at all.
+func parseMembers(field string, present bool) (int, bool) {
+	if !present {
+		return 0, true
+	}
+	n, err := strconv.Atoi(field)
+	if err != nil || n < 0 || strings.ContainsAny(field, "+-") {
+		return 0, false
+	}
+	return n, true
+}
+
+// ValidLocale reports whether tag looks like a BCP 47 language tag, such
+// as "de" or "pt-BR": letters and digits in subtags of up to 8,
+// separated by "-", starting with a language of 2 or 3 letters.
+func ValidLocale(tag string) bool {
+	if len(tag) > 35 {
+		return false
+	}
+	for i, sub := range strings.Split(tag, "-") {
+		if len(sub) == 0 || len(sub) > 8 {
+			return false
+		}
+		for _, r := range sub {
+			letter := r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z'
+			if !letter && (i == 0 || r < '0' || r > '9') {
+				return false
+			}
+		}
+		if i == 0 && len(sub) > 3 {
+			return false
+		}
+	}
+	return true
+}
+
+// NormalizeUsername returns name in Unicode Normalization Form C, so a
+// name typed with "é" as one code point and the same name typed as "e"
+// and a combining accent, as some systems do, are the same string.
+func NormalizeUsername(name string) string {
+	return norm.NFC.String(name)
+}
+
+// checkUsername returns ErrMissingUsername or ErrInvalidUsername for a
+// name that validUsername rejects.
+func checkUsername(name string) error {
+	switch {
+	case name == "":
+		return ErrMissingUsername
+	case !validUsername(name):
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+// ScanLines is a bufio.SplitFunc like bufio.ScanLines that fails with
+// ErrLineTooLong once a line, with its newline, would be longer than
+// MaxLineLength. Give the Scanner a buffer of at least MaxLineLength.
+func ScanLines(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = bufio.ScanLines(data, atEOF)
+	if advance == 0 && token == nil && err == nil && len(data) >= MaxLineLength {
+		return 0, nil, ErrLineTooLong
+	}
+	return advance, token, err
+}
+
+// validUsername reports whether name can be carried in every message
+// type: non-empty, at most MaxUsernameLength bytes and free of "|".
+func validUsername(name string) bool {
+	return name != "" && len(name) <= MaxUsernameLength && !strings.Contains(name, "|")
+}
+
+// ValidText reports whether s could appear in a line: valid UTF-8 with no
+// line breaks or other control characters except tab. Decode rejects
+// lines that aren't valid text.
+func ValidText(s string) bool {
+	return utf8.ValidString(s) && !strings.ContainsFunc(s, isControl)
+}
+
+// isControl reports whether r is an ASCII or C1 control character other
+// than tab.
+func isControl(r rune) bool {
+	return r != '\t' && (r < 0x20 || r >= 0x7f && r < 0xa0)
+}
+
+// Stats is the structured payload of a STATS reply.
+type Stats struct {
+	Uptime   time.Duration // Time since the server started listening
+	Users    int           // Currently connected users
+	Rooms    int           // Number of chat rooms
+	Messages uint64        // Total messages relayed since start
+	Rate     float64       // Average messages per second since start
+}
+
+// EncodeStats serializes Stats into the Body of a STATS reply.
+func EncodeStats(s Stats) string {
+	return fmt.Sprintf("uptime=%d|users=%d|rooms=%d|messages=%d|rate=%.2f",
+		int64(s.Uptime/time.Second), s.Users, s.Rooms, s.Messages, s.Rate)
+}
+
+// ParseStats parses the Body of a STATS reply. Unknown keys are ignored so
+// that servers can add fields without breaking older clients.
+func ParseStats(body string) (Stats, error) {
+	if body == "" {
+		return Stats{}, ErrInvalidMessage
+	}
+
+	var s Stats
+	for _, field := range strings.Split(body, "|") {
+		key, val, ok := strings.Cut(field, "=")
+		if !ok {
+			return Stats{}, ErrInvalidMessage
+		}
+
+		var err error
+		switch key {
+		case "uptime":
+			var secs int64
+			secs, err = strconv.ParseInt(val, 10, 64)
+			s.Uptime = time.Duration(secs) * time.Second
+		case "users":
+			s.Users, err = strconv.Atoi(val)
+		case "rooms":
+			s.Rooms, err = strconv.Atoi(val)
+		case "messages":
+			s.Messages, err = strconv.ParseUint(val, 10, 64)
+		case "rate":
+			s.Rate, err = strconv.ParseFloat(val, 64)
+		}
+		if err != nil {
+			return Stats{}, ErrInvalidMessage
+		}
+	}
+	return s, nil
+}
+
+// Presence is the structured payload of a PRESENCE message.
+type Presence struct {
+	Away bool   // The user is away
+	Note string // Optional note given when going away
+}
+
+// EncodePresence serializes p into the Body of a PRESENCE message: "here",
+// "away" or "away|<note>".
+func EncodePresence(p Presence) string {
+	switch {
+	case !p.Away:
+		return "here"
+	case p.Note == "":
+		return "away"
+	default:
+		return "away|" + p.Note
+	}
+}
+
+// ParsePresence parses the Body of a PRESENCE message.
+func ParsePresence(body string) (Presence, error) {
+	state, note, _ := strings.Cut(body, "|")
+	switch state {
+	case "here":
+		return Presence{}, nil
+	case "away":
+		return Presence{Away: true, Note: note}, nil
+	default:
+		return Presence{}, ErrInvalidMessage
+	}
+}
+
+// Status is the structured payload of a STATUS message.
+type Status struct {
+	Text   string        // free-form text shown with the user's name
+	Expiry time.Duration // how long until the server clears it; 0 keeps it until changed
+}
+
+// EncodeStatus serializes s into the Body of a STATUS message:
+// "<seconds>|<text>", or "" to clear the status. Expiry is rounded up to
+// whole seconds.
+func EncodeStatus(s Status) string {
+	if s.Text == "" {
+		return ""
+	}
+	secs := (s.Expiry + time.Second - 1) / time.Second
+	return strconv.FormatInt(int64(secs), 10) + "|" + s.Text
+}
+
+// ParseStatus parses the Body of a STATUS message.
+func ParseStatus(body string) (Status, error) {
+	if body == "" {
+		return Status{}, nil
+	}
+	field, text, _ := strings.Cut(body, "|")
+	secs, err := strconv.Atoi(field)
+	if err != nil || secs < 0 || strings.ContainsAny(field, "+-") || text == "" {
+		return Status{}, ErrInvalidMessage
+	}
+	return Status{Text: text, Expiry: time.Duration(secs) * time.Second}, nil
+}
+
+// Seen is the structured payload of a SEEN reply.
+type Seen struct {
+	Online bool      // the user is connected now
+	At     time.Time // when the user last disconnected, if not Online
+}
+
+// EncodeSeen serializes s into the Body of a SEEN reply: "online", or
+// the Unix time in seconds when the user was last connected.
+func EncodeSeen(s Seen) string {
+	if s.Online {
+		return "online"
+	}
+	return strconv.FormatInt(s.At.Unix(), 10)
+}
+
+// ParseSeen parses the Body of a SEEN reply.
+func ParseSeen(body string) (Seen, error) {
+	if body == "online" {
+		return Seen{Online: true}, nil
+	}
+	secs, err := strconv.ParseInt(body, 10, 64)
+	if err != nil || secs < 0 || strings.ContainsAny(body, "+-") {
+		return Seen{}, ErrInvalidMessage
+	}
+	return Seen{At: time.Unix(secs, 0)}, nil
+}
+
+// RoomMode is the structured payload of a MODE message: who may post and
+// how often.
+type RoomMode struct {
+	Announce bool          // only operators may post, as notices
+	Slow     time.Duration // minimum time between one user's messages; 0 is no limit
+}
+
+// EncodeRoomMode serializes m into the Body of a MODE message: "open", or
+// "announce" and "slow=<seconds>" separated by "|". Slow is rounded up to
+// whole seconds.
+func EncodeRoomMode(m RoomMode) string {
+	var fields []string
+	if m.Announce {
+		fields = append(fields, "announce")
+	}
+	if m.Slow > 0 {
+		secs := (m.Slow + time.Second - 1) / time.Second
+		fields = append(fields, "slow="+strconv.FormatInt(int64(secs), 10))
+	}
+	if len(fields) == 0 {
+		return "open"
+	}
+	return strings.Join(fields, "|")
+}
+
+// ParseRoomMode parses the Body of a MODE message. Unknown fields are
+// ignored so servers can add rules without breaking older clients.
+func ParseRoomMode(body string) (RoomMode, error) {
+	var m RoomMode
+	for _, field := range strings.Split(body, "|") {
+		key, value, _ := strings.Cut(field, "=")
+		switch key {
+		case "announce":
+			m.Announce = true
+		case "slow":
+			secs, err := strconv.Atoi(value)
+			if err != nil || secs < 0 {
+				return RoomMode{}, ErrInvalidMessage
+			}
+			m.Slow = time.Duration(secs) * time.Second
+		}
+	}
+	return m, nil
+}
+
+// Receipt is the structured payload of a DELIVERED message.
+type Receipt struct {
+	Delivered []string // recipients the message was written to
+	Missed    []string // recipients it was dropped for or that left first
+}
+
+// EncodeReceipt serializes r into the Body of a DELIVERED message: the
+// recipients separated by "|", each prefixed with "+" if the message was
+// delivered or "-" if it was missed.
+func EncodeReceipt(r Receipt) string {
+	var b strings.Builder
+	for _, names := range []struct {
+		mark string
+		list []string
+	}{{"+", r.Delivered}, {"-", r.Missed}} {
+		for _, name := range names.list {
+			if b.Len() > 0 {
+				b.WriteByte('|')
+			}
+			b.WriteString(names.mark + name)
+		}
+	}
+	return b.String()
+}
+
+// ParseReceipt parses the Body of a DELIVERED message.
+func ParseReceipt(body string) (Receipt, error) {
+	var r Receipt
+	if body == "" {
+		return r, nil
+	}
+	for _, field := range strings.Split(body, "|") {
+		switch {
+		case len(field) < 2:
+			return Receipt{}, ErrInvalidMessage
+		case field[0] == '+':
+			r.Delivered = append(r.Delivered, field[1:])
+		case field[0] == '-':
+			r.Missed = append(r.Missed, field[1:])
+		default:
+			return Receipt{}, ErrInvalidMessage
+		}
+	}
+	return r, nil
+}
+
+// EncodeCapabilities serializes caps into the Body of an OK message.
+func EncodeCapabilities(caps []string) string {
+	return strings.Join(caps, " ")
+}
+
+// ParseCapabilities parses the Body of an OK message.
+func ParseCapabilities(body string) []string {
+	return strings.Fields(body)
+}
+
+// RateCapability returns the CapRate capability for a limit of rate chat
+// messages a second in bursts of up to burst.
+func RateCapability(rate float64, burst int) string {
+	return CapRate + "=" + strconv.FormatFloat(rate, 'g', -1, 64) + "/" + strconv.Itoa(burst)
+}
+
+// ParseRateCapability returns the limit in the CapRate capability among
+// caps, or false if there is none or it is malformed.
+func ParseRateCapability(caps []string) (rate float64, burst int, ok bool) {
+	for _, c := range caps {
+		limit, found := strings.CutPrefix(c, CapRate+"=")
+		if !found {
+			continue
+		}
+		r, b, found := strings.Cut(limit, "/")
+		if !found {
+			return 0, 0, false
+		}
+		rate, err := strconv.ParseFloat(r, 64)
+		if err != nil || rate <= 0 || math.IsInf(rate, 0) {
+			return 0, 0, false
+		}
+		burst, err := strconv.Atoi(b)
+		if err != nil || burst < 1 {
+			return 0, 0, false
+		}
+		return rate, burst, true
 	}
+	return 0, 0, false
 }
@@ -1,7 +1,12 @@
 package protocol
 
 import (
+	"bufio"
+	"errors"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestEncodeRoundTrip(t *testing.T) {
@@ -11,13 +16,60 @@ func TestEncodeRoundTrip(t *testing.T) {
 		want string
 	}{
 		{"JOIN", Message{Type: TypeJoin, Username: "alice"}, "JOIN|alice"},
+		{"JOIN with locale", Message{Type: TypeJoin, Username: "alice", Locale: "pt-BR"}, "JOIN|alice|locale=pt-BR"},
+		{"JOIN with rename", Message{Type: TypeJoin, Username: "alice", Locale: "de", Rename: true}, "JOIN|alice|locale=de|rename"},
 		{"SEND", Message{Type: TypeSend, Body: "hello world"}, "SEND|hello world"},
 		{"LEAVE", Message{Type: TypeLeave}, "LEAVE"},
 		{"OK", Message{Type: TypeOK}, "OK"},
+		{"OK with capabilities", Message{Type: TypeOK, Body: "format"}, "OK|format"},
+		{"OK with members", Message{Type: TypeOK, Body: "format", Members: 42}, "OK|format|42"},
+		{"OK with members only", Message{Type: TypeOK, Members: 1}, "OK||1"},
+		{"OK with a new name", Message{Type: TypeOK, Body: "format", Members: 2, Username: "alice_2"}, "OK|format|2|alice_2"},
 		{"ERR", Message{Type: TypeErr, Body: "username taken"}, "ERR|username taken"},
 		{"MSG", Message{Type: TypeMsg, Username: "bob", Body: "hi there"}, "MSG|bob|hi there"},
 		{"JOINED", Message{Type: TypeJoined, Username: "charlie"}, "JOINED|charlie"},
 		{"LEFT", Message{Type: TypeLeft, Username: "dave"}, "LEFT|dave"},
+		{"JOINED with members", Message{Type: TypeJoined, Username: "charlie", Members: 3}, "JOINED|charlie|3"},
+		{"LEFT with members", Message{Type: TypeLeft, Username: "dave", Members: 2}, "LEFT|dave|2"},
+		{"STATS request", Message{Type: TypeStats}, "STATS"},
+		{"STATS reply", Message{Type: TypeStats, Body: "users=2"}, "STATS|users=2"},
+		{"WHO request", Message{Type: TypeWho}, "WHO"},
+		{"WHO reply", Message{Type: TypeWho, Body: "alice|bob"}, "WHO|alice|bob"},
+		{"SENDID", Message{Type: TypeSendID, ID: "7", Body: "a|b"}, "SENDID|7|a|b"},
+		{"KICKED", Message{Type: TypeKicked, Body: "spam"}, "KICKED|spam"},
+		{"SENDRCPT", Message{Type: TypeSendReceipt, ID: "8", Body: "urgent"}, "SENDRCPT|8|urgent"},
+		{"ACK", Message{Type: TypeAck, ID: "7"}, "ACK|7"},
+		{"DELIVERED", Message{Type: TypeDelivered, ID: "8", Body: "+alice|-bob"}, "DELIVERED|8|+alice|-bob"},
+		{"DELIVERED to nobody", Message{Type: TypeDelivered, ID: "8"}, "DELIVERED|8"},
+		{"NACK", Message{Type: TypeNack, ID: "7", Body: "muted"}, "NACK|7|muted"},
+		{"PING", Message{Type: TypePing, Body: "42"}, "PING|42"},
+		{"PONG", Message{Type: TypePong}, "PONG"},
+		{"RECONNECT", Message{Type: TypeReconnect}, "RECONNECT"},
+		{"RECONNECT hint", Message{Type: TypeReconnect, Body: "chat2:8080"}, "RECONNECT|chat2:8080"},
+		{"AWAY", Message{Type: TypeAway, Body: "lunch"}, "AWAY|lunch"},
+		{"AWAY without note", Message{Type: TypeAway}, "AWAY"},
+		{"BACK", Message{Type: TypeBack}, "BACK"},
+		{"DND", Message{Type: TypeDND, Body: "on"}, "DND|on"},
+		{"PRESENCE", Message{Type: TypePresence, Username: "bob", Body: "away|out|back soon"}, "PRESENCE|bob|away|out|back soon"},
+		{"STATUS", Message{Type: TypeStatus, Body: "3600|in a meeting"}, "STATUS|3600|in a meeting"},
+		{"STATUS clearing", Message{Type: TypeStatus}, "STATUS"},
+		{"USERSTATUS", Message{Type: TypeUserStatus, Username: "bob", Body: "on call|pager"}, "USERSTATUS|bob|on call|pager"},
+		{"USERSTATUS cleared", Message{Type: TypeUserStatus, Username: "bob"}, "USERSTATUS|bob"},
+		{"SEEN", Message{Type: TypeSeen, Username: "bob"}, "SEEN|bob"},
+		{"SEEN reply", Message{Type: TypeSeen, Username: "bob", Body: "1700000000"}, "SEEN|bob|1700000000"},
+		{"HIDESEEN", Message{Type: TypeHideSeen, Body: "on"}, "HIDESEEN|on"},
+		{"NOTICE", Message{Type: TypeNotice, Body: "maintenance at 5|ish"}, "NOTICE|maintenance at 5|ish"},
+		{"NOTIFY", Message{Type: TypeNotify, Body: NotifyMentions}, "NOTIFY|mentions"},
+		{"CHALLENGE", Message{Type: TypeChallenge, Body: "work|20|abc"}, "CHALLENGE|work|20|abc"},
+		{"ANSWER", Message{Type: TypeAnswer, Body: "1234"}, "ANSWER|1234"},
+		{"REPORT", Message{Type: TypeReport, Username: "bob", Body: "buy|cheap"}, "REPORT|bob|buy|cheap"},
+		{"MIGRATE", Message{Type: TypeMigrate, ID: "tok", Body: "ws://chat2/ws"}, "MIGRATE|tok|ws://chat2/ws"},
+		{"RESUME", Message{Type: TypeResume, Username: "alice", ID: "tok"}, "RESUME|alice|tok"},
+		{"MODE", Message{Type: TypeMode, Body: "announce|slow=30"}, "MODE|announce|slow=30"},
+		{"ATTACH", Message{Type: TypeAttach, Attachment: "f00d", Body: "cat|dog"}, "ATTACH|f00d|cat|dog"},
+		{"ATTACH without caption", Message{Type: TypeAttach, Attachment: "f00d"}, "ATTACH|f00d"},
+		{"ATTACHED", Message{Type: TypeAttached, Username: "bob", Attachment: "http://chat/attachments/f00d", Body: "cat"}, "ATTACHED|bob|http://chat/attachments/f00d|cat"},
+		{"ATTACHED without caption", Message{Type: TypeAttached, Username: "bob", Attachment: "/attachments/f00d"}, "ATTACHED|bob|/attachments/f00d|"},
 	}
 
 	for _, tt := range tests {
@@ -40,6 +92,9 @@ func TestEncodeRoundTrip(t *testing.T) {
 			if decoded.Body != tt.msg.Body {
 				t.Errorf("Decode().Body = %q, want %q", decoded.Body, tt.msg.Body)
 			}
+			if decoded.ID != tt.msg.ID {
+				t.Errorf("Decode().ID = %q, want %q", decoded.ID, tt.msg.ID)
+			}
 		})
 	}
 }
@@ -51,6 +106,9 @@ func TestDecodeValid(t *testing.T) {
 		want  Message
 	}{
 		{"JOIN", "JOIN|alice", Message{Type: TypeJoin, Username: "alice"}},
+		{"JOIN with locale", "JOIN|alice|locale=de", Message{Type: TypeJoin, Username: "alice", Locale: "de"}},
+		{"JOIN with rename", "JOIN|alice|rename", Message{Type: TypeJoin, Username: "alice", Rename: true}},
+		{"JOIN as rename", "JOIN|rename", Message{Type: TypeJoin, Username: "rename"}},
 		{"SEND", "SEND|hello", Message{Type: TypeSend, Body: "hello"}},
 		{"LEAVE", "LEAVE", Message{Type: TypeLeave}},
 		{"OK", "OK", Message{Type: TypeOK}},
@@ -94,6 +152,42 @@ func TestDecodeInvalid(t *testing.T) {
 		{"JOINED no payload", "JOINED"},
 		{"LEFT without username", "LEFT|"},
 		{"LEFT no payload", "LEFT"},
+		{"SENDID without body", "SENDID|1|"},
+		{"SENDID without ID", "SENDID||hi"},
+		{"KICKED without reason", "KICKED"},
+		{"NOTICE without text", "NOTICE"},
+		{"NOTIFY without level", "NOTIFY"},
+		{"REPORT without text", "REPORT|bob"},
+		{"ANSWER without answer", "ANSWER"},
+		{"PRESENCE without state", "PRESENCE|bob"},
+		{"PRESENCE without username", "PRESENCE||here"},
+		{"ACK without ID", "ACK"},
+		{"NACK without reason", "NACK|1"},
+		{"SENDRCPT without ID", "SENDRCPT||hi"},
+		{"DELIVERED without ID", "DELIVERED"},
+		{"DELIVERED empty ID", "DELIVERED||+alice"},
+		{"JOIN username with separator", "JOIN|a|b"},
+		{"JOIN username too long", "JOIN|" + strings.Repeat("a", MaxUsernameLength+1)},
+		{"JOIN with a bad locale", "JOIN|alice|locale=de_DE"},
+		{"JOIN with a locale and a separator", "JOIN|a|b|locale=de"},
+		{"JOINED username with separator", "JOINED|a|b"},
+		{"SEND with NUL", "SEND|a\x00b"},
+		{"SEND with escape sequence", "SEND|\x1b[2J"},
+		{"SEND with C1 control", "SEND|a\u0085b"},
+		{"SEND invalid UTF-8", "SEND|\xff\xfe"},
+		{"MIGRATE without address", "MIGRATE|tok"},
+		{"RESUME without token", "RESUME|alice"},
+		{"RESUME with extra field", "RESUME|alice|tok|x"},
+		{"MSG with carriage return", "MSG|bob|hi\r"},
+		{"MODE without rules", "MODE"},
+		{"JOINED with a bad count", "JOINED|bob|many"},
+		{"LEFT with a negative count", "LEFT|bob|-1"},
+		{"OK with an empty count", "OK|format|"},
+		{"OK with an empty new name", "OK|format|2|"},
+		{"ATTACH without ID", "ATTACH"},
+		{"ATTACH empty ID", "ATTACH||cat"},
+		{"ATTACHED without URL", "ATTACHED|bob"},
+		{"ATTACHED empty URL", "ATTACHED|bob||cat"},
 	}
 
 	for _, tt := range tests {
@@ -106,6 +200,97 @@ func TestDecodeInvalid(t *testing.T) {
 	}
 }
 
+func TestDecodeErrors(t *testing.T) {
+	if _, err := Decode("JOIN|a|b"); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("JOIN|a|b: got %v, want ErrInvalidUsername", err)
+	}
+	if m, err := Decode("JOIN|a|b|locale=de"); !errors.Is(err, ErrInvalidUsername) || m.Locale != "de" {
+		t.Errorf("JOIN|a|b|locale=de: got %+v, %v, want locale de and ErrInvalidUsername", m, err)
+	}
+	if _, err := Decode("JOIN|alice|locale=de_DE"); !errors.Is(err, ErrInvalidLocale) {
+		t.Errorf("JOIN with locale de_DE: got %v, want ErrInvalidLocale", err)
+	}
+	if _, err := Decode("SEND|\x00"); !errors.Is(err, ErrInvalidMessage) {
+		t.Errorf("SEND with NUL: got %v, want ErrInvalidMessage", err)
+	}
+	if !ValidText("tabs\tand ünïcode are fine") {
+		t.Error("ValidText rejected tab and non-ASCII text")
+	}
+}
+
+func TestDecodeNormalizesUsernames(t *testing.T) {
+	// "José" with a combining acute accent, as macOS file names and some
+	// keyboards produce it, and with the precomposed é.
+	decomposed, composed := "Jose\u0301", "Jos\u00e9"
+	for _, line := range []string{
+		"JOIN|" + decomposed,
+		"JOIN|" + decomposed + "|locale=es",
+		"RESUME|" + decomposed + "|token",
+		"MSG|" + decomposed + "|hola",
+		"SEEN|" + decomposed,
+		"JOINED|" + decomposed + "|2",
+	} {
+		m, err := Decode(line)
+		if err != nil {
+			t.Errorf("Decode(%q) error = %v", line, err)
+			continue
+		}
+		if m.Username != composed {
+			t.Errorf("Decode(%q).Username = %q, want %q", line, m.Username, composed)
+		}
+	}
+
+	// U+0958 is decomposed by NFC, doubling its length in UTF-8.
+	long := strings.Repeat("\u0958", MaxUsernameLength/3)
+	if _, err := Decode("JOIN|" + long); !errors.Is(err, ErrInvalidUsername) {
+		t.Errorf("JOIN with a name too long once normalized: got %v, want ErrInvalidUsername", err)
+	}
+	if got := NormalizeUsername(decomposed); got != composed {
+		t.Errorf("NormalizeUsername(%q) = %q, want %q", decomposed, got, composed)
+	}
+}
+
+func TestDecodeErrorKinds(t *testing.T) {
+	for _, tt := range []struct {
+		input string
+		want  error
+	}{
+		{"FROB|x", ErrUnknownType},
+		{"JOIN|", ErrMissingUsername},
+		{"JOIN||locale=de", ErrMissingUsername},
+		{"MSG||hello", ErrMissingUsername},
+		{"JOINED|", ErrMissingUsername},
+		{"SEND|", ErrEmptyBody},
+		{"SENDID|1|", ErrEmptyBody},
+		{"MSG|bob", ErrEmptyBody},
+		{"NOTICE", ErrEmptyBody},
+		{"USERSTATUS|a" + strings.Repeat("a", MaxUsernameLength), ErrInvalidUsername},
+	} {
+		_, err := Decode(tt.input)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("Decode(%q) error = %v, want %v", tt.input, err, tt.want)
+		}
+		if !errors.Is(err, ErrInvalidMessage) {
+			t.Errorf("Decode(%q) error = %v, does not wrap ErrInvalidMessage", tt.input, err)
+		}
+	}
+}
+
+func TestScanLines(t *testing.T) {
+	fits := strings.Repeat("x", MaxLineLength-1)
+	scanner := bufio.NewScanner(strings.NewReader("SEND|hi\r\n" + fits + "\n" + fits + "x\nSEND|after\n"))
+	scanner.Buffer(make([]byte, MaxLineLength), MaxLineLength)
+	scanner.Split(ScanLines)
+	for _, want := range []string{"SEND|hi", fits} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("Scan() = %q (%v), want %d bytes", scanner.Text(), scanner.Err(), len(want))
+		}
+	}
+	if scanner.Scan() || !errors.Is(scanner.Err(), ErrLineTooLong) {
+		t.Errorf("Scan() of an overlong line = %q, %v; want ErrLineTooLong", scanner.Text(), scanner.Err())
+	}
+}
+
 func TestDecodeMessageBodyWithPipes(t *testing.T) {
 	input := "MSG|alice|hello|world|foo"
 	got, err := Decode(input)
@@ -143,3 +328,155 @@ func TestEncodeUnknownType(t *testing.T) {
 		t.Errorf("Encode(unknown) = %q, want empty string", encoded)
 	}
 }
+
+func TestStatsRoundTrip(t *testing.T) {
+	want := Stats{
+		Uptime:   90 * time.Second,
+		Users:    3,
+		Rooms:    1,
+		Messages: 42,
+		Rate:     0.47,
+	}
+	got, err := ParseStats(EncodeStats(want))
+	if err != nil {
+		t.Fatalf("ParseStats() error = %v", err)
+	}
+	if got != want {
+		t.Errorf("ParseStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseStatsInvalid(t *testing.T) {
+	for _, body := range []string{"", "users", "users=abc", "uptime=1|rate=x"} {
+		if _, err := ParseStats(body); err == nil {
+			t.Errorf("ParseStats(%q) expected error, got nil", body)
+		}
+	}
+}
+
+func TestParseStatsIgnoresUnknownKeys(t *testing.T) {
+	got, err := ParseStats("users=5|future=1")
+	if err != nil {
+		t.Fatalf("ParseStats() error = %v", err)
+	}
+	if got.Users != 5 {
+		t.Errorf("Users = %d, want 5", got.Users)
+	}
+}
+
+func TestPresenceRoundTrip(t *testing.T) {
+	for _, p := range []Presence{{}, {Away: true}, {Away: true, Note: "lunch|then gym"}} {
+		got, err := ParsePresence(EncodePresence(p))
+		if err != nil {
+			t.Fatalf("ParsePresence(%q) error = %v", EncodePresence(p), err)
+		}
+		if got != p {
+			t.Errorf("round trip of %+v = %+v", p, got)
+		}
+	}
+	if _, err := ParsePresence("busy"); err == nil {
+		t.Error("ParsePresence(\"busy\") expected error, got nil")
+	}
+}
+
+func TestReceiptRoundTrip(t *testing.T) {
+	for _, r := range []Receipt{{}, {Delivered: []string{"alice", "+bob"}}, {Delivered: []string{"alice"}, Missed: []string{"-carol"}}} {
+		got, err := ParseReceipt(EncodeReceipt(r))
+		if err != nil {
+			t.Fatalf("ParseReceipt(%q) error = %v", EncodeReceipt(r), err)
+		}
+		if !reflect.DeepEqual(got, r) {
+			t.Errorf("round trip of %+v = %+v", r, got)
+		}
+	}
+	for _, body := range []string{"alice", "+", "+alice||-bob"} {
+		if _, err := ParseReceipt(body); err == nil {
+			t.Errorf("ParseReceipt(%q) expected error, got nil", body)
+		}
+	}
+}
+
+func TestCapabilitiesRoundTrip(t *testing.T) {
+	caps := []string{CapFormat, "future"}
+	if got := ParseCapabilities(EncodeCapabilities(caps)); !reflect.DeepEqual(got, caps) {
+		t.Errorf("round trip of %q = %q", caps, got)
+	}
+	if got := ParseCapabilities(""); len(got) != 0 {
+		t.Errorf("ParseCapabilities(\"\") = %q, want none", got)
+	}
+}
+
+func TestRateCapability(t *testing.T) {
+	caps := ParseCapabilities(EncodeCapabilities([]string{CapFormat, RateCapability(0.5, 3)}))
+	if rate, burst, ok := ParseRateCapability(caps); !ok || rate != 0.5 || burst != 3 {
+		t.Errorf("ParseRateCapability(%q) = %v, %v, %v; want 0.5, 3, true", caps, rate, burst, ok)
+	}
+	for _, c := range []string{"format", "rate", "rate=", "rate=2", "rate=x/1", "rate=2/x", "rate=0/1", "rate=-1/1", "rate=2/0", "rate=Inf/1"} {
+		if _, _, ok := ParseRateCapability([]string{c}); ok {
+			t.Errorf("ParseRateCapability([%q]) ok, want false", c)
+		}
+	}
+}
+
+func TestStatusRoundTrip(t *testing.T) {
+	for _, st := range []Status{{}, {Text: "in a meeting"}, {Text: "on call|pager", Expiry: time.Hour}} {
+		got, err := ParseStatus(EncodeStatus(st))
+		if err != nil || got != st {
+			t.Errorf("round trip of %+v = %+v, %v", st, got, err)
+		}
+	}
+	if got := EncodeStatus(Status{Text: "out", Expiry: 1500 * time.Millisecond}); got != "2|out" {
+		t.Errorf("EncodeStatus(1.5s) = %q, want 2|out", got)
+	}
+	for _, body := range []string{"in a meeting", "-5|out", "+5|out", "60|"} {
+		if _, err := ParseStatus(body); err == nil {
+			t.Errorf("ParseStatus(%q) expected error, got nil", body)
+		}
+	}
+}
+
+func TestSeenRoundTrip(t *testing.T) {
+	for _, s := range []Seen{{Online: true}, {At: time.Unix(1700000000, 0)}} {
+		got, err := ParseSeen(EncodeSeen(s))
+		if err != nil || got.Online != s.Online || !got.At.Equal(s.At) {
+			t.Errorf("round trip of %+v = %+v, %v", s, got, err)
+		}
+	}
+	for _, body := range []string{"", "yesterday", "-1", "+1"} {
+		if _, err := ParseSeen(body); err == nil {
+			t.Errorf("ParseSeen(%q) expected error, got nil", body)
+		}
+	}
+}
+
+func TestRoomModeRoundTrip(t *testing.T) {
+	for _, m := range []RoomMode{{}, {Announce: true}, {Slow: 30 * time.Second}, {Announce: true, Slow: time.Minute}} {
+		got, err := ParseRoomMode(EncodeRoomMode(m))
+		if err != nil || got != m {
+			t.Errorf("round trip of %+v = %+v, %v", m, got, err)
+		}
+	}
+	if got := EncodeRoomMode(RoomMode{}); got != "open" {
+		t.Errorf("EncodeRoomMode(open) = %q, want open", got)
+	}
+	if got := EncodeRoomMode(RoomMode{Slow: 1500 * time.Millisecond}); got != "slow=2" {
+		t.Errorf("EncodeRoomMode(1.5s) = %q, want slow=2", got)
+	}
+	if got, err := ParseRoomMode("slow=5|pinned"); err != nil || got.Slow != 5*time.Second {
+		t.Errorf("ParseRoomMode with an unknown field = %+v, %v", got, err)
+	}
+	if _, err := ParseRoomMode("slow=soon"); err == nil {
+		t.Error("ParseRoomMode(\"slow=soon\") expected error, got nil")
+	}
+}
+
+func TestValidLocale(t *testing.T) {
+	for tag, want := range map[string]bool{
+		"de": true, "pt-BR": true, "zh-Hant-TW": true, "es-419": true,
+		"": false, "de_DE": false, "english": false, "1a": false, "de-": false, "de|fr": false,
+	} {
+		if got := ValidLocale(tag); got != want {
+			t.Errorf("ValidLocale(%q) = %v, want %v", tag, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package ratelimit provides the rate limiters the chat server uses for
+// connections, joins and messages, for bridges and bots to pace
+// themselves the same way.
+//
+// A Bucket allows bursts and then a steady rate; a Window allows a fixed
+// number of events in any period of a given length. Keyed keeps one
+// limiter per key, such as a client address, and forgets idle ones. All
+// of them are safe for concurrent use.
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter is implemented by Bucket and Window.
+type Limiter interface {
+	// AllowAt reports whether an event at now is within the limit, and
+	// counts it if so.
+	AllowAt(now time.Time) bool
+	// Idle reports whether the limiter would be back in its initial
+	// state at now, so that forgetting it changes nothing.
+	Idle(now time.Time) bool
+}
+
+// Bucket is a token bucket: it holds up to burst tokens, refilled at rate
+// tokens per second, and each event takes one.
+type Bucket struct {
+	rate  float64
+	burst float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns a full bucket allowing rate events per second in
+// bursts of up to burst. A burst below one is taken as one.
+func NewBucket(rate float64, burst int) *Bucket {
+	b := float64(max(burst, 1))
+	return &Bucket{rate: rate, burst: b, tokens: b}
+}
+
+// Allow is AllowAt(time.Now()).
+func (b *Bucket) Allow() bool {
+	return b.AllowAt(time.Now())
+}
+
+// AllowAt takes a token if one is available at now.
+func (b *Bucket) AllowAt(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Wait blocks until a token is available and takes it, or returns ctx's
+// error if ctx ends first.
+func (b *Bucket) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		now := time.Now()
+		b.refill(now)
+		if b.tokens >= 1 {
+			b.tokens--
+			b.mu.Unlock()
+			return nil
+		}
+		var wait <-chan time.Time
+		if b.rate > 0 {
+			wait = time.After(time.Duration((1 - b.tokens) / b.rate * float64(time.Second)))
+		}
+		b.mu.Unlock()
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Idle reports whether the bucket would be full at now.
+func (b *Bucket) Idle(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// refill adds the tokens earned since the last call. b.mu must be held.
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// Window allows up to limit events in any period of the given length.
+// Unlike a Bucket it never allows more than limit events in a period, at
+// the cost of remembering the time of each.
+type Window struct {
+	limit  int
+	period time.Duration
+
+	mu     sync.Mutex
+	events []time.Time // oldest first
+}
+
+// NewWindow returns a Window allowing limit events per period.
+func NewWindow(limit int, period time.Duration) *Window {
+	return &Window{limit: limit, period: period}
+}
+
+// Allow is AllowAt(time.Now()).
+func (w *Window) Allow() bool {
+	return w.AllowAt(time.Now())
+}
+
+// AllowAt counts an event at now if fewer than limit happened in the
+// period before it.
+func (w *Window) AllowAt(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire(now)
+	if len(w.events) >= w.limit {
+		return false
+	}
+	w.events = append(w.events, now)
+	return true
+}
+
+// Idle reports whether no events fall in the period before now.
+func (w *Window) Idle(now time.Time) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.expire(now)
+	return len(w.events) == 0
+}
+
+// expire forgets events older than the period. w.mu must be held.
+func (w *Window) expire(now time.Time) {
+	cutoff := now.Add(-w.period)
+	i := 0
+	for i < len(w.events) && !w.events[i].After(cutoff) {
+		i++
+	}
+	w.events = w.events[i:]
+}
+
+// sweepInterval is how often Keyed forgets idle limiters.
+const sweepInterval = time.Minute
+
+// Keyed keeps a limiter per key, created on first use. Limiters that have
+// gone idle are forgotten from time to time so memory stays bounded by
+// the number of recently active keys.
+type Keyed[L Limiter] struct {
+	newLimiter func() L
+
+	mu        sync.Mutex
+	limiters  map[string]L
+	lastSweep time.Time
+}
+
+// NewKeyed returns a Keyed that makes limiters with newLimiter, e.g.
+//
+//	perIP := ratelimit.NewKeyed(func() *ratelimit.Bucket { return ratelimit.NewBucket(1, 5) })
+func NewKeyed[L Limiter](newLimiter func() L) *Keyed[L] {
+	return &Keyed[L]{newLimiter: newLimiter, limiters: make(map[string]L)}
+}
+
+// Allow is AllowAt(key, time.Now()).
+func (k *Keyed[L]) Allow(key string) bool {
+	return k.AllowAt(key, time.Now())
+}
+
+// AllowAt reports whether an event for key at now is within the limit,
+// and counts it if so.
+func (k *Keyed[L]) AllowAt(key string, now time.Time) bool {
+	k.mu.Lock()
+	if now.Sub(k.lastSweep) >= sweepInterval {
+		k.lastSweep = now
+		for key, l := range k.limiters {
+			if l.Idle(now) {
+				delete(k.limiters, key)
+			}
+		}
+	}
+	l, ok := k.limiters[key]
+	if !ok {
+		l = k.newLimiter()
+		k.limiters[key] = l
+	}
+	k.mu.Unlock()
+	return l.AllowAt(now)
+}
+
+// Len returns how many keys have a limiter.
+func (k *Keyed[L]) Len() int {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return len(k.limiters)
+}
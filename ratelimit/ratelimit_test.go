@@ -0,0 +1,105 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestBucket(t *testing.T) {
+	b := NewBucket(2, 3)
+	now := time.Now()
+	for i, want := range []bool{true, true, true, false} {
+		if got := b.AllowAt(now); got != want {
+			t.Errorf("event %d: AllowAt = %v, want %v", i+1, got, want)
+		}
+	}
+	if b.Idle(now) {
+		t.Error("empty bucket reported idle")
+	}
+
+	// Half a second refills one token at two per second.
+	now = now.Add(500 * time.Millisecond)
+	if !b.AllowAt(now) {
+		t.Error("refilled token refused")
+	}
+	if b.AllowAt(now) {
+		t.Error("allowed more than was refilled")
+	}
+
+	// Refills stop at the burst.
+	now = now.Add(time.Hour)
+	if !b.Idle(now) {
+		t.Error("bucket not idle after an hour")
+	}
+	for range 3 {
+		b.AllowAt(now)
+	}
+	if b.AllowAt(now) {
+		t.Error("allowed more than the burst after a long pause")
+	}
+}
+
+func TestBucketWait(t *testing.T) {
+	b := NewBucket(100, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	for range 3 {
+		if err := b.Wait(ctx); err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("three events at 100/s with burst 1 took %s", elapsed)
+	}
+
+	never := NewBucket(0, 1)
+	never.Allow()
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := never.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Wait() on an empty bucket that never refills = %v, want deadline exceeded", err)
+	}
+}
+
+func TestWindow(t *testing.T) {
+	w := NewWindow(2, time.Minute)
+	start := time.Now()
+	if !w.AllowAt(start) || !w.AllowAt(start.Add(30*time.Second)) {
+		t.Fatal("events within the limit refused")
+	}
+	if w.AllowAt(start.Add(59 * time.Second)) {
+		t.Error("third event in a minute allowed")
+	}
+	// The first event leaves the window, making room for one more.
+	if !w.AllowAt(start.Add(61 * time.Second)) {
+		t.Error("event refused after the first left the window")
+	}
+	if w.AllowAt(start.Add(62 * time.Second)) {
+		t.Error("window allowed more than its limit")
+	}
+	if w.Idle(start.Add(90*time.Second)) || !w.Idle(start.Add(3*time.Minute)) {
+		t.Error("Idle doesn't track the window")
+	}
+}
+
+func TestKeyed(t *testing.T) {
+	k := NewKeyed(func() *Window { return NewWindow(1, time.Second) })
+	now := time.Now()
+	if !k.AllowAt("a", now) || !k.AllowAt("b", now) {
+		t.Fatal("first event per key refused")
+	}
+	if k.AllowAt("a", now) {
+		t.Error("second event for a allowed")
+	}
+	if got := k.Len(); got != 2 {
+		t.Errorf("Len() = %d, want 2", got)
+	}
+
+	// Idle limiters are swept on a later call.
+	k.AllowAt("c", now.Add(sweepInterval))
+	if got := k.Len(); got != 1 {
+		t.Errorf("Len() after sweep = %d, want 1", got)
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/pankaj/simple-chat/ratelimit"
+)
+
+// AcceptLimit sheds connection storms in the accept loop, before any
+// goroutine or buffer is spent on the connection. Connections over the
+// limit are closed straight away. Zero rates are unlimited.
+//
+// Behind a proxy using the PROXY protocol every connection comes from the
+// proxy's address, so PerIPRate should be left at zero there.
+type AcceptLimit struct {
+	// Rate and Burst limit connections accepted overall, in connections
+	// per second with bursts of up to Burst.
+	Rate  float64
+	Burst int
+
+	// PerIPRate and PerIPBurst limit connections from each address.
+	PerIPRate  float64
+	PerIPBurst int
+}
+
+// AcceptStats counts connections refused by the AcceptLimit.
+type AcceptStats struct {
+	Global uint64 `json:"global"` // over the overall rate
+	PerIP  uint64 `json:"per_ip"` // over the per-address rate
+}
+
+// acceptLimiter applies an AcceptLimit. Either limiter is nil when its
+// rate is zero.
+type acceptLimiter struct {
+	global *ratelimit.Bucket
+	perIP  *ratelimit.Keyed[*ratelimit.Bucket]
+
+	shedGlobal, shedPerIP atomic.Uint64
+}
+
+func newAcceptLimiter(l AcceptLimit) *acceptLimiter {
+	a := &acceptLimiter{}
+	if l.Rate > 0 {
+		a.global = ratelimit.NewBucket(l.Rate, l.Burst)
+	}
+	if l.PerIPRate > 0 {
+		a.perIP = ratelimit.NewKeyed(func() *ratelimit.Bucket {
+			return ratelimit.NewBucket(l.PerIPRate, l.PerIPBurst)
+		})
+	}
+	return a
+}
+
+// allow reports whether a connection from addr may be accepted at now.
+// The per-address limit is checked first so that one busy address doesn't
+// use up the overall allowance.
+func (l *acceptLimiter) allow(addr net.Addr, now time.Time) bool {
+	if l.perIP != nil && !l.perIP.AllowAt(addrKey(addr), now) {
+		l.shedPerIP.Add(1)
+		return false
+	}
+	if l.global != nil && !l.global.AllowAt(now) {
+		l.shedGlobal.Add(1)
+		return false
+	}
+	return true
+}
+
+// addrKey returns the IP address of addr as a string, for limits kept per
+// client address.
+func addrKey(addr net.Addr) string {
+	if ip := addrIP(addr); ip != nil {
+		return ip.String()
+	}
+	return "unknown"
+}
+
+// admit reports whether to go on with a connection just accepted from
+// addr: always, unless an AcceptLimit is set and addr is over it.
+func (s *ChatServer) admit(addr net.Addr) bool {
+	return s.accepts == nil || s.accepts.allow(addr, time.Now())
+}
+
+// AcceptStats returns how many connections the AcceptLimit has refused. It
+// is zero when no AcceptLimit is set.
+func (s *ChatServer) AcceptStats() AcceptStats {
+	if s.accepts == nil {
+		return AcceptStats{}
+	}
+	return AcceptStats{
+		Global: s.accepts.shedGlobal.Load(),
+		PerIP:  s.accepts.shedPerIP.Load(),
+	}
+}
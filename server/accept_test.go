@@ -0,0 +1,69 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestAcceptLimiter(t *testing.T) {
+	l := newAcceptLimiter(AcceptLimit{Rate: 10, Burst: 3, PerIPRate: 1, PerIPBurst: 2})
+	a := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1}
+	b := &net.TCPAddr{IP: net.ParseIP("192.0.2.2"), Port: 1}
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false} {
+		if got := l.allow(a, now); got != want {
+			t.Errorf("connection %d from a: allow = %v, want %v", i+1, got, want)
+		}
+	}
+	// b has its own allowance but only one global token is left.
+	if !l.allow(b, now) {
+		t.Error("first connection from b refused")
+	}
+	if l.allow(b, now) {
+		t.Error("connection over the global burst accepted")
+	}
+	if got, want := (AcceptStats{Global: l.shedGlobal.Load(), PerIP: l.shedPerIP.Load()}), (AcceptStats{Global: 1, PerIP: 1}); got != want {
+		t.Errorf("shed = %+v, want %+v", got, want)
+	}
+
+	// A second later a has one token back.
+	now = now.Add(time.Second)
+	if !l.allow(a, now) {
+		t.Error("connection from a refused after refill")
+	}
+	if l.allow(a, now) {
+		t.Error("a got more than the refilled token")
+	}
+
+}
+
+func TestAcceptLimit(t *testing.T) {
+	srv := New(WithAcceptLimit(AcceptLimit{Rate: 0.001, Burst: 1}))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+
+	conn, err := net.DialTimeout("tcp", srv.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil || isTimeout(err) {
+		t.Fatalf("read from shed connection: %v, want it closed", err)
+	}
+	if got := srv.AcceptStats(); got.Global != 1 {
+		t.Errorf("AcceptStats() = %+v, want one shed globally", got)
+	}
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrNoSuchUser is returned by Kick when the user is not connected.
+var ErrNoSuchUser = errors.New("no such user")
+
+// UserInfo describes a connected user for operators.
+type UserInfo struct {
+	Name     string    `json:"name"`
+	Addr     string    `json:"addr"`
+	JoinedAt time.Time `json:"joined_at"`
+	Away     bool      `json:"away"`
+	Note     string    `json:"note,omitempty"`
+	Status   string    `json:"status,omitempty"`
+
+	ShadowBanned bool `json:"shadow_banned,omitempty"`
+}
+
+// Users returns the connected users in alphabetical order.
+func (s *ChatServer) Users() []UserInfo {
+	s.mu.RLock()
+	users := make([]UserInfo, 0, len(s.clients))
+	for name, c := range s.clients {
+		u := UserInfo{Name: name, Addr: c.conn.RemoteAddr().String(), JoinedAt: c.joined}
+		if note := c.away.Load(); note != nil {
+			u.Away, u.Note = true, *note
+		}
+		u.Status = c.statusText()
+		_, u.ShadowBanned = s.shadowBans[s.nameKey(name)]
+		users = append(users, u)
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(users, func(i, j int) bool { return users[i].Name < users[j].Name })
+	return users
+}
+
+// Kick disconnects a user, telling them the reason in a KICKED notice.
+// Clients don't reconnect after being kicked, but nothing stops the user
+// from joining again; use Ban for that. name matches the user's name as
+// nameKey does, so "Alice" kicks alice.
+func (s *ChatServer) Kick(name, reason string) error {
+	c, ok := s.online(name)
+	if !ok {
+		return ErrNoSuchUser
+	}
+	if reason == "" {
+		reason = "kicked by an operator"
+	}
+	log.Printf("kicking %s: %s", c.username, reason)
+	c.kick(reason)
+	return nil
+}
+
+// online returns the connected client whose name has the same nameKey as
+// name.
+func (s *ChatServer) online(name string) (*ConnectedClient, bool) {
+	key := s.nameKey(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := s.clients[s.names[key]]
+	return c, ok
+}
+
+// Ban stops name from joining and kicks the user if connected. The reason
+// is shown to the user. Like a reservation, a ban also covers the names
+// that differ from name only as nameKey ignores. Bans last until Unban,
+// or a restart unless they are kept with SaveState.
+func (s *ChatServer) Ban(name, reason string) {
+	name = protocol.NormalizeUsername(name)
+	if reason == "" {
+		reason = "banned"
+	}
+	s.mu.Lock()
+	s.bans[s.nameKey(name)] = Ban{Name: name, Reason: reason}
+	s.mu.Unlock()
+
+	// The user may not be connected; that's fine.
+	s.Kick(name, reason)
+}
+
+// Unban lifts a ban. It reports whether name was banned.
+func (s *ChatServer) Unban(name string) bool {
+	key := s.nameKey(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.bans[key]
+	delete(s.bans, key)
+	return ok
+}
+
+// Bans returns the banned usernames mapped to the ban reasons.
+func (s *ChatServer) Bans() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	bans := make(map[string]string, len(s.bans))
+	for _, ban := range s.bans {
+		bans[ban.Name] = ban.Reason
+	}
+	return bans
+}
+
+// banned returns the reason name, or a name with the same nameKey, is
+// banned, or false if it isn't.
+func (s *ChatServer) banned(name string) (string, bool) {
+	key := s.nameKey(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ban, ok := s.bans[key]
+	return ban.Reason, ok
+}
+
+// ShadowBan stops name's chat messages from reaching anyone else, without
+// telling them: the server keeps acknowledging their messages, so their
+// client shows them as sent. It's meant for persistent trolls, who tend
+// to come back under a new name when banned outright. Shadow bans last
+// until Unshadow or a restart.
+func (s *ChatServer) ShadowBan(name string) {
+	name = protocol.NormalizeUsername(name)
+	s.mu.Lock()
+	s.shadowBans[s.nameKey(name)] = name
+	s.mu.Unlock()
+	log.Printf("shadow-banned %s", name)
+}
+
+// Unshadow lifts a shadow ban. It reports whether name was shadow-banned.
+func (s *ChatServer) Unshadow(name string) bool {
+	key := s.nameKey(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.shadowBans[key]
+	delete(s.shadowBans, key)
+	return ok
+}
+
+// ShadowBans returns the shadow-banned usernames in alphabetical order.
+func (s *ChatServer) ShadowBans() []string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.shadowBans))
+	for _, name := range s.shadowBans {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// shadowBanned reports whether name, or a name with the same nameKey, is
+// shadow-banned.
+func (s *ChatServer) shadowBanned(name string) bool {
+	key := s.nameKey(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.shadowBans[key]
+	return ok
+}
+
+// MOTD returns the message of the day, or "" if none is set.
+func (s *ChatServer) MOTD() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.motd
+}
+
+// SetMOTD sets the message of the day, which users receive as a NOTICE
+// when they join. An empty text clears it. Connected users aren't told;
+// use Announce for that.
+func (s *ChatServer) SetMOTD(text string) {
+	s.mu.Lock()
+	s.motd = text
+	s.mu.Unlock()
+}
+
+// Announce sends text to every connected user as a NOTICE.
+func (s *ChatServer) Announce(text string) {
+	s.broadcast("", protocol.Encode(protocol.Message{Type: protocol.TypeNotice, Body: text}))
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestKick(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	if err := srv.Kick("nobody", ""); err != ErrNoSuchUser {
+		t.Errorf("Kick(nobody) = %v, want ErrNoSuchUser", err)
+	}
+	if err := srv.Kick("bob", "be nice"); err != nil {
+		t.Fatalf("Kick: %v", err)
+	}
+
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(bob)
+	if !scanner.Scan() || scanner.Text() != "KICKED|be nice" {
+		t.Fatalf("bob got %q (%v), want KICKED|be nice", scanner.Text(), scanner.Err())
+	}
+	if scanner.Scan() {
+		t.Errorf("bob got %q after KICKED, want the connection closed", scanner.Text())
+	}
+	if line := readLine(t, alice, 2*time.Second); line != "LEFT|bob|1" {
+		t.Errorf("alice got %q, want LEFT|bob|1", line)
+	}
+}
+
+func TestBan(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	srv.Ban("mallory", "spamming")
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "mallory"}))
+	if line := readLine(t, conn, 2*time.Second); line != "ERR|spamming" {
+		t.Errorf("got %q, want ERR|spamming", line)
+	}
+
+	if !srv.Unban("mallory") {
+		t.Error("Unban(mallory) = false, want true")
+	}
+	if srv.Unban("mallory") {
+		t.Error("second Unban(mallory) = true, want false")
+	}
+	connectClient(t, addr, "mallory").Close()
+}
+
+func TestMOTDAndAnnounce(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	srv.SetMOTD("welcome")
+
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "alice"}))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	for _, want := range []string{"OK|format commands|1", "NOTICE|welcome"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+
+	srv.Announce("restart at noon")
+	if !scanner.Scan() || scanner.Text() != "NOTICE|restart at noon" {
+		t.Errorf("got %q (%v), want NOTICE|restart at noon", scanner.Text(), scanner.Err())
+	}
+}
+
+func TestAdminHandler(t *testing.T) {
+	srv := startServer(t)
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+
+	api := httptest.NewServer(srv.AdminHandler())
+	defer api.Close()
+
+	do := func(method, path, body string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(method, api.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if method != "GET" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	var users []UserInfo
+	json.NewDecoder(do("GET", "/users", "").Body).Decode(&users)
+	if len(users) != 1 || users[0].Name != "alice" || users[0].JoinedAt.IsZero() {
+		t.Errorf("GET /users = %+v, want alice", users)
+	}
+
+	var st AdminStats
+	json.NewDecoder(do("GET", "/stats", "").Body).Decode(&st)
+	if st.Users != 1 {
+		t.Errorf("GET /stats users = %d, want 1", st.Users)
+	}
+
+	tests := []struct {
+		method, path, body string
+		status             int
+	}{
+		{"PUT", "/motd", `{"text":"hello"}`, http.StatusNoContent},
+		{"POST", "/announce", `{"text":"hi all"}`, http.StatusNoContent},
+		{"POST", "/announce", `{}`, http.StatusBadRequest},
+		{"POST", "/announce", `{`, http.StatusBadRequest},
+		{"PUT", "/bans/mallory", `{"reason":"spam"}`, http.StatusNoContent},
+		{"DELETE", "/bans/nobody", "", http.StatusNotFound},
+		{"PUT", "/shadowbans/troll", "", http.StatusNoContent},
+		{"DELETE", "/shadowbans/nobody", "", http.StatusNotFound},
+		{"PUT", "/reserved/carol", "", http.StatusNoContent},
+		{"DELETE", "/reserved/nobody", "", http.StatusNotFound},
+		{"POST", "/users/nobody/kick", "", http.StatusNotFound},
+		{"DELETE", "/reports/1", "", http.StatusNotFound},
+		{"DELETE", "/reports/first", "", http.StatusBadRequest},
+		{"POST", "/reports/1/ban", "", http.StatusNotFound},
+		{"GET", "/nothing", "", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if resp := do(tt.method, tt.path, tt.body); resp.StatusCode != tt.status {
+			t.Errorf("%s %s: status %d, want %d", tt.method, tt.path, resp.StatusCode, tt.status)
+		}
+	}
+
+	// Requests a web page could make a browser send are refused.
+	req, _ := http.NewRequest("POST", api.URL+"/announce", strings.NewReader(`{"text":"pwned"}`))
+	req.Header.Set("Content-Type", "text/plain")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("POST /announce as text/plain: %v, %v; want 415", resp.Status, err)
+	}
+	port := strconv.Itoa(api.Listener.Addr().(*net.TCPAddr).Port)
+	for host, want := range map[string]int{
+		"attacker.example:" + port: http.StatusForbidden,
+		"localhost:" + port:        http.StatusOK,
+	} {
+		req, _ := http.NewRequest("GET", api.URL+"/users", nil)
+		req.Host = host
+		if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != want {
+			t.Errorf("GET /users with Host %s: %v, %v; want %d", host, resp.Status, err, want)
+		}
+	}
+
+	if srv.MOTD() != "hello" {
+		t.Errorf("MOTD() = %q, want hello", srv.MOTD())
+	}
+	if line := readLine(t, alice, 2*time.Second); line != "NOTICE|hi all" {
+		t.Errorf("alice got %q, want NOTICE|hi all", line)
+	}
+	var bans []Ban
+	json.NewDecoder(do("GET", "/bans", "").Body).Decode(&bans)
+	if len(bans) != 1 || bans[0] != (Ban{Name: "mallory", Reason: "spam"}) {
+		t.Errorf("GET /bans = %+v, want mallory", bans)
+	}
+	var shadowBans []string
+	json.NewDecoder(do("GET", "/shadowbans", "").Body).Decode(&shadowBans)
+	if len(shadowBans) != 1 || shadowBans[0] != "troll" {
+		t.Errorf("GET /shadowbans = %v, want [troll]", shadowBans)
+	}
+	var reserved []string
+	json.NewDecoder(do("GET", "/reserved", "").Body).Decode(&reserved)
+	if len(reserved) != 1 || reserved[0] != "carol" {
+		t.Errorf("GET /reserved = %v, want [carol]", reserved)
+	}
+}
@@ -0,0 +1,348 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Ban is one entry in the admin API's ban list.
+type Ban struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// AdminStats is the admin API's view of Stats.
+type AdminStats struct {
+	Users    int     `json:"users"`
+	Rooms    int     `json:"rooms"`
+	Messages uint64  `json:"messages"`
+	Uptime   float64 `json:"uptime_seconds"`
+	Rate     float64 `json:"rate"`
+
+	// GeoDenied counts connections refused by the GeoPolicy, by country.
+	GeoDenied map[string]uint64 `json:"geo_denied,omitempty"`
+	// AcceptsShed counts connections refused by the AcceptLimit.
+	AcceptsShed AcceptStats `json:"accepts_shed"`
+	// JoinThrottle counts failed JOINs and what the JoinThrottle did.
+	JoinThrottle JoinThrottleStats `json:"join_throttle"`
+}
+
+// AdminMode is the admin API's view of the room's RoomMode.
+type AdminMode struct {
+	Announce    bool `json:"announce"`
+	SlowSeconds int  `json:"slow_seconds"`
+}
+
+// adminRequest is the body accepted by the admin API's write endpoints.
+type adminRequest struct {
+	Reason string `json:"reason"`
+	Text   string `json:"text"`
+	Addr   string `json:"addr"`
+}
+
+// AdminHandler returns an HTTP handler exposing a JSON API for operators:
+//
+//	GET    /users                connected users
+//	POST   /users/{name}/kick    kick a user; body {"reason": "..."}
+//	POST   /users/{name}/migrate move a user to another server; body {"addr": "..."}
+//	GET    /bans                 banned usernames
+//	PUT    /bans/{name}          ban a user; body {"reason": "..."}
+//	DELETE /bans/{name}          lift a ban
+//	GET    /shadowbans           shadow-banned usernames
+//	PUT    /shadowbans/{name}    shadow-ban a user
+//	DELETE /shadowbans/{name}    lift a shadow ban
+//	GET    /reserved             reserved usernames
+//	PUT    /reserved/{name}      reserve a username for its registered user
+//	DELETE /reserved/{name}      release a reserved username
+//	GET    /motd                 message of the day
+//	PUT    /motd                 set it; body {"text": "..."}
+//	POST   /announce             notify everyone; body {"text": "..."}
+//	GET    /mode                 the room's posting rules
+//	PUT    /mode                 change them; body {"announce": true, "slow_seconds": 30}
+//	GET    /stats                server statistics
+//	GET    /reports              messages users have reported
+//	DELETE /reports/{id}         dismiss a report
+//	POST   /reports/{id}/ban     ban the reported author; body {"reason": "..."}
+//	POST   /state                save a snapshot to the state file
+//
+// The API has no authentication of its own. Serve it on a Unix socket or
+// a loopback address that only operators can reach. So that web pages
+// can't use an operator's browser to reach it, requests other than GET
+// must have the Content-Type application/json, and over TCP the Host
+// header must name the address the API is served on.
+func (s *ChatServer) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /users", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Users())
+	})
+	mux.HandleFunc("POST /users/{name}/kick", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		err := s.Kick(r.PathValue("name"), req.Reason)
+		if errors.Is(err, ErrNoSuchUser) {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /users/{name}/migrate", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		err := s.Migrate(r.PathValue("name"), req.Addr)
+		switch {
+		case errors.Is(err, ErrNoSuchUser):
+			writeError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			writeError(w, http.StatusBadRequest, err.Error())
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	mux.HandleFunc("GET /bans", func(w http.ResponseWriter, r *http.Request) {
+		bans := []Ban{}
+		for name, reason := range s.Bans() {
+			bans = append(bans, Ban{Name: name, Reason: reason})
+		}
+		sort.Slice(bans, func(i, j int) bool { return bans[i].Name < bans[j].Name })
+		writeJSON(w, http.StatusOK, bans)
+	})
+	mux.HandleFunc("PUT /bans/{name}", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		s.Ban(r.PathValue("name"), req.Reason)
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /bans/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Unban(r.PathValue("name")) {
+			writeError(w, http.StatusNotFound, "not banned")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /shadowbans", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.ShadowBans())
+	})
+	mux.HandleFunc("PUT /shadowbans/{name}", func(w http.ResponseWriter, r *http.Request) {
+		s.ShadowBan(r.PathValue("name"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /shadowbans/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Unshadow(r.PathValue("name")) {
+			writeError(w, http.StatusNotFound, "not shadow-banned")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /reserved", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Reservations())
+	})
+	mux.HandleFunc("PUT /reserved/{name}", func(w http.ResponseWriter, r *http.Request) {
+		s.Reserve(r.PathValue("name"))
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("DELETE /reserved/{name}", func(w http.ResponseWriter, r *http.Request) {
+		if !s.Unreserve(r.PathValue("name")) {
+			writeError(w, http.StatusNotFound, "not reserved")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /motd", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, adminRequest{Text: s.MOTD()})
+	})
+	mux.HandleFunc("PUT /motd", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		s.SetMOTD(req.Text)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /announce", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := readAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		if req.Text == "" {
+			writeError(w, http.StatusBadRequest, "text is required")
+			return
+		}
+		s.Announce(req.Text)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /mode", func(w http.ResponseWriter, r *http.Request) {
+		m := s.RoomMode()
+		writeJSON(w, http.StatusOK, AdminMode{Announce: m.Announce, SlowSeconds: int(m.Slow / time.Second)})
+	})
+	mux.HandleFunc("PUT /mode", func(w http.ResponseWriter, r *http.Request) {
+		var req AdminMode
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+			return
+		}
+		if req.SlowSeconds < 0 {
+			writeError(w, http.StatusBadRequest, "slow_seconds must not be negative")
+			return
+		}
+		s.SetRoomMode(protocol.RoomMode{Announce: req.Announce, Slow: time.Duration(req.SlowSeconds) * time.Second})
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("POST /state", func(w http.ResponseWriter, r *http.Request) {
+		err := s.SaveState()
+		switch {
+		case errors.Is(err, ErrNoStateFile):
+			writeError(w, http.StatusNotFound, err.Error())
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		st := s.Stats()
+		writeJSON(w, http.StatusOK, AdminStats{
+			Users:    st.Users,
+			Rooms:    st.Rooms,
+			Messages: st.Messages,
+			Uptime:   st.Uptime.Seconds(),
+			Rate:     st.Rate,
+
+			GeoDenied:    s.GeoDenied(),
+			AcceptsShed:  s.AcceptStats(),
+			JoinThrottle: s.JoinThrottleStats(),
+		})
+	})
+
+	mux.HandleFunc("GET /reports", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Reports())
+	})
+	mux.HandleFunc("DELETE /reports/{id}", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := reportID(w, r)
+		if !ok {
+			return
+		}
+		if err := s.DismissReport(id); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("POST /reports/{id}/ban", func(w http.ResponseWriter, r *http.Request) {
+		id, ok := reportID(w, r)
+		if !ok {
+			return
+		}
+		req, ok := readAdminRequest(w, r)
+		if !ok {
+			return
+		}
+		if err := s.BanReportAuthor(id, req.Reason); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	return guardAdmin(mux)
+}
+
+// guardAdmin refuses requests that a web page could make an operator's
+// browser send to next. A cross-site form can't set the Content-Type to
+// application/json without a CORS preflight, which the API never grants,
+// and a page that rebinds its own host name to the API's address still
+// sends that name as the Host.
+func guardAdmin(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminHost(r) {
+			writeError(w, http.StatusForbidden, "unexpected Host "+strconv.Quote(r.Host))
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			if mt, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mt != "application/json" {
+				writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// adminHost reports whether r's Host names the TCP address r arrived on,
+// or "localhost" with its port if that is a loopback address. Browsers
+// can't reach Unix sockets, so requests over one may name any host, as
+// may requests that didn't come over a connection at all.
+func adminHost(r *http.Request) bool {
+	local, ok := r.Context().Value(http.LocalAddrContextKey).(net.Addr)
+	if !ok || local.Network() != "tcp" {
+		return true
+	}
+	if r.Host == local.String() {
+		return true
+	}
+	host, port, err := net.SplitHostPort(r.Host)
+	if err != nil || host != "localhost" {
+		return false
+	}
+	addr, ok := local.(*net.TCPAddr)
+	return ok && addr.IP.IsLoopback() && port == strconv.Itoa(addr.Port)
+}
+
+// reportID parses the {id} path value. On error it writes a 400 response
+// and returns false.
+func reportID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid report ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// readAdminRequest decodes the request body, which may be empty. The
+// Content-Type was checked by guardAdmin. On error
+// it writes a 400 response and returns false.
+func readAdminRequest(w http.ResponseWriter, r *http.Request) (adminRequest, bool) {
+	var req adminRequest
+	if r.ContentLength == 0 {
+		return req, true
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return req, false
+	}
+	return req, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes {"error": msg}, the admin API's error format.
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
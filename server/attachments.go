@@ -0,0 +1,365 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// AttachmentPolicy configures file sharing. Files are uploaded over HTTP
+// to AttachmentHandler, and the ID it returns is shared with an ATTACH
+// message, so files never pass through chat connections.
+type AttachmentPolicy struct {
+	// Dir is the directory uploads are stored in. It is created if
+	// missing.
+	Dir string
+	// MaxSize bounds an upload in bytes; zero means
+	// DefaultMaxAttachmentSize.
+	MaxSize int64
+	// MaxTotal bounds the size of all uploads kept in Dir together, in
+	// bytes; zero means DefaultMaxAttachmentTotal. Uploads are refused
+	// while it is reached.
+	MaxTotal int64
+	// MaxAge is how long uploads are kept before they are deleted, which
+	// makes room for new ones. Zero keeps them until an operator deletes
+	// them.
+	MaxAge time.Duration
+	// BaseURL is where AttachmentHandler is reachable, such as
+	// "https://chat.example.com". ATTACHED messages carry
+	// BaseURL + "/attachments/" + ID; with no BaseURL the URL is relative.
+	BaseURL string
+}
+
+// DefaultMaxAttachmentSize is the upload limit when AttachmentPolicy
+// leaves MaxSize zero.
+const DefaultMaxAttachmentSize = 10 << 20
+
+// DefaultMaxAttachmentTotal is the limit on all uploads together when
+// AttachmentPolicy leaves MaxTotal zero.
+const DefaultMaxAttachmentTotal = 1 << 30
+
+var (
+	// ErrAttachmentsDisabled is reported to clients that send an ATTACH
+	// to a server without an AttachmentPolicy.
+	ErrAttachmentsDisabled = errors.New("attachments are disabled")
+
+	// ErrNoSuchAttachment is reported for an ATTACH naming an ID that
+	// wasn't uploaded, or has expired.
+	ErrNoSuchAttachment = errors.New("no such attachment")
+
+	// errAttachmentsFull is returned by save when an upload would take
+	// the attachments over MaxTotal.
+	errAttachmentsFull = errors.New("no room for more attachments; try again later")
+)
+
+// attachmentIDPattern matches the IDs newAttachmentID makes, which keeps
+// IDs from the network from naming other files.
+var attachmentIDPattern = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// inlineTypes are the content types served for display in the browser.
+// Anything else is served as a download, so an uploaded HTML page or SVG
+// can't run scripts on the chat's origin.
+var inlineTypes = map[string]bool{
+	"image/png":  true,
+	"image/jpeg": true,
+	"image/gif":  true,
+	"image/webp": true,
+	"text/plain": true,
+}
+
+// attachmentMeta is stored next to each upload as <id>.json.
+type attachmentMeta struct {
+	Name string `json:"name,omitempty"`
+	Type string `json:"type"`
+}
+
+// attachmentStore keeps uploads as files named by their ID.
+type attachmentStore struct {
+	policy AttachmentPolicy
+
+	mu        sync.Mutex
+	used      int64 // bytes of the files in Dir; -1 until sweep counts them
+	reserved  int64 // bytes set aside for uploads in progress
+	lastSweep time.Time
+}
+
+func newAttachmentStore(p AttachmentPolicy) *attachmentStore {
+	if p.MaxSize <= 0 {
+		p.MaxSize = DefaultMaxAttachmentSize
+	}
+	if p.MaxTotal <= 0 {
+		p.MaxTotal = DefaultMaxAttachmentTotal
+	}
+	p.BaseURL = strings.TrimSuffix(p.BaseURL, "/")
+	return &attachmentStore{policy: p, used: -1}
+}
+
+// sweepInterval is how often expired uploads are looked for.
+const sweepInterval = time.Minute
+
+// expired reports whether an upload written at mod is past MaxAge.
+func (a *attachmentStore) expired(mod time.Time) bool {
+	return a.policy.MaxAge > 0 && time.Since(mod) > a.policy.MaxAge
+}
+
+// reserve sets aside room for an upload and returns how many bytes it
+// may take: MaxSize, or less when the attachments are near MaxTotal. The
+// caller gives the room back with done.
+func (a *attachmentStore) reserve() (int64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.used < 0 || (a.policy.MaxAge > 0 && time.Since(a.lastSweep) > sweepInterval) {
+		if err := a.sweep(); err != nil {
+			return 0, err
+		}
+	}
+	room := min(a.policy.MaxSize, a.policy.MaxTotal-a.used-a.reserved)
+	if room <= 0 {
+		return 0, errAttachmentsFull
+	}
+	a.reserved += room
+	return room, nil
+}
+
+// done gives back room set aside by reserve, and counts the stored bytes
+// of the upload it was for.
+func (a *attachmentStore) done(room, stored int64) {
+	a.mu.Lock()
+	a.reserved -= room
+	a.used += stored
+	a.mu.Unlock()
+}
+
+// sweep deletes expired uploads and counts the bytes of the rest. a.mu
+// must be held.
+func (a *attachmentStore) sweep() error {
+	entries, err := os.ReadDir(a.policy.Dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	expired := make(map[string]bool)
+	for _, e := range entries {
+		if !attachmentIDPattern.MatchString(e.Name()) {
+			continue
+		}
+		if info, err := e.Info(); err == nil && a.expired(info.ModTime()) {
+			path := filepath.Join(a.policy.Dir, e.Name())
+			os.Remove(path)
+			os.Remove(path + ".json")
+			expired[e.Name()] = true
+		}
+	}
+	var used int64
+	for _, e := range entries {
+		name := e.Name()
+		if expired[strings.TrimSuffix(name, ".json")] || strings.HasSuffix(name, ".tmp") {
+			// Uploads in progress are counted in a.reserved.
+			continue
+		}
+		if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			used += info.Size()
+		}
+	}
+	a.used = used
+	a.lastSweep = time.Now()
+	return nil
+}
+
+// url returns where the attachment with id can be downloaded.
+func (a *attachmentStore) url(id string) string {
+	return a.policy.BaseURL + "/attachments/" + id
+}
+
+// exists reports whether id names an upload that hasn't expired.
+func (a *attachmentStore) exists(id string) bool {
+	if !attachmentIDPattern.MatchString(id) {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(a.policy.Dir, id))
+	return err == nil && !a.expired(info.ModTime())
+}
+
+// save stores the contents of r, which must fit in the size limit, and
+// returns the new attachment's ID. It returns errAttachmentsFull if r
+// doesn't fit in what is left of MaxTotal.
+func (a *attachmentStore) save(r io.Reader, meta attachmentMeta) (string, error) {
+	if err := os.MkdirAll(a.policy.Dir, 0o755); err != nil {
+		return "", err
+	}
+	room, err := a.reserve()
+	if err != nil {
+		return "", err
+	}
+	var stored int64
+	defer func() { a.done(room, stored) }()
+	id := newAttachmentID()
+	path := filepath.Join(a.policy.Dir, id)
+
+	// Write the metadata first: exists only looks for the data, which
+	// shows up once it's complete.
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path+".json", encoded, 0o644); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(a.policy.Dir, id+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	n, err := io.Copy(tmp, io.LimitReader(r, room+1))
+	if err == nil && n > room {
+		err = errAttachmentsFull
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(path + ".json")
+		return "", err
+	}
+	stored = n + int64(len(encoded))
+	return id, nil
+}
+
+// open returns the attachment with id and its metadata.
+func (a *attachmentStore) open(id string) (*os.File, attachmentMeta, error) {
+	var meta attachmentMeta
+	if !attachmentIDPattern.MatchString(id) {
+		return nil, meta, ErrNoSuchAttachment
+	}
+	path := filepath.Join(a.policy.Dir, id)
+	encoded, err := os.ReadFile(path + ".json")
+	if err != nil {
+		return nil, meta, ErrNoSuchAttachment
+	}
+	if err := json.Unmarshal(encoded, &meta); err != nil {
+		return nil, meta, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, meta, ErrNoSuchAttachment
+	}
+	if info, err := f.Stat(); err == nil && a.expired(info.ModTime()) {
+		f.Close()
+		return nil, meta, ErrNoSuchAttachment
+	}
+	return f, meta, nil
+}
+
+// checkAttachment returns why an ATTACH naming id is refused, or nil.
+func (s *ChatServer) checkAttachment(id string) error {
+	switch {
+	case s.attachments == nil:
+		return ErrAttachmentsDisabled
+	case !s.attachments.exists(id):
+		return ErrNoSuchAttachment
+	default:
+		return nil
+	}
+}
+
+func newAttachmentID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// attachmentUpload is AttachmentHandler's reply to an upload.
+type attachmentUpload struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// AttachmentHandler returns an HTTP handler for sharing files:
+//
+//	POST /attachments        upload the request body; replies {"id": "...", "url": "..."}
+//	GET  /attachments/{id}   download an attachment
+//
+// Uploads take their type from the Content-Type header and an optional
+// file name from the name query parameter. Share the returned ID with an
+// ATTACH message. The handler returns 404 for everything if the server
+// has no AttachmentPolicy.
+//
+// Anyone who can reach the handler may upload, so serve it where chat
+// users can reach it but strangers can't, or behind a proxy that
+// authenticates them. Once the uploads kept add up to the policy's
+// MaxTotal, further uploads get 507 until old ones expire. IDs are random and hard to guess, but anyone
+// given one can download the file.
+func (s *ChatServer) AttachmentHandler() http.Handler {
+	mux := http.NewServeMux()
+	if s.attachments == nil {
+		return mux
+	}
+	a := s.attachments
+
+	mux.HandleFunc("POST /attachments", func(w http.ResponseWriter, r *http.Request) {
+		meta := attachmentMeta{Name: filepath.Base(r.URL.Query().Get("name")), Type: "application/octet-stream"}
+		if meta.Name == "." || meta.Name == "/" {
+			meta.Name = ""
+		}
+		if t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err == nil {
+			meta.Type = t
+		}
+		id, err := a.save(http.MaxBytesReader(w, r.Body, a.policy.MaxSize), meta)
+		var tooLarge *http.MaxBytesError
+		switch {
+		case errors.As(err, &tooLarge):
+			writeError(w, http.StatusRequestEntityTooLarge, "attachment larger than "+strconv.FormatInt(a.policy.MaxSize, 10)+" bytes")
+			return
+		case errors.Is(err, errAttachmentsFull):
+			writeError(w, http.StatusInsufficientStorage, err.Error())
+			return
+		case err != nil:
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusCreated, attachmentUpload{ID: id, URL: a.url(id)})
+	})
+
+	mux.HandleFunc("GET /attachments/{id}", func(w http.ResponseWriter, r *http.Request) {
+		f, meta, err := a.open(r.PathValue("id"))
+		if errors.Is(err, ErrNoSuchAttachment) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		disposition := "attachment"
+		if inlineTypes[meta.Type] {
+			disposition = "inline"
+		}
+		if meta.Name != "" {
+			disposition = mime.FormatMediaType(disposition, map[string]string{"filename": meta.Name})
+		}
+		w.Header().Set("Content-Type", meta.Type)
+		w.Header().Set("Content-Disposition", disposition)
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		var modified time.Time
+		if info, err := f.Stat(); err == nil {
+			modified = info.ModTime()
+		}
+		http.ServeContent(w, r, "", modified, f)
+	})
+	return mux
+}
@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAttachmentHandler(t *testing.T) {
+	srv := New(WithAttachments(AttachmentPolicy{Dir: t.TempDir(), MaxSize: 16, BaseURL: "https://chat.example/"}))
+	web := httptest.NewServer(srv.AttachmentHandler())
+	defer web.Close()
+
+	resp, err := http.Post(web.URL+"/attachments?name=../notes.txt", "text/plain; charset=utf-8", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var upload attachmentUpload
+	json.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated || upload.URL != "https://chat.example/attachments/"+upload.ID {
+		t.Fatalf("upload = %d %+v", resp.StatusCode, upload)
+	}
+
+	resp, err = http.Get(web.URL + "/attachments/" + upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "hello" || resp.Header.Get("Content-Type") != "text/plain" ||
+		resp.Header.Get("Content-Disposition") != `inline; filename=notes.txt` {
+		t.Errorf("download = %q, %v", body, resp.Header)
+	}
+
+	resp, err = http.Post(web.URL+"/attachments", "text/html", strings.NewReader("<script>"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(resp.Body).Decode(&upload)
+	resp.Body.Close()
+	resp, err = http.Get(web.URL + "/attachments/" + upload.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("Content-Disposition"); got != "attachment" {
+		t.Errorf("HTML served with Content-Disposition %q, want attachment", got)
+	}
+
+	resp, err = http.Post(web.URL+"/attachments", "text/plain", strings.NewReader(strings.Repeat("x", 17)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("oversized upload status = %d, want 413", resp.StatusCode)
+	}
+
+	for _, id := range []string{"0123456789abcdef0123456789abcdef", "..%2fsecret"} {
+		resp, err = http.Get(web.URL + "/attachments/" + id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("GET %s status = %d, want 404", id, resp.StatusCode)
+		}
+	}
+}
+
+func TestAttachmentTotal(t *testing.T) {
+	dir := t.TempDir()
+	srv := New(WithAttachments(AttachmentPolicy{Dir: dir, MaxSize: 16, MaxTotal: 60, MaxAge: time.Hour}))
+	web := httptest.NewServer(srv.AttachmentHandler())
+	defer web.Close()
+
+	// Each upload takes 16 bytes and 21 of metadata, so the second one
+	// takes the total over 60 and the third is refused.
+	upload := func() (int, string) {
+		resp, err := http.Post(web.URL+"/attachments", "text/plain", strings.NewReader(strings.Repeat("x", 16)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var u attachmentUpload
+		json.NewDecoder(resp.Body).Decode(&u)
+		return resp.StatusCode, u.ID
+	}
+	var ids []string
+	for range 2 {
+		status, id := upload()
+		if status != http.StatusCreated {
+			t.Fatalf("upload status = %d, want 201", status)
+		}
+		ids = append(ids, id)
+	}
+	if status, _ := upload(); status != http.StatusInsufficientStorage {
+		t.Fatalf("upload over the total status = %d, want 507", status)
+	}
+
+	// Expired uploads are deleted, making room.
+	old := time.Now().Add(-2 * time.Hour)
+	for _, id := range ids {
+		os.Chtimes(filepath.Join(dir, id), old, old)
+	}
+	srv.attachments.lastSweep = time.Time{}
+	if status, _ := upload(); status != http.StatusCreated {
+		t.Fatalf("upload after expiry status = %d, want 201", status)
+	}
+	if srv.attachments.exists(ids[0]) {
+		t.Error("expired attachment still exists")
+	}
+	if _, err := os.Stat(filepath.Join(dir, ids[0]+".json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expired attachment's metadata: %v", err)
+	}
+}
+
+func TestAttach(t *testing.T) {
+	srv := New(WithAttachments(AttachmentPolicy{Dir: t.TempDir()}))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	id, err := srv.attachments.save(strings.NewReader("GIF89a"), attachmentMeta{Type: "image/gif"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	if line := readLine(t, alice, 2*time.Second); line != "JOINED|bob|2" {
+		t.Fatalf("alice got %q, want JOINED|bob|2", line)
+	}
+
+	fmt.Fprintf(bob, "ATTACH|%s|our cat\n", id)
+	if line := readLine(t, alice, 2*time.Second); line != "ATTACHED|bob|/attachments/"+id+"|our cat" {
+		t.Errorf("alice got %q", line)
+	}
+	fmt.Fprintf(bob, "ATTACH|0123456789abcdef0123456789abcdef\n")
+	if line := readLine(t, bob, 2*time.Second); line != "ERR|no such attachment" {
+		t.Errorf("bob got %q, want ERR|no such attachment", line)
+	}
+}
+
+func TestAttachDisabled(t *testing.T) {
+	srv := startServer(t)
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+
+	fmt.Fprintf(alice, "ATTACH|0123456789abcdef0123456789abcdef\n")
+	if line := readLine(t, alice, 2*time.Second); line != "ERR|attachments are disabled" {
+		t.Errorf("alice got %q, want ERR|attachments are disabled", line)
+	}
+
+	rec := httptest.NewRecorder()
+	srv.AttachmentHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/attachments", strings.NewReader("x")))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("upload without a policy status = %d, want 404", rec.Code)
+	}
+}
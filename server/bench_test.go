@@ -0,0 +1,118 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/pankaj/simple-chat/protocol"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// BenchmarkBroadcast measures fan-out of one MSG to n connected clients.
+// Each client's outbox is drained by its own goroutine, as writeLoop
+// would, but nothing touches the network, so the numbers isolate the
+// cost of the broadcast path itself. The dropped/op metric counts
+// messages lost to full outboxes.
+//
+// Compare runs before and after a change with benchstat:
+//
+//	go test -run XXX -bench Broadcast -count 10 ./server > old.txt
+//	(make the change)
+//	go test -run XXX -bench Broadcast -count 10 ./server > new.txt
+//	benchstat old.txt new.txt
+func BenchmarkBroadcast(b *testing.B) {
+	out := log.Writer()
+	log.SetOutput(io.Discard) // Send logs every drop
+	defer log.SetOutput(out)
+
+	for _, n := range []int{100, 1000, 10000} {
+		b.Run(fmt.Sprintf("clients=%d", n), func(b *testing.B) {
+			srv := New()
+			stop := make(chan struct{})
+			var drained sync.WaitGroup
+			var delivered atomic.Int64
+			for i := range n {
+				c := newConnectedClient(fmt.Sprintf("user%d", i), nil, srv)
+				srv.addClient(c)
+				drained.Add(1)
+				go func() {
+					defer drained.Done()
+					var got int64
+					defer func() { delivered.Add(got + int64(len(c.outbox))) }()
+					for {
+						select {
+						case <-c.outbox:
+							got++
+						case <-stop:
+							return
+						}
+					}
+				}()
+			}
+
+			line := protocol.Encode(protocol.Message{Type: protocol.TypeMsg, Username: "user0", Body: "hello everyone"})
+			b.ReportAllocs()
+			for b.Loop() {
+				srv.broadcast("user0", line)
+			}
+
+			close(stop)
+			drained.Wait()
+			dropped := int64(b.N)*int64(n-1) - delivered.Load()
+			b.ReportMetric(float64(dropped)/float64(b.N), "dropped/op")
+		})
+	}
+}
+
+// discardConn is a net.Conn whose writes go nowhere. If writes is set it
+// counts them.
+type discardConn struct {
+	net.Conn
+	writes *atomic.Int64
+}
+
+func (c discardConn) Write(p []byte) (int, error) {
+	if c.writes != nil {
+		c.writes.Add(1)
+	}
+	return len(p), nil
+}
+
+// BenchmarkWriteLoop measures writeLoop writing queued lines to a
+// connection, without the cost of the network. The writes/op metric is the
+// number of Write calls, or syscalls on a real connection, per line.
+func BenchmarkWriteLoop(b *testing.B) {
+	var writes atomic.Int64
+	c := newConnectedClient("alice", discardConn{writes: &writes}, New())
+	written := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(written)
+	}()
+
+	// As for a broadcast, the line is formatted once and shared.
+	line := newLine(protocol.Encode(protocol.Message{Type: protocol.TypeMsg, Username: "bob", Body: "hello everyone"}))
+	b.ReportAllocs()
+	for b.Loop() {
+		c.outbox <- outgoing{line: line}
+	}
+	close(c.done)
+	<-written
+	b.ReportMetric(float64(writes.Load())/float64(b.N), "writes/op")
+}
+
+// BenchmarkRejectJoin measures a reply written straight to a connection
+// during the handshake.
+func BenchmarkRejectJoin(b *testing.B) {
+	srv := New()
+	span := noop.Span{}
+	b.ReportAllocs()
+	for b.Loop() {
+		srv.rejectJoin(discardConn{}, span, "", "username taken")
+	}
+}
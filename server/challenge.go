@@ -0,0 +1,82 @@
+package server
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// challengeTimeout bounds how long a joining client has to answer each
+// challenge. Questions are answered by people, so it's generous.
+const challengeTimeout = time.Minute
+
+// JoinChallenge configures the checks a client must pass before its JOIN
+// is accepted, to make automated mass joins expensive on open servers.
+// Zero fields are skipped; when both are set the proof of work comes
+// first.
+type JoinChallenge struct {
+	// Work is the proof-of-work difficulty in leading zero bits of a
+	// SHA-256 hash. Each bit doubles the client's cost: 20 takes a
+	// fraction of a second, 24 a few seconds.
+	Work int
+
+	// Question, if set, must be answered with one of Answers. Answers are
+	// compared ignoring case and surrounding space.
+	Question string
+	Answers  []string
+}
+
+// challenge puts a joining client through s.joinChallenge. It returns the
+// reason to reject the client, or "" if it passed.
+func (s *ChatServer) challenge(conn net.Conn, scanner *bufio.Scanner) string {
+	jc := s.joinChallenge
+	if jc.Work > 0 {
+		nonce := make([]byte, 16)
+		rand.Read(nonce)
+		c := protocol.Challenge{Kind: protocol.ChallengeWork, Bits: jc.Work, Nonce: hex.EncodeToString(nonce)}
+		answer, ok := ask(conn, scanner, c)
+		if !ok {
+			return "expected ANSWER message"
+		}
+		if !protocol.CheckWork(c.Nonce, c.Bits, answer) {
+			return "proof of work is wrong"
+		}
+	}
+	if jc.Question != "" {
+		answer, ok := ask(conn, scanner, protocol.Challenge{Kind: protocol.ChallengeQuestion, Question: jc.Question})
+		if !ok {
+			return "expected ANSWER message"
+		}
+		answer = strings.TrimSpace(answer)
+		for _, a := range jc.Answers {
+			if strings.EqualFold(answer, strings.TrimSpace(a)) {
+				return ""
+			}
+		}
+		return "wrong answer"
+	}
+	return ""
+}
+
+// ask sends c and waits for the ANSWER. It reports false if the client
+// sent something else or nothing in time.
+func ask(conn net.Conn, scanner *bufio.Scanner, c protocol.Challenge) (string, bool) {
+	conn.SetReadDeadline(time.Now().Add(challengeTimeout))
+	writeMessage(conn, protocol.Message{
+		Type: protocol.TypeChallenge,
+		Body: protocol.EncodeChallenge(c),
+	})
+	if !scanner.Scan() {
+		return "", false
+	}
+	msg, err := protocol.Decode(scanner.Text())
+	if err != nil || msg.Type != protocol.TypeAnswer {
+		return "", false
+	}
+	return msg.Body, true
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestJoinChallenge(t *testing.T) {
+	srv := New(WithJoinChallenge(JoinChallenge{
+		Work:     8,
+		Question: "What is this server about?",
+		Answers:  []string{"gardening", "plants"},
+	}))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	// join runs the handshake, answering the question with answer and the
+	// proof of work correctly unless badWork is set.
+	join := func(name, answer string, badWork bool) string {
+		t.Helper()
+		conn, err := net.DialTimeout("tcp", srv.Addr().String(), 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		scanner := bufio.NewScanner(conn)
+		fmt.Fprintf(conn, "JOIN|%s\n", name)
+		for scanner.Scan() {
+			msg, err := protocol.Decode(scanner.Text())
+			if err != nil || msg.Type != protocol.TypeChallenge {
+				return scanner.Text()
+			}
+			ch, err := protocol.ParseChallenge(msg.Body)
+			if err != nil {
+				t.Fatalf("bad challenge %q: %v", msg.Body, err)
+			}
+			switch {
+			case ch.Kind == protocol.ChallengeQuestion:
+				fmt.Fprintf(conn, "ANSWER|%s\n", answer)
+			case badWork:
+				wrong := 0
+				for protocol.CheckWork(ch.Nonce, ch.Bits, fmt.Sprint(wrong)) {
+					wrong++
+				}
+				fmt.Fprintf(conn, "ANSWER|%d\n", wrong)
+			default:
+				fmt.Fprintf(conn, "ANSWER|%s\n", protocol.SolveWork(ch.Nonce, ch.Bits))
+			}
+		}
+		return scanner.Err().Error()
+	}
+
+	if got := join("alice", " Plants ", false); got != "OK|format commands|1" {
+		t.Errorf("correct answers: got %q, want OK", got)
+	}
+	if got := join("bob", "cooking", false); got != "ERR|wrong answer" {
+		t.Errorf("wrong answer: got %q", got)
+	}
+	if got := join("carol", "plants", true); got != "ERR|proof of work is wrong" {
+		t.Errorf("bad proof of work: got %q", got)
+	}
+}
@@ -2,89 +2,363 @@ package server
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pankaj/simple-chat/protocol"
+	"github.com/pankaj/simple-chat/ratelimit"
 )
 
 const outboxSize = 256
 
+// flushDelay is how long writeLoop waits for more lines to write along
+// with one just queued.
+const flushDelay = time.Millisecond
+
+// tooFastReason is reported for messages over the WithMessageRate limit.
+const tooFastReason = "sending too fast; slow down"
+
+// outgoing is a line queued for a client, with the receipt to update once
+// it's written, if the sender asked for one. line includes its newline and
+// may be shared with other clients' outboxes; see newLine.
+type outgoing struct {
+	line    []byte
+	receipt *receipt
+}
+
 // ConnectedClient represents a single TCP connection after a successful JOIN.
 type ConnectedClient struct {
-	username string
-	conn     net.Conn
-	server   *ChatServer
-	outbox   chan string
-	done     chan struct{}
+	username  string
+	conn      net.Conn
+	server    *ChatServer
+	outbox    chan outgoing
+	done      chan struct{}
+	spam      *spamDetector     // nil when spam detection is disabled
+	sendLimit *ratelimit.Bucket // nil when messages aren't rate limited
+	joined    time.Time
+	locale    string    // from the JOIN, for translating what the server says
+	lastPost  time.Time // when the last message was broadcast, for slow mode; readLoop only
+
+	// away holds the away note while the user is away and is nil
+	// otherwise.
+	away atomic.Pointer[string]
+
+	// status is the user's status text, cleared by statusTimer if it
+	// was set with an expiry. Both are guarded by statusMu.
+	statusMu    sync.Mutex
+	status      string
+	statusTimer *time.Timer
+
+	dndOn atomic.Bool // checked on every broadcast without taking dnd.mu
+	dnd   dndState
+
+	notify atomic.Int32 // a notifyLevel
 }
 
 func newConnectedClient(username string, conn net.Conn, srv *ChatServer) *ConnectedClient {
-	return &ConnectedClient{
+	c := &ConnectedClient{
 		username: username,
 		conn:     conn,
 		server:   srv,
-		outbox:   make(chan string, outboxSize),
+		outbox:   make(chan outgoing, outboxSize),
 		done:     make(chan struct{}),
+		joined:   time.Now(),
+	}
+	if srv.spamPolicy != nil {
+		c.spam = newSpamDetector(*srv.spamPolicy)
 	}
+	if srv.messageRate > 0 {
+		c.sendLimit = ratelimit.NewBucket(srv.messageRate, srv.messageBurst)
+	}
+	return c
 }
 
 // Send enqueues a message to the client's outbox. Non-blocking: drops
 // the message if the buffer is full (protects against slow clients).
-func (c *ConnectedClient) Send(line string) {
+// It reports whether the message was queued.
+func (c *ConnectedClient) Send(line string) bool {
+	return c.queue(outgoing{line: newLine(line)})
+}
+
+// queue is Send for a line that may carry a receipt, which is told
+// straight away if the line is dropped.
+func (c *ConnectedClient) queue(o outgoing) bool {
 	select {
-	case c.outbox <- line:
+	case c.outbox <- o:
+		return true
 	default:
 		log.Printf("dropping message for slow client %s", c.username)
+		if c.server != nil {
+			c.server.events.publish(MessageDropped{Username: c.username, Line: o.text()})
+		}
+		o.receipt.done(c.username, false)
+		return false
 	}
 }
 
+// sendError queues an ERR message for the client.
+func (c *ConnectedClient) sendError(body string) {
+	c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeErr, Body: c.tr(body)}))
+}
+
+// kick queues a KICKED notice and ends the read loop, which makes
+// handleConnection flush the notice and close the connection.
+func (c *ConnectedClient) kick(reason string) {
+	c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeKicked, Body: c.tr(reason)}))
+	c.conn.SetReadDeadline(time.Now())
+}
+
 // readLoop reads lines from the TCP connection and dispatches them.
 func (c *ConnectedClient) readLoop() {
 	scanner := bufio.NewScanner(c.conn)
-	scanner.Buffer(make([]byte, 4096), 4096)
+	scanner.Buffer(make([]byte, protocol.MaxLineLength), protocol.MaxLineLength)
+	scanner.Split(protocol.ScanLines)
 
 	for scanner.Scan() {
 		msg, err := protocol.Decode(scanner.Text())
-		if err != nil {
+		switch {
+		case errors.Is(err, protocol.ErrUnknownType):
+			c.sendError("unknown message type")
+			continue
+		case errors.Is(err, protocol.ErrEmptyBody):
+			c.sendError("message cannot be empty")
+			continue
+		case err != nil:
+			c.sendError("invalid message")
 			continue
 		}
 
 		switch msg.Type {
-		case protocol.TypeSend:
-			line := protocol.Encode(protocol.Message{
-				Type:     protocol.TypeMsg,
-				Username: c.username,
-				Body:     msg.Body,
-			})
-			c.server.broadcast(c.username, line)
+		case protocol.TypeSend, protocol.TypeSendID, protocol.TypeSendReceipt, protocol.TypeAttach:
+			if msg.Type != protocol.TypeAttach {
+				if name, args, ok := command(msg.Body); ok {
+					c.runCommand(name, args, msg.ID)
+					break
+				}
+				msg.Body = unescapeCommand(msg.Body)
+			}
+			out := protocol.Message{Type: protocol.TypeMsg, Username: c.username, Body: msg.Body}
+			if msg.Type == protocol.TypeAttach {
+				if err := c.server.checkAttachment(msg.Attachment); err != nil {
+					c.sendError(err.Error())
+					break
+				}
+				out.Type, out.Attachment = protocol.TypeAttached, c.server.attachments.url(msg.Attachment)
+			}
+
+			verdict := spamAllow
+			if c.spam != nil {
+				verdict = c.spam.check(msg.Attachment+msg.Body, time.Now())
+			}
+			switch verdict {
+			case spamMuted:
+				log.Printf("muting %s for repeated messages", c.username)
+			case spamDisconnect:
+				log.Printf("disconnecting %s for repeated messages", c.username)
+				// Write directly: the connection closes as soon as we return.
+				writeMessage(c.conn, protocol.Message{
+					Type: protocol.TypeKicked,
+					Body: c.tr("disconnected for repeating the same message"),
+				})
+				return
+			}
+
+			// refused is why the message wasn't broadcast, if it wasn't.
+			var refused string
+			if verdict != spamAllow {
+				refused = mutedReason
+			} else if reason := c.checkMode(time.Now()); reason != "" {
+				refused = reason
+			} else if c.sendLimit != nil && !c.sendLimit.Allow() {
+				refused = tooFastReason
+			} else if c.server.quotas != nil {
+				refused = c.server.quotas.take(c.username, time.Now())
+			}
+
+			if refused == "" {
+				c.lastPost = time.Now()
+				line := protocol.Encode(out)
+				shadowBanned := c.server.shadowBanned(c.username)
+				c.server.events.publish(MessageReceived{
+					Username:     c.username,
+					Body:         msg.Body,
+					Attachment:   out.Attachment,
+					ShadowBanned: shadowBanned,
+				})
+				if msg.Type == protocol.TypeSendReceipt {
+					// Acknowledge first so the ACK arrives before the receipt.
+					c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeAck, ID: msg.ID}))
+				}
+				switch {
+				case shadowBanned && msg.Type == protocol.TypeSendReceipt:
+					c.server.shadowReceipt(c, msg.ID)
+				case shadowBanned:
+					// Nobody else sees it; see ShadowBan.
+				case msg.Type == protocol.TypeSendReceipt:
+					c.server.broadcastWithReceipt(c, msg.ID, line)
+				default:
+					c.server.broadcast(c.username, line)
+				}
+			}
+
+			switch {
+			case msg.Type == protocol.TypeSendID && refused == "":
+				c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeAck, ID: msg.ID}))
+			case msg.ID != "" && refused != "":
+				c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeNack, ID: msg.ID, Body: c.tr(refused)}))
+			case verdict == spamMuted:
+				c.sendError(mutedReason)
+			case verdict == spamAllow && refused != "":
+				c.sendError(refused)
+			}
+
+		case protocol.TypeStats:
+			c.Send(protocol.Encode(protocol.Message{
+				Type: protocol.TypeStats,
+				Body: protocol.EncodeStats(c.server.Stats()),
+			}))
+
+		case protocol.TypeWho:
+			c.Send(protocol.Encode(protocol.Message{
+				Type: protocol.TypeWho,
+				Body: strings.Join(c.server.Usernames(), "|"),
+			}))
+
+		case protocol.TypePing:
+			c.Send(protocol.Encode(protocol.Message{Type: protocol.TypePong, Body: msg.Body}))
+
+		case protocol.TypeAway:
+			note := msg.Body
+			c.away.Store(&note)
+			c.broadcastPresence(protocol.Presence{Away: true, Note: note})
+
+		case protocol.TypeStatus:
+			st, err := protocol.ParseStatus(msg.Body)
+			switch {
+			case err != nil:
+				c.sendError("invalid status")
+			case !validStatus(st.Text):
+				c.sendError(statusTooLongReason)
+			default:
+				c.setStatus(st)
+			}
+
+		case protocol.TypeDND:
+			c.setDND(msg.Body == "on")
+
+		case protocol.TypeSeen:
+			c.Send(protocol.Encode(c.server.seenReply(msg.Username)))
+
+		case protocol.TypeHideSeen:
+			c.server.SetHideSeen(c.username, msg.Body == "on")
+
+		case protocol.TypeNotify:
+			c.setNotify(msg.Body)
+
+		case protocol.TypeReport:
+			if err := c.server.moderation.report(c.username, msg.Username, msg.Body); err != nil {
+				c.sendError(err.Error())
+				break
+			}
+			c.Send(protocol.Encode(protocol.Message{
+				Type: protocol.TypeNotice,
+				Body: c.tr("Thanks, the operators will review the message from " + msg.Username),
+			}))
+
+		case protocol.TypeBack:
+			if c.away.Swap(nil) != nil {
+				c.broadcastPresence(protocol.Presence{})
+			}
 
 		case protocol.TypeLeave:
 			return
 		}
 	}
+	if errors.Is(scanner.Err(), protocol.ErrLineTooLong) {
+		log.Printf("disconnecting %s: line longer than %d bytes", c.username, protocol.MaxLineLength)
+		c.sendError(fmt.Sprintf("line too long: send at most %d bytes", protocol.MaxLineLength))
+	}
+}
+
+// broadcastPresence tells every user, including this one, about a change
+// in this user's presence.
+func (c *ConnectedClient) broadcastPresence(p protocol.Presence) {
+	c.server.broadcast("", protocol.Encode(protocol.Message{
+		Type:     protocol.TypePresence,
+		Username: c.username,
+		Body:     protocol.EncodePresence(p),
+	}))
 }
 
-// writeLoop drains the outbox channel and writes each message to the connection.
+// writeLoop drains the outbox channel and writes each message to the
+// connection. Lines are buffered, and once one is written whatever else is
+// queued within flushDelay goes out in the same write, so a busy chat
+// costs one syscall per batch rather than per line. Receipts are settled
+// once their line has been flushed.
 func (c *ConnectedClient) writeLoop() {
-	for {
-		select {
-		case msg := <-c.outbox:
-			_, err := fmt.Fprintf(c.conn, "%s\n", msg)
-			if err != nil {
+	w := bufio.NewWriter(c.conn)
+	var receipts []*receipt
+	write := func(o outgoing) {
+		w.Write(o.line) // an error sticks and is returned by Flush
+		if o.receipt != nil {
+			receipts = append(receipts, o.receipt)
+		}
+	}
+	flush := func() error {
+		err := w.Flush()
+		for _, r := range receipts {
+			r.done(c.username, err == nil)
+		}
+		clear(receipts)
+		receipts = receipts[:0]
+		return err
+	}
+	// drain writes what's left in the outbox and flushes it.
+	drain := func() {
+		for {
+			select {
+			case o := <-c.outbox:
+				write(o)
+			default:
+				flush()
 				return
 			}
+		}
+	}
+
+	timer := time.NewTimer(flushDelay)
+	timer.Stop()
+	for {
+		select {
+		case o := <-c.outbox:
+			write(o)
 		case <-c.done:
-			// Drain remaining messages
-			for {
-				select {
-				case msg := <-c.outbox:
-					fmt.Fprintf(c.conn, "%s\n", msg)
-				default:
-					return
-				}
+			drain()
+			return
+		}
+
+		timer.Reset(flushDelay)
+	batch:
+		for {
+			select {
+			case o := <-c.outbox:
+				write(o)
+			case <-timer.C:
+				break batch
+			case <-c.done:
+				timer.Stop()
+				drain()
+				return
 			}
 		}
+		if flush() != nil {
+			return
+		}
 	}
 }
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrUnknownCommand is returned for a slash command no handler is
+// registered for.
+var ErrUnknownCommand = errors.New("unknown command")
+
+// CommandFunc handles a slash command sent as a chat message. user is who
+// sent it and args the rest of the line after the command name, trimmed.
+// The reply, if not empty, is sent only to user as a NOTICE; an error is
+// sent only to them as an ERR. Handlers run on the sender's read loop, so
+// they should return promptly.
+type CommandFunc func(user, args string) (string, error)
+
+// builtinCommands are registered on every server; WithCommand can
+// replace them.
+func (s *ChatServer) builtinCommands() map[string]CommandFunc {
+	return map[string]CommandFunc{
+		"who": func(user, args string) (string, error) {
+			names := s.Usernames()
+			return fmt.Sprintf("Online (%d): %s", len(names), strings.Join(names, ", ")), nil
+		},
+	}
+}
+
+// command splits a chat message body into a slash command's name, in
+// lower case, and its arguments. It reports false for ordinary messages,
+// including those escaped with a leading "//".
+func command(body string) (name, args string, ok bool) {
+	if !strings.HasPrefix(body, "/") || strings.HasPrefix(body, "//") || len(body) == 1 {
+		return "", "", false
+	}
+	name, args, _ = strings.Cut(body[1:], " ")
+	return strings.ToLower(name), strings.TrimSpace(args), true
+}
+
+// unescapeCommand turns a message escaped with a leading "//" back into
+// the text the user meant, which starts with a single "/".
+func unescapeCommand(body string) string {
+	if strings.HasPrefix(body, "//") {
+		return body[1:]
+	}
+	return body
+}
+
+// runCommand dispatches a slash command sent by c and tells c the result.
+// id is the message's ID, if any: it is acknowledged when the command
+// succeeds and refused with a NACK when it fails.
+func (c *ConnectedClient) runCommand(name, args, id string) {
+	fn, ok := c.server.commands[name]
+	var reply string
+	err := ErrUnknownCommand
+	if ok {
+		reply, err = fn(c.username, args)
+	}
+	switch {
+	case err != nil && id != "":
+		c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeNack, ID: id, Body: c.tr(commandError(name, err))}))
+	case err != nil:
+		c.sendError(commandError(name, err))
+	default:
+		if reply != "" {
+			c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeNotice, Body: c.tr(reply)}))
+		}
+		if id != "" {
+			c.Send(protocol.Encode(protocol.Message{Type: protocol.TypeAck, ID: id}))
+		}
+	}
+}
+
+// commandError is the text of an ERR or NACK for a failed command.
+func commandError(name string, err error) string {
+	if errors.Is(err, ErrUnknownCommand) {
+		return "unknown command /" + name
+	}
+	return "/" + name + ": " + err.Error()
+}
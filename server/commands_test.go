@@ -0,0 +1,78 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCommands(t *testing.T) {
+	srv := New(
+		WithCommand("Roll", func(user, args string) (string, error) {
+			if args == "" {
+				return "", errors.New("usage: /roll <sides>")
+			}
+			return user + " rolled a d" + args, nil
+		}),
+		WithCommand("quiet", func(user, args string) (string, error) { return "", nil }),
+	)
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	addr := srv.Addr().String()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob|2
+
+	for _, tt := range []struct{ send, want string }{
+		{"SEND|/who", "NOTICE|Online (2): alice, bob"},
+		{"SEND|/ROLL 20", "NOTICE|bob rolled a d20"},
+		{"SEND|/roll", "ERR|/roll: usage: /roll <sides>"},
+		{"SEND|/nope", "ERR|unknown command /nope"},
+		{"SENDID|7|/nope", "NACK|7|unknown command /nope"},
+		{"SENDID|8|/quiet", "ACK|8"},
+	} {
+		fmt.Fprintf(bob, "%s\n", tt.send)
+		if line := readLine(t, bob, 2*time.Second); line != tt.want {
+			t.Errorf("%s: bob got %q, want %q", tt.send, line, tt.want)
+		}
+	}
+
+	// Commands aren't broadcast; an escaped slash is.
+	fmt.Fprintf(bob, "SEND|//shrug\n")
+	if line := readLine(t, alice, 2*time.Second); line != "MSG|bob|/shrug" {
+		t.Errorf("alice got %q, want MSG|bob|/shrug", line)
+	}
+}
+
+func TestUnescapeCommand(t *testing.T) {
+	for body, want := range map[string]string{
+		"//shrug": "/shrug",
+		"///x":    "//x",
+		"/who":    "/who",
+		"hello":   "hello",
+	} {
+		if got := unescapeCommand(body); got != want {
+			t.Errorf("unescapeCommand(%q) = %q, want %q", body, got, want)
+		}
+	}
+}
+
+func TestWithCommandRemovesBuiltin(t *testing.T) {
+	srv := New(WithCommand("WHO", nil))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+
+	fmt.Fprintf(alice, "SEND|/who\n")
+	if line := readLine(t, alice, 2*time.Second); line != "ERR|unknown command /who" {
+		t.Errorf("alice got %q, want ERR|unknown command /who", line)
+	}
+}
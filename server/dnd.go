@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// maxHeld bounds the broadcasts kept for a user in do-not-disturb mode.
+// It leaves room in the outbox so the replay isn't dropped.
+const maxHeld = outboxSize / 2
+
+// dndState holds back routine broadcasts while do-not-disturb is on.
+type dndState struct {
+	mu      sync.Mutex
+	held    []outgoing // oldest first
+	dropped int        // held lines discarded to stay under maxHeld
+}
+
+// setDND turns do-not-disturb on or off. Turning it off replays what was
+// held back, preceded by a NOTICE if some of it had to be discarded.
+func (c *ConnectedClient) setDND(on bool) {
+	c.dnd.mu.Lock()
+	defer c.dnd.mu.Unlock()
+
+	if on {
+		c.dndOn.Store(true)
+		return
+	}
+	if !c.dndOn.Swap(false) {
+		return
+	}
+	// Replay under the lock so broadcasts arriving meanwhile queue up
+	// behind the held lines instead of overtaking them.
+	if c.dnd.dropped > 0 {
+		c.Send(protocol.Encode(protocol.Message{
+			Type: protocol.TypeNotice,
+			Body: fmt.Sprintf("%d older message(s) were not kept while do-not-disturb was on", c.dnd.dropped),
+		}))
+	}
+	for _, o := range c.dnd.held {
+		c.queue(o)
+	}
+	c.dnd.held, c.dnd.dropped = nil, 0
+}
+
+// deliver is Send for broadcasts. Lines the client's notification level
+// filters out are dropped, and while do-not-disturb is on routine lines
+// are held for later instead. It reports whether the line was queued,
+// held or deliberately dropped.
+func (c *ConnectedClient) deliver(o outgoing) bool {
+	if !c.dndOn.Load() && notifyLevel(c.notify.Load()) == notifyAll {
+		return c.queue(o)
+	}
+	msg, err := protocol.Decode(o.text())
+	if err != nil {
+		return c.queue(o)
+	}
+	if !c.wants(msg) {
+		o.receipt.done(c.username, false)
+		return true
+	}
+	c.dnd.mu.Lock()
+	defer c.dnd.mu.Unlock()
+	if !c.dndOn.Load() || !c.routine(msg) {
+		return c.queue(o)
+	}
+	if len(c.dnd.held) == maxHeld {
+		c.dnd.held[0].receipt.done(c.username, false)
+		c.dnd.held = c.dnd.held[1:]
+		c.dnd.dropped++
+	}
+	c.dnd.held = append(c.dnd.held, o)
+	return true
+}
+
+// routine reports whether a broadcast can wait: chat messages that don't
+// mention the user, and joins, leaves, presence and status changes.
+// Operator notices always go through.
+func (c *ConnectedClient) routine(msg protocol.Message) bool {
+	switch msg.Type {
+	case protocol.TypeMsg, protocol.TypeAttached:
+		return !c.mentions(msg.Body)
+	case protocol.TypeJoined, protocol.TypeLeft, protocol.TypePresence, protocol.TypeUserStatus:
+		return true
+	default:
+		return false
+	}
+}
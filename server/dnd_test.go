@@ -0,0 +1,67 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDND(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(alice)
+	expect := func(want string) {
+		t.Helper()
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("alice got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	expect("JOINED|bob|2")
+
+	fmt.Fprintf(alice, "DND|on\n")
+	fmt.Fprintf(alice, "WHO\n")
+	expect("WHO|alice|bob") // replies to alice herself still arrive
+
+	fmt.Fprintf(bob, "SEND|lunch?\nSEND|@Alice are you there\n")
+	expect("MSG|bob|@Alice are you there")
+	carol := connectClient(t, addr, "carol")
+	defer carol.Close()
+	srv.Announce("maintenance soon")
+	expect("NOTICE|maintenance soon")
+
+	fmt.Fprintf(alice, "DND|off\n")
+	expect("MSG|bob|lunch?")
+	expect("JOINED|carol|3")
+
+	// Once DND is off, broadcasts flow normally again.
+	fmt.Fprintf(bob, "SEND|hi\n")
+	expect("MSG|bob|hi")
+}
+
+func TestDNDOverflow(t *testing.T) {
+	srv := New()
+	c := newConnectedClient("alice", nil, srv)
+	c.setDND(true)
+	for i := range maxHeld + 3 {
+		c.deliver(outgoing{line: newLine(fmt.Sprintf("MSG|bob|%d", i))})
+	}
+	c.setDND(false)
+
+	if got, want := (<-c.outbox).text(), "NOTICE|3 older message(s) were not kept while do-not-disturb was on"; got != want {
+		t.Errorf("first line = %q, want %q", got, want)
+	}
+	if got := (<-c.outbox).text(); got != "MSG|bob|3" {
+		t.Errorf("first replayed line = %q, want MSG|bob|3", got)
+	}
+	if n := len(c.outbox); n != maxHeld-1 {
+		t.Errorf("%d more lines queued, want %d", n, maxHeld-1)
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"net"
+	"slices"
+	"sync"
+)
+
+// Event is something that happened on the server: a ClientJoined,
+// ClientLeft, MessageReceived or MessageDropped. Subscribers switch on
+// its type.
+type Event interface {
+	event()
+}
+
+// ClientJoined is published when a user has joined, before anyone else
+// is told.
+type ClientJoined struct {
+	Username string
+	Addr     net.Addr
+	Resumed  bool // arrived with a migration token rather than a JOIN
+}
+
+// ClientLeft is published when a user has left, however they went.
+type ClientLeft struct {
+	Username string
+}
+
+// MessageReceived is published for every chat message a user is allowed
+// to send, before it is broadcast. Messages from shadow-banned users are
+// published too, but only their author sees them.
+type MessageReceived struct {
+	Username     string
+	Body         string
+	Attachment   string // URL of the file shared by an ATTACH, if any
+	ShadowBanned bool
+}
+
+// MessageDropped is published when a line couldn't be queued for a user
+// because their outbox was full.
+type MessageDropped struct {
+	Username string
+	Line     string // without the newline
+}
+
+func (ClientJoined) event()    {}
+func (ClientLeft) event()      {}
+func (MessageReceived) event() {}
+func (MessageDropped) event()  {}
+
+// bus delivers events to subscribers in the order they subscribed.
+type bus struct {
+	mu   sync.RWMutex
+	subs []*subscriber
+}
+
+type subscriber struct {
+	fn func(Event)
+}
+
+func (b *bus) subscribe(fn func(Event)) (cancel func()) {
+	sub := &subscriber{fn}
+	b.mu.Lock()
+	b.subs = append(b.subs, sub)
+	b.mu.Unlock()
+	return func() {
+		b.mu.Lock()
+		b.subs = slices.DeleteFunc(b.subs, func(s *subscriber) bool { return s == sub })
+		b.mu.Unlock()
+	}
+}
+
+func (b *bus) publish(e Event) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for _, sub := range b.subs {
+		sub.fn(e)
+	}
+}
+
+// Subscribe calls fn with every event published from now on, until the
+// returned cancel func is called. fn runs on the goroutine that published
+// the event, sometimes with the server's locks held, so it must be quick
+// and mustn't call back into the ChatServer or the bus; hand the event to
+// a goroutine for anything slow.
+func (s *ChatServer) Subscribe(fn func(Event)) (cancel func()) {
+	return s.events.subscribe(fn)
+}
+
+// subscribeInternal wires the server's own bookkeeping to the bus.
+func (s *ChatServer) subscribeInternal() {
+	s.events.subscribe(func(e Event) {
+		switch e := e.(type) {
+		case MessageReceived:
+			s.messages.Add(1)
+			if !e.ShadowBanned {
+				s.moderation.remember(e.Username, e.Body)
+			}
+		case MessageDropped:
+			s.counters.dropped.Add(1)
+		}
+	})
+}
@@ -0,0 +1,76 @@
+package server
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestSubscribe(t *testing.T) {
+	srv := startServer(t)
+
+	var mu sync.Mutex
+	var events []Event
+	cancel := srv.Subscribe(func(e Event) {
+		if j, ok := e.(ClientJoined); ok {
+			j.Addr = nil // ephemeral port
+			e = j
+		}
+		mu.Lock()
+		events = append(events, e)
+		mu.Unlock()
+	})
+
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	fmt.Fprintf(bob, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "hi"}))
+	readLine(t, alice, 2*time.Second) // MSG|bob|hi
+	bob.Close()
+	readLine(t, alice, 2*time.Second) // LEFT|bob
+
+	cancel()
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "unseen"}))
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypePing}))
+	readLine(t, alice, 2*time.Second) // PONG
+
+	want := []Event{
+		ClientJoined{Username: "alice"},
+		ClientJoined{Username: "bob"},
+		MessageReceived{Username: "bob", Body: "hi"},
+		ClientLeft{Username: "bob"},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %+v, want %+v", events, want)
+	}
+}
+
+func TestMessageDroppedEvent(t *testing.T) {
+	srv := New()
+	var dropped []MessageDropped
+	srv.Subscribe(func(e Event) {
+		if d, ok := e.(MessageDropped); ok {
+			dropped = append(dropped, d)
+		}
+	})
+
+	c := &ConnectedClient{username: "alice", server: srv, outbox: make(chan outgoing, 1)}
+	c.Send("msg1")
+	c.Send("msg2")
+
+	want := []MessageDropped{{Username: "alice", Line: "msg2"}}
+	if !reflect.DeepEqual(dropped, want) {
+		t.Errorf("dropped = %+v, want %+v", dropped, want)
+	}
+	if got := srv.counters.dropped.Load(); got != 1 {
+		t.Errorf("dropped counter = %d, want 1", got)
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// FuzzHandshake feeds arbitrary bytes to a fresh connection, optionally
+// behind the PROXY protocol, and checks that the server neither panics
+// nor leaves the connection's goroutines running.
+func FuzzHandshake(f *testing.F) {
+	for _, seed := range []string{
+		"JOIN|alice\n",
+		"JOIN|alice\nSEND|hi\nWHO\nAWAY|x\nBACK\nLEAVE\n",
+		"JOIN|a|b\n",
+		"JOIN|" + strings.Repeat("a", 100) + "\n",
+		"JOIN|\x00\n",
+		"JOIN|alice\nSEND|\xff\n",
+		"SEND|hi\n",
+		strings.Repeat("x", 5000) + "\n",
+		"PROXY TCP4 1.2.3.4 5.6.7.8 1 2\r\nJOIN|alice\n",
+		"\r\n\r\n\x00\r\nQUIT\n",
+	} {
+		f.Add([]byte(seed), strings.HasPrefix(seed, "PROXY") || strings.HasPrefix(seed, "\r\n"))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, proxied bool) {
+		srv := New()
+		client, conn := net.Pipe()
+		srv.wg.Add(1)
+		go srv.handleConnection(conn, proxied, false)
+
+		go func() {
+			client.Write(data)
+			client.Close()
+		}()
+		client.SetReadDeadline(time.Now().Add(5 * time.Second))
+		io.Copy(io.Discard, client)
+
+		done := make(chan struct{})
+		go func() {
+			srv.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("connection handler still running for input %q", data)
+		}
+		if n := len(srv.Usernames()); n != 0 {
+			t.Fatalf("%d user(s) still registered after disconnect", n)
+		}
+	})
+}
@@ -0,0 +1,125 @@
+package server
+
+import (
+	"log"
+	"maps"
+	"net"
+	"strings"
+	"sync"
+)
+
+// unknownCountry is the key under which GeoDenied counts connections
+// whose country couldn't be determined.
+const unknownCountry = "unknown"
+
+// CountryLookup returns the ISO 3166-1 alpha-2 code of the country ip is
+// registered in, such as "DE", or "" if it isn't known. A MaxMind GeoIP2
+// or GeoLite2 database is the usual source.
+type CountryLookup func(ip net.IP) (string, error)
+
+// GeoPolicy admits or refuses connections by the country of the client's
+// address, for operators with regional compliance requirements. Country
+// codes are compared ignoring case.
+type GeoPolicy struct {
+	Lookup CountryLookup
+
+	// Allow, if not empty, admits only clients from these countries.
+	Allow []string
+	// Deny refuses clients from these countries.
+	Deny []string
+	// DenyUnknown refuses clients whose country can't be determined, such
+	// as private addresses and failed lookups. By default they are
+	// admitted unless Allow is set.
+	DenyUnknown bool
+}
+
+// geoFilter applies a GeoPolicy and counts the connections it refuses.
+type geoFilter struct {
+	lookup      CountryLookup
+	allow, deny map[string]bool
+	denyUnknown bool
+
+	mu     sync.Mutex
+	denied map[string]uint64 // country -> refused connections
+}
+
+func newGeoFilter(p GeoPolicy) *geoFilter {
+	return &geoFilter{
+		lookup:      p.Lookup,
+		allow:       countrySet(p.Allow),
+		deny:        countrySet(p.Deny),
+		denyUnknown: p.DenyUnknown || len(p.Allow) > 0,
+		denied:      make(map[string]uint64),
+	}
+}
+
+func countrySet(codes []string) map[string]bool {
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[strings.ToUpper(strings.TrimSpace(code))] = true
+	}
+	return set
+}
+
+// check looks up the country of addr and reports whether a client from
+// there is admitted. country is "" if it couldn't be determined.
+func (f *geoFilter) check(addr net.Addr) (country string, ok bool) {
+	if ip := addrIP(addr); ip != nil {
+		code, err := f.lookup(ip)
+		if err != nil {
+			log.Printf("geoip lookup for %s: %v", ip, err)
+		}
+		country = strings.ToUpper(code)
+	}
+
+	switch {
+	case country == "":
+		ok = !f.denyUnknown
+	case f.deny[country]:
+		ok = false
+	case len(f.allow) > 0:
+		ok = f.allow[country]
+	default:
+		ok = true
+	}
+	if !ok {
+		key := country
+		if key == "" {
+			key = unknownCountry
+		}
+		f.mu.Lock()
+		f.denied[key]++
+		f.mu.Unlock()
+	}
+	return country, ok
+}
+
+// addrIP returns the IP address of addr, or nil if it has none.
+func addrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		host = addr.String()
+	}
+	return net.ParseIP(host)
+}
+
+// GeoDenied returns how many connections the GeoPolicy has refused, by
+// country code. Connections from addresses whose country couldn't be
+// determined are counted under "unknown". It returns nil when no
+// GeoPolicy is set.
+func (s *ChatServer) GeoDenied() map[string]uint64 {
+	if s.geo == nil {
+		return nil
+	}
+	s.geo.mu.Lock()
+	defer s.geo.mu.Unlock()
+	return maps.Clone(s.geo.denied)
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// fakeCountries maps addresses to countries for tests.
+func fakeCountries(m map[string]string) CountryLookup {
+	return func(ip net.IP) (string, error) {
+		code, ok := m[ip.String()]
+		if !ok {
+			return "", errors.New("address not in database")
+		}
+		return code, nil
+	}
+}
+
+func TestGeoFilter(t *testing.T) {
+	lookup := fakeCountries(map[string]string{
+		"192.0.2.1":   "de",
+		"192.0.2.2":   "FR",
+		"2001:db8::1": "KP",
+	})
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234} }
+
+	tests := []struct {
+		name   string
+		policy GeoPolicy
+		ip     string
+		want   bool
+	}{
+		{"deny listed", GeoPolicy{Deny: []string{"kp"}}, "2001:db8::1", false},
+		{"deny unlisted", GeoPolicy{Deny: []string{"KP"}}, "192.0.2.1", true},
+		{"deny unknown admitted", GeoPolicy{Deny: []string{"KP"}}, "10.0.0.1", true},
+		{"deny unknown refused", GeoPolicy{DenyUnknown: true}, "10.0.0.1", false},
+		{"allow listed", GeoPolicy{Allow: []string{"DE", "FR"}}, "192.0.2.1", true},
+		{"allow unlisted", GeoPolicy{Allow: []string{"DE"}}, "192.0.2.2", false},
+		{"allow unknown", GeoPolicy{Allow: []string{"DE"}}, "10.0.0.1", false},
+		{"deny beats allow", GeoPolicy{Allow: []string{"DE"}, Deny: []string{"DE"}}, "192.0.2.1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.policy.Lookup = lookup
+			if _, ok := newGeoFilter(tt.policy).check(addr(tt.ip)); ok != tt.want {
+				t.Errorf("check(%s) = %v, want %v", tt.ip, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeoPolicy(t *testing.T) {
+	srv := New(WithGeoPolicy(GeoPolicy{
+		Lookup: fakeCountries(map[string]string{"127.0.0.1": "XX"}),
+		Deny:   []string{"XX"},
+	}))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	for range 2 {
+		conn, err := net.DialTimeout("tcp", srv.Addr().String(), 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(conn, "JOIN|alice\n")
+		if got := readLine(t, conn, 2*time.Second); got != "ERR|connections from your region are not accepted" {
+			t.Errorf("got %q, want ERR", got)
+		}
+		conn.Close()
+	}
+
+	if got, want := srv.GeoDenied(), map[string]uint64{"XX": 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("GeoDenied() = %v, want %v", got, want)
+	}
+	if got := New().GeoDenied(); got != nil {
+		t.Errorf("GeoDenied() without a policy = %v, want nil", got)
+	}
+}
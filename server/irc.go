@@ -0,0 +1,383 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+const (
+	// ircServerName identifies the server in IRC prefixes and replies.
+	ircServerName = "simple-chat"
+
+	// ircChannel is the one channel IRC clients see; it is the chat room.
+	ircChannel = "#chat"
+
+	// maxIRCLine is the longest line IRC clients may send, including CRLF.
+	maxIRCLine = 512
+)
+
+// ListenIRC binds addr and accepts IRC clients such as irssi or WeeChat
+// on it. They register with NICK and USER, find themselves in the channel
+// #chat, which is the chat room, and talk with PRIVMSG. Only enough of RFC
+// 1459 for that is understood: there are no other channels, private
+// messages, nick changes or modes. Call it after Listen; Shutdown closes
+// it with the other listeners.
+func (s *ChatServer) ListenIRC(addr string) error {
+	if err := validateListenAddr(s.network, addr); err != nil {
+		return err
+	}
+	ln, err := s.listen(s.network, addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	// Hold the lifecycle lock so a concurrent Shutdown either sees the
+	// listener and closes it or happens before it is added.
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.startLocked()
+	s.ircListener = ln
+	s.wg.Add(1)
+	go s.serve(ln, true)
+	return nil
+}
+
+// IRCAddr returns the address of the IRC listener, or nil if ListenIRC
+// hasn't been called.
+func (s *ChatServer) IRCAddr() net.Addr {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.ircListener == nil {
+		return nil
+	}
+	return s.ircListener.Addr()
+}
+
+// ircConn translates between an IRC client and the chat protocol, so the
+// server handles it like any other connection. Commands that need no
+// server state, such as PING, are answered directly.
+type ircConn struct {
+	net.Conn
+	srv     *ChatServer
+	r       *bufio.Reader
+	pending []byte // translated input not yet read
+
+	mu         sync.Mutex // guards writes to Conn and the fields below
+	nick       string
+	user       bool // USER has been sent
+	registered bool // JOIN has been sent to the server
+	joined     bool // OK has been received
+}
+
+func newIRCConn(conn net.Conn, srv *ChatServer) *ircConn {
+	return &ircConn{Conn: conn, srv: srv, r: bufio.NewReaderSize(conn, maxIRCLine)}
+}
+
+func (c *ircConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		line, err := c.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return 0, fmt.Errorf("IRC line longer than %d bytes", maxIRCLine)
+		}
+		if err != nil && len(line) == 0 {
+			return 0, err
+		}
+		m, ok := parseIRC(strings.TrimRight(string(line), "\r\n"))
+		if !ok {
+			continue
+		}
+		msg, replies := c.translate(m)
+		for _, reply := range replies {
+			if err := c.writeIRC(reply); err != nil {
+				return 0, err
+			}
+		}
+		if msg.Type != "" {
+			c.pending = []byte(protocol.Encode(msg) + "\n")
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// translate turns an IRC command into the protocol message to pass on, if
+// any, and replies to send the client straight away.
+func (c *ircConn) translate(m ircMessage) (protocol.Message, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	param := func(i int) string {
+		if i < len(m.Params) {
+			return m.Params[i]
+		}
+		return ""
+	}
+
+	if !c.registered {
+		switch m.Command {
+		case "CAP":
+			if param(0) == "LS" {
+				return protocol.Message{}, []string{c.reply("CAP", "*", "LS", "")}
+			}
+			return protocol.Message{}, nil
+		case "PASS":
+			return protocol.Message{}, nil
+		case "NICK":
+			c.nick = protocol.NormalizeUsername(param(0))
+		case "USER":
+			c.user = true
+		case "QUIT":
+			return protocol.Message{Type: protocol.TypeLeave}, nil
+		default:
+			return protocol.Message{}, []string{c.numeric("451", "You have not registered")}
+		}
+		if c.nick == "" || !c.user {
+			return protocol.Message{}, nil
+		}
+		c.registered = true
+		return protocol.Message{Type: protocol.TypeJoin, Username: c.nick}, nil
+	}
+
+	switch m.Command {
+	case "PING":
+		return protocol.Message{}, []string{c.reply("PONG", ircServerName, param(0))}
+	case "PONG", "CAP", "USERHOST":
+		return protocol.Message{}, nil
+	case "PRIVMSG", "NOTICE":
+		if !strings.EqualFold(param(0), ircChannel) {
+			return protocol.Message{}, []string{c.numeric("401", param(0), "Private messages aren't supported; talk in "+ircChannel)}
+		}
+		text := param(1)
+		if ctcp, ok := strings.CutPrefix(text, "\x01"); ok {
+			action, ok := strings.CutPrefix(strings.TrimSuffix(ctcp, "\x01"), "ACTION ")
+			if !ok {
+				return protocol.Message{}, nil
+			}
+			text = "*" + action + "*"
+		}
+		if strings.HasPrefix(text, "/") {
+			// IRC clients run their own commands; this is chat text.
+			text = "/" + text
+		}
+		return protocol.Message{Type: protocol.TypeSend, Body: text}, nil
+	case "JOIN":
+		var replies []string
+		for _, ch := range strings.Split(param(0), ",") {
+			if !strings.EqualFold(ch, ircChannel) {
+				replies = append(replies, c.numeric("403", ch, "No such channel; there is only "+ircChannel))
+			}
+		}
+		return protocol.Message{}, replies
+	case "PART":
+		return protocol.Message{}, []string{c.reply("NOTICE", c.nick, "There is only one channel; use /quit to leave.")}
+	case "NAMES":
+		return protocol.Message{}, c.names()
+	case "WHO":
+		return protocol.Message{}, c.who()
+	case "MODE":
+		if strings.EqualFold(param(0), ircChannel) {
+			return protocol.Message{}, []string{c.numeric("324", ircChannel, "+")}
+		}
+		return protocol.Message{}, []string{c.numeric("221", "+")}
+	case "TOPIC":
+		return protocol.Message{}, []string{c.numeric("331", ircChannel, "No topic is set")}
+	case "AWAY":
+		if note := param(0); note != "" {
+			return protocol.Message{Type: protocol.TypeAway, Body: note}, []string{c.numeric("306", "You have been marked as being away")}
+		}
+		return protocol.Message{Type: protocol.TypeBack}, []string{c.numeric("305", "You are no longer marked as being away")}
+	case "NICK":
+		return protocol.Message{}, []string{c.reply("NOTICE", c.nick, "Nick changes aren't supported; reconnect with the new nick.")}
+	case "QUIT":
+		return protocol.Message{Type: protocol.TypeLeave}, nil
+	default:
+		return protocol.Message{}, []string{c.numeric("421", m.Command, "Unknown command")}
+	}
+}
+
+// Write sends each protocol line in p to the client as IRC. The server
+// writes whole lines, so p never ends part way through one.
+func (c *ircConn) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		msg, err := protocol.Decode(line)
+		if err != nil {
+			continue
+		}
+		c.mu.Lock()
+		lines := c.format(msg)
+		c.mu.Unlock()
+		for _, l := range lines {
+			if err := c.writeIRC(l); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return len(p), nil
+}
+
+func (c *ircConn) writeIRC(line string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := io.WriteString(c.Conn, line+"\r\n")
+	return err
+}
+
+// format renders msg as IRC lines. c.mu must be held.
+func (c *ircConn) format(msg protocol.Message) []string {
+	switch msg.Type {
+	case protocol.TypeOK:
+		c.joined = true
+		lines := []string{
+			c.numeric("001", "Welcome to simple-chat, "+c.nick),
+			c.numeric("002", "Your host is "+ircServerName),
+			c.numeric("003", "This server speaks just enough IRC to chat in "+ircChannel),
+			c.numeric("422", "MOTD File is missing"),
+			":" + ircPrefix(c.nick) + " JOIN " + ircChannel,
+			c.numeric("331", ircChannel, "No topic is set"),
+		}
+		return append(lines, c.names()...)
+	case protocol.TypeErr:
+		if !c.joined {
+			switch msg.Body {
+			case "username taken":
+				return []string{c.numeric("433", c.nick, "Nickname is already in use")}
+			case protocol.ReasonNameReserved:
+				return []string{c.numeric("433", c.nick, "Nickname is reserved for a registered user")}
+			}
+			return []string{"ERROR :" + msg.Body}
+		}
+		return []string{c.reply("NOTICE", c.nick, msg.Body)}
+	case protocol.TypeMsg:
+		return []string{":" + ircPrefix(msg.Username) + " PRIVMSG " + ircChannel + " :" + msg.Body}
+	case protocol.TypeAttached:
+		return []string{":" + ircPrefix(msg.Username) + " PRIVMSG " + ircChannel + " :" + strings.TrimSpace(msg.Body+" "+msg.Attachment)}
+	case protocol.TypeJoined:
+		return []string{":" + ircPrefix(msg.Username) + " JOIN " + ircChannel}
+	case protocol.TypeLeft:
+		return []string{":" + ircPrefix(msg.Username) + " PART " + ircChannel}
+	case protocol.TypeNotice:
+		return []string{c.reply("NOTICE", ircChannel, msg.Body)}
+	case protocol.TypeMode:
+		return []string{c.reply("NOTICE", ircChannel, describeMode(msg.Body))}
+	case protocol.TypeNack:
+		return []string{c.reply("NOTICE", c.nick, "Message not sent: "+msg.Body)}
+	case protocol.TypeKicked:
+		return []string{
+			":" + ircServerName + " KICK " + ircChannel + " " + ircNick(c.nick) + " :" + msg.Body,
+			"ERROR :Closing link: " + msg.Body,
+		}
+	case protocol.TypeReconnect:
+		text := "The server is restarting; please reconnect"
+		if msg.Body != "" {
+			text = "The server is restarting; reconnect to " + msg.Body
+		}
+		return []string{c.reply("NOTICE", ircChannel, text)}
+	case protocol.TypeMigrate:
+		return []string{c.reply("NOTICE", ircChannel, "You are being moved to another server; reconnect to "+msg.Body)}
+	case protocol.TypeChallenge:
+		return []string{"ERROR :This server asks joining clients a challenge; join with the chat client instead"}
+	default:
+		return nil
+	}
+}
+
+// names lists the channel members as RPL_NAMREPLY and RPL_ENDOFNAMES.
+func (c *ircConn) names() []string {
+	users := c.srv.Usernames()
+	nicks := make([]string, len(users))
+	for i, name := range users {
+		nicks[i] = ircNick(name)
+	}
+	return []string{
+		c.numeric("353", "=", ircChannel, strings.Join(nicks, " ")),
+		c.numeric("366", ircChannel, "End of /NAMES list"),
+	}
+}
+
+// who lists the channel members as RPL_WHOREPLY and RPL_ENDOFWHO.
+func (c *ircConn) who() []string {
+	var lines []string
+	for _, name := range c.srv.Usernames() {
+		nick := ircNick(name)
+		lines = append(lines, c.numeric("352", ircChannel, nick, ircServerName, ircServerName, nick, "H", "0 "+name))
+	}
+	return append(lines, c.numeric("315", ircChannel, "End of /WHO list"))
+}
+
+// numeric formats a numeric reply to the client. The last parameter is
+// sent as the trailing one.
+func (c *ircConn) numeric(code string, params ...string) string {
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	return c.reply(code, append([]string{ircNick(nick)}, params...)...)
+}
+
+// reply formats a line from the server. The last parameter is sent as the
+// trailing one.
+func (c *ircConn) reply(command string, params ...string) string {
+	line := ":" + ircServerName + " " + command
+	for i, p := range params {
+		if i == len(params)-1 {
+			line += " :" + p
+		} else {
+			line += " " + p
+		}
+	}
+	return line
+}
+
+// ircNick makes a chat username usable as an IRC nickname, which can't
+// contain spaces.
+func ircNick(name string) string {
+	return strings.ReplaceAll(name, " ", "_")
+}
+
+// ircPrefix returns the nick!user@host prefix for a chat user.
+func ircPrefix(name string) string {
+	nick := ircNick(name)
+	return nick + "!" + nick + "@" + ircServerName
+}
+
+// ircMessage is one parsed IRC line (RFC 1459 section 2.3.1), without
+// IRCv3 tags.
+type ircMessage struct {
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// parseIRC parses a line without its trailing CRLF.
+func parseIRC(line string) (ircMessage, bool) {
+	var m ircMessage
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.Prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param == "" {
+			continue
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(param)
+		} else {
+			m.Params = append(m.Params, param)
+		}
+	}
+	return m, m.Command != ""
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIRC(t *testing.T) {
+	srv := startServer(t)
+	if err := srv.ListenIRC("127.0.0.1:0"); err != nil {
+		t.Fatalf("ListenIRC: %v", err)
+	}
+
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	bobLines := bufio.NewScanner(bob)
+	bobExpect := func(want string) {
+		t.Helper()
+		bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if !bobLines.Scan() || bobLines.Text() != want {
+			t.Fatalf("bob got %q (%v), want %q", bobLines.Text(), bobLines.Err(), want)
+		}
+	}
+
+	conn, err := net.DialTimeout("tcp", srv.IRCAddr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	lines := bufio.NewScanner(conn)
+	// expect skips lines until one starting with prefix.
+	expect := func(prefix string) string {
+		t.Helper()
+		for lines.Scan() {
+			if strings.HasPrefix(lines.Text(), prefix) {
+				return lines.Text()
+			}
+		}
+		t.Fatalf("no line starting %q: %v", prefix, lines.Err())
+		return ""
+	}
+
+	fmt.Fprint(conn, "CAP LS 302\r\nNICK alice\r\nUSER alice 0 * :Alice\r\n")
+	expect(":simple-chat CAP * LS")
+	expect(":simple-chat 001 alice ")
+	expect(":alice!alice@simple-chat JOIN #chat")
+	if got, want := expect(":simple-chat 353 "), ":simple-chat 353 alice = #chat :alice bob"; got != want {
+		t.Errorf("names: got %q, want %q", got, want)
+	}
+	bobExpect("JOINED|alice|2")
+
+	fmt.Fprint(conn, "JOIN #chat\r\nPRIVMSG #chat :hello from irssi\r\n")
+	bobExpect("MSG|alice|hello from irssi")
+	fmt.Fprint(conn, "PRIVMSG #chat :\x01ACTION waves\x01\r\n")
+	bobExpect("MSG|alice|*waves*")
+	fmt.Fprint(conn, "PRIVMSG #chat :/shrug\r\n")
+	bobExpect("MSG|alice|/shrug")
+
+	fmt.Fprintf(bob, "SEND|hi alice\n")
+	expect(":bob!bob@simple-chat PRIVMSG #chat :hi alice")
+
+	fmt.Fprint(conn, "PING :12345\r\n")
+	expect(":simple-chat PONG simple-chat :12345")
+	fmt.Fprint(conn, "JOIN #other\r\n")
+	expect(":simple-chat 403 alice #other ")
+
+	fmt.Fprint(conn, "QUIT :bye\r\n")
+	bobExpect("LEFT|alice|1")
+}
+
+func TestIRCNickInUse(t *testing.T) {
+	srv := startServer(t)
+	if err := srv.ListenIRC("127.0.0.1:0"); err != nil {
+		t.Fatalf("ListenIRC: %v", err)
+	}
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+
+	conn, err := net.DialTimeout("tcp", srv.IRCAddr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprint(conn, "NICK bob\r\nUSER bob 0 * :Bob\r\n")
+	if got, want := readLine(t, conn, 2*time.Second), ":simple-chat 433 bob bob :Nickname is already in use"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package server
+
+import (
+	"log"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// JoinThrottle slows down and then blocks addresses that keep failing to
+// join: answering the join challenge wrongly, presenting a bad migration
+// token, or asking for a banned or taken username. It blunts guessing at
+// challenge answers and squatting on names as users leave.
+//
+// Behind a proxy, enable WithProxyProtocol: otherwise every connection
+// comes from the proxy's address and the throttle hits everyone at once.
+type JoinThrottle struct {
+	// Free is how many failed JOINs an address may make before its
+	// later JOINs are delayed.
+	Free int
+
+	// Delay is how long the first JOIN over Free waits before it is
+	// handled. Each further failure doubles the delay, up to MaxDelay.
+	Delay    time.Duration
+	MaxDelay time.Duration
+
+	// BlockAfter failed JOINs block the address for BlockFor: its JOINs
+	// are rejected without being looked at. Zero never blocks.
+	BlockAfter int
+	BlockFor   time.Duration
+
+	// Forget is how long an address must go without failing before its
+	// failures are forgotten. The default is 15 minutes.
+	Forget time.Duration
+}
+
+// JoinThrottleStats counts what the JoinThrottle has done.
+type JoinThrottleStats struct {
+	Failures  uint64 `json:"failures"`  // failed JOINs
+	Delayed   uint64 `json:"delayed"`   // JOINs made to wait
+	Blocked   uint64 `json:"blocked"`   // times an address was blocked
+	Addresses int    `json:"addresses"` // addresses with failures remembered
+}
+
+// joinFailures is what a joinThrottle remembers about one address.
+type joinFailures struct {
+	count        int
+	last         time.Time // of the latest failure
+	blockedUntil time.Time
+}
+
+// joinThrottle applies a JoinThrottle.
+type joinThrottle struct {
+	policy JoinThrottle
+
+	mu        sync.Mutex
+	addrs     map[string]*joinFailures // by addrKey
+	lastSweep time.Time
+
+	failures, delayed, blocked atomic.Uint64
+}
+
+func newJoinThrottle(p JoinThrottle) *joinThrottle {
+	if p.Forget <= 0 {
+		p.Forget = 15 * time.Minute
+	}
+	if p.MaxDelay < p.Delay {
+		p.MaxDelay = p.Delay
+	}
+	return &joinThrottle{policy: p, addrs: make(map[string]*joinFailures)}
+}
+
+// check returns how long a JOIN from addr at now should wait before it
+// is handled, or that the address is blocked.
+func (t *joinThrottle) check(addr string, now time.Time) (delay time.Duration, blocked bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(now)
+	f := t.addrs[addr]
+	switch {
+	case f == nil || t.stale(f, now):
+		return 0, false
+	case now.Before(f.blockedUntil):
+		return 0, true
+	case f.count <= t.policy.Free || t.policy.Delay <= 0:
+		return 0, false
+	}
+	delay = t.policy.Delay
+	for i := t.policy.Free + 1; i < f.count && delay < t.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	t.delayed.Add(1)
+	return min(delay, t.policy.MaxDelay), false
+}
+
+// fail records a failed JOIN from addr at now, blocking the address if
+// that makes BlockAfter failures.
+func (t *joinThrottle) fail(addr string, now time.Time) {
+	t.failures.Add(1)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f := t.addrs[addr]
+	if f == nil || t.stale(f, now) {
+		f = &joinFailures{}
+		t.addrs[addr] = f
+	}
+	f.count++
+	f.last = now
+	if t.policy.BlockAfter > 0 && f.count >= t.policy.BlockAfter && !now.Before(f.blockedUntil) {
+		f.blockedUntil = now.Add(t.policy.BlockFor)
+		t.blocked.Add(1)
+		log.Printf("blocking joins from %s for %s after %d failures", addr, t.policy.BlockFor, f.count)
+	}
+}
+
+// succeed forgets the failures of addr once a JOIN from it is accepted.
+func (t *joinThrottle) succeed(addr string) {
+	t.mu.Lock()
+	delete(t.addrs, addr)
+	t.mu.Unlock()
+}
+
+// sweep forgets stale addresses, at most once a minute. t.mu must be
+// held.
+func (t *joinThrottle) sweep(now time.Time) {
+	if now.Sub(t.lastSweep) < time.Minute {
+		return
+	}
+	t.lastSweep = now
+	for addr, f := range t.addrs {
+		if t.stale(f, now) {
+			delete(t.addrs, addr)
+		}
+	}
+}
+
+// stale reports whether f is old enough at now to be forgotten.
+func (t *joinThrottle) stale(f *joinFailures, now time.Time) bool {
+	return now.Sub(f.last) > t.policy.Forget && !now.Before(f.blockedUntil)
+}
+
+// joinFailed records a failed JOIN from addr, if JOINs are throttled.
+func (s *ChatServer) joinFailed(addr net.Addr) {
+	if s.joinThrottle != nil {
+		s.joinThrottle.fail(addrKey(addr), time.Now())
+	}
+}
+
+// JoinThrottleStats returns what the JoinThrottle has done. It is zero
+// when no JoinThrottle is set.
+func (s *ChatServer) JoinThrottleStats() JoinThrottleStats {
+	t := s.joinThrottle
+	if t == nil {
+		return JoinThrottleStats{}
+	}
+	t.mu.Lock()
+	addrs := len(t.addrs)
+	t.mu.Unlock()
+	return JoinThrottleStats{
+		Failures:  t.failures.Load(),
+		Delayed:   t.delayed.Load(),
+		Blocked:   t.blocked.Load(),
+		Addresses: addrs,
+	}
+}
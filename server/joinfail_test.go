@@ -0,0 +1,81 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestJoinThrottleEscalates(t *testing.T) {
+	th := newJoinThrottle(JoinThrottle{
+		Free: 1, Delay: time.Second, MaxDelay: 3 * time.Second,
+		BlockAfter: 5, BlockFor: time.Minute, Forget: time.Hour,
+	})
+	now := time.Now()
+
+	for i, want := range []time.Duration{0, 0, time.Second, 2 * time.Second, 3 * time.Second} {
+		if delay, blocked := th.check("a", now); delay != want || blocked {
+			t.Errorf("after %d failures: check = %v, %v; want %v, false", i, delay, blocked, want)
+		}
+		th.fail("a", now)
+	}
+	if _, blocked := th.check("a", now); !blocked {
+		t.Error("address not blocked after 5 failures")
+	}
+	if delay, blocked := th.check("b", now); delay != 0 || blocked {
+		t.Errorf("other address: check = %v, %v; want no delay", delay, blocked)
+	}
+
+	// The block ends, but the failures are remembered until Forget.
+	now = now.Add(2 * time.Minute)
+	if delay, blocked := th.check("a", now); delay != 3*time.Second || blocked {
+		t.Errorf("after the block: check = %v, %v; want 3s, false", delay, blocked)
+	}
+	now = now.Add(2 * time.Hour)
+	if delay, _ := th.check("a", now); delay != 0 {
+		t.Errorf("after Forget: delay = %v, want 0", delay)
+	}
+
+	th.fail("c", now)
+	th.fail("c", now)
+	th.succeed("c")
+	if delay, _ := th.check("c", now); delay != 0 {
+		t.Errorf("after a successful join: delay = %v, want 0", delay)
+	}
+}
+
+func TestJoinThrottle(t *testing.T) {
+	srv := New(WithJoinThrottle(JoinThrottle{Free: 1, Delay: time.Millisecond, BlockAfter: 3, BlockFor: time.Hour}))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	addr := srv.Addr().String()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	join := func(username string) string {
+		t.Helper()
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: username}))
+		return readLine(t, conn, 2*time.Second)
+	}
+	for range 3 {
+		if got := join("alice"); got != "ERR|username taken" {
+			t.Fatalf("JOIN as alice: got %q, want ERR|username taken", got)
+		}
+	}
+	if got, want := join("bob"), "ERR|too many failed joins from your address; try again later"; got != want {
+		t.Errorf("JOIN as bob when blocked: got %q, want %q", got, want)
+	}
+	if got, want := srv.JoinThrottleStats(), (JoinThrottleStats{Failures: 3, Delayed: 1, Blocked: 1, Addresses: 1}); got != want {
+		t.Errorf("JoinThrottleStats() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// linePool holds buffers for lines written straight to a connection, such
+// as handshake replies, so that writing one doesn't allocate.
+var linePool = sync.Pool{
+	New: func() any { return new(bytes.Buffer) },
+}
+
+// writeMessage writes msg to w as one protocol line.
+func writeMessage(w io.Writer, msg protocol.Message) error {
+	buf := linePool.Get().(*bytes.Buffer)
+	buf.Reset()
+	buf.WriteString(protocol.Encode(msg))
+	buf.WriteByte('\n')
+	_, err := w.Write(buf.Bytes())
+	linePool.Put(buf)
+	return err
+}
+
+// newLine returns line with its newline, ready to be written. Broadcasts
+// format a line once and share it between every recipient's outbox, so
+// the result must not be modified.
+func newLine(line string) []byte {
+	b := make([]byte, len(line)+1)
+	copy(b, line)
+	b[len(line)] = '\n'
+	return b
+}
+
+// text returns the line o carries, without its newline.
+func (o outgoing) text() string {
+	return strings.TrimSuffix(string(o.line), "\n")
+}
@@ -0,0 +1,107 @@
+package server
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Translations maps the English text of messages the server sends users,
+// such as errors and notices, to their translation in one language. Keys
+// for messages with parts that vary use %s and %d where those parts go,
+// as in "quota exceeded: %d messages per hour", and so may translations;
+// %[n]s picks the nth part when a language needs them in another order.
+// The message of the day is translated too, keyed by its full text.
+type Translations map[string]string
+
+// translation is one compiled entry of a Translations.
+type translation struct {
+	match  *regexp.Regexp // matches the English text, capturing the parts that vary
+	format string         // the translation, with every verb taking a string
+}
+
+// catalog holds the compiled Translations for one locale.
+type catalog struct {
+	source  Translations
+	exact   map[string]string
+	formats []translation
+}
+
+// verb matches the fmt verbs a Translations key or value may use.
+var verb = regexp.MustCompile(`%(\[\d+\])?[sdvq]`)
+
+func newCatalog(t Translations) *catalog {
+	c := &catalog{source: t, exact: make(map[string]string)}
+	for english, translated := range t {
+		if !verb.MatchString(english) {
+			c.exact[english] = translated
+			continue
+		}
+		var pattern strings.Builder
+		pattern.WriteString("^")
+		last := 0
+		for _, loc := range verb.FindAllStringIndex(english, -1) {
+			pattern.WriteString(regexp.QuoteMeta(english[last:loc[0]]))
+			pattern.WriteString("(.*?)")
+			last = loc[1]
+		}
+		pattern.WriteString(regexp.QuoteMeta(english[last:]) + "$")
+		c.formats = append(c.formats, translation{
+			match:  regexp.MustCompile(pattern.String()),
+			format: verb.ReplaceAllString(translated, "%${1}s"),
+		})
+	}
+	return c
+}
+
+// translate returns text in the catalog's language, or text itself if
+// the catalog has no translation for it.
+func (c *catalog) translate(text string) string {
+	if t, ok := c.exact[text]; ok {
+		return t
+	}
+	for _, t := range c.formats {
+		parts := t.match.FindStringSubmatch(text)
+		if parts == nil {
+			continue
+		}
+		args := make([]any, len(parts)-1)
+		for i, p := range parts[1:] {
+			args[i] = p
+		}
+		return fmt.Sprintf(t.format, args...)
+	}
+	return text
+}
+
+// canonicalLocale folds the spellings of a locale together: "pt_br" and
+// "PT-BR" are both "pt-br".
+func canonicalLocale(locale string) string {
+	return strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+}
+
+// translate returns text in locale, falling back to the locale's base
+// language, so "pt-BR" can use "pt", and then to English.
+func (s *ChatServer) translate(locale, text string) string {
+	if locale == "" || len(s.locales) == 0 {
+		return text
+	}
+	locale = canonicalLocale(locale)
+	for {
+		if c, ok := s.locales[locale]; ok {
+			if t := c.translate(text); t != text {
+				return t
+			}
+		}
+		i := strings.LastIndex(locale, "-")
+		if i < 0 {
+			return text
+		}
+		locale = locale[:i]
+	}
+}
+
+// tr returns text in the client's locale.
+func (c *ConnectedClient) tr(text string) string {
+	return c.server.translate(c.locale, text)
+}
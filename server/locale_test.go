@@ -0,0 +1,86 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestTranslate(t *testing.T) {
+	srv := New(
+		WithTranslations("pt", Translations{
+			"username taken":      "nome de usuário em uso",
+			"unknown command /%s": "comando desconhecido /%s",
+		}),
+		WithTranslations("PT_br", Translations{"username taken": "nome já usado"}),
+		WithTranslations("de", Translations{"%s rolled %d": "%[2]s gewürfelt von %[1]s"}),
+	)
+	for _, tt := range []struct{ locale, text, want string }{
+		{"", "username taken", "username taken"},
+		{"pt", "username taken", "nome de usuário em uso"},
+		{"pt-BR", "username taken", "nome já usado"},
+		{"pt-BR", "unknown command /nope", "comando desconhecido /nope"},
+		{"de", "bob rolled 6", "6 gewürfelt von bob"},
+		{"de", "username taken", "username taken"},
+		{"fr", "username taken", "username taken"},
+	} {
+		if got := srv.translate(tt.locale, tt.text); got != tt.want {
+			t.Errorf("translate(%q, %q) = %q, want %q", tt.locale, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLocalizedJoin(t *testing.T) {
+	srv := New(WithTranslations("de", Translations{
+		"welcome":             "willkommen",
+		"username taken":      "Benutzername vergeben",
+		"unknown command /%s": "unbekannter Befehl /%s",
+		"NAME_RESERVED":       "Name reserviert",
+	}))
+	srv.Reserve("carol")
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Shutdown()
+	addr := srv.Addr().String()
+	srv.SetMOTD("welcome")
+
+	join := func(username, locale string) (net.Conn, *bufio.Scanner) {
+		t.Helper()
+		conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+		if err != nil {
+			t.Fatalf("failed to connect: %v", err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: username, Locale: locale}))
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		return conn, bufio.NewScanner(conn)
+	}
+	expect := func(scanner *bufio.Scanner, want string) {
+		t.Helper()
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+
+	alice, aliceLines := join("alice", "de-AT")
+	expect(aliceLines, "OK|format commands|1")
+	expect(aliceLines, "NOTICE|willkommen")
+	fmt.Fprintf(alice, "SEND|/nope\n")
+	expect(aliceLines, "ERR|unbekannter Befehl /nope")
+
+	_, bobLines := join("bob", "")
+	expect(bobLines, "OK|format commands|2")
+	expect(bobLines, "NOTICE|welcome")
+
+	_, takenLines := join("alice", "de")
+	expect(takenLines, "ERR|Benutzername vergeben")
+	_, reservedLines := join("carol", "de")
+	expect(reservedLines, "ERR|Name reserviert")
+	_, badLines := join("alice|locale=de_DE", "")
+	expect(badLines, `ERR|invalid locale: use a language tag such as "de" or "pt-BR"`)
+}
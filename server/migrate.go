@@ -0,0 +1,80 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrNoMigrationKey is returned by Migrate when no key was set with
+// WithMigrationKey.
+var ErrNoMigrationKey = errors.New("no migration key configured")
+
+// migrationTokenTTL is how long a migration token can be used to resume
+// a session on the target server.
+const migrationTokenTTL = 30 * time.Second
+
+// Migrate moves a user's session to the server at addr, for instance to
+// rebalance load: the client is sent a MIGRATE with a token and
+// disconnected, and the client library reconnects to addr and joins with
+// the token. The target must share this server's migration key. It lets
+// the user in without asking the join challenge again, since this server
+// already did, but still refuses banned users and taken usernames.
+func (s *ChatServer) Migrate(name, addr string) error {
+	if s.migrationKey == nil {
+		return ErrNoMigrationKey
+	}
+	if addr == "" || !protocol.ValidText(addr) {
+		return errors.New("invalid address")
+	}
+	c, ok := s.online(name)
+	if !ok {
+		return ErrNoSuchUser
+	}
+	name = c.username
+	log.Printf("migrating %s to %s", name, addr)
+	c.Send(protocol.Encode(protocol.Message{
+		Type: protocol.TypeMigrate,
+		ID:   migrationToken(s.migrationKey, name, time.Now().Add(migrationTokenTTL)),
+		Body: addr,
+	}))
+	c.conn.SetReadDeadline(time.Now())
+	return nil
+}
+
+// migrationToken returns a token letting name resume its session until
+// expires: the expiry time and a MAC of it and the name.
+func migrationToken(key []byte, name string, expires time.Time) string {
+	b := binary.BigEndian.AppendUint64(nil, uint64(expires.Unix()))
+	b = append(b, migrationMAC(key, name, b)...)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// validMigrationToken reports whether token lets name resume its session
+// at now.
+func validMigrationToken(key []byte, name, token string, now time.Time) bool {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) != 8+sha256.Size/2 {
+		return false
+	}
+	expiry, mac := b[:8], b[8:]
+	if now.Unix() > int64(binary.BigEndian.Uint64(expiry)) {
+		return false
+	}
+	return hmac.Equal(mac, migrationMAC(key, name, expiry))
+}
+
+func migrationMAC(key []byte, name string, expiry []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte("migrate\x00"))
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write(expiry)
+	return h.Sum(nil)[:sha256.Size/2]
+}
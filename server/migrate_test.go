@@ -0,0 +1,94 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestMigrationToken(t *testing.T) {
+	key := []byte("secret")
+	now := time.Now()
+	token := migrationToken(key, "alice", now.Add(time.Minute))
+
+	for _, tc := range []struct {
+		name, key, user, token string
+		at                     time.Time
+		want                   bool
+	}{
+		{"valid", "secret", "alice", token, now, true},
+		{"other user", "secret", "bob", token, now, false},
+		{"other key", "guess", "alice", token, now, false},
+		{"expired", "secret", "alice", token, now.Add(2 * time.Minute), false},
+		{"garbage", "secret", "alice", "not-a-token", now, false},
+		{"truncated", "secret", "alice", token[:len(token)-2], now, false},
+	} {
+		if got := validMigrationToken([]byte(tc.key), tc.user, tc.token, tc.at); got != tc.want {
+			t.Errorf("%s: valid = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	key := WithMigrationKey([]byte("shared secret"))
+	from := New(key)
+	to := New(key, WithJoinChallenge(JoinChallenge{Question: "Favourite plant?", Answers: []string{"fern"}}))
+	for _, srv := range []*ChatServer{from, to} {
+		if err := srv.Listen("127.0.0.1:0"); err != nil {
+			t.Fatalf("failed to start server: %v", err)
+		}
+		t.Cleanup(srv.Shutdown)
+	}
+
+	if err := New().Migrate("alice", "x:1"); err != ErrNoMigrationKey {
+		t.Errorf("Migrate() without a key = %v, want ErrNoMigrationKey", err)
+	}
+	if err := from.Migrate("nobody", "x:1"); err != ErrNoSuchUser {
+		t.Errorf("Migrate(nobody) = %v, want ErrNoSuchUser", err)
+	}
+
+	alice := connectClient(t, from.Addr().String(), "alice")
+	defer alice.Close()
+	target := to.Addr().String()
+	if err := from.Migrate("alice", target); err != nil {
+		t.Fatalf("Migrate() error = %v", err)
+	}
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	lines := bufio.NewScanner(alice)
+	if !lines.Scan() {
+		t.Fatalf("no MIGRATE received: %v", lines.Err())
+	}
+	msg, err := protocol.Decode(lines.Text())
+	if err != nil || msg.Type != protocol.TypeMigrate || msg.Body != target {
+		t.Fatalf("got %q, want MIGRATE to %s", lines.Text(), target)
+	}
+	if lines.Scan() {
+		t.Errorf("got %q after MIGRATE, want the connection closed", lines.Text())
+	}
+
+	resume := func(name, token string) string {
+		t.Helper()
+		conn, err := net.DialTimeout("tcp", target, 2*time.Second)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeResume, Username: name, ID: token}))
+		return readLine(t, conn, 2*time.Second)
+	}
+	// The token skips the target's join question, but only for alice.
+	if got := resume("mallory", msg.ID); got != "ERR|invalid or expired migration token" {
+		t.Errorf("RESUME with alice's token as mallory: got %q", got)
+	}
+	if got := resume("alice", msg.ID); got != "OK|format commands|1" {
+		t.Errorf("RESUME: got %q, want OK", got)
+	}
+	if names := strings.Join(to.Usernames(), ","); names != "alice" {
+		t.Errorf("users on the target = %q, want alice", names)
+	}
+}
@@ -0,0 +1,67 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// announceOnlyReason is reported for messages sent while the room is in
+// announcement mode.
+const announceOnlyReason = "the room is in announcement mode; only operators can post"
+
+// RoomMode returns the room's posting rules.
+func (s *ChatServer) RoomMode() protocol.RoomMode {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mode
+}
+
+// SetRoomMode changes the room's posting rules. In announcement mode users
+// can't post and only operators reach the room, with Announce; in slow
+// mode each user must wait m.Slow between messages. If the rules change,
+// every user is sent a MODE message.
+func (s *ChatServer) SetRoomMode(m protocol.RoomMode) {
+	s.mu.Lock()
+	changed := s.mode != m
+	s.mode = m
+	s.mu.Unlock()
+	if !changed {
+		return
+	}
+	log.Printf("room mode is now %s", protocol.EncodeRoomMode(m))
+	s.broadcast("", protocol.Encode(protocol.Message{Type: protocol.TypeMode, Body: protocol.EncodeRoomMode(m)}))
+}
+
+// checkMode returns why the room's mode refuses a message sent by c at
+// now, or "" if it's allowed. It must only be called from c's readLoop.
+func (c *ConnectedClient) checkMode(now time.Time) string {
+	m := c.server.RoomMode()
+	switch {
+	case m.Announce:
+		return announceOnlyReason
+	case m.Slow > 0 && !c.lastPost.IsZero() && now.Sub(c.lastPost) < m.Slow:
+		wait := (m.Slow - now.Sub(c.lastPost) + time.Second - 1).Truncate(time.Second)
+		return fmt.Sprintf("slow mode is on; wait %s before posting again", wait)
+	default:
+		return ""
+	}
+}
+
+// describeMode renders the Body of a MODE message for the text-based
+// transports.
+func describeMode(body string) string {
+	m, err := protocol.ParseRoomMode(body)
+	switch {
+	case err != nil:
+		return "The room's posting rules changed"
+	case m.Announce:
+		return "The room is in announcement mode: only operators can post"
+	case m.Slow > 0:
+		return fmt.Sprintf("Slow mode is on: one message every %s", m.Slow)
+	default:
+		return "Everyone can post freely again"
+	}
+}
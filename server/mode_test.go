@@ -0,0 +1,76 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestRoomMode(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	srv.SetRoomMode(protocol.RoomMode{Announce: true})
+	if line := readLine(t, alice, 2*time.Second); line != "MODE|announce" {
+		t.Fatalf("alice got %q, want MODE|announce", line)
+	}
+	srv.SetRoomMode(protocol.RoomMode{Announce: true}) // unchanged: no MODE
+
+	bob, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.Close()
+	fmt.Fprintf(bob, "JOIN|bob\n")
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(bob)
+	for _, want := range []string{"OK|format commands|2", "MODE|announce"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("bob got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	if line := readLine(t, alice, 2*time.Second); line != "JOINED|bob|2" {
+		t.Fatalf("alice got %q, want JOINED|bob|2", line)
+	}
+
+	fmt.Fprintf(bob, "SEND|hi\n")
+	if line := readLine(t, bob, 2*time.Second); line != "ERR|"+announceOnlyReason {
+		t.Errorf("bob got %q, want ERR|%s", line, announceOnlyReason)
+	}
+	fmt.Fprintf(bob, "SENDID|1|hi\n")
+	if line := readLine(t, bob, 2*time.Second); line != "NACK|1|"+announceOnlyReason {
+		t.Errorf("bob got %q, want NACK|1|%s", line, announceOnlyReason)
+	}
+
+	srv.SetRoomMode(protocol.RoomMode{Slow: time.Minute})
+	if line := readLine(t, alice, 2*time.Second); line != "MODE|slow=60" {
+		t.Fatalf("alice got %q, want MODE|slow=60", line)
+	}
+	if line := readLine(t, bob, 2*time.Second); line != "MODE|slow=60" {
+		t.Fatalf("bob got %q, want MODE|slow=60", line)
+	}
+	fmt.Fprintf(bob, "SEND|first\n")
+	if line := readLine(t, alice, 2*time.Second); line != "MSG|bob|first" {
+		t.Errorf("alice got %q, want MSG|bob|first", line)
+	}
+	fmt.Fprintf(bob, "SEND|second\n")
+	if line := readLine(t, bob, 2*time.Second); !strings.HasPrefix(line, "ERR|slow mode is on; wait 1m0s") {
+		t.Errorf("bob got %q, want a slow mode ERR", line)
+	}
+
+	if snap := srv.Snapshot(); snap.SlowMode != time.Minute || snap.Announce {
+		t.Errorf("Snapshot() mode = %v, %v; want slow mode of 1m", snap.Announce, snap.SlowMode)
+	}
+	restored := New()
+	restored.Restore(srv.Snapshot())
+	if m := restored.RoomMode(); m != (protocol.RoomMode{Slow: time.Minute}) {
+		t.Errorf("restored RoomMode() = %+v", m)
+	}
+}
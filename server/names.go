@@ -0,0 +1,51 @@
+package server
+
+import (
+	"strings"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// confusables maps characters that look like a Latin letter or digit, in
+// the common fonts, to that letter once case is folded. It covers the
+// Cyrillic and Greek lookalikes and the digits usually used to pass one
+// name off as another; it is a small subset of the Unicode confusables
+// data (UTS #39), not all of it.
+var confusables = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'с': 'c', 'ԁ': 'd', 'е': 'e', 'ё': 'e', 'һ': 'h',
+	'і': 'i', 'ї': 'i', 'ј': 'j', 'к': 'k', 'ӏ': 'l', 'м': 'm', 'н': 'h',
+	'о': 'o', 'р': 'p', 'ԛ': 'q', 'ѕ': 's', 'т': 't', 'у': 'y', 'ԝ': 'w',
+	'х': 'x',
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'i', 'κ': 'k', 'ν': 'v',
+	'ο': 'o', 'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+	// Digits
+	'0': 'o', '1': 'l',
+}
+
+// fold folds case for comparing usernames. Caser values aren't safe for
+// concurrent use, so each call makes its own.
+func fold(name string) string {
+	return cases.Fold().String(name)
+}
+
+// nameKey is what usernames are told apart by: two names with the same
+// key can't be online at once, nor can one take a name reserved under
+// the other. Case and compatibility variants never count, so "Alice"
+// and "ａｌｉｃｅ" in fullwidth letters are "alice"; with
+// WithConfusableNames, lookalike letters don't either, so "аlice" with a
+// Cyrillic "а" is "alice" too.
+func (s *ChatServer) nameKey(name string) string {
+	key := fold(norm.NFKC.String(name))
+	if !s.confusableNames {
+		return key
+	}
+	return strings.Map(func(r rune) rune {
+		if l, ok := confusables[r]; ok {
+			return l
+		}
+		return r
+	}, key)
+}
@@ -0,0 +1,113 @@
+package server
+
+import "testing"
+
+func TestNameKey(t *testing.T) {
+	plain, confusable := New(), New(WithConfusableNames())
+	tests := []struct {
+		a, b            string
+		same, lookalike bool // same key without and with WithConfusableNames
+	}{
+		{"alice", "alice", true, true},
+		{"alice", "ALICE", true, true},
+		{"Straße", "STRASSE", true, true},
+		{"alice", "аlice", false, true}, // Cyrillic а
+		{"alice", "АLICE", false, true}, // Cyrillic А
+		{"bob", "bοb", false, true},     // Greek ο
+		{"bob", "b0b", false, true},
+		{"alice", "bob", false, false},
+		{"jose", "josé", false, false},
+		{"josé", "jose\u0301", true, true},
+		{"alice", "ａｌｉｃｅ", true, true}, // fullwidth
+	}
+	for _, tt := range tests {
+		if got := plain.nameKey(tt.a) == plain.nameKey(tt.b); got != tt.same {
+			t.Errorf("nameKey(%q) == nameKey(%q) is %v, want %v", tt.a, tt.b, got, tt.same)
+		}
+		if got := confusable.nameKey(tt.a) == confusable.nameKey(tt.b); got != tt.lookalike {
+			t.Errorf("with confusables, nameKey(%q) == nameKey(%q) is %v, want %v", tt.a, tt.b, got, tt.lookalike)
+		}
+	}
+}
+
+func TestAddClientLookalikes(t *testing.T) {
+	srv := New(WithConfusableNames())
+	alice := &ConnectedClient{username: "alice", outbox: make(chan outgoing, 1)}
+	if !srv.addClient(alice) {
+		t.Fatal("addClient(alice) failed")
+	}
+	for _, name := range []string{"Alice", "аlice"} {
+		if srv.addClient(&ConnectedClient{username: name, outbox: make(chan outgoing, 1)}) {
+			t.Errorf("addClient(%q) succeeded while alice is online", name)
+		}
+	}
+
+	srv.removeClient("alice")
+	if !srv.addClient(&ConnectedClient{username: "Alice", outbox: make(chan outgoing, 1)}) {
+		t.Error("addClient(Alice) failed after alice left")
+	}
+
+	srv.Reserve("bob")
+	if !srv.isReserved("Bοb") {
+		t.Error("Bοb with a Greek ο isn't reserved along with bob")
+	}
+	if !srv.Unreserve("BOB") {
+		t.Error("Unreserve(BOB) = false, want true")
+	}
+}
+
+func TestJoinDecomposedName(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	decomposed, composed := "jose\u0301", "jos\u00e9"
+
+	jose := connectClient(t, addr, decomposed)
+	defer jose.Close()
+	if got := srv.Usernames(); len(got) != 1 || got[0] != composed {
+		t.Errorf("Usernames() = %q, want [%q]", got, composed)
+	}
+	if got := joinAs(t, addr, composed); got != "ERR|username taken" {
+		t.Errorf("JOIN as %q got %q, want ERR|username taken", composed, got)
+	}
+	if _, ok := srv.Seen(decomposed); !ok {
+		t.Errorf("Seen(%q) found nobody", decomposed)
+	}
+
+	srv.Ban(decomposed, "testing")
+	if _, ok := srv.banned(composed); !ok {
+		t.Errorf("Ban(%q) didn't ban %q", decomposed, composed)
+	}
+}
+
+func TestBanCoversVariants(t *testing.T) {
+	srv := New(WithConfusableNames())
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	addr := srv.Addr().String()
+
+	srv.Ban("ALICE", "spam")
+	for _, name := range []string{"alice", "Alice", "ａｌｉｃｅ", "аlice"} {
+		if got := joinAs(t, addr, name); got != "ERR|spam" {
+			t.Errorf("JOIN as banned %q got %q, want ERR|spam", name, got)
+		}
+	}
+	if got := srv.Bans(); got["ALICE"] != "spam" || len(got) != 1 {
+		t.Errorf("Bans() = %v, want the name as banned", got)
+	}
+	if !srv.Unban("alice") {
+		t.Error("Unban(alice) = false, want true")
+	}
+
+	srv.ShadowBan("troll")
+	if !srv.shadowBanned("TROLL") || !srv.shadowBanned("ｔｒｏｌｌ") {
+		t.Error("shadow ban on troll doesn't cover TROLL or fullwidth ｔｒｏｌｌ")
+	}
+
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	if err := srv.Kick("BOB", ""); err != nil {
+		t.Errorf("Kick(BOB) error = %v, want bob kicked", err)
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// notifyLevel is which chat messages a client wants pushed to it.
+type notifyLevel int32
+
+const (
+	notifyAll notifyLevel = iota
+	notifyMentions
+	notifyNone
+)
+
+var notifyLevels = map[string]notifyLevel{
+	protocol.NotifyAll:      notifyAll,
+	protocol.NotifyMentions: notifyMentions,
+	protocol.NotifyNone:     notifyNone,
+}
+
+// setNotify handles a NOTIFY request, answering with an error for an
+// unknown level.
+func (c *ConnectedClient) setNotify(level string) {
+	l, ok := notifyLevels[level]
+	if !ok {
+		c.sendError("unknown notification level " + level + `: use "all", "mentions" or "none"`)
+		return
+	}
+	c.notify.Store(int32(l))
+}
+
+// wants reports whether the client's notification level lets msg through.
+// Only chat messages are filtered.
+func (c *ConnectedClient) wants(msg protocol.Message) bool {
+	if msg.Type != protocol.TypeMsg && msg.Type != protocol.TypeAttached {
+		return true
+	}
+	switch notifyLevel(c.notify.Load()) {
+	case notifyMentions:
+		return c.mentions(msg.Body)
+	case notifyNone:
+		return false
+	default:
+		return true
+	}
+}
+
+// mentions reports whether text mentions the client's username, with or
+// without a leading "@", as a whole word in any case.
+func (c *ConnectedClient) mentions(text string) bool {
+	return mentions(text, c.username)
+}
+
+// mentions reports whether name appears in text, ignoring case, with no
+// letter or digit right before or after it. The boundaries are checked by
+// hand because regexp's \b only knows ASCII, so it would never find
+// names like "José" or "bob-".
+func mentions(text, name string) bool {
+	text, name = strings.ToLower(text), strings.ToLower(name)
+	if name == "" {
+		return false
+	}
+	for start := 0; start < len(text); {
+		i := strings.Index(text[start:], name)
+		if i < 0 {
+			return false
+		}
+		i += start
+		end := i + len(name)
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[end:])
+		if !wordRune(before) && !wordRune(after) {
+			return true
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		start = i + size
+	}
+	return false
+}
+
+// wordRune reports whether r continues a word, as "_" does in "bob_2".
+// The utf8.RuneError that marks the start or end of the text doesn't.
+func wordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNotify(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	bot := connectClient(t, addr, "bot")
+	defer bot.Close()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	bot.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(bot)
+	expect := func(want string) {
+		t.Helper()
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("bot got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	expect("JOINED|alice|2")
+
+	fmt.Fprintf(bot, "NOTIFY|loud\n")
+	expect(`ERR|unknown notification level loud: use "all", "mentions" or "none"`)
+
+	fmt.Fprintf(bot, "NOTIFY|mentions\n")
+	fmt.Fprintf(bot, "WHO\n")
+	expect("WHO|alice|bot")
+	fmt.Fprintf(alice, "SEND|good morning\nSEND|@bot weather\n")
+	expect("MSG|alice|@bot weather")
+
+	fmt.Fprintf(bot, "NOTIFY|none\n")
+	fmt.Fprintf(bot, "WHO\n")
+	expect("WHO|alice|bot")
+	fmt.Fprintf(alice, "SEND|@bot are you there\n")
+	// Joins aren't chat messages, so they still arrive.
+	carol := connectClient(t, addr, "carol")
+	defer carol.Close()
+	expect("JOINED|carol|3")
+
+	fmt.Fprintf(bot, "NOTIFY|all\n")
+	fmt.Fprintf(bot, "WHO\n")
+	expect("WHO|alice|bot|carol")
+	fmt.Fprintf(alice, "SEND|hello\n")
+	expect("MSG|alice|hello")
+}
+
+func TestMentions(t *testing.T) {
+	for _, tt := range []struct {
+		text, name string
+		want       bool
+	}{
+		{"@bot weather", "bot", true},
+		{"hey BOT!", "bot", true},
+		{"robot uprising", "bot", false},
+		{"bot_2 is here", "bot", false},
+		{"¿José?", "josé", true},
+		{"@José hola", "José", true},
+		{"Josého", "José", false},
+		{"ask bob- about it", "bob-", true},
+		{"bob-bob-", "bob-", true},
+		{"über alles", "über", true},
+		{"sauber", "ber", false},
+	} {
+		if got := mentions(tt.text, tt.name); got != tt.want {
+			t.Errorf("mentions(%q, %q) = %v, want %v", tt.text, tt.name, got, tt.want)
+		}
+	}
+}
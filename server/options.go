@@ -0,0 +1,226 @@
+package server
+
+import (
+	"maps"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pankaj/simple-chat/netopt"
+	"github.com/pankaj/simple-chat/ratelimit"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a ChatServer.
+type Option func(*ChatServer)
+
+// WithNetwork selects the address family to bind: "tcp" for dual-stack
+// (the default), "tcp4" for IPv4 only or "tcp6" for IPv6 only. Other values
+// make Listen fail.
+func WithNetwork(network string) Option {
+	return func(s *ChatServer) {
+		s.network = network
+	}
+}
+
+// WithTracerProvider enables OpenTelemetry tracing of connection lifecycles,
+// JOIN handling and broadcast fan-out using the given provider. Tracing is
+// a no-op when this option is not set.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(s *ChatServer) {
+		s.tracer = tp.Tracer(tracerName)
+	}
+}
+
+// WithReconnectHint sets the hint sent to clients in the RECONNECT notice
+// when the server drains, typically the address of another instance.
+func WithReconnectHint(hint string) Option {
+	return func(s *ChatServer) {
+		s.reconnectHint = hint
+	}
+}
+
+// WithProxyProtocol requires every accepted connection to start with a
+// PROXY protocol v1 or v2 header, as sent by HAProxy or AWS NLB, so the
+// server sees real client addresses. Connections without a valid header
+// are closed.
+func WithProxyProtocol() Option {
+	return func(s *ChatServer) {
+		s.proxyProtocol = true
+	}
+}
+
+// WithPlainText lets people join by typing "join <name>" with nothing but
+// nc or telnet. Connections whose first line isn't a protocol message are
+// treated as plain text: each typed line is sent as a message, a few
+// slash commands are understood, and messages are shown as readable text.
+// Because the first line may be typed by hand, every client gets a minute
+// rather than a few seconds to send it.
+func WithPlainText() Option {
+	return func(s *ChatServer) {
+		s.plainText = true
+	}
+}
+
+// WithMigrationKey sets the secret that signs and checks the tokens users
+// moved by Migrate rejoin with. Every server users are moved between must
+// have the same key.
+func WithMigrationKey(key []byte) Option {
+	return func(s *ChatServer) {
+		s.migrationKey = key
+	}
+}
+
+// WithStateFile sets the file SaveState writes a Snapshot to and
+// LoadState restores one from.
+func WithStateFile(path string) Option {
+	return func(s *ChatServer) {
+		s.stateFile = path
+	}
+}
+
+// WithTCPOptions tunes the TCP connections accepted by Listen, ListenIRC
+// and ListenSSH: keep-alives, Nagle's algorithm, socket buffers and
+// linger.
+func WithTCPOptions(o netopt.TCP) Option {
+	return func(s *ChatServer) {
+		s.tcp = o
+	}
+}
+
+// WithAcceptLimit limits how fast connections are accepted, overall and
+// per address, so connection storms are shed cheaply. Refused
+// connections are counted in AcceptStats.
+func WithAcceptLimit(l AcceptLimit) Option {
+	return func(s *ChatServer) {
+		s.accepts = newAcceptLimiter(l)
+	}
+}
+
+// WithMessageRate limits each connection to rate messages per second, in
+// bursts of up to burst. Messages over the limit are refused with an ERR,
+// or a NACK for SENDID and SENDRCPT. The limit is listed in the OK as
+// protocol.CapRate, so clients can pace themselves.
+func WithMessageRate(rate float64, burst int) Option {
+	return func(s *ChatServer) {
+		s.messageRate, s.messageBurst = rate, burst
+	}
+}
+
+// WithJoinLimit limits each client address to limit JOINs in any period,
+// so a misbehaving client can't cycle through usernames. JOINs over the
+// limit are rejected before the username is looked at.
+func WithJoinLimit(limit int, period time.Duration) Option {
+	return func(s *ChatServer) {
+		s.joins = ratelimit.NewKeyed(func() *ratelimit.Window {
+			return ratelimit.NewWindow(limit, period)
+		})
+	}
+}
+
+// WithJoinThrottle delays and then blocks JOINs from addresses that keep
+// failing to join; see JoinThrottle. What it has done is counted in
+// JoinThrottleStats.
+func WithJoinThrottle(t JoinThrottle) Option {
+	return func(s *ChatServer) {
+		s.joinThrottle = newJoinThrottle(t)
+	}
+}
+
+// WithConfusableNames treats usernames that differ only in lookalike
+// letters, such as "alice" and "аlice" with a Cyrillic "а", as the same
+// name: only one of them can be online at once, and reserving one
+// reserves both. Names differing only in case are always the same.
+func WithConfusableNames() Option {
+	return func(s *ChatServer) {
+		s.confusableNames = true
+	}
+}
+
+// WithSpamPolicy enables detection of clients that repeatedly send the
+// same message, independent of any rate limiting.
+func WithSpamPolicy(p SpamPolicy) Option {
+	return func(s *ChatServer) {
+		s.spamPolicy = &p
+	}
+}
+
+// WithQuotas limits how many messages each user may send per hour and
+// per day, according to their class. Messages over quota are refused
+// with an ERR, or a NACK for SENDID. Usage is counted per username and
+// survives reconnects.
+func WithQuotas(p QuotaPolicy) Option {
+	return func(s *ChatServer) {
+		s.quotas = newQuotaTracker(p)
+	}
+}
+
+// WithCommand registers fn to run when a user sends a chat message
+// starting with "/name", which is then not broadcast. Names are matched
+// without regard to case, and a later registration replaces an earlier
+// one or the built-in command of that name, such as "who". A nil fn
+// removes the command.
+func WithCommand(name string, fn CommandFunc) Option {
+	return func(s *ChatServer) {
+		if fn == nil {
+			delete(s.commands, strings.ToLower(name))
+			return
+		}
+		s.commands[strings.ToLower(name)] = fn
+	}
+}
+
+// WithTranslations has the server translate what it says to users who
+// ask for locale when they join, such as errors, notices and the message
+// of the day. Users whose locale has no translation for a message get
+// its base language's, so "pt-BR" can use "pt", and then the English.
+// Translations for the same locale given twice are merged.
+func WithTranslations(locale string, t Translations) Option {
+	return func(s *ChatServer) {
+		if s.locales == nil {
+			s.locales = make(map[string]*catalog)
+		}
+		merged := make(Translations)
+		if c, ok := s.locales[canonicalLocale(locale)]; ok {
+			maps.Copy(merged, c.source)
+		}
+		maps.Copy(merged, t)
+		s.locales[canonicalLocale(locale)] = newCatalog(merged)
+	}
+}
+
+// WithAttachments lets users share files: they upload them to
+// AttachmentHandler and send the ID it returns in an ATTACH message,
+// which is relayed to everyone as ATTACHED with the file's URL.
+func WithAttachments(p AttachmentPolicy) Option {
+	return func(s *ChatServer) {
+		s.attachments = newAttachmentStore(p)
+	}
+}
+
+// WithJoinChallenge makes joining clients solve a proof of work, answer a
+// question, or both, before their JOIN is accepted. Clients that don't
+// understand CHALLENGE messages can't join.
+func WithJoinChallenge(c JoinChallenge) Option {
+	return func(s *ChatServer) {
+		s.joinChallenge = c
+	}
+}
+
+// WithGeoPolicy checks the country of every connecting client's address,
+// after any PROXY protocol header, and refuses those the policy doesn't
+// admit. Refusals are logged and counted in GeoDenied.
+func WithGeoPolicy(p GeoPolicy) Option {
+	return func(s *ChatServer) {
+		s.geo = newGeoFilter(p)
+	}
+}
+
+// WithListenFunc replaces net.Listen for the chat, IRC and SSH listeners,
+// typically to serve sockets inherited from the process being upgraded
+// rather than binding new ones. Addresses are still validated first.
+func WithListenFunc(listen func(network, addr string) (net.Listener, error)) Option {
+	return func(s *ChatServer) {
+		s.listen = listen
+	}
+}
@@ -0,0 +1,287 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// plainJoinTimeout replaces the usual JOIN deadline when plain-text mode is
+// on, since the first line may be typed by hand.
+const plainJoinTimeout = time.Minute
+
+// plainHelp is shown to plain-text users for /help.
+const plainHelp = `Type a line to send it. Commands:
+  /who             list connected users
+  /away [note]     mark yourself as away
+  /back            mark yourself as present again
+  /status [text]   set your status, or clear it
+  /seen <user>     show when a user was last here
+  /dnd on|off      hold back messages that don't mention you
+  /stats           show server statistics
+  /ping            check the server is there
+  /quit            leave the chat
+Start a line with // to send a message beginning with /.`
+
+// detectPlain reads the first line from conn. If it looks like a protocol
+// message, conn is returned with the line put back; otherwise the client
+// is taken to be a person typing, for instance with nc, and conn is
+// wrapped to translate between their lines and protocol messages.
+// Protocol messages always contain a "|" apart from a few that make no
+// sense as a first line, so a first line without one is plain text.
+func detectPlain(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReaderSize(conn, protocol.MaxLineLength)
+	line, err := r.ReadSlice('\n')
+	if err != nil && len(line) == 0 {
+		return nil, err
+	}
+	first := bytes.Clone(line)
+	replay := bufio.NewReader(io.MultiReader(bytes.NewReader(first), r))
+	if bytes.IndexByte(first, '|') >= 0 {
+		return &proxyConn{Conn: conn, r: replay}, nil
+	}
+
+	lines := bufio.NewReaderSize(replay, protocol.MaxLineLength)
+	readLine := func() (string, error) {
+		line, err := lines.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			return "", fmt.Errorf("line longer than %d bytes", protocol.MaxLineLength)
+		}
+		if err != nil && len(line) == 0 {
+			return "", err
+		}
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+	return &plainConn{Conn: conn, readLine: readLine, out: conn}, nil
+}
+
+// plainConn translates a person's typed lines into protocol lines on Read,
+// and protocol lines written to it into readable text. The server handles
+// it like any other connection.
+type plainConn struct {
+	net.Conn
+	readLine func() (string, error) // the next typed line, without its newline
+	out      io.Writer              // where text for the person goes
+	pending  []byte                 // translated input not yet read
+
+	// authenticated is set when the transport vouches for the username,
+	// as SSH does, so the user may take a reserved name.
+	authenticated bool
+
+	mu        sync.Mutex // guards writes to out and the fields below
+	username  string     // set once the user has typed "join <name>"
+	answering bool       // the next line answers a join question
+}
+
+func (c *plainConn) Read(p []byte) (int, error) {
+	for len(c.pending) == 0 {
+		line, err := c.readLine()
+		if err != nil {
+			return 0, err
+		}
+		msg, reply := c.translate(line)
+		if reply != "" {
+			c.writeText(reply)
+		}
+		if msg.Type != "" {
+			c.pending = []byte(protocol.Encode(msg) + "\n")
+		}
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// translate turns a typed line into the protocol message to pass on, if
+// any, and a reply to show the user straight away, if any.
+func (c *plainConn) translate(line string) (protocol.Message, string) {
+	line = strings.TrimSpace(line)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.username == "" {
+		cmd, name, _ := strings.Cut(strings.TrimPrefix(line, "/"), " ")
+		name = strings.TrimSpace(name)
+		if !strings.EqualFold(cmd, "join") || name == "" {
+			return protocol.Message{}, `Type "join <name>" to join the chat.`
+		}
+		c.username = protocol.NormalizeUsername(name)
+		return protocol.Message{Type: protocol.TypeJoin, Username: c.username}, ""
+	}
+	if c.answering {
+		c.answering = false
+		return protocol.Message{Type: protocol.TypeAnswer, Body: line}, ""
+	}
+
+	switch {
+	case line == "":
+		return protocol.Message{}, ""
+	case !strings.HasPrefix(line, "/"), strings.HasPrefix(line, "//"):
+		// The server unescapes "//".
+		return protocol.Message{Type: protocol.TypeSend, Body: line}, ""
+	}
+
+	cmd, args, _ := strings.Cut(line[1:], " ")
+	args = strings.TrimSpace(args)
+	switch strings.ToLower(cmd) {
+	case "who":
+		return protocol.Message{Type: protocol.TypeWho}, ""
+	case "away":
+		return protocol.Message{Type: protocol.TypeAway, Body: args}, ""
+	case "back":
+		return protocol.Message{Type: protocol.TypeBack}, ""
+	case "seen":
+		if args == "" {
+			return protocol.Message{}, "Usage: /seen <user>"
+		}
+		return protocol.Message{Type: protocol.TypeSeen, Username: strings.TrimPrefix(args, "@")}, ""
+	case "status":
+		return protocol.Message{Type: protocol.TypeStatus, Body: protocol.EncodeStatus(protocol.Status{Text: args})}, ""
+	case "dnd":
+		if args != "on" && args != "off" {
+			return protocol.Message{}, "Usage: /dnd on|off"
+		}
+		return protocol.Message{Type: protocol.TypeDND, Body: args}, ""
+	case "stats":
+		return protocol.Message{Type: protocol.TypeStats}, ""
+	case "ping":
+		return protocol.Message{Type: protocol.TypePing}, ""
+	case "quit", "leave":
+		return protocol.Message{Type: protocol.TypeLeave}, ""
+	case "help":
+		return protocol.Message{}, plainHelp
+	default:
+		// Leave it to the server's commands, which answer unknown ones.
+		return protocol.Message{Type: protocol.TypeSend, Body: line}, ""
+	}
+}
+
+// Write shows each protocol line in p as text. The server writes whole
+// lines, so p never ends part way through one.
+func (c *plainConn) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimSuffix(string(p), "\n"), "\n") {
+		msg, err := protocol.Decode(line)
+		text := line
+		if err == nil {
+			text = c.format(msg)
+		}
+		if text == "" {
+			continue
+		}
+		if err := c.writeText(text); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (c *plainConn) writeText(text string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, err := io.WriteString(c.out, text+"\n")
+	return err
+}
+
+// online describes a member count for join and leave lines, or returns
+// "" if the count is unknown.
+func online(members int) string {
+	if members == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (%d online)", members)
+}
+
+// format renders msg for a person, or returns "" to show nothing.
+func (c *plainConn) format(msg protocol.Message) string {
+	switch msg.Type {
+	case protocol.TypeOK:
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return fmt.Sprintf("Welcome, %s! Type a line to send it, or /help for commands.", c.username)
+	case protocol.TypeMsg:
+		return fmt.Sprintf("<%s> %s", msg.Username, msg.Body)
+	case protocol.TypeAttached:
+		return fmt.Sprintf("<%s> %s", msg.Username, strings.TrimSpace(msg.Body+" "+msg.Attachment))
+	case protocol.TypeJoined:
+		return fmt.Sprintf("* %s joined%s", msg.Username, online(msg.Members))
+	case protocol.TypeLeft:
+		return fmt.Sprintf("* %s left%s", msg.Username, online(msg.Members))
+	case protocol.TypePresence:
+		p, err := protocol.ParsePresence(msg.Body)
+		switch {
+		case err != nil:
+			return ""
+		case !p.Away:
+			return fmt.Sprintf("* %s is back", msg.Username)
+		case p.Note != "":
+			return fmt.Sprintf("* %s is away: %s", msg.Username, p.Note)
+		default:
+			return fmt.Sprintf("* %s is away", msg.Username)
+		}
+	case protocol.TypeUserStatus:
+		if msg.Body == "" {
+			return fmt.Sprintf("* %s cleared their status", msg.Username)
+		}
+		return fmt.Sprintf("* %s's status: %s", msg.Username, msg.Body)
+	case protocol.TypeSeen:
+		seen, err := protocol.ParseSeen(msg.Body)
+		switch {
+		case err != nil:
+			return fmt.Sprintf("* %s hasn't been seen", msg.Username)
+		case seen.Online:
+			return fmt.Sprintf("* %s is online", msg.Username)
+		default:
+			return fmt.Sprintf("* %s was last seen %s", msg.Username, ago(seen.At, time.Now()))
+		}
+	case protocol.TypeWho:
+		return "Online: " + strings.ReplaceAll(msg.Body, "|", ", ")
+	case protocol.TypeStats:
+		st, err := protocol.ParseStats(msg.Body)
+		if err != nil {
+			return ""
+		}
+		return fmt.Sprintf("%d users, %d messages, up %s", st.Users, st.Messages, st.Uptime)
+	case protocol.TypePong:
+		return "pong"
+	case protocol.TypeNotice:
+		return "* " + msg.Body
+	case protocol.TypeMode:
+		return "* " + describeMode(msg.Body)
+	case protocol.TypeErr:
+		if msg.Body == protocol.ReasonNameReserved {
+			return "! That username is reserved for a registered user"
+		}
+		return "! " + msg.Body
+	case protocol.TypeNack:
+		return "! Message not sent: " + msg.Body
+	case protocol.TypeKicked:
+		return "* You were disconnected: " + msg.Body
+	case protocol.TypeReconnect:
+		if msg.Body != "" {
+			return "* The server is restarting; reconnect to " + msg.Body
+		}
+		return "* The server is restarting; please reconnect"
+	case protocol.TypeMigrate:
+		return "* You are being moved to another server; reconnect to " + msg.Body
+	case protocol.TypeChallenge:
+		ch, err := protocol.ParseChallenge(msg.Body)
+		if err != nil || ch.Kind != protocol.ChallengeQuestion {
+			return "! This server asks for a proof of work; join with the chat client instead."
+		}
+		c.mu.Lock()
+		c.answering = true
+		c.mu.Unlock()
+		return "? " + ch.Question
+	case protocol.TypeAck, protocol.TypeDelivered:
+		return ""
+	default:
+		return protocol.Encode(msg)
+	}
+}
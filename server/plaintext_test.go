@@ -0,0 +1,77 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPlainText(t *testing.T) {
+	srv := New(WithPlainText())
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	// bob uses the protocol, which must work as before.
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	bobLines := bufio.NewScanner(bob)
+
+	conn, err := net.DialTimeout("tcp", srv.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	lines := bufio.NewScanner(conn)
+	expect := func(want string) {
+		t.Helper()
+		if !lines.Scan() {
+			t.Fatalf("reading %q: %v", want, lines.Err())
+		}
+		if got := lines.Text(); got != want {
+			t.Fatalf("got %q, want %q", got, want)
+		}
+	}
+
+	fmt.Fprint(conn, "hello\r\n")
+	expect(`Type "join <name>" to join the chat.`)
+	fmt.Fprint(conn, "join alice\r\n")
+	expect("Welcome, alice! Type a line to send it, or /help for commands.")
+
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if !bobLines.Scan() || bobLines.Text() != "JOINED|alice|2" {
+		t.Fatalf("bob got %q, want JOINED|alice|2", bobLines.Text())
+	}
+
+	fmt.Fprint(conn, "hi there | everyone\n")
+	if !bobLines.Scan() || bobLines.Text() != "MSG|alice|hi there | everyone" {
+		t.Fatalf("bob got %q, want alice's message", bobLines.Text())
+	}
+	fmt.Fprint(conn, "//shrug\n")
+	if !bobLines.Scan() || bobLines.Text() != "MSG|alice|/shrug" {
+		t.Fatalf("bob got %q, want /shrug", bobLines.Text())
+	}
+
+	fmt.Fprintf(bob, "SEND|hello alice\n")
+	expect("<bob> hello alice")
+
+	fmt.Fprint(conn, "/frobnicate\n")
+	expect("! unknown command /frobnicate")
+	fmt.Fprint(conn, "/who\n")
+	expect("Online: alice, bob")
+	carol := connectClient(t, srv.Addr().String(), "carol")
+	expect("* carol joined (3 online)")
+	carol.Close()
+	expect("* carol left (2 online)")
+	bobLines.Scan() // JOINED|carol|3
+	bobLines.Scan() // LEFT|carol|2
+
+	fmt.Fprint(conn, "/quit\n")
+	if !bobLines.Scan() || bobLines.Text() != "LEFT|alice|1" {
+		t.Fatalf("bob got %q, want LEFT|alice|1", bobLines.Text())
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// proxyV2Signature prefixes every PROXY protocol v2 header.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// maxProxyV1Length is the longest valid v1 header, including CRLF.
+const maxProxyV1Length = 107
+
+// ErrInvalidProxyHeader is returned when a connection does not start with a
+// valid PROXY protocol header.
+var ErrInvalidProxyHeader = errors.New("invalid PROXY protocol header")
+
+// proxyConn is a connection whose PROXY header has been consumed. Reads are
+// served from the buffered reader so bytes sent right after the header are
+// not lost, and RemoteAddr reports the original client address.
+type proxyConn struct {
+	net.Conn
+	r      *bufio.Reader
+	remote net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	if c.remote == nil {
+		return c.Conn.RemoteAddr()
+	}
+	return c.remote
+}
+
+// readProxyHeader consumes a PROXY protocol v1 or v2 header from conn and
+// returns a connection reporting the client address it carried. Headers for
+// LOCAL connections (e.g. load balancer health checks) and UNKNOWN
+// families keep the socket's own remote address.
+func readProxyHeader(conn net.Conn) (net.Conn, error) {
+	r := bufio.NewReader(conn)
+
+	peek, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	var remote net.Addr
+	if bytes.Equal(peek, proxyV2Signature) {
+		remote, err = parseProxyV2(r)
+	} else {
+		remote, err = parseProxyV1(r)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &proxyConn{Conn: conn, r: r, remote: remote}, nil
+}
+
+// parseProxyV1 parses the human-readable form, e.g.
+// "PROXY TCP4 203.0.113.7 10.0.0.1 56324 8080\r\n".
+func parseProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for len(line) < maxProxyV1Length {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, ErrInvalidProxyHeader
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return nil, ErrInvalidProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil || (fields[1] == "TCP4") != (ip.To4() != nil) {
+		return nil, ErrInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// parseProxyV2 parses the binary form. TLVs after the addresses are skipped.
+func parseProxyV2(r *bufio.Reader) (net.Addr, error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	verCmd, family := hdr[12], hdr[13]
+	if verCmd>>4 != 2 {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, ErrInvalidProxyHeader
+	}
+
+	switch verCmd & 0x0F {
+	case 0x0: // LOCAL
+		return nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, ErrInvalidProxyHeader
+	}
+
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		if len(payload) < 12 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:4]),
+			Port: int(binary.BigEndian.Uint16(payload[8:10])),
+		}, nil
+	case 0x2: // AF_INET6
+		if len(payload) < 36 {
+			return nil, ErrInvalidProxyHeader
+		}
+		return &net.TCPAddr{
+			IP:   net.IP(payload[0:16]),
+			Port: int(binary.BigEndian.Uint16(payload[32:34])),
+		}, nil
+	default: // AF_UNSPEC or AF_UNIX
+		return nil, nil
+	}
+}
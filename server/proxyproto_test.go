@@ -0,0 +1,160 @@
+package server
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// pipeWith returns the server side of a pipe after writing data to the
+// client side in the background.
+func pipeWith(t *testing.T, data []byte) net.Conn {
+	t.Helper()
+	server, client := net.Pipe()
+	t.Cleanup(func() { server.Close(); client.Close() })
+	go func() {
+		client.Write(data)
+	}()
+	return server
+}
+
+func proxyV2Header(cmd, family byte, addrs []byte) []byte {
+	hdr := append([]byte{}, proxyV2Signature...)
+	hdr = append(hdr, 0x20|cmd, family)
+	hdr = binary.BigEndian.AppendUint16(hdr, uint16(len(addrs)))
+	return append(hdr, addrs...)
+}
+
+func TestReadProxyHeaderV1(t *testing.T) {
+	conn := pipeWith(t, []byte("PROXY TCP4 203.0.113.7 10.0.0.1 56324 8080\r\nJOIN|alice\n"))
+
+	pc, err := readProxyHeader(conn)
+	if err != nil {
+		t.Fatalf("readProxyHeader() error = %v", err)
+	}
+	if got := pc.RemoteAddr().String(); got != "203.0.113.7:56324" {
+		t.Errorf("RemoteAddr() = %q, want 203.0.113.7:56324", got)
+	}
+
+	// Bytes following the header must still be readable.
+	line, err := bufio.NewReader(pc).ReadString('\n')
+	if err != nil || line != "JOIN|alice\n" {
+		t.Errorf("read after header = %q, %v", line, err)
+	}
+}
+
+func TestReadProxyHeaderV1Unknown(t *testing.T) {
+	conn := pipeWith(t, []byte("PROXY UNKNOWN\r\n"))
+
+	pc, err := readProxyHeader(conn)
+	if err != nil {
+		t.Fatalf("readProxyHeader() error = %v", err)
+	}
+	if pc.RemoteAddr() != conn.RemoteAddr() {
+		t.Errorf("RemoteAddr() = %v, want socket address %v", pc.RemoteAddr(), conn.RemoteAddr())
+	}
+}
+
+func TestReadProxyHeaderV2(t *testing.T) {
+	v4 := []byte{198, 51, 100, 9, 10, 0, 0, 1, 0xC3, 0x50, 0x1F, 0x90}
+	v6 := make([]byte, 36)
+	copy(v6, net.ParseIP("2001:db8::1"))
+	binary.BigEndian.PutUint16(v6[32:], 4242)
+
+	tests := []struct {
+		name string
+		hdr  []byte
+		want string
+	}{
+		{"IPv4", proxyV2Header(0x1, 0x11, v4), "198.51.100.9:50000"},
+		{"IPv6", proxyV2Header(0x1, 0x21, v6), "[2001:db8::1]:4242"},
+		{"IPv4 with TLVs", proxyV2Header(0x1, 0x11, append(v4, 0x04, 0x00, 0x01, 0xFF)), "198.51.100.9:50000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := pipeWith(t, append(tt.hdr, "OK\n"...))
+			pc, err := readProxyHeader(conn)
+			if err != nil {
+				t.Fatalf("readProxyHeader() error = %v", err)
+			}
+			if got := pc.RemoteAddr().String(); got != tt.want {
+				t.Errorf("RemoteAddr() = %q, want %q", got, tt.want)
+			}
+			rest := make([]byte, 3)
+			if _, err := io.ReadFull(pc, rest); err != nil || string(rest) != "OK\n" {
+				t.Errorf("read after header = %q, %v", rest, err)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderV2Local(t *testing.T) {
+	conn := pipeWith(t, proxyV2Header(0x0, 0x00, nil))
+	pc, err := readProxyHeader(conn)
+	if err != nil {
+		t.Fatalf("readProxyHeader() error = %v", err)
+	}
+	if pc.RemoteAddr() != conn.RemoteAddr() {
+		t.Errorf("RemoteAddr() = %v, want socket address", pc.RemoteAddr())
+	}
+}
+
+func TestReadProxyHeaderInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"plain JOIN", []byte("JOIN|alice\nSEND|hi\n")},
+		{"v1 missing CRLF", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 1 2\n")},
+		{"v1 bad family", []byte("PROXY UDP4 1.2.3.4 5.6.7.8 1 2\r\n")},
+		{"v1 mismatched family", []byte("PROXY TCP4 ::1 ::1 1 2\r\n")},
+		{"v1 bad port", []byte("PROXY TCP4 1.2.3.4 5.6.7.8 99999 2\r\n")},
+		{"v2 bad version", append(append([]byte{}, proxyV2Signature...), 0x11, 0x11, 0, 0)},
+		{"v2 short IPv4", proxyV2Header(0x1, 0x11, []byte{1, 2, 3})},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := pipeWith(t, tt.data)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+			if _, err := readProxyHeader(conn); err == nil {
+				t.Error("readProxyHeader() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestProxyProtocolJoin(t *testing.T) {
+	srv := New(WithProxyProtocol())
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	conn, err := net.Dial("tcp", srv.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "PROXY TCP4 203.0.113.7 10.0.0.1 56324 8080\r\n%s\n",
+		protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "alice"}))
+	msg, err := protocol.Decode(readLine(t, conn, 2*time.Second))
+	if err != nil || msg.Type != protocol.TypeOK {
+		t.Fatalf("expected OK, got %+v (%v)", msg, err)
+	}
+
+	srv.mu.RLock()
+	c := srv.clients["alice"]
+	srv.mu.RUnlock()
+	if got := c.conn.RemoteAddr().String(); got != "203.0.113.7:56324" {
+		t.Errorf("client address = %q, want 203.0.113.7:56324", got)
+	}
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+	"github.com/quic-go/quic-go"
+)
+
+// quicLinger is how long a closed QUIC connection waits for the peer to
+// read what was last written, such as an ERR or KICKED, before it is torn
+// down.
+const quicLinger = 2 * time.Second
+
+// quicConfig keeps idle connections alive through NAT and lets clients
+// carry on across address changes.
+var quicConfig = &quic.Config{
+	KeepAlivePeriod: 15 * time.Second,
+	MaxIdleTimeout:  time.Minute,
+}
+
+// ListenQUIC binds addr over UDP and accepts clients using the QUIC
+// transport. It is experimental. tlsConf must hold the server's
+// certificate; its NextProtos is replaced with protocol.QUICProtocol. Call
+// it after Listen; Shutdown closes it with the other listeners. The PROXY
+// protocol setting doesn't apply.
+func (s *ChatServer) ListenQUIC(addr string, tlsConf *tls.Config) error {
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{protocol.QUICProtocol}
+	ln, err := quic.ListenAddr(addr, tlsConf, quicConfig)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	// Hold the lifecycle lock so a concurrent Shutdown either sees the
+	// listener and closes it or happens before it is added.
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.startLocked()
+	s.quicListener = ln
+	s.wg.Add(1)
+	go s.serveQUIC(ln)
+	return nil
+}
+
+// QUICAddr returns the address of the QUIC listener, or nil if ListenQUIC
+// hasn't been called.
+func (s *ChatServer) QUICAddr() net.Addr {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.quicListener == nil {
+		return nil
+	}
+	return s.quicListener.Addr()
+}
+
+// serveQUIC runs the accept loop for the QUIC listener.
+func (s *ChatServer) serveQUIC(ln *quic.Listener) {
+	defer s.wg.Done()
+	quit := s.run.Load().quit
+	s.counters.acceptLoops.Add(1)
+	defer s.counters.acceptLoops.Add(-1)
+	for {
+		qc, err := ln.Accept(context.Background())
+		if err != nil {
+			// Accept only fails once the listener is closed.
+			select {
+			case <-quit:
+			default:
+				log.Printf("quic accept error: %v", err)
+			}
+			return
+		}
+		if !s.admit(qc.RemoteAddr()) {
+			qc.CloseWithError(0, "too many connections")
+			continue
+		}
+		s.wg.Add(1)
+		go func() {
+			ctx, cancel := context.WithTimeout(qc.Context(), 5*time.Second)
+			defer cancel()
+			stream, err := qc.AcceptStream(ctx)
+			if err != nil {
+				qc.CloseWithError(0, "no stream opened")
+				s.wg.Done()
+				return
+			}
+			s.handleConnection(&quicConn{Stream: stream, conn: qc}, false, false)
+		}()
+	}
+}
+
+// quicConn presents a QUIC connection's stream as a net.Conn.
+type quicConn struct {
+	*quic.Stream
+	conn *quic.Conn
+}
+
+func (c *quicConn) LocalAddr() net.Addr  { return c.conn.LocalAddr() }
+func (c *quicConn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// Close ends the stream, unblocking any Read, and closes the connection
+// once the peer has closed its side or quicLinger has passed.
+func (c *quicConn) Close() error {
+	c.Stream.CancelRead(0)
+	err := c.Stream.Close()
+	go func() {
+		select {
+		case <-c.conn.Context().Done():
+		case <-time.After(quicLinger):
+		}
+		c.conn.CloseWithError(0, "")
+	}()
+	return err
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+	"github.com/quic-go/quic-go"
+)
+
+// selfSignedCert returns a certificate for localhost, valid for an hour.
+func selfSignedCert(t *testing.T) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestQUIC(t *testing.T) {
+	srv := startServer(t)
+	cert := selfSignedCert(t)
+	if err := srv.ListenQUIC("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}}); err != nil {
+		t.Fatalf("ListenQUIC: %v", err)
+	}
+
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	qc, err := quic.DialAddr(ctx, srv.QUICAddr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{protocol.QUICProtocol},
+	}, nil)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer qc.CloseWithError(0, "")
+	stream, err := qc.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.SetDeadline(time.Now().Add(5 * time.Second))
+	lines := bufio.NewScanner(stream)
+
+	fmt.Fprintf(stream, "JOIN|alice\n")
+	if !lines.Scan() || lines.Text() != "OK|format commands|2" {
+		t.Fatalf("got %q (%v), want OK", lines.Text(), lines.Err())
+	}
+	fmt.Fprintf(bob, "SEND|hello over tcp\n")
+	for lines.Scan() {
+		if lines.Text() == "MSG|bob|hello over tcp" {
+			return
+		}
+	}
+	t.Fatalf("no message from bob: %v", lines.Err())
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Quota limits how many messages a user may send per clock hour and per
+// UTC day. Zero fields are unlimited.
+type Quota struct {
+	PerHour int
+	PerDay  int
+}
+
+// QuotaPolicy assigns quotas by user class, so a community server can
+// give trusted users and bots more room than everyone else.
+type QuotaPolicy struct {
+	Classes      map[string]Quota  // class name -> quota
+	Users        map[string]string // username -> class name
+	DefaultClass string            // class of users not in Users; "" is unlimited
+}
+
+// quotaFor returns the quota that applies to username.
+func (p QuotaPolicy) quotaFor(username string) Quota {
+	class, ok := p.Users[username]
+	if !ok {
+		class = p.DefaultClass
+	}
+	return p.Classes[class]
+}
+
+type quotaUsage struct {
+	hour, day     time.Time // the windows the counts belong to
+	inHour, inDay int
+}
+
+// quotaTracker counts messages per username rather than per connection,
+// so reconnecting doesn't reset a user's usage.
+type quotaTracker struct {
+	policy QuotaPolicy
+
+	mu    sync.Mutex
+	day   time.Time // current day, for sweeping stale entries
+	usage map[string]*quotaUsage
+}
+
+func newQuotaTracker(p QuotaPolicy) *quotaTracker {
+	return &quotaTracker{policy: p, usage: make(map[string]*quotaUsage)}
+}
+
+// take counts one message from username at now. If the user is over
+// quota it counts nothing and returns the reason to give the client;
+// otherwise it returns "".
+func (t *quotaTracker) take(username string, now time.Time) string {
+	q := t.policy.quotaFor(username)
+	if q.PerHour == 0 && q.PerDay == 0 {
+		return ""
+	}
+	now = now.UTC()
+	hour := now.Truncate(time.Hour)
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !day.Equal(t.day) {
+		// A new day: forget users whose counts are from earlier days.
+		t.day = day
+		for name, u := range t.usage {
+			if u.day.Before(day) {
+				delete(t.usage, name)
+			}
+		}
+	}
+
+	u, ok := t.usage[username]
+	if !ok {
+		u = &quotaUsage{}
+		t.usage[username] = u
+	}
+	if !u.hour.Equal(hour) {
+		u.hour, u.inHour = hour, 0
+	}
+	if !u.day.Equal(day) {
+		u.day, u.inDay = day, 0
+	}
+
+	switch {
+	case q.PerHour > 0 && u.inHour >= q.PerHour:
+		return fmt.Sprintf("quota exceeded: %d messages per hour", q.PerHour)
+	case q.PerDay > 0 && u.inDay >= q.PerDay:
+		return fmt.Sprintf("quota exceeded: %d messages per day", q.PerDay)
+	}
+	u.inHour++
+	u.inDay++
+	return ""
+}
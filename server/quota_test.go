@@ -0,0 +1,104 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+var testQuotas = QuotaPolicy{
+	Classes: map[string]Quota{
+		"limited": {PerHour: 2, PerDay: 3},
+		"trusted": {},
+	},
+	Users:        map[string]string{"bot": "trusted"},
+	DefaultClass: "limited",
+}
+
+func TestQuotaTracker(t *testing.T) {
+	q := newQuotaTracker(testQuotas)
+	start := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+
+	for i, want := range []string{"", "", "quota exceeded: 2 messages per hour"} {
+		if got := q.take("alice", start); got != want {
+			t.Fatalf("message %d: got %q, want %q", i, got, want)
+		}
+	}
+	// The hour rolls over but the daily quota is nearly used up.
+	next := start.Add(time.Hour)
+	for i, want := range []string{"", "quota exceeded: 3 messages per day"} {
+		if got := q.take("alice", next); got != want {
+			t.Fatalf("next hour, message %d: got %q, want %q", i, got, want)
+		}
+	}
+	// Everything resets at midnight UTC, and stale users are forgotten.
+	if got := q.take("alice", start.Add(14*time.Hour)); got != "" {
+		t.Errorf("next day: got %q, want allowed", got)
+	}
+
+	// Other users have their own counts; trusted users are unlimited.
+	if got := q.take("bob", next); got != "" {
+		t.Errorf("bob: got %q, want allowed", got)
+	}
+	for range 10 {
+		if got := q.take("bot", start); got != "" {
+			t.Fatalf("bot: got %q, want unlimited", got)
+		}
+	}
+}
+
+func TestQuotaSweep(t *testing.T) {
+	q := newQuotaTracker(testQuotas)
+	day := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	q.take("alice", day)
+	q.take("bob", day.Add(24*time.Hour))
+	if _, ok := q.usage["alice"]; ok || len(q.usage) != 1 {
+		t.Errorf("usage after a day = %v, want only bob", q.usage)
+	}
+}
+
+func TestQuotaEnforced(t *testing.T) {
+	srv := New(WithQuotas(QuotaPolicy{
+		Classes:      map[string]Quota{"limited": {PerDay: 1}},
+		DefaultClass: "limited",
+	}))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	fmt.Fprintf(alice, "SENDID|1|hello\nSENDID|2|again\n")
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(alice)
+	for _, want := range []string{"ACK|1", "NACK|2|quota exceeded: 1 messages per day"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	alice.Close()
+
+	// Reconnecting doesn't reset the count.
+	waitForUsers(t, srv, 0)
+	alice = connectClient(t, addr, "alice")
+	defer alice.Close()
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "hi"}))
+	if line := readLine(t, alice, 2*time.Second); line != "ERR|quota exceeded: 1 messages per day" {
+		t.Errorf("got %q after reconnecting, want a quota error", line)
+	}
+}
+
+func waitForUsers(t *testing.T, srv *ChatServer, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for len(srv.Usernames()) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("users = %v, want %d", srv.Usernames(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMessageRate(t *testing.T) {
+	srv := New(WithMessageRate(0.001, 2))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	if want := "format commands rate=0.001/2"; srv.capabilities != want {
+		t.Errorf("capabilities = %q, want %q", srv.capabilities, want)
+	}
+
+	conn := connectClient(t, srv.Addr().String(), "alice")
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	lines := bufio.NewScanner(conn)
+
+	fmt.Fprintf(conn, "SENDID|1|one\nSENDID|2|two\nSENDID|3|three\nSEND|four\n")
+	for _, want := range []string{"ACK|1", "ACK|2", "NACK|3|" + tooFastReason, "ERR|" + tooFastReason} {
+		if !lines.Scan() || lines.Text() != want {
+			t.Fatalf("got %q (%v), want %q", lines.Text(), lines.Err(), want)
+		}
+	}
+}
+
+func TestJoinLimit(t *testing.T) {
+	srv := New(WithJoinLimit(2, time.Minute))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	for _, name := range []string{"alice", "bob"} {
+		conn := connectClient(t, srv.Addr().String(), name)
+		conn.Close()
+	}
+
+	conn, err := net.DialTimeout("tcp", srv.Addr().String(), 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "JOIN|carol\n")
+	if got, want := readLine(t, conn, 2*time.Second), "ERR|too many joins from your address; try again later"; got != want {
+		t.Errorf("third JOIN: got %q, want %q", got, want)
+	}
+}
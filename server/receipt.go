@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// receiptTimeout bounds how long a receipt waits for recipients. Anyone
+// whose copy hasn't been written by then, for example because it's held
+// back by do-not-disturb or their connection stalled, is reported as
+// missed.
+const receiptTimeout = 10 * time.Second
+
+// receiptChunk bounds the Body of each DELIVERED message, so receipts
+// for busy servers are split over several lines.
+const receiptChunk = protocol.MaxLineLength / 2
+
+// receipt collects what happened to one broadcast for each recipient and
+// reports it to the sender as DELIVERED messages once all are known.
+type receipt struct {
+	sender *ConnectedClient
+	id     string
+
+	mu      sync.Mutex
+	sealed  bool                // all recipients have been added
+	waiting map[string]struct{} // recipients not yet written to or dropped
+	result  protocol.Receipt
+	timer   *time.Timer
+	sent    bool
+}
+
+// broadcastWithReceipt is broadcast for a SENDRCPT from sender: once the
+// line has been written to every recipient, or dropped, sender gets the
+// receipt.
+func (s *ChatServer) broadcastWithReceipt(sender *ConnectedClient, id, line string) {
+	_, span := s.tracer.Start(context.Background(), "chat.broadcast",
+		trace.WithAttributes(
+			attribute.String("chat.sender", sender.username),
+			attribute.String("chat.room", lobby),
+			attribute.Bool("chat.receipt", true),
+		),
+	)
+	defer span.End()
+
+	r := &receipt{sender: sender, id: id, waiting: make(map[string]struct{})}
+	r.timer = time.AfterFunc(receiptTimeout, r.expire)
+
+	payload := newLine(line)
+	recipients, dropped := 0, 0
+	s.routes.route(lobby, sender.username, func(c *ConnectedClient) {
+		recipients++
+		r.add(c.username)
+		if !c.deliver(outgoing{line: payload, receipt: r}) {
+			dropped++
+		}
+	})
+	span.SetAttributes(
+		attribute.Int("chat.recipients", recipients),
+		attribute.Int("chat.dropped", dropped),
+	)
+
+	r.mu.Lock()
+	r.sealed = true
+	r.maybeReport()
+	r.mu.Unlock()
+}
+
+// shadowReceipt answers a SENDRCPT from a shadow-banned sender with the
+// receipt they'd expect: everyone else got it.
+func (s *ChatServer) shadowReceipt(sender *ConnectedClient, id string) {
+	var rec protocol.Receipt
+	for _, name := range s.Usernames() {
+		if name != sender.username {
+			rec.Delivered = append(rec.Delivered, name)
+		}
+	}
+	sendReceipt(sender, id, rec)
+}
+
+// add registers a recipient before the line is queued for it.
+func (r *receipt) add(name string) {
+	r.mu.Lock()
+	r.waiting[name] = struct{}{}
+	r.mu.Unlock()
+}
+
+// done records whether the line reached recipient name. It is safe to
+// call on a nil receipt, and calls after the receipt was sent are
+// ignored.
+func (r *receipt) done(name string, delivered bool) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.waiting[name]; !ok {
+		return
+	}
+	delete(r.waiting, name)
+	if delivered {
+		r.result.Delivered = append(r.result.Delivered, name)
+	} else {
+		r.result.Missed = append(r.result.Missed, name)
+	}
+	r.maybeReport()
+}
+
+// expire reports recipients still waiting at the timeout as missed.
+func (r *receipt) expire() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range r.waiting {
+		r.result.Missed = append(r.result.Missed, name)
+	}
+	clear(r.waiting)
+	r.sealed = true
+	r.maybeReport()
+}
+
+// maybeReport sends the receipt once every recipient is accounted for.
+// r.mu must be held.
+func (r *receipt) maybeReport() {
+	if r.sent || !r.sealed || len(r.waiting) > 0 {
+		return
+	}
+	r.sent = true
+	r.timer.Stop()
+
+	sort.Strings(r.result.Delivered)
+	sort.Strings(r.result.Missed)
+	sendReceipt(r.sender, r.id, r.result)
+}
+
+// sendReceipt sends rec to sender as one or more DELIVERED messages.
+func sendReceipt(sender *ConnectedClient, id string, rec protocol.Receipt) {
+	for _, part := range splitReceipt(rec) {
+		sender.Send(protocol.Encode(protocol.Message{
+			Type: protocol.TypeDelivered,
+			ID:   id,
+			Body: protocol.EncodeReceipt(part),
+		}))
+	}
+}
+
+// splitReceipt divides rec into parts whose encodings fit in
+// receiptChunk bytes. It always returns at least one part.
+func splitReceipt(rec protocol.Receipt) []protocol.Receipt {
+	var parts []protocol.Receipt
+	var cur protocol.Receipt
+	size := 0
+	add := func(list *[]string, name string) {
+		n := len(name) + 2 // mark and separator
+		if size > 0 && size+n > receiptChunk {
+			parts = append(parts, cur)
+			cur, size = protocol.Receipt{}, 0
+		}
+		*list = append(*list, name)
+		size += n
+	}
+	for _, name := range rec.Delivered {
+		add(&cur.Delivered, name)
+	}
+	for _, name := range rec.Missed {
+		add(&cur.Missed, name)
+	}
+	return append(parts, cur)
+}
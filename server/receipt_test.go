@@ -0,0 +1,89 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestReceipt(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	carol := connectClient(t, addr, "carol")
+	defer carol.Close()
+
+	// carol doesn't want chat messages, so she never gets the message.
+	fmt.Fprintf(carol, "NOTIFY|none\nWHO\n")
+	if line := readLine(t, carol, 2*time.Second); line != "WHO|alice|bob|carol" {
+		t.Fatalf("carol got %q, want the WHO reply", line)
+	}
+
+	fmt.Fprintf(alice, "SENDRCPT|1|fire drill at noon\n")
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(alice)
+	for _, want := range []string{"JOINED|bob|2", "JOINED|carol|3", "ACK|1", "DELIVERED|1|+bob|-carol"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("alice got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	if line := readLine(t, bob, 2*time.Second); line != "JOINED|carol|3" {
+		t.Fatalf("bob got %q, want JOINED|carol", line)
+	}
+}
+
+func TestReceiptExpires(t *testing.T) {
+	srv := New()
+	alice := newConnectedClient("alice", nil, srv)
+	r := &receipt{sender: alice, id: "7", waiting: make(map[string]struct{})}
+	r.timer = time.AfterFunc(time.Hour, r.expire)
+	r.add("bob")
+	r.add("carol")
+	r.sealed = true
+	r.done("bob", true)
+	r.expire()
+	r.done("carol", true) // too late to count
+
+	if got, want := (<-alice.outbox).text(), "DELIVERED|7|+bob|-carol"; got != want {
+		t.Errorf("receipt = %q, want %q", got, want)
+	}
+	if n := len(alice.outbox); n != 0 {
+		t.Errorf("%d more lines queued, want none", n)
+	}
+}
+
+func TestSplitReceipt(t *testing.T) {
+	var rec protocol.Receipt
+	for i := range 500 {
+		rec.Delivered = append(rec.Delivered, fmt.Sprintf("user%03d", i))
+	}
+	rec.Missed = []string{"late"}
+
+	parts := splitReceipt(rec)
+	if len(parts) < 2 {
+		t.Fatalf("got %d part(s), want the receipt split", len(parts))
+	}
+	var delivered, missed []string
+	for _, p := range parts {
+		if body := protocol.EncodeReceipt(p); len(body) > receiptChunk {
+			t.Errorf("part is %d bytes, want at most %d", len(body), receiptChunk)
+		}
+		delivered = append(delivered, p.Delivered...)
+		missed = append(missed, p.Missed...)
+	}
+	if strings.Join(delivered, ",") != strings.Join(rec.Delivered, ",") || len(missed) != 1 {
+		t.Errorf("parts don't add up to the receipt: %d delivered, missed %v", len(delivered), missed)
+	}
+
+	if parts := splitReceipt(protocol.Receipt{}); len(parts) != 1 {
+		t.Errorf("empty receipt split into %d parts, want 1", len(parts))
+	}
+}
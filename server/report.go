@@ -0,0 +1,134 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+)
+
+// ErrNoSuchReport is returned for report IDs that aren't in the
+// moderation queue.
+var ErrNoSuchReport = errors.New("no such report")
+
+const (
+	// recentMessages is how many broadcast messages are remembered so
+	// reports can be checked against what was really said.
+	recentMessages = 256
+
+	// maxReports bounds the moderation queue.
+	maxReports = 1000
+)
+
+// Report is a flagged message waiting in the moderation queue.
+type Report struct {
+	ID        int       `json:"id"`
+	Author    string    `json:"author"`
+	Text      string    `json:"text"`
+	Reporters []string  `json:"reporters"`
+	Reported  time.Time `json:"reported"` // when it was first reported
+}
+
+type recentMessage struct {
+	author, text string
+}
+
+// moderation holds the moderation queue and the recent messages that can
+// be reported.
+type moderation struct {
+	mu      sync.Mutex
+	recent  []recentMessage // ring buffer of the last recentMessages
+	next    int             // index in recent to overwrite next
+	reports []*Report       // oldest first
+	lastID  int
+}
+
+// remember records a broadcast message so it can be reported.
+func (m *moderation) remember(author, text string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.recent) < recentMessages {
+		m.recent = append(m.recent, recentMessage{author, text})
+		return
+	}
+	m.recent[m.next] = recentMessage{author, text}
+	m.next = (m.next + 1) % recentMessages
+}
+
+// report flags author's message text on behalf of reporter. Reports of a
+// message already in the queue add the reporter to it. It returns an
+// error, suitable for showing the reporter, if the message wasn't said
+// recently or the queue is full.
+func (m *moderation) report(reporter, author, text string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !slices.Contains(m.recent, recentMessage{author, text}) {
+		return fmt.Errorf("no recent message from %s with that text", author)
+	}
+	for _, r := range m.reports {
+		if r.Author == author && r.Text == text {
+			if !slices.Contains(r.Reporters, reporter) {
+				r.Reporters = append(r.Reporters, reporter)
+			}
+			return nil
+		}
+	}
+	if len(m.reports) >= maxReports {
+		return errors.New("the moderation queue is full; try again later")
+	}
+	m.lastID++
+	m.reports = append(m.reports, &Report{
+		ID:        m.lastID,
+		Author:    author,
+		Text:      text,
+		Reporters: []string{reporter},
+		Reported:  time.Now(),
+	})
+	log.Printf("%s reported a message from %s", reporter, author)
+	return nil
+}
+
+// remove takes a report out of the queue and returns it.
+func (m *moderation) remove(id int) (Report, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	i := slices.IndexFunc(m.reports, func(r *Report) bool { return r.ID == id })
+	if i < 0 {
+		return Report{}, ErrNoSuchReport
+	}
+	r := *m.reports[i]
+	m.reports = slices.Delete(m.reports, i, i+1)
+	return r, nil
+}
+
+// Reports returns the moderation queue, oldest first.
+func (s *ChatServer) Reports() []Report {
+	s.moderation.mu.Lock()
+	defer s.moderation.mu.Unlock()
+	reports := make([]Report, len(s.moderation.reports))
+	for i, r := range s.moderation.reports {
+		reports[i] = *r
+		reports[i].Reporters = slices.Clone(r.Reporters)
+	}
+	return reports
+}
+
+// DismissReport removes a report from the queue without acting on it.
+func (s *ChatServer) DismissReport(id int) error {
+	_, err := s.moderation.remove(id)
+	return err
+}
+
+// BanReportAuthor removes a report from the queue and bans the author of
+// the reported message; see Ban.
+func (s *ChatServer) BanReportAuthor(id int, reason string) error {
+	r, err := s.moderation.remove(id)
+	if err != nil {
+		return err
+	}
+	s.Ban(r.Author, reason)
+	return nil
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestReport(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(alice)
+	expect := func(want string) {
+		t.Helper()
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("alice got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	expect("JOINED|bob|2")
+
+	fmt.Fprintf(bob, "SEND|buy cheap watches\n")
+	expect("MSG|bob|buy cheap watches")
+
+	fmt.Fprintf(alice, "REPORT|bob|something bob never said\n")
+	expect("ERR|no recent message from bob with that text")
+	for range 2 {
+		fmt.Fprintf(alice, "REPORT|bob|buy cheap watches\n")
+		expect("NOTICE|Thanks, the operators will review the message from bob")
+	}
+
+	reports := srv.Reports()
+	if len(reports) != 1 || reports[0].Author != "bob" || reports[0].Text != "buy cheap watches" ||
+		!slices.Equal(reports[0].Reporters, []string{"alice"}) {
+		t.Fatalf("Reports() = %+v, want one report of bob by alice", reports)
+	}
+
+	if err := srv.BanReportAuthor(reports[0].ID, "spam"); err != nil {
+		t.Fatalf("BanReportAuthor() error = %v", err)
+	}
+	if line := readLine(t, bob, 2*time.Second); line != "KICKED|spam" {
+		t.Errorf("bob got %q, want KICKED|spam", line)
+	}
+	if _, ok := srv.Bans()["bob"]; !ok {
+		t.Error("bob should be banned")
+	}
+	if n := len(srv.Reports()); n != 0 {
+		t.Errorf("%d report(s) left in the queue, want none", n)
+	}
+	if err := srv.DismissReport(reports[0].ID); !errors.Is(err, ErrNoSuchReport) {
+		t.Errorf("DismissReport() of a handled report = %v, want ErrNoSuchReport", err)
+	}
+}
+
+func TestModerationForgetsOldMessages(t *testing.T) {
+	var m moderation
+	for i := range recentMessages + 1 {
+		m.remember("bob", fmt.Sprint(i))
+	}
+	if err := m.report("alice", "bob", "0"); err == nil {
+		t.Error("reporting a message that scrolled out of memory should fail")
+	}
+	if err := m.report("alice", "bob", fmt.Sprint(recentMessages)); err != nil {
+		t.Errorf("reporting the latest message: %v", err)
+	}
+
+	r, err := m.remove(1)
+	if err != nil || r.Text != fmt.Sprint(recentMessages) {
+		t.Errorf("remove(1) = %+v, %v", r, err)
+	}
+}
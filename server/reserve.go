@@ -0,0 +1,68 @@
+package server
+
+import (
+	"log"
+	"net"
+	"sort"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// Reserve keeps name for a registered user: JOINs asking for it, or for
+// a name that differs only in case or, with WithConfusableNames, in
+// lookalike letters, are rejected with protocol.ReasonNameReserved unless
+// the transport has authenticated the user, as SSH does with the key
+// mapped to name. It holds whether or not the user is online, until
+// Unreserve, and is kept across restarts by SaveState. Someone already
+// connected under name keeps it until they leave.
+func (s *ChatServer) Reserve(name string) {
+	name = protocol.NormalizeUsername(name)
+	key := s.nameKey(name)
+	s.mu.Lock()
+	_, ok := s.reserved[key]
+	s.reserved[key] = name
+	s.mu.Unlock()
+	if !ok {
+		log.Printf("reserved the username %s", name)
+	}
+}
+
+// Unreserve lets anyone join as name again. It reports whether name was
+// reserved.
+func (s *ChatServer) Unreserve(name string) bool {
+	key := s.nameKey(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.reserved[key]
+	delete(s.reserved, key)
+	return ok
+}
+
+// Reservations returns the reserved usernames in alphabetical order.
+func (s *ChatServer) Reservations() []string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.reserved))
+	for _, name := range s.reserved {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// isReserved reports whether name, or a name with the same nameKey, is
+// reserved.
+func (s *ChatServer) isReserved(name string) bool {
+	key := s.nameKey(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.reserved[key]
+	return ok
+}
+
+// authenticated reports whether conn's transport vouched for the
+// username it joins with.
+func authenticated(conn net.Conn) bool {
+	pc, ok := conn.(*plainConn)
+	return ok && pc.authenticated
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// joinAs sends a JOIN as username on a new connection and returns the
+// server's first reply.
+func joinAs(t *testing.T, addr, username string) string {
+	t.Helper()
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: username}))
+	return readLine(t, conn, 2*time.Second)
+}
+
+func TestReserve(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	srv.Reserve("alice")
+	srv.Reserve("carol")
+
+	if got := joinAs(t, addr, "alice"); got != "ERR|NAME_RESERVED" {
+		t.Errorf("JOIN as alice got %q, want ERR|NAME_RESERVED", got)
+	}
+	if got := joinAs(t, addr, "bob"); !strings.HasPrefix(got, "OK") {
+		t.Errorf("JOIN as bob got %q, want OK", got)
+	}
+	if got, want := srv.Reservations(), []string{"alice", "carol"}; !slices.Equal(got, want) {
+		t.Errorf("Reservations() = %v, want %v", got, want)
+	}
+
+	if !srv.Unreserve("alice") {
+		t.Error("Unreserve(alice) = false, want true")
+	}
+	if srv.Unreserve("alice") {
+		t.Error("second Unreserve(alice) = true, want false")
+	}
+	if got := joinAs(t, addr, "alice"); !strings.HasPrefix(got, "OK") {
+		t.Errorf("JOIN as alice after Unreserve got %q, want OK", got)
+	}
+}
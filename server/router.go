@@ -0,0 +1,72 @@
+package server
+
+import "sync"
+
+// lobby is the room every client is in. The protocol has no way to join
+// another yet, but broadcasts are routed by room so that adding rooms only
+// means changing who is a member of which.
+const lobby = "lobby"
+
+// router tracks room membership, so that a broadcast visits only the
+// members of its room rather than every connected client. The lobby always
+// exists; other rooms exist while they have members.
+type router struct {
+	mu    sync.RWMutex
+	rooms map[string]map[string]*ConnectedClient // room -> username -> member
+	in    map[string][]string                    // username -> rooms
+}
+
+func newRouter() *router {
+	return &router{
+		rooms: map[string]map[string]*ConnectedClient{lobby: {}},
+		in:    make(map[string][]string),
+	}
+}
+
+// join adds c to room.
+func (r *router) join(room string, c *ConnectedClient) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	members := r.rooms[room]
+	if members == nil {
+		members = make(map[string]*ConnectedClient)
+		r.rooms[room] = members
+	}
+	if _, ok := members[c.username]; !ok {
+		r.in[c.username] = append(r.in[c.username], room)
+	}
+	members[c.username] = c
+}
+
+// leaveAll removes username from every room it is in.
+func (r *router) leaveAll(username string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, room := range r.in[username] {
+		delete(r.rooms[room], username)
+		if len(r.rooms[room]) == 0 && room != lobby {
+			delete(r.rooms, room)
+		}
+	}
+	delete(r.in, username)
+}
+
+// route calls fn for each member of room other than sender. fn runs with
+// membership locked against changes, so it must not block or join or
+// leave rooms.
+func (r *router) route(room, sender string, fn func(*ConnectedClient)) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for name, c := range r.rooms[room] {
+		if name != sender {
+			fn(c)
+		}
+	}
+}
+
+// count returns how many rooms there are.
+func (r *router) count() int {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return len(r.rooms)
+}
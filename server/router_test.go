@@ -0,0 +1,46 @@
+package server
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestRouter(t *testing.T) {
+	r := newRouter()
+	alice := &ConnectedClient{username: "alice"}
+	bob := &ConnectedClient{username: "bob"}
+	carol := &ConnectedClient{username: "carol"}
+	for _, c := range []*ConnectedClient{alice, bob, carol} {
+		r.join(lobby, c)
+	}
+	r.join("ops", alice)
+	r.join("ops", bob)
+
+	members := func(room, sender string) []string {
+		var names []string
+		r.route(room, sender, func(c *ConnectedClient) { names = append(names, c.username) })
+		slices.Sort(names)
+		return names
+	}
+	if got, want := members(lobby, "alice"), []string{"bob", "carol"}; !slices.Equal(got, want) {
+		t.Errorf("lobby recipients = %v, want %v", got, want)
+	}
+	if got, want := members("ops", "bob"), []string{"alice"}; !slices.Equal(got, want) {
+		t.Errorf("ops recipients = %v, want %v", got, want)
+	}
+	if got := r.count(); got != 2 {
+		t.Errorf("count() = %d, want 2", got)
+	}
+
+	r.leaveAll("alice")
+	r.leaveAll("bob")
+	if got := members("ops", ""); len(got) != 0 {
+		t.Errorf("ops recipients after leaving = %v, want none", got)
+	}
+	if got := r.count(); got != 1 {
+		t.Errorf("count() = %d, want 1: empty rooms other than the lobby go away", got)
+	}
+	if got, want := members(lobby, ""), []string{"carol"}; !slices.Equal(got, want) {
+		t.Errorf("lobby recipients = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// maxSeen bounds how many users' last-seen times are kept. Once it's
+// reached, the user seen longest ago is forgotten first.
+const maxSeen = 10000
+
+// Seen reports whether name is connected, or when they last were. It
+// returns false if the server doesn't know, including for users who
+// turned last-seen privacy on. Usernames aren't registered, so this is
+// when anyone last used the name.
+func (s *ChatServer) Seen(name string) (protocol.Seen, bool) {
+	name = protocol.NormalizeUsername(name)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if _, ok := s.clients[name]; ok {
+		return protocol.Seen{Online: true}, true
+	}
+	at, ok := s.seen[name]
+	return protocol.Seen{At: at}, ok
+}
+
+// SetHideSeen turns last-seen privacy on or off for name. While it's on
+// the server doesn't record when name was last connected and SEEN
+// queries for them get no answer.
+func (s *ChatServer) SetHideSeen(name string, on bool) {
+	name = protocol.NormalizeUsername(name)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if on {
+		s.hideSeen[name] = struct{}{}
+		delete(s.seen, name)
+	} else {
+		delete(s.hideSeen, name)
+	}
+}
+
+// recordSeen notes that name disconnected at t, unless they turned
+// last-seen privacy on. s.mu must be held.
+func (s *ChatServer) recordSeen(name string, t time.Time) {
+	if _, hidden := s.hideSeen[name]; hidden {
+		return
+	}
+	if _, ok := s.seen[name]; !ok && len(s.seen) >= maxSeen {
+		oldest := ""
+		for n, at := range s.seen {
+			if oldest == "" || at.Before(s.seen[oldest]) {
+				oldest = n
+			}
+		}
+		delete(s.seen, oldest)
+	}
+	s.seen[name] = t
+}
+
+// seenReply answers a SEEN query for name.
+func (s *ChatServer) seenReply(name string) protocol.Message {
+	reply := protocol.Message{Type: protocol.TypeSeen, Username: name}
+	if seen, ok := s.Seen(name); ok {
+		reply.Body = protocol.EncodeSeen(seen)
+	}
+	return reply
+}
+
+// ago describes how long before now t was, roughly, as in "2h ago".
+func ago(t, now time.Time) string {
+	d := now.Sub(t)
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", d/time.Minute)
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", d/time.Hour)
+	default:
+		return fmt.Sprintf("%dd ago", d/(24*time.Hour))
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSeen(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	bob := connectClient(t, addr, "bob")
+	readLine(t, alice, 2*time.Second) // JOINED|bob|2
+	bob.Close()
+	readLine(t, alice, 2*time.Second) // LEFT|bob|1
+
+	fmt.Fprintf(alice, "SEEN|bob\n")
+	line := readLine(t, alice, 2*time.Second)
+	secs, err := strconv.ParseInt(strings.TrimPrefix(line, "SEEN|bob|"), 10, 64)
+	if err != nil || time.Since(time.Unix(secs, 0)) > time.Minute {
+		t.Errorf("alice got %q, want bob last seen just now", line)
+	}
+	for query, want := range map[string]string{"alice": "SEEN|alice|online", "zed": "SEEN|zed"} {
+		fmt.Fprintf(alice, "SEEN|%s\n", query)
+		if line := readLine(t, alice, 2*time.Second); line != want {
+			t.Errorf("alice got %q, want %q", line, want)
+		}
+	}
+
+	bob = connectClient(t, addr, "bob")
+	readLine(t, alice, 2*time.Second) // JOINED|bob|2
+	fmt.Fprintf(bob, "HIDESEEN|on\n")
+	bob.Close()
+	readLine(t, alice, 2*time.Second) // LEFT|bob|1
+	fmt.Fprintf(alice, "SEEN|bob\n")
+	if line := readLine(t, alice, 2*time.Second); line != "SEEN|bob" {
+		t.Errorf("alice got %q for a user hiding last-seen, want SEEN|bob", line)
+	}
+
+	restored := New()
+	restored.Restore(srv.Snapshot())
+	if _, ok := restored.Seen("bob"); ok {
+		t.Error("restored server knows when bob was last seen")
+	}
+	restored.SetHideSeen("bob", false)
+	restored.removeClient("bob") // not connected: nothing to record
+	if _, ok := restored.Seen("bob"); ok {
+		t.Error("removing a user who wasn't connected recorded them as seen")
+	}
+}
+
+func TestAgo(t *testing.T) {
+	now := time.Now()
+	for d, want := range map[time.Duration]string{
+		10 * time.Second: "just now",
+		5 * time.Minute:  "5m ago",
+		2 * time.Hour:    "2h ago",
+		72 * time.Hour:   "3d ago",
+	} {
+		if got := ago(now.Add(-d), now); got != want {
+			t.Errorf("ago(%v) = %q, want %q", d, got, want)
+		}
+	}
+}
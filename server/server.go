@@ -2,53 +2,398 @@ package server
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
+	"github.com/gliderlabs/ssh"
+	"github.com/pankaj/simple-chat/netopt"
 	"github.com/pankaj/simple-chat/protocol"
+	"github.com/pankaj/simple-chat/ratelimit"
+	"github.com/quic-go/quic-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
+const tracerName = "github.com/pankaj/simple-chat/server"
+
 // ChatServer manages all connected clients in a single chat room.
 type ChatServer struct {
-	listener net.Listener
-	mu       sync.RWMutex
-	clients  map[string]*ConnectedClient
-	quit     chan struct{}
-	wg       sync.WaitGroup
+	network      string
+	listen       func(network, addr string) (net.Listener, error)
+	listeners    []net.Listener
+	ircListener  net.Listener   // nil unless ListenIRC was called
+	quicListener *quic.Listener // nil unless ListenQUIC was called
+	sshServer    *ssh.Server    // nil unless ListenSSH was called
+	sshAddr      net.Addr
+	mu           sync.RWMutex
+	clients      map[string]*ConnectedClient
+	names        map[string]string // nameKey -> username of each client, guarded by mu
+	routes       *router
+	run          atomic.Pointer[run] // replaced when listening after Shutdown
+	lifecycle    sync.Mutex          // serializes starting and Shutdown
+	wg           sync.WaitGroup
+	messages     atomic.Uint64
+	tracer       trace.Tracer
+
+	draining      atomic.Bool
+	reconnectHint string
+
+	proxyProtocol bool
+	tcp           netopt.TCP
+	accepts       *acceptLimiter                      // nil when accepts aren't limited
+	joins         *ratelimit.Keyed[*ratelimit.Window] // per address; nil when joins aren't limited
+	joinThrottle  *joinThrottle                       // nil when failed joins aren't throttled
+	messageRate   float64                             // per connection per second; 0 is unlimited
+	messageBurst  int
+	plainText     bool
+	spamPolicy    *SpamPolicy
+	quotas        *quotaTracker // nil when quotas are disabled
+	joinChallenge JoinChallenge
+	geo           *geoFilter       // nil when there is no GeoPolicy
+	attachments   *attachmentStore // nil when attachments are disabled
+
+	confusableNames bool // nameKey maps lookalike letters; fixed once New returns
+
+	// capabilities is the Body of the OK sent to joining clients; see
+	// New. It is fixed once New returns.
+	capabilities string
+
+	bans       map[string]Ban         // by nameKey, guarded by mu
+	shadowBans map[string]string      // nameKey -> username, guarded by mu
+	reserved   map[string]string      // nameKey -> username only registered users may take, guarded by mu
+	motd       string                 // guarded by mu
+	mode       protocol.RoomMode      // guarded by mu
+	commands   map[string]CommandFunc // by lower-case name; fixed once New returns
+	locales    map[string]*catalog    // by canonical locale; fixed once New returns
+	seen       map[string]time.Time   // username -> last disconnected, guarded by mu
+	hideSeen   map[string]struct{}    // users with last-seen privacy on, guarded by mu
+
+	moderation moderation
+	stateFile  string // for SaveState and LoadState
+
+	migrationKey []byte // nil unless WithMigrationKey was used
+
+	counters counters
+	events   bus
 }
 
-// New creates a new ChatServer.
-func New() *ChatServer {
-	return &ChatServer{
-		clients: make(map[string]*ConnectedClient),
-		quit:    make(chan struct{}),
+// New creates a new ChatServer configured by opts.
+func New(opts ...Option) *ChatServer {
+	s := &ChatServer{
+		network:    "tcp",
+		listen:     net.Listen,
+		clients:    make(map[string]*ConnectedClient),
+		names:      make(map[string]string),
+		routes:     newRouter(),
+		bans:       make(map[string]Ban),
+		shadowBans: make(map[string]string),
+		reserved:   make(map[string]string),
+		seen:       make(map[string]time.Time),
+		hideSeen:   make(map[string]struct{}),
+		tracer:     noop.NewTracerProvider().Tracer(tracerName),
+	}
+	s.commands = s.builtinCommands()
+	s.run.Store(newRun())
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Message bodies are relayed verbatim, so formatting reaches clients
+	// intact, and those starting with "/" are run as commands.
+	caps := []string{protocol.CapFormat, protocol.CapCommands}
+	if s.messageRate > 0 {
+		caps = append(caps, protocol.RateCapability(s.messageRate, max(s.messageBurst, 1)))
+	}
+	s.capabilities = protocol.EncodeCapabilities(caps)
+	s.subscribeInternal()
+	return s
+}
+
+// run is the state of one period of serving, from the first listener
+// until Shutdown. Listening again after Shutdown begins a new run.
+type run struct {
+	quit      chan struct{} // closed by Shutdown
+	drained   chan struct{} // closed once the last client leaves while draining
+	drainOnce sync.Once
+	started   time.Time // zero until the first listener
+	stopped   bool      // guarded by ChatServer.lifecycle
+}
+
+func newRun() *run {
+	return &run{quit: make(chan struct{}), drained: make(chan struct{})}
+}
+
+// closed reports whether Shutdown has ended r. Unlike stopped, it needs no
+// lock.
+func (r *run) closed() bool {
+	select {
+	case <-r.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// startLocked is called as each listener starts, with s.lifecycle held.
+// After a Shutdown it begins a new run, forgetting the old listeners, so
+// the server can serve again.
+func (s *ChatServer) startLocked() *run {
+	r := s.run.Load()
+	if r.stopped {
+		r = newRun()
+		s.listeners = nil
+		s.ircListener = nil
+		s.quicListener = nil
+		s.sshServer = nil
+		s.sshAddr = nil
+		s.draining.Store(false)
+		s.run.Store(r)
+	}
+	if r.started.IsZero() {
+		r.started = time.Now()
+		go s.sampleRate(r.quit)
 	}
+	return r
+}
+
+// track adds a connection that didn't come through an accept loop, such
+// as a WebSocket or SSH session, to s.wg so that Shutdown waits for it.
+// Checking for Shutdown and adding happen under s.lifecycle, so Shutdown
+// either waits for the connection or has finished first, in which case
+// track returns false and the connection must be refused. Otherwise the
+// caller must see to s.wg.Done.
+func (s *ChatServer) track() bool {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if s.run.Load().stopped {
+		return false
+	}
+	s.wg.Add(1)
+	return true
 }
 
 // Listen binds to the given address and starts accepting connections.
 func (s *ChatServer) Listen(addr string) error {
-	ln, err := net.Listen("tcp", addr)
+	return s.ListenAll([]string{addr})
+}
+
+// ListenAll binds to every given address, e.g. one per interface, and
+// accepts connections on all of them. If any address fails to bind, the
+// listeners opened so far are closed and the error is returned.
+func (s *ChatServer) ListenAll(addrs []string) error {
+	if len(addrs) == 0 {
+		return errors.New("no listen addresses given")
+	}
+	switch s.network {
+	case "tcp", "tcp4", "tcp6":
+	default:
+		return fmt.Errorf("unsupported network %q: want tcp, tcp4 or tcp6", s.network)
+	}
+	for _, addr := range addrs {
+		if err := validateListenAddr(s.network, addr); err != nil {
+			return err
+		}
+	}
+
+	var listeners []net.Listener
+	for _, addr := range addrs {
+		ln, err := s.listen(s.network, addr)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return fmt.Errorf("listening on %s: %w", addr, err)
+		}
+		listeners = append(listeners, ln)
+	}
+
+	// Hold the lifecycle lock so a concurrent Shutdown either sees the
+	// new listeners and closes them or happens before they are added.
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.startLocked()
+	s.listeners = append(s.listeners, listeners...)
+	for _, ln := range listeners {
+		s.wg.Add(1)
+		go s.serve(ln, false)
+	}
+	return nil
+}
+
+// validateListenAddr checks that addr is well formed and that a literal IP
+// host matches the configured network family.
+func validateListenAddr(network, addr string) error {
+	host, _, err := net.SplitHostPort(addr)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid listen address %q: %w", addr, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil // hostname or wildcard; resolved by net.Listen
+	}
+	switch {
+	case network == "tcp4" && ip.To4() == nil:
+		return fmt.Errorf("listen address %q is not IPv4 but network is %s", addr, network)
+	case network == "tcp6" && ip.To4() != nil:
+		return fmt.Errorf("listen address %q is not IPv6 but network is %s", addr, network)
 	}
-	s.listener = ln
-	s.wg.Add(1)
-	go s.serve()
 	return nil
 }
 
-// Addr returns the listener's address (useful in tests with ":0" port).
+// Addr returns the first listener's address (useful in tests with ":0"
+// port), or nil if the server isn't listening.
 func (s *ChatServer) Addr() net.Addr {
-	return s.listener.Addr()
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	return s.listeners[0].Addr()
+}
+
+// Addrs returns the addresses of all listeners, or nil if the server
+// isn't listening.
+func (s *ChatServer) Addrs() []net.Addr {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	if len(s.listeners) == 0 {
+		return nil
+	}
+	addrs := make([]net.Addr, len(s.listeners))
+	for i, ln := range s.listeners {
+		addrs[i] = ln.Addr()
+	}
+	return addrs
+}
+
+// Stats returns a snapshot of the server's runtime statistics.
+func (s *ChatServer) Stats() protocol.Stats {
+	s.mu.RLock()
+	users := len(s.clients)
+	s.mu.RUnlock()
+
+	st := protocol.Stats{
+		Users:    users,
+		Rooms:    s.routes.count(),
+		Messages: s.messages.Load(),
+	}
+	if started := s.run.Load().started; !started.IsZero() {
+		st.Uptime = time.Since(started)
+		if secs := st.Uptime.Seconds(); secs > 0 {
+			st.Rate = float64(st.Messages) / secs
+		}
+	}
+	return st
+}
+
+// Usernames returns the connected users in alphabetical order.
+func (s *ChatServer) Usernames() []string {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.clients))
+	for name := range s.clients {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+
+	sort.Strings(names)
+	return names
+}
+
+// Away returns the users who are currently away, mapped to their away
+// notes.
+func (s *ChatServer) Away() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	away := make(map[string]string)
+	for name, c := range s.clients {
+		if note := c.away.Load(); note != nil {
+			away[name] = *note
+		}
+	}
+	return away
+}
+
+// Ready reports whether the server is listening and accepting new users.
+// It turns false as soon as Drain or Shutdown is called, which makes it
+// suitable for load balancer readiness checks.
+func (s *ChatServer) Ready() bool {
+	// Check before taking the lock, which Shutdown holds while clients
+	// leave, so that readiness checks don't hang meanwhile.
+	if s.draining.Load() || s.run.Load().closed() {
+		return false
+	}
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	return len(s.listeners) > 0 && !s.run.Load().closed()
+}
+
+// Drain prepares the server for a rolling restart. It marks the server as
+// not ready, rejects new JOINs, asks connected clients to reconnect
+// elsewhere and waits up to timeout for them to leave before calling
+// Shutdown.
+func (s *ChatServer) Drain(timeout time.Duration) {
+	s.draining.Store(true)
+
+	s.mu.RLock()
+	remaining := len(s.clients)
+	for _, c := range s.clients {
+		c.Send(protocol.Encode(protocol.Message{
+			Type: protocol.TypeReconnect,
+			Body: s.reconnectHint,
+		}))
+	}
+	s.mu.RUnlock()
+
+	if remaining > 0 {
+		log.Printf("draining %d client(s)", remaining)
+		select {
+		case <-s.run.Load().drained:
+		case <-time.After(timeout):
+			s.mu.RLock()
+			log.Printf("drain timed out with %d client(s) connected", len(s.clients))
+			s.mu.RUnlock()
+		}
+	}
+
+	s.Shutdown()
 }
 
-// Shutdown gracefully stops the server.
+// Shutdown gracefully stops the server. It can be called more than once,
+// and the server can listen again afterwards; Stats then reports uptime
+// from the new start and STATS message counts carry on.
 func (s *ChatServer) Shutdown() {
-	close(s.quit)
-	s.listener.Close()
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+
+	r := s.run.Load()
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	close(r.quit)
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	if s.ircListener != nil {
+		s.ircListener.Close()
+	}
+	if s.quicListener != nil {
+		s.quicListener.Close()
+	}
+	if s.sshServer != nil {
+		s.sshServer.Close()
+	}
 
 	s.mu.Lock()
 	for _, c := range s.clients {
@@ -59,121 +404,387 @@ func (s *ChatServer) Shutdown() {
 	s.wg.Wait()
 }
 
-// serve runs the accept loop.
-func (s *ChatServer) serve() {
+// serve runs the accept loop for one listener. irc marks the listener
+// opened by ListenIRC.
+func (s *ChatServer) serve(ln net.Listener, irc bool) {
 	defer s.wg.Done()
+	quit := s.run.Load().quit
+	s.counters.acceptLoops.Add(1)
+	defer s.counters.acceptLoops.Add(-1)
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := ln.Accept()
 		if err != nil {
 			select {
-			case <-s.quit:
+			case <-quit:
 				return
 			default:
+				if errors.Is(err, net.ErrClosed) {
+					// Closed by whoever supplied it, e.g. once passed
+					// to a new process during an upgrade.
+					return
+				}
 				log.Printf("accept error: %v", err)
 				continue
 			}
 		}
+		if !s.admit(conn.RemoteAddr()) {
+			conn.Close()
+			continue
+		}
+		if err := s.tcp.Apply(conn); err != nil {
+			log.Printf("%s: %v", conn.RemoteAddr(), err)
+		}
 		s.wg.Add(1)
-		go s.handleConnection(conn)
+		go s.handleConnection(conn, s.proxyProtocol, irc)
 	}
 }
 
-// handleConnection manages a single TCP connection from accept to close.
-func (s *ChatServer) handleConnection(conn net.Conn) {
+// handleConnection manages a single connection from accept to close. If
+// proxied is set the connection must start with a PROXY protocol header.
+// If irc is set the client speaks IRC rather than the chat protocol.
+func (s *ChatServer) handleConnection(conn net.Conn, proxied, irc bool) {
 	defer s.wg.Done()
 	defer conn.Close()
 
+	s.counters.connectionsTotal.Add(1)
+	s.counters.connections.Add(1)
+	s.counters.connLoops.Add(1)
+	defer s.counters.connections.Add(-1)
+	defer s.counters.connLoops.Add(-1)
+
+	ctx, span := s.tracer.Start(context.Background(), "chat.connection",
+		trace.WithSpanKind(trace.SpanKindServer),
+	)
+	defer span.End()
+
 	// Set a deadline for the initial JOIN message.
 	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
 
+	if proxied {
+		pc, err := readProxyHeader(conn)
+		if err != nil {
+			log.Printf("rejecting connection from %s: %v", conn.RemoteAddr(), err)
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		conn = pc
+	}
+	span.SetAttributes(attribute.String("net.peer.address", conn.RemoteAddr().String()))
+	if irc {
+		span.SetAttributes(attribute.Bool("chat.irc", true))
+		conn = newIRCConn(conn, s)
+	}
+
+	if s.geo != nil {
+		country, ok := s.geo.check(conn.RemoteAddr())
+		if country != "" {
+			span.SetAttributes(attribute.String("chat.country", country))
+		}
+		if !ok {
+			log.Printf("rejecting connection from %s: country %q not allowed", conn.RemoteAddr(), country)
+			writeMessage(conn, protocol.Message{
+				Type: protocol.TypeErr,
+				Body: "connections from your region are not accepted",
+			})
+			span.SetStatus(codes.Error, "country not allowed")
+			return
+		}
+	}
+
+	if s.plainText && !irc {
+		conn.SetReadDeadline(time.Now().Add(plainJoinTimeout))
+		pc, err := detectPlain(conn)
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+			return
+		}
+		if _, ok := pc.(*plainConn); ok {
+			span.SetAttributes(attribute.Bool("chat.plain_text", true))
+		}
+		conn = pc
+	}
+
+	_, joinSpan := s.tracer.Start(ctx, "chat.join")
+
 	scanner := bufio.NewScanner(conn)
-	scanner.Buffer(make([]byte, 4096), 4096)
+	scanner.Buffer(make([]byte, protocol.MaxLineLength), protocol.MaxLineLength)
 
 	if !scanner.Scan() {
+		joinSpan.SetStatus(codes.Error, "no JOIN received")
+		joinSpan.End()
 		return
 	}
 
 	msg, err := protocol.Decode(scanner.Text())
-	if err != nil || msg.Type != protocol.TypeJoin {
-		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{
-			Type: protocol.TypeErr,
-			Body: "expected JOIN message",
-		}))
+	if errors.Is(err, protocol.ErrInvalidUsername) {
+		s.rejectJoin(conn, joinSpan, msg.Locale, fmt.Sprintf("invalid username: use up to %d bytes and no \"|\"", protocol.MaxUsernameLength))
+		return
+	}
+	if errors.Is(err, protocol.ErrMissingUsername) {
+		s.rejectJoin(conn, joinSpan, msg.Locale, "username cannot be empty")
+		return
+	}
+	if errors.Is(err, protocol.ErrInvalidLocale) {
+		s.rejectJoin(conn, joinSpan, "", `invalid locale: use a language tag such as "de" or "pt-BR"`)
+		return
+	}
+	resumed := msg.Type == protocol.TypeResume
+	if err != nil || (msg.Type != protocol.TypeJoin && !resumed) {
+		s.rejectJoin(conn, joinSpan, msg.Locale, "expected JOIN message")
+		return
+	}
+	if resumed && (s.migrationKey == nil || !validMigrationToken(s.migrationKey, msg.Username, msg.ID, time.Now())) {
+		s.joinFailed(conn.RemoteAddr())
+		s.rejectJoin(conn, joinSpan, msg.Locale, "invalid or expired migration token")
 		return
 	}
 
 	username := msg.Username
 	if username == "" {
-		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{
-			Type: protocol.TypeErr,
-			Body: "username cannot be empty",
-		}))
+		s.rejectJoin(conn, joinSpan, msg.Locale, "username cannot be empty")
+		return
+	}
+	joinSpan.SetAttributes(attribute.String("chat.username", username))
+	span.SetAttributes(attribute.String("chat.username", username))
+
+	if s.draining.Load() {
+		s.rejectJoin(conn, joinSpan, msg.Locale, "server draining")
+		return
+	}
+	if s.joins != nil && !s.joins.Allow(addrKey(conn.RemoteAddr())) {
+		s.rejectJoin(conn, joinSpan, msg.Locale, "too many joins from your address; try again later")
+		return
+	}
+	if s.joinThrottle != nil {
+		delay, blocked := s.joinThrottle.check(addrKey(conn.RemoteAddr()), time.Now())
+		if blocked {
+			s.rejectJoin(conn, joinSpan, msg.Locale, "too many failed joins from your address; try again later")
+			return
+		}
+		if delay > 0 {
+			joinSpan.SetAttributes(attribute.Int64("chat.join_delay_ms", delay.Milliseconds()))
+			select {
+			case <-time.After(delay):
+			case <-s.run.Load().quit:
+				joinSpan.End()
+				return
+			}
+		}
+	}
+	if reason, ok := s.banned(username); ok {
+		s.joinFailed(conn.RemoteAddr())
+		s.rejectJoin(conn, joinSpan, msg.Locale, reason)
+		return
+	}
+	// A RESUME's token shows the name was already granted elsewhere.
+	if !resumed && !authenticated(conn) && s.isReserved(username) {
+		s.joinFailed(conn.RemoteAddr())
+		joinSpan.SetAttributes(attribute.Bool("chat.reserved", true))
+		s.rejectJoin(conn, joinSpan, msg.Locale, protocol.ReasonNameReserved)
+		return
+	}
+	if resumed {
+		// The server the user migrated from asked the challenge.
+		joinSpan.SetAttributes(attribute.Bool("chat.resumed", true))
+	} else if reason := s.challenge(conn, scanner); reason != "" {
+		s.joinFailed(conn.RemoteAddr())
+		s.rejectJoin(conn, joinSpan, msg.Locale, reason)
 		return
 	}
 
 	client := newConnectedClient(username, conn, s)
-	if !s.addClient(client) {
-		fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{
-			Type: protocol.TypeErr,
-			Body: "username taken",
-		}))
+	client.locale = msg.Locale
+	added := s.addClient(client)
+	if !added && msg.Rename && !resumed {
+		added = s.addClientRenamed(client)
+	}
+	if !added {
+		s.joinFailed(conn.RemoteAddr())
+		s.rejectJoin(conn, joinSpan, msg.Locale, "username taken")
 		return
 	}
+	// renamed is the name granted in place of a taken one, if any.
+	var renamed string
+	if client.username != username {
+		renamed, username = client.username, client.username
+		joinSpan.SetAttributes(attribute.String("chat.renamed", renamed))
+		span.SetAttributes(attribute.String("chat.username", username))
+	}
+	if s.joinThrottle != nil {
+		s.joinThrottle.succeed(addrKey(conn.RemoteAddr()))
+	}
+	s.events.publish(ClientJoined{Username: username, Addr: conn.RemoteAddr(), Resumed: resumed})
 
 	// Clear the deadline for normal operation.
 	conn.SetReadDeadline(time.Time{})
 
 	// Send OK to the new client.
-	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeOK}))
+	writeMessage(conn, protocol.Message{Type: protocol.TypeOK, Body: s.capabilities, Members: s.members(), Username: renamed})
+	joinSpan.End()
+
+	if motd := s.MOTD(); motd != "" {
+		client.Send(protocol.Encode(protocol.Message{Type: protocol.TypeNotice, Body: client.tr(motd)}))
+	}
+	if mode := s.RoomMode(); mode != (protocol.RoomMode{}) {
+		client.Send(protocol.Encode(protocol.Message{Type: protocol.TypeMode, Body: protocol.EncodeRoomMode(mode)}))
+	}
+
+	// Tell the new client who is away and who has set a status; later
+	// changes arrive as they happen.
+	for name, note := range s.Away() {
+		client.Send(protocol.Encode(protocol.Message{
+			Type:     protocol.TypePresence,
+			Username: name,
+			Body:     protocol.EncodePresence(protocol.Presence{Away: true, Note: note}),
+		}))
+	}
+	for name, text := range s.Statuses() {
+		client.Send(protocol.Encode(protocol.Message{Type: protocol.TypeUserStatus, Username: name, Body: text}))
+	}
 
 	// Notify others that this user joined.
 	s.broadcast(username, protocol.Encode(protocol.Message{
 		Type:     protocol.TypeJoined,
 		Username: username,
+		Members:  s.members(),
 	}))
 
 	// Start read and write loops.
-	go client.writeLoop()
+	written := make(chan struct{})
+	go func() {
+		s.counters.writeLoops.Add(1)
+		defer s.counters.writeLoops.Add(-1)
+		client.writeLoop()
+		close(written)
+	}()
 	client.readLoop()
 
-	// readLoop returned: the client disconnected or sent LEAVE.
+	// readLoop returned: the client disconnected, sent LEAVE or was
+	// kicked. Let the write loop flush what's queued, such as a KICKED
+	// notice, before the connection is closed.
 	close(client.done)
+	client.stopStatus()
 	s.removeClient(username)
+	conn.SetWriteDeadline(time.Now().Add(time.Second))
+	<-written
+}
+
+// rejectJoin replies to a failed handshake with an ERR message, in the
+// locale the JOIN asked for, and records the reason on the JOIN span.
+func (s *ChatServer) rejectJoin(conn net.Conn, span trace.Span, locale, reason string) {
+	writeMessage(conn, protocol.Message{
+		Type: protocol.TypeErr,
+		Body: s.translate(locale, reason),
+	})
+	span.SetStatus(codes.Error, reason)
+	span.End()
 }
 
-// addClient registers a client. Returns false if the username is taken.
+// addClient registers a client. Returns false if the username, or one
+// with the same nameKey, is taken.
 func (s *ChatServer) addClient(c *ConnectedClient) bool {
+	key := s.nameKey(c.username)
 	s.mu.Lock()
 	defer s.mu.Unlock()
-	if _, exists := s.clients[c.username]; exists {
+	if _, exists := s.names[key]; exists {
 		return false
 	}
 	s.clients[c.username] = c
+	s.names[key] = c.username
+	s.routes.join(lobby, c)
 	return true
 }
 
+// maxRenames bounds the derived names addClientRenamed tries.
+const maxRenames = 100
+
+// addClientRenamed registers c under the first free name derived from
+// its username, alice_2, alice_3 and so on, skipping banned and reserved
+// names, and sets c.username to it. It returns false if none is free.
+func (s *ChatServer) addClientRenamed(c *ConnectedClient) bool {
+	base := c.username
+	for n := 2; n < 2+maxRenames; n++ {
+		name := derivedName(base, n)
+		if _, banned := s.banned(name); banned || s.isReserved(name) {
+			continue
+		}
+		c.username = name
+		if s.addClient(c) {
+			log.Printf("%s was taken; admitted as %s", base, name)
+			return true
+		}
+	}
+	c.username = base
+	return false
+}
+
+// derivedName appends "_n" to name, shortening name if need be to keep
+// the result within protocol.MaxUsernameLength without splitting a rune.
+func derivedName(name string, n int) string {
+	suffix := "_" + strconv.Itoa(n)
+	for len(name)+len(suffix) > protocol.MaxUsernameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	return name + suffix
+}
+
+// members counts the users in the room.
+func (s *ChatServer) members() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
 // removeClient unregisters a client and broadcasts a LEFT message.
 func (s *ChatServer) removeClient(username string) {
 	s.mu.Lock()
 	_, exists := s.clients[username]
 	delete(s.clients, username)
+	if exists {
+		delete(s.names, s.nameKey(username))
+		s.recordSeen(username, time.Now())
+	}
+	s.routes.leaveAll(username)
+	members := len(s.clients)
+	if s.draining.Load() && len(s.clients) == 0 {
+		r := s.run.Load()
+		r.drainOnce.Do(func() { close(r.drained) })
+	}
 	s.mu.Unlock()
 
 	if exists {
+		s.events.publish(ClientLeft{Username: username})
 		s.broadcast(username, protocol.Encode(protocol.Message{
 			Type:     protocol.TypeLeft,
 			Username: username,
+			Members:  members,
 		}))
 	}
 }
 
-// broadcast sends a message to all connected clients except the sender.
+// broadcast sends a message to every member of the lobby except the
+// sender.
 func (s *ChatServer) broadcast(sender string, line string) {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-	for name, c := range s.clients {
-		if name != sender {
-			c.Send(line)
+	_, span := s.tracer.Start(context.Background(), "chat.broadcast",
+		trace.WithAttributes(
+			attribute.String("chat.sender", sender),
+			attribute.String("chat.room", lobby),
+		),
+	)
+	defer span.End()
+
+	payload := newLine(line)
+	recipients, dropped := 0, 0
+	s.routes.route(lobby, sender, func(c *ConnectedClient) {
+		recipients++
+		if !c.deliver(outgoing{line: payload}) {
+			dropped++
 		}
-	}
+	})
+
+	span.SetAttributes(
+		attribute.Int("chat.recipients", recipients),
+		attribute.Int("chat.dropped", dropped),
+	)
 }
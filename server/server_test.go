@@ -4,10 +4,16 @@ import (
 	"bufio"
 	"fmt"
 	"net"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/pankaj/simple-chat/netopt"
 	"github.com/pankaj/simple-chat/protocol"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 // helper: connect a raw TCP client, send JOIN, wait for OK.
@@ -53,8 +59,8 @@ func startServer(t *testing.T) *ChatServer {
 
 func TestAddClientUniqueness(t *testing.T) {
 	srv := New()
-	c1 := &ConnectedClient{username: "alice", outbox: make(chan string, 1)}
-	c2 := &ConnectedClient{username: "alice", outbox: make(chan string, 1)}
+	c1 := &ConnectedClient{username: "alice", outbox: make(chan outgoing, 1)}
+	c2 := &ConnectedClient{username: "alice", outbox: make(chan outgoing, 1)}
 
 	if !srv.addClient(c1) {
 		t.Fatal("first addClient should succeed")
@@ -66,7 +72,7 @@ func TestAddClientUniqueness(t *testing.T) {
 
 func TestRemoveClient(t *testing.T) {
 	srv := New()
-	c := &ConnectedClient{username: "alice", outbox: make(chan string, 1)}
+	c := &ConnectedClient{username: "alice", outbox: make(chan outgoing, 1)}
 	srv.addClient(c)
 	srv.removeClient("alice")
 
@@ -80,9 +86,9 @@ func TestRemoveClient(t *testing.T) {
 
 func TestBroadcastExcludesSender(t *testing.T) {
 	srv := New()
-	c1 := &ConnectedClient{username: "alice", outbox: make(chan string, 10)}
-	c2 := &ConnectedClient{username: "bob", outbox: make(chan string, 10)}
-	c3 := &ConnectedClient{username: "charlie", outbox: make(chan string, 10)}
+	c1 := &ConnectedClient{username: "alice", outbox: make(chan outgoing, 10)}
+	c2 := &ConnectedClient{username: "bob", outbox: make(chan outgoing, 10)}
+	c3 := &ConnectedClient{username: "charlie", outbox: make(chan outgoing, 10)}
 
 	srv.addClient(c1)
 	srv.addClient(c2)
@@ -101,8 +107,8 @@ func TestBroadcastExcludesSender(t *testing.T) {
 	for _, c := range []*ConnectedClient{c2, c3} {
 		select {
 		case msg := <-c.outbox:
-			if msg != "MSG|alice|hello" {
-				t.Errorf("expected MSG|alice|hello, got %s", msg)
+			if msg.text() != "MSG|alice|hello" {
+				t.Errorf("expected MSG|alice|hello, got %s", msg.text())
 			}
 		default:
 			t.Errorf("client %s should have received the broadcast", c.username)
@@ -111,20 +117,62 @@ func TestBroadcastExcludesSender(t *testing.T) {
 }
 
 func TestSendNonBlocking(t *testing.T) {
-	c := &ConnectedClient{username: "alice", outbox: make(chan string, 1)}
+	c := &ConnectedClient{username: "alice", outbox: make(chan outgoing, 1)}
 	c.Send("msg1")
 	c.Send("msg2") // should not block, msg2 gets dropped
 
 	select {
 	case msg := <-c.outbox:
-		if msg != "msg1" {
-			t.Errorf("expected msg1, got %s", msg)
+		if msg.text() != "msg1" {
+			t.Errorf("expected msg1, got %s", msg.text())
 		}
 	default:
 		t.Fatal("outbox should have msg1")
 	}
 }
 
+// recordingConn is a net.Conn that records each Write.
+type recordingConn struct {
+	net.Conn
+	mu     sync.Mutex
+	writes []string
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writes = append(c.writes, string(p))
+	return len(p), nil
+}
+
+func TestWriteLoopBatches(t *testing.T) {
+	conn := &recordingConn{}
+	c := newConnectedClient("alice", conn, New())
+	for _, line := range []string{"MSG|bob|one", "MSG|bob|two", "MSG|bob|three"} {
+		c.Send(line)
+	}
+	written := make(chan struct{})
+	go func() {
+		c.writeLoop()
+		close(written)
+	}()
+	time.Sleep(10 * flushDelay)
+
+	conn.mu.Lock()
+	got := conn.writes
+	conn.mu.Unlock()
+	if want := []string{"MSG|bob|one\nMSG|bob|two\nMSG|bob|three\n"}; !slices.Equal(got, want) {
+		t.Errorf("writes = %q, want %q", got, want)
+	}
+
+	c.Send("MSG|bob|four")
+	close(c.done)
+	<-written
+	if got := conn.writes[len(conn.writes)-1]; got != "MSG|bob|four\n" {
+		t.Errorf("last write = %q, want the line queued before closing", got)
+	}
+}
+
 func TestHandleConnectionBadFirstMessage(t *testing.T) {
 	srv := startServer(t)
 	addr := srv.Addr().String()
@@ -147,6 +195,47 @@ func TestHandleConnectionBadFirstMessage(t *testing.T) {
 	}
 }
 
+func TestHandleConnectionInvalidInput(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "JOIN|a|b\n")
+	if line := readLine(t, conn, 2*time.Second); !strings.HasPrefix(line, "ERR|invalid username") {
+		t.Errorf("got %q, want an invalid username error", line)
+	}
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	fmt.Fprintf(alice, "SEND|\x1b[2J\n")
+	if line := readLine(t, alice, 2*time.Second); line != "ERR|invalid message" {
+		t.Errorf("got %q, want ERR|invalid message", line)
+	}
+
+	// Each kind of bad line gets its own error, and overlong ones end the
+	// connection after saying why.
+	fmt.Fprintf(alice, "SEND|\nFROB|x\nSEND|%s\n", strings.Repeat("x", protocol.MaxLineLength))
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(alice)
+	for _, want := range []string{
+		"ERR|message cannot be empty",
+		"ERR|unknown message type",
+		fmt.Sprintf("ERR|line too long: send at most %d bytes", protocol.MaxLineLength),
+	} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	// The server may reset the connection, having not read the rest.
+	if scanner.Scan() {
+		t.Errorf("got %q after an overlong line, want the connection closed", scanner.Text())
+	}
+}
+
 func TestHandleConnectionDuplicateUsername(t *testing.T) {
 	srv := startServer(t)
 	addr := srv.Addr().String()
@@ -175,6 +264,51 @@ func TestHandleConnectionDuplicateUsername(t *testing.T) {
 	}
 }
 
+func TestHandleConnectionRename(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	srv.Ban("alice_2", "impersonation")
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "alice", Rename: true}))
+	msg, err := protocol.Decode(readLine(t, conn, 2*time.Second))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if msg.Type != protocol.TypeOK || msg.Username != "alice_3" {
+		t.Fatalf("got %+v, want OK admitting alice_3", msg)
+	}
+	if line := readLine(t, alice, 2*time.Second); line != "JOINED|alice_3|2" {
+		t.Errorf("alice got %q, want JOINED|alice_3|2", line)
+	}
+}
+
+func TestDerivedName(t *testing.T) {
+	long := strings.Repeat("a", protocol.MaxUsernameLength-2) + "é"
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"alice", 2, "alice_2"},
+		{"alice", 10, "alice_10"},
+		{long, 2, strings.Repeat("a", protocol.MaxUsernameLength-2) + "_2"},
+		{long, 100, strings.Repeat("a", protocol.MaxUsernameLength-4) + "_100"},
+	}
+	for _, tt := range tests {
+		if got := derivedName(tt.name, tt.n); got != tt.want {
+			t.Errorf("derivedName(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
 func TestMessageBroadcast(t *testing.T) {
 	srv := startServer(t)
 	addr := srv.Addr().String()
@@ -261,3 +395,472 @@ func TestLeaveNotification(t *testing.T) {
 		t.Errorf("expected username 'bob', got %q", msg.Username)
 	}
 }
+
+func TestStatsRequest(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+
+	// Drain the JOINED notification.
+	readLine(t, alice, 2*time.Second)
+
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "hi"}))
+	readLine(t, bob, 2*time.Second)
+
+	fmt.Fprintf(bob, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeStats}))
+	line := readLine(t, bob, 2*time.Second)
+	msg, err := protocol.Decode(line)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if msg.Type != protocol.TypeStats {
+		t.Fatalf("expected STATS, got %s", msg.Type)
+	}
+	st, err := protocol.ParseStats(msg.Body)
+	if err != nil {
+		t.Fatalf("failed to parse stats: %v", err)
+	}
+	if st.Users != 2 || st.Rooms != 1 || st.Messages != 1 {
+		t.Errorf("unexpected stats: %+v", st)
+	}
+}
+
+func TestTracingSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	srv := New(WithTracerProvider(tp))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	fmt.Fprintf(alice, "SENDRCPT|1|hello\n")
+	readLine(t, alice, 2*time.Second) // DELIVERED|1|
+	alice.Close()
+	srv.Shutdown()
+
+	names := make(map[string]int)
+	receipts := 0
+	for _, span := range recorder.Ended() {
+		names[span.Name()]++
+		for _, kv := range span.Attributes() {
+			if kv.Key == "chat.receipt" && kv.Value.AsBool() {
+				receipts++
+			}
+		}
+	}
+	if receipts != 1 {
+		t.Errorf("got %d chat.broadcast spans for SENDRCPT, want 1", receipts)
+	}
+	for _, want := range []string{"chat.connection", "chat.join", "chat.broadcast"} {
+		if names[want] == 0 {
+			t.Errorf("expected a %q span, got %v", want, names)
+		}
+	}
+}
+
+func TestDrain(t *testing.T) {
+	srv := New(WithReconnectHint("chat2:8080"))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	if !srv.Ready() {
+		t.Fatal("server should be ready before draining")
+	}
+
+	done := make(chan struct{})
+	go func() {
+		srv.Drain(5 * time.Second)
+		close(done)
+	}()
+
+	// Alice should be asked to reconnect.
+	line := readLine(t, alice, 2*time.Second)
+	msg, err := protocol.Decode(line)
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if msg.Type != protocol.TypeReconnect || msg.Body != "chat2:8080" {
+		t.Fatalf("expected RECONNECT|chat2:8080, got %q", line)
+	}
+	if srv.Ready() {
+		t.Error("server should not be ready while draining")
+	}
+
+	// New users are turned away.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "bob"}))
+	msg, _ = protocol.Decode(readLine(t, conn, 2*time.Second))
+	if msg.Type != protocol.TypeErr || msg.Body != "server draining" {
+		t.Errorf("expected ERR|server draining, got %+v", msg)
+	}
+
+	// Once alice leaves, Drain completes without waiting for the timeout.
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeLeave}))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Drain did not return after the last client left")
+	}
+}
+
+func TestDrainTimeout(t *testing.T) {
+	srv := New()
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+
+	start := time.Now()
+	srv.Drain(100 * time.Millisecond)
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Drain took %s, expected it to give up after the timeout", elapsed)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	srv := New()
+	if srv.Addr() != nil || srv.Addrs() != nil || srv.Ready() {
+		t.Error("a server that isn't listening has an address or is ready")
+	}
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+
+	srv.Drain(10 * time.Millisecond)
+	srv.Shutdown() // a second call is harmless
+	if srv.Ready() {
+		t.Fatal("Ready() after Shutdown")
+	}
+
+	// Listening again starts afresh: the old listener and the drain are
+	// forgotten.
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() after Shutdown error = %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	if !srv.Ready() {
+		t.Error("Ready() = false after listening again")
+	}
+	if n := len(srv.Addrs()); n != 1 {
+		t.Errorf("%d listeners after restart, want 1", n)
+	}
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	if got := srv.Usernames(); !slices.Equal(got, []string{"bob"}) {
+		t.Errorf("Usernames() = %v, want [bob]", got)
+	}
+	if up := srv.Stats().Uptime; up <= 0 || up > time.Second {
+		t.Errorf("Uptime = %s, want it counted from the restart", up)
+	}
+}
+
+func TestListenAllMultipleAddresses(t *testing.T) {
+	srv := New(WithNetwork("tcp4"))
+	if err := srv.ListenAll([]string{"127.0.0.1:0", "127.0.0.1:0"}); err != nil {
+		t.Fatalf("ListenAll() error = %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	addrs := srv.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 listeners, got %d", len(addrs))
+	}
+
+	alice := connectClient(t, addrs[0].String(), "alice")
+	defer alice.Close()
+	bob := connectClient(t, addrs[1].String(), "bob")
+	defer bob.Close()
+
+	// Clients on different listeners share the room.
+	msg, err := protocol.Decode(readLine(t, alice, 2*time.Second))
+	if err != nil || msg.Type != protocol.TypeJoined || msg.Username != "bob" {
+		t.Errorf("expected JOINED|bob, got %+v (%v)", msg, err)
+	}
+}
+
+func TestListenAllAddsListeners(t *testing.T) {
+	srv := New(WithNetwork("tcp4"))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("second Listen() error = %v", err)
+	}
+	addrs := srv.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("expected 2 listeners, got %d", len(addrs))
+	}
+
+	// Shutdown closes the first listener as well as the second.
+	srv.Shutdown()
+	for _, addr := range addrs {
+		if conn, err := net.DialTimeout("tcp", addr.String(), time.Second); err == nil {
+			conn.Close()
+			t.Errorf("listener %s still open after Shutdown", addr)
+		}
+	}
+}
+
+func TestListenAllValidation(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		addrs   []string
+	}{
+		{"no addresses", "tcp", nil},
+		{"bad network", "udp", []string{":0"}},
+		{"missing port", "tcp", []string{"127.0.0.1"}},
+		{"IPv6 literal on tcp4", "tcp4", []string{"[::1]:0"}},
+		{"IPv4 literal on tcp6", "tcp6", []string{"127.0.0.1:0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := New(WithNetwork(tt.network))
+			if err := srv.ListenAll(tt.addrs); err == nil {
+				srv.Shutdown()
+				t.Fatal("ListenAll() expected error, got nil")
+			}
+		})
+	}
+}
+
+func TestListenAllClosesOnPartialFailure(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer taken.Close()
+
+	srv := New()
+	if err := srv.ListenAll([]string{"127.0.0.1:0", taken.Addr().String()}); err == nil {
+		srv.Shutdown()
+		t.Fatal("ListenAll() expected error for an address in use")
+	}
+	if srv.Ready() {
+		t.Error("server should not be ready after a failed ListenAll")
+	}
+}
+
+func TestListenFunc(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer ln.Close()
+
+	// Pass the socket on as an upgrading process would.
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File() error = %v", err)
+	}
+	inherited, err := net.FileListener(f)
+	f.Close()
+	if err != nil {
+		t.Fatalf("FileListener() error = %v", err)
+	}
+
+	var asked string
+	srv := New(WithListenFunc(func(network, addr string) (net.Listener, error) {
+		asked = addr
+		return inherited, nil
+	}))
+	if err := srv.Listen("127.0.0.1:9999"); err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	if asked != "127.0.0.1:9999" {
+		t.Errorf("listen func got %q", asked)
+	}
+
+	addr := ln.Addr().String()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	// Once its copy of the socket is closed the server stops accepting,
+	// leaving new connections to whoever else holds it, but clients
+	// already connected carry on.
+	inherited.Close()
+	accepted := make(chan struct{})
+	go func() {
+		if conn, err := ln.Accept(); err == nil {
+			conn.Close()
+			close(accepted)
+		}
+	}()
+	bob, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("dial error = %v", err)
+	}
+	defer bob.Close()
+	select {
+	case <-accepted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection wasn't accepted on the other copy of the socket")
+	}
+
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypePing, Body: "1"}))
+	msg, err := protocol.Decode(readLine(t, alice, 2*time.Second))
+	if err != nil || msg.Type != protocol.TypePong {
+		t.Errorf("expected PONG, got %+v (%v)", msg, err)
+	}
+}
+
+func TestWhoRequest(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeWho}))
+	msg, err := protocol.Decode(readLine(t, alice, 2*time.Second))
+	if err != nil {
+		t.Fatalf("failed to decode: %v", err)
+	}
+	if msg.Type != protocol.TypeWho || msg.Body != "alice|bob" {
+		t.Errorf("expected WHO|alice|bob, got %+v", msg)
+	}
+}
+
+func TestPresence(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeAway, Body: "lunch"}))
+	want := "PRESENCE|alice|away|lunch"
+	for _, conn := range []net.Conn{alice, bob} {
+		if line := readLine(t, conn, 2*time.Second); line != want {
+			t.Errorf("got %q, want %q", line, want)
+		}
+	}
+
+	// A user joining later is told who is away right after OK.
+	carol, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer carol.Close()
+	fmt.Fprintf(carol, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "carol"}))
+	carol.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(carol)
+	for _, want := range []string{"OK|format commands|3", "PRESENCE|alice|away|lunch"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("carol got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+
+	readLine(t, bob, 2*time.Second) // JOINED|carol
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeBack}))
+	if line := readLine(t, bob, 2*time.Second); line != "PRESENCE|alice|here" {
+		t.Errorf("got %q, want PRESENCE|alice|here", line)
+	}
+	if away := srv.Away(); len(away) != 0 {
+		t.Errorf("Away() = %v after BACK, want none", away)
+	}
+}
+
+func TestPing(t *testing.T) {
+	srv := startServer(t)
+	conn := connectClient(t, srv.Addr().String(), "alice")
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypePing, Body: "7"}))
+	msg, err := protocol.Decode(readLine(t, conn, 2*time.Second))
+	if err != nil || msg.Type != protocol.TypePong || msg.Body != "7" {
+		t.Errorf("expected PONG|7, got %+v (%v)", msg, err)
+	}
+}
+
+func TestSendIDAcknowledged(t *testing.T) {
+	srv := New(WithSpamPolicy(SpamPolicy{Threshold: 1, Window: time.Minute, Action: SpamMute, MuteFor: time.Minute}))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	for _, id := range []string{"1", "2"} {
+		fmt.Fprintf(bob, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSendID, ID: id, Body: "hello"}))
+	}
+
+	bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(bob)
+	for _, want := range []protocol.Message{
+		{Type: protocol.TypeAck, ID: "1"},
+		{Type: protocol.TypeNack, ID: "2", Body: mutedReason},
+	} {
+		if !scanner.Scan() {
+			t.Fatalf("failed to read %s: %v", want.Type, scanner.Err())
+		}
+		msg, err := protocol.Decode(scanner.Text())
+		if err != nil || msg != want {
+			t.Errorf("got %+v (%v), want %+v", msg, err, want)
+		}
+	}
+
+	msg, err := protocol.Decode(readLine(t, alice, 2*time.Second))
+	if err != nil || msg.Type != protocol.TypeMsg || msg.Body != "hello" {
+		t.Errorf("expected broadcast of first message, got %+v (%v)", msg, err)
+	}
+}
+
+func TestTCPOptions(t *testing.T) {
+	srv := New(WithTCPOptions(netopt.TCP{
+		KeepAlive:   time.Minute,
+		Nagle:       true,
+		ReadBuffer:  16 << 10,
+		WriteBuffer: 16 << 10,
+		Linger:      time.Second,
+	}))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	fmt.Fprintf(bob, "SEND|hello\n")
+	if got := readLine(t, alice, 2*time.Second); got != "MSG|bob|hello" {
+		t.Errorf("got %q, want MSG|bob|hello", got)
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestShadowBan(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+
+	troll := connectClient(t, addr, "troll")
+	defer troll.Close()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+
+	srv.ShadowBan("troll")
+	if got := srv.ShadowBans(); len(got) != 1 || got[0] != "troll" {
+		t.Errorf("ShadowBans() = %v, want [troll]", got)
+	}
+
+	// The troll sees everything succeed as usual.
+	troll.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(troll)
+	fmt.Fprintf(troll, "SEND|first\nSENDID|1|second\nSENDRCPT|2|third\n")
+	for _, want := range []string{"JOINED|alice|2", "ACK|1", "ACK|2", "DELIVERED|2|+alice"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("troll got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+
+	// Nobody else does; the next thing alice sees is after the ban is lifted.
+	if !srv.Unshadow("troll") || srv.Unshadow("troll") {
+		t.Error("Unshadow() should report true once")
+	}
+	fmt.Fprintf(troll, "SEND|sorry\n")
+	if line := readLine(t, alice, 2*time.Second); line != "MSG|troll|sorry" {
+		t.Errorf("alice got %q, want only the message sent after Unshadow", line)
+	}
+}
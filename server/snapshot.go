@@ -0,0 +1,171 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// ErrNoStateFile is returned by SaveState and LoadState when no state file
+// was configured with WithStateFile.
+var ErrNoStateFile = errors.New("no state file configured")
+
+// Snapshot is the server state worth keeping across a restart: what
+// operators have set up and the moderation queue. Connections and presence
+// are not included; clients reconnect and rejoin.
+type Snapshot struct {
+	Saved      time.Time         `json:"saved"`
+	Bans       map[string]string `json:"bans,omitempty"` // username -> reason
+	ShadowBans []string          `json:"shadow_bans,omitempty"`
+	Reserved   []string          `json:"reserved,omitempty"` // usernames kept for registered users
+	MOTD       string            `json:"motd,omitempty"`
+	Announce   bool              `json:"announce,omitempty"`  // room in announcement mode
+	SlowMode   time.Duration     `json:"slow_mode,omitempty"` // room's slow mode interval
+
+	// LastSeen holds when users last disconnected, and HideSeen the
+	// users who asked for that not to be recorded.
+	LastSeen map[string]time.Time `json:"last_seen,omitempty"`
+	HideSeen []string             `json:"hide_seen,omitempty"`
+
+	// Recent holds the last broadcast messages, oldest first, so that
+	// they can still be reported after a restart.
+	Recent  []RecentMessage `json:"recent,omitempty"`
+	Reports []Report        `json:"reports,omitempty"`
+}
+
+// RecentMessage is a broadcast message in a Snapshot.
+type RecentMessage struct {
+	Author string `json:"author"`
+	Text   string `json:"text"`
+}
+
+// Snapshot returns the server's current state.
+func (s *ChatServer) Snapshot() Snapshot {
+	snap := Snapshot{
+		Saved:      time.Now(),
+		Bans:       s.Bans(),
+		ShadowBans: s.ShadowBans(),
+		Reserved:   s.Reservations(),
+		MOTD:       s.MOTD(),
+		Reports:    s.Reports(),
+	}
+	mode := s.RoomMode()
+	snap.Announce, snap.SlowMode = mode.Announce, mode.Slow
+	s.mu.RLock()
+	snap.LastSeen = maps.Clone(s.seen)
+	for name := range s.hideSeen {
+		snap.HideSeen = append(snap.HideSeen, name)
+	}
+	s.mu.RUnlock()
+	slices.Sort(snap.HideSeen)
+	m := &s.moderation
+	m.mu.Lock()
+	// recent is a ring: once full, the oldest message is at next.
+	for i := range m.recent {
+		r := m.recent[(m.next+i)%len(m.recent)]
+		snap.Recent = append(snap.Recent, RecentMessage{Author: r.author, Text: r.text})
+	}
+	m.mu.Unlock()
+	return snap
+}
+
+// Restore replaces the server's state with snap. It is meant to be called
+// at startup, before clients connect: bans it restores don't kick anyone.
+func (s *ChatServer) Restore(snap Snapshot) {
+	s.mu.Lock()
+	s.bans = make(map[string]Ban, len(snap.Bans))
+	for name, reason := range snap.Bans {
+		name = protocol.NormalizeUsername(name)
+		s.bans[s.nameKey(name)] = Ban{Name: name, Reason: reason}
+	}
+	s.shadowBans = make(map[string]string, len(snap.ShadowBans))
+	for _, name := range snap.ShadowBans {
+		s.shadowBans[s.nameKey(name)] = protocol.NormalizeUsername(name)
+	}
+	s.reserved = make(map[string]string, len(snap.Reserved))
+	for _, name := range snap.Reserved {
+		s.reserved[s.nameKey(name)] = protocol.NormalizeUsername(name)
+	}
+	s.motd = snap.MOTD
+	s.mode = protocol.RoomMode{Announce: snap.Announce, Slow: snap.SlowMode}
+	s.seen = make(map[string]time.Time, len(snap.LastSeen))
+	for name, at := range snap.LastSeen {
+		s.seen[name] = at
+	}
+	s.hideSeen = make(map[string]struct{}, len(snap.HideSeen))
+	for _, name := range snap.HideSeen {
+		s.hideSeen[name] = struct{}{}
+		delete(s.seen, name)
+	}
+	s.mu.Unlock()
+
+	m := &s.moderation
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.recent, m.next = nil, 0
+	for _, r := range snap.Recent[max(0, len(snap.Recent)-recentMessages):] {
+		m.recent = append(m.recent, recentMessage{r.Author, r.Text})
+	}
+	m.reports, m.lastID = nil, 0
+	for _, r := range snap.Reports[:min(len(snap.Reports), maxReports)] {
+		r.Reporters = slices.Clone(r.Reporters)
+		m.reports = append(m.reports, &r)
+		m.lastID = max(m.lastID, r.ID)
+	}
+}
+
+// SaveState writes a Snapshot to the file set with WithStateFile. The file
+// is replaced atomically, so a crash while saving leaves the previous one.
+func (s *ChatServer) SaveState() error {
+	if s.stateFile == "" {
+		return ErrNoStateFile
+	}
+	data, err := json.MarshalIndent(s.Snapshot(), "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.stateFile), filepath.Base(s.stateFile)+".*")
+	if err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	defer os.Remove(tmp.Name()) // fails harmlessly once renamed
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("saving state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.stateFile); err != nil {
+		return fmt.Errorf("saving state: %w", err)
+	}
+	return nil
+}
+
+// LoadState restores the Snapshot in the file set with WithStateFile. A
+// missing file is not an error: there is nothing to restore on first run.
+func (s *ChatServer) LoadState() error {
+	if s.stateFile == "" {
+		return ErrNoStateFile
+	}
+	data, err := os.ReadFile(s.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("loading state: %w", err)
+	}
+	var snap Snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("loading state from %s: %w", s.stateFile, err)
+	}
+	s.Restore(snap)
+	return nil
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSaveAndLoadState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	srv := New(WithStateFile(path))
+	srv.Ban("mallory", "spamming")
+	srv.ShadowBan("eve")
+	srv.Reserve("carol")
+	srv.SetMOTD("be kind")
+	for i := range recentMessages + 10 {
+		srv.moderation.remember("bob", fmt.Sprintf("message %d", i))
+	}
+	if err := srv.moderation.report("alice", "bob", "message 260"); err != nil {
+		t.Fatal(err)
+	}
+	if err := srv.SaveState(); err != nil {
+		t.Fatalf("SaveState() error = %v", err)
+	}
+
+	restored := New(WithStateFile(path))
+	if err := restored.LoadState(); err != nil {
+		t.Fatalf("LoadState() error = %v", err)
+	}
+	want, got := srv.Snapshot(), restored.Snapshot()
+	// Times come back from JSON in UTC without a monotonic reading.
+	got.Saved = want.Saved
+	for i := range min(len(got.Reports), len(want.Reports)) {
+		if !got.Reports[i].Reported.Equal(want.Reports[i].Reported) {
+			t.Errorf("report %d restored as reported at %v, want %v", i, got.Reports[i].Reported, want.Reports[i].Reported)
+		}
+		got.Reports[i].Reported = want.Reports[i].Reported
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("restored snapshot = %+v\nwant %+v", got, want)
+	}
+	if got := want.Recent[0].Text; got != "message 10" {
+		t.Errorf("oldest recent message = %q, want message 10", got)
+	}
+
+	// Restored messages can still be reported, and new reports don't
+	// reuse IDs.
+	if err := restored.moderation.report("carol", "bob", "message 100"); err != nil {
+		t.Errorf("reporting a restored message: %v", err)
+	}
+	if reports := restored.Reports(); reports[len(reports)-1].ID != 2 {
+		t.Errorf("new report ID = %d, want 2", reports[len(reports)-1].ID)
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	srv := New(WithStateFile(filepath.Join(t.TempDir(), "state.json")))
+	if err := srv.LoadState(); err != nil {
+		t.Errorf("LoadState() with no file yet = %v, want nil", err)
+	}
+	if err := New().SaveState(); err != ErrNoStateFile {
+		t.Errorf("SaveState() without a state file = %v, want ErrNoStateFile", err)
+	}
+}
+
+func TestAdminSaveState(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state.json")
+	for _, tc := range []struct {
+		srv  *ChatServer
+		want int
+	}{
+		{New(), http.StatusNotFound},
+		{New(WithStateFile(path)), http.StatusNoContent},
+	} {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/state", nil)
+		req.Header.Set("Content-Type", "application/json")
+		tc.srv.AdminHandler().ServeHTTP(rec, req)
+		if rec.Code != tc.want {
+			t.Errorf("POST /state = %d, want %d", rec.Code, tc.want)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state file not written: %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+//go:build soak
+
+package server
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// The soak test is opt-in:
+//
+//	go test -tags soak -run Soak -timeout 0 ./server -soak.duration 4h
+var (
+	soakDuration = flag.Duration("soak.duration", time.Minute, "how long TestSoak runs")
+	soakWorkers  = flag.Int("soak.workers", 50, "concurrent simulated clients in TestSoak")
+)
+
+// soakNames is deliberately small so workers compete for usernames.
+const soakNames = 20
+
+// holders tracks which worker holds each username, to catch the server
+// accepting the same name twice.
+type holders struct {
+	mu    sync.Mutex
+	names map[string]int
+}
+
+func (h *holders) acquire(t *testing.T, name string, worker int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if other, ok := h.names[name]; ok {
+		t.Errorf("server accepted %q for worker %d while worker %d holds it", name, worker, other)
+	}
+	h.names[name] = worker
+}
+
+// release must be called before the connection is closed, so a later
+// JOIN can't succeed while the name is still recorded as held.
+func (h *holders) release(name string) {
+	h.mu.Lock()
+	delete(h.names, name)
+	h.mu.Unlock()
+}
+
+// TestSoak runs workers that randomly join, chat, flood, send garbage,
+// half-close and drop connections against one server. It checks that
+// usernames are never shared, that the user count stays consistent,
+// and that no goroutines are left once everyone has gone. A panic
+// anywhere in the server fails the run.
+func TestSoak(t *testing.T) {
+	out := log.Writer()
+	log.SetOutput(io.Discard) // drops and spam are expected
+	defer log.SetOutput(out)
+
+	baseline := runtime.NumGoroutine()
+	srv := New(WithSpamPolicy(SpamPolicy{Threshold: 20, Window: time.Second, Action: SpamDisconnect}))
+	if err := srv.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	addr := srv.Addr().String()
+
+	h := &holders{names: make(map[string]int)}
+	var sessions, accepted atomic.Int64
+	deadline := time.Now().Add(*soakDuration)
+	var wg sync.WaitGroup
+	for w := range *soakWorkers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rng := rand.New(rand.NewPCG(uint64(w), uint64(time.Now().UnixNano())))
+			for time.Now().Before(deadline) && !t.Failed() {
+				sessions.Add(1)
+				if soakSession(t, addr, w, rng, h) {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+
+	// Check server-wide invariants while the workers run.
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-ticker.C:
+			if users, names := srv.Stats().Users, len(srv.Usernames()); users > soakNames || names > soakNames {
+				t.Errorf("%d users (%d names) connected, but only %d names exist", users, names, soakNames)
+			}
+		}
+	}
+	t.Logf("%d sessions, %d joined", sessions.Load(), accepted.Load())
+
+	// Everyone has disconnected; the server should notice promptly.
+	waitUntil(t, 10*time.Second, "all users to leave", func() bool { return len(srv.Usernames()) == 0 })
+	srv.Shutdown()
+	waitUntil(t, 10*time.Second, "goroutines to exit", func() bool { return runtime.NumGoroutine() <= baseline })
+}
+
+// soakSession runs one connection through a random script. It reports
+// whether the JOIN was accepted.
+func soakSession(t *testing.T, addr string, worker int, rng *rand.Rand, h *holders) bool {
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		t.Errorf("dial: %v", err)
+		return false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+
+	if rng.IntN(20) == 0 {
+		// Never send JOIN: the handshake must time out or notice the close.
+		time.Sleep(time.Duration(rng.IntN(200)) * time.Millisecond)
+		return false
+	}
+
+	name := fmt.Sprintf("user%d", rng.IntN(soakNames))
+	fmt.Fprintf(conn, "JOIN|%s\n", name)
+	reply, err := readReply(conn)
+	if err != nil || !strings.HasPrefix(reply, "OK") {
+		return false
+	}
+	h.acquire(t, name, worker)
+	held := true
+	// giveUp releases the name before doing something the server may
+	// disconnect us for, since we'd no longer know when the name frees up.
+	giveUp := func() {
+		if held {
+			h.release(name)
+			held = false
+		}
+	}
+
+	// Read everything in the background so the server never blocks on us,
+	// unless this session plays a client that has stopped reading.
+	if rng.IntN(10) > 0 {
+		go io.Copy(io.Discard, conn)
+	}
+
+	for range rng.IntN(50) {
+		var line string
+		switch rng.IntN(10) {
+		case 0:
+			giveUp()
+			line = strings.Repeat("x", rng.IntN(2*protocol.MaxLineLength))
+		case 1:
+			line = string([]byte{byte(rng.IntN(256)), 0, 0xff, '|'})
+		case 2:
+			line = "WHO"
+		case 3:
+			line = "AWAY|soaking"
+		case 4:
+			line = "BACK"
+		case 5:
+			// Flood: the spam policy should disconnect us eventually.
+			giveUp()
+			for range 30 {
+				fmt.Fprintf(conn, "SEND|flood\n")
+			}
+			continue
+		default:
+			line = fmt.Sprintf("SENDID|%d|hello from %s", rng.IntN(1000), name)
+		}
+		if _, err := fmt.Fprintf(conn, "%s\n", line); err != nil {
+			break
+		}
+		time.Sleep(time.Duration(rng.IntN(5)) * time.Millisecond)
+	}
+
+	giveUp()
+	switch rng.IntN(3) {
+	case 0:
+		fmt.Fprintf(conn, "LEAVE\n")
+	case 1:
+		// Half-close: the server sees EOF but may still write to us.
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	// Otherwise just drop the connection.
+	return true
+}
+
+// readReply reads the server's answer to JOIN a byte at a time, so the
+// background reader sees everything after it.
+func readReply(conn net.Conn) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+}
+
+func waitUntil(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<20)
+			t.Fatalf("timed out waiting for %s; goroutines:\n%s", what, buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
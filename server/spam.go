@@ -0,0 +1,101 @@
+package server
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// SpamAction is what the server does to a client caught repeating itself.
+type SpamAction int
+
+const (
+	// SpamMute drops the client's messages for SpamPolicy.MuteFor.
+	SpamMute SpamAction = iota
+	// SpamDisconnect closes the client's connection.
+	SpamDisconnect
+)
+
+// SpamPolicy configures duplicate-message detection. A client that sends
+// the same body more than Threshold times within Window is flagged and
+// handled according to Action. Bodies are compared case-insensitively with
+// surrounding whitespace ignored.
+type SpamPolicy struct {
+	Threshold int
+	Window    time.Duration
+	Action    SpamAction
+	MuteFor   time.Duration
+}
+
+// mutedReason is reported to a client whose messages are being dropped.
+const mutedReason = "muted for repeating the same message"
+
+// maxSpamHistory bounds the per-client memory used by the detector.
+const maxSpamHistory = 64
+
+type spamVerdict int
+
+const (
+	spamAllow spamVerdict = iota
+	spamMuted             // newly muted: tell the client once
+	spamDrop              // already muted: drop silently
+	spamDisconnect
+)
+
+type sentBody struct {
+	hash uint64
+	at   time.Time
+}
+
+// spamDetector tracks one client's recent messages. It is only used from
+// that client's read loop, so it needs no locking.
+type spamDetector struct {
+	policy     SpamPolicy
+	recent     []sentBody
+	mutedUntil time.Time
+}
+
+func newSpamDetector(p SpamPolicy) *spamDetector {
+	return &spamDetector{policy: p}
+}
+
+// check records body as sent at now and returns what to do with it.
+func (d *spamDetector) check(body string, now time.Time) spamVerdict {
+	if now.Before(d.mutedUntil) {
+		return spamDrop
+	}
+
+	// Forget messages that fell out of the window.
+	cutoff := now.Add(-d.policy.Window)
+	i := 0
+	for i < len(d.recent) && d.recent[i].at.Before(cutoff) {
+		i++
+	}
+	d.recent = d.recent[i:]
+
+	h := fnv.New64a()
+	h.Write([]byte(strings.ToLower(strings.TrimSpace(body))))
+	sum := h.Sum64()
+
+	repeats := 0
+	for _, sb := range d.recent {
+		if sb.hash == sum {
+			repeats++
+		}
+	}
+
+	if len(d.recent) == maxSpamHistory {
+		d.recent = d.recent[1:]
+	}
+	d.recent = append(d.recent, sentBody{hash: sum, at: now})
+
+	if repeats < d.policy.Threshold {
+		return spamAllow
+	}
+	if d.policy.Action == SpamDisconnect {
+		return spamDisconnect
+	}
+	d.mutedUntil = now.Add(d.policy.MuteFor)
+	d.recent = d.recent[:0]
+	return spamMuted
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestSpamDetectorMute(t *testing.T) {
+	d := newSpamDetector(SpamPolicy{Threshold: 2, Window: time.Minute, Action: SpamMute, MuteFor: time.Minute})
+	now := time.Now()
+
+	for i, want := range []spamVerdict{spamAllow, spamAllow, spamMuted, spamDrop} {
+		if got := d.check("buy now", now); got != want {
+			t.Fatalf("message %d: verdict = %v, want %v", i, got, want)
+		}
+	}
+
+	// Muted clients can't send anything else either.
+	if got := d.check("something else", now); got != spamDrop {
+		t.Errorf("verdict while muted = %v, want drop", got)
+	}
+
+	// After the mute expires, the client starts with a clean slate.
+	if got := d.check("buy now", now.Add(2*time.Minute)); got != spamAllow {
+		t.Errorf("verdict after mute = %v, want allow", got)
+	}
+}
+
+func TestSpamDetectorNormalizesBodies(t *testing.T) {
+	d := newSpamDetector(SpamPolicy{Threshold: 1, Window: time.Minute, Action: SpamDisconnect})
+	now := time.Now()
+
+	d.check("Hello", now)
+	if got := d.check("  hello ", now); got != spamDisconnect {
+		t.Errorf("verdict = %v, want disconnect", got)
+	}
+}
+
+func TestSpamDetectorWindow(t *testing.T) {
+	d := newSpamDetector(SpamPolicy{Threshold: 1, Window: time.Second, Action: SpamDisconnect})
+	now := time.Now()
+
+	d.check("ping", now)
+	if got := d.check("ping", now.Add(2*time.Second)); got != spamAllow {
+		t.Errorf("repeat outside window: verdict = %v, want allow", got)
+	}
+	if got := d.check("pong", now.Add(2*time.Second)); got != spamAllow {
+		t.Errorf("different body: verdict = %v, want allow", got)
+	}
+}
+
+func TestSpamDetectorBoundedHistory(t *testing.T) {
+	d := newSpamDetector(SpamPolicy{Threshold: 1000, Window: time.Hour})
+	now := time.Now()
+	for i := 0; i < 10*maxSpamHistory; i++ {
+		d.check(fmt.Sprintf("msg %d", i), now)
+	}
+	if len(d.recent) > maxSpamHistory {
+		t.Errorf("history grew to %d entries, want at most %d", len(d.recent), maxSpamHistory)
+	}
+}
+
+func TestSpamDisconnect(t *testing.T) {
+	srv := New(WithSpamPolicy(SpamPolicy{Threshold: 1, Window: time.Minute, Action: SpamDisconnect}))
+	if err := srv.Listen(":0"); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	t.Cleanup(srv.Shutdown)
+	addr := srv.Addr().String()
+
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	send := protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "spam"})
+	fmt.Fprintf(bob, "%s\n", send)
+	fmt.Fprintf(bob, "%s\n", send)
+
+	// Alice sees the first copy, then bob leaving.
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(alice)
+	for _, want := range []string{protocol.TypeMsg, protocol.TypeLeft} {
+		if !scanner.Scan() {
+			t.Fatalf("failed to read %s: %v", want, scanner.Err())
+		}
+		msg, err := protocol.Decode(scanner.Text())
+		if err != nil || msg.Type != want {
+			t.Fatalf("expected %s, got %+v (%v)", want, msg, err)
+		}
+	}
+
+	msg, err := protocol.Decode(readLine(t, bob, 2*time.Second))
+	if err != nil || msg.Type != protocol.TypeKicked {
+		t.Errorf("expected KICKED for spammer, got %+v (%v)", msg, err)
+	}
+}
@@ -0,0 +1,207 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/ssh"
+	"github.com/pankaj/simple-chat/protocol"
+	gossh "golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// sshUserKey is the ssh.Context key under which the username a key maps
+// to is stored during authentication.
+type sshUserKey struct{}
+
+// SSHConfig configures ListenSSH.
+type SSHConfig struct {
+	// HostKey is the server's private host key.
+	HostKey gossh.Signer
+
+	// Users maps public key fingerprints, in the "SHA256:..." form printed
+	// by ssh-keygen -l, to the username each key joins as. Other keys are
+	// refused.
+	Users map[string]string
+
+	// ReserveNames reserves the usernames in Users, so that only their
+	// keys' owners can join under them, over SSH; see Reserve.
+	ReserveNames bool
+}
+
+// ListenSSH binds addr and accepts people connecting with an SSH client,
+// as in "ssh -p 2222 chat.example.com". They are authenticated by public
+// key, join under the username their key maps to, and chat in a simple
+// line-editing terminal session with the commands of plain-text mode. Call
+// it after Listen; Shutdown closes it with the other listeners.
+func (s *ChatServer) ListenSSH(addr string, cfg SSHConfig) error {
+	if err := validateListenAddr(s.network, addr); err != nil {
+		return err
+	}
+	ln, err := s.listen(s.network, addr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", addr, err)
+	}
+
+	srv := &ssh.Server{
+		Handler: s.handleSSH,
+		ConnCallback: func(ctx ssh.Context, conn net.Conn) net.Conn {
+			if !s.admit(conn.RemoteAddr()) {
+				return nil
+			}
+			if err := s.tcp.Apply(conn); err != nil {
+				log.Printf("%s: %v", conn.RemoteAddr(), err)
+			}
+			return conn
+		},
+		PublicKeyHandler: func(ctx ssh.Context, key ssh.PublicKey) bool {
+			name, ok := cfg.Users[gossh.FingerprintSHA256(key)]
+			if ok {
+				ctx.SetValue(sshUserKey{}, protocol.NormalizeUsername(name))
+			}
+			return ok
+		},
+	}
+	srv.AddHostKey(cfg.HostKey)
+	if cfg.ReserveNames {
+		for _, name := range cfg.Users {
+			s.Reserve(name)
+		}
+	}
+
+	// Hold the lifecycle lock so a concurrent Shutdown either sees the
+	// server and closes it or happens before it is added.
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	s.startLocked()
+	s.sshServer = srv
+	s.sshAddr = ln.Addr()
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		srv.Serve(ln)
+	}()
+	return nil
+}
+
+// SSHAddr returns the address of the SSH listener, or nil if ListenSSH
+// hasn't been called.
+func (s *ChatServer) SSHAddr() net.Addr {
+	s.lifecycle.Lock()
+	defer s.lifecycle.Unlock()
+	return s.sshAddr
+}
+
+// handleSSH runs one SSH session as a chat connection.
+func (s *ChatServer) handleSSH(sess ssh.Session) {
+	pty, winCh, ok := sess.Pty()
+	if !ok {
+		io.WriteString(sess, "This chat needs an interactive terminal; connect with ssh -t.\n")
+		sess.Exit(1)
+		return
+	}
+	name, _ := sess.Context().Value(sshUserKey{}).(string)
+
+	t := term.NewTerminal(sess, "> ")
+	t.SetSize(pty.Window.Width, pty.Window.Height)
+	go func() {
+		for win := range winCh {
+			t.SetSize(win.Width, win.Height)
+		}
+	}()
+
+	conn := newSSHConn(sess, t)
+	pc := &plainConn{
+		Conn:     conn,
+		readLine: conn.readLine,
+		out:      t,
+		username: name,
+		pending:  []byte(protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: name}) + "\n"),
+
+		authenticated: true,
+	}
+	if !s.track() {
+		io.WriteString(sess, "The server is shutting down.\n")
+		sess.Exit(1)
+		return
+	}
+	s.handleConnection(pc, false, false)
+}
+
+// sshConn presents an SSH session as a net.Conn. Lines are read from the
+// terminal in the background so that read deadlines, which the server
+// uses to end handshakes and kick users, can interrupt a read without
+// ending the session.
+type sshConn struct {
+	ssh.Session
+	lines chan string // closed when the terminal stops reading
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired chan struct{} // closed when the read deadline passes
+}
+
+func newSSHConn(sess ssh.Session, t *term.Terminal) *sshConn {
+	c := &sshConn{Session: sess, lines: make(chan string), expired: make(chan struct{})}
+	go func() {
+		defer close(c.lines)
+		for {
+			line, err := t.ReadLine()
+			if err != nil {
+				return
+			}
+			select {
+			case c.lines <- line:
+			case <-sess.Context().Done():
+				return
+			}
+		}
+	}()
+	return c
+}
+
+// readLine returns the next line typed, failing once the read deadline
+// has passed.
+func (c *sshConn) readLine() (string, error) {
+	c.mu.Lock()
+	expired := c.expired
+	c.mu.Unlock()
+	select {
+	case line, ok := <-c.lines:
+		if !ok {
+			return "", io.EOF
+		}
+		return line, nil
+	case <-expired:
+		return "", os.ErrDeadlineExceeded
+	}
+}
+
+func (c *sshConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *sshConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.expired = make(chan struct{})
+	if !t.IsZero() {
+		expired := c.expired
+		c.timer = time.AfterFunc(time.Until(t), func() { close(expired) })
+	}
+	return nil
+}
+
+// SetWriteDeadline does nothing: SSH channels have flow control of their
+// own.
+func (c *sshConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
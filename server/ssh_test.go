@@ -0,0 +1,129 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	gossh "golang.org/x/crypto/ssh"
+)
+
+func newSSHSigner(t *testing.T) gossh.Signer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := gossh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func TestSSH(t *testing.T) {
+	srv := startServer(t)
+	userKey, strangerKey := newSSHSigner(t), newSSHSigner(t)
+	err := srv.ListenSSH("127.0.0.1:0", SSHConfig{
+		HostKey: newSSHSigner(t),
+		Users:   map[string]string{gossh.FingerprintSHA256(userKey.PublicKey()): "alice"},
+
+		ReserveNames: true,
+	})
+	if err != nil {
+		t.Fatalf("ListenSSH: %v", err)
+	}
+	if got := joinAs(t, srv.Addr().String(), "alice"); got != "ERR|NAME_RESERVED" {
+		t.Errorf("TCP JOIN as alice got %q, want ERR|NAME_RESERVED", got)
+	}
+
+	dial := func(key gossh.Signer) (*gossh.Client, error) {
+		return gossh.Dial("tcp", srv.SSHAddr().String(), &gossh.ClientConfig{
+			User:            "whoever",
+			Auth:            []gossh.AuthMethod{gossh.PublicKeys(key)},
+			HostKeyCallback: gossh.InsecureIgnoreHostKey(),
+			Timeout:         2 * time.Second,
+		})
+	}
+	if _, err := dial(strangerKey); err == nil {
+		t.Error("unknown key was accepted")
+	}
+
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	bobLines := bufio.NewScanner(bob)
+	bobExpect := func(want string) {
+		t.Helper()
+		bob.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if !bobLines.Scan() || bobLines.Text() != want {
+			t.Fatalf("bob got %q (%v), want %q", bobLines.Text(), bobLines.Err(), want)
+		}
+	}
+
+	client, err := dial(userKey)
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	defer client.Close()
+	sess, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sess.Close()
+	if err := sess.RequestPty("xterm", 24, 80, gossh.TerminalModes{}); err != nil {
+		t.Fatal(err)
+	}
+	stdin, _ := sess.StdinPipe()
+	stdout, _ := sess.StdoutPipe()
+	if err := sess.Shell(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Output is interleaved with terminal control sequences, so look for
+	// text rather than whole lines.
+	out := make(chan []byte)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := stdout.Read(buf)
+			if err != nil {
+				close(out)
+				return
+			}
+			out <- bytes.Clone(buf[:n])
+		}
+	}()
+	var seen strings.Builder
+	expect := func(want string) {
+		t.Helper()
+		timeout := time.After(2 * time.Second)
+		for !strings.Contains(seen.String(), want) {
+			select {
+			case b, ok := <-out:
+				if !ok {
+					t.Fatalf("session ended before %q; got %q", want, seen.String())
+				}
+				seen.Write(b)
+			case <-timeout:
+				t.Fatalf("no %q in %q", want, seen.String())
+			}
+		}
+	}
+
+	expect("Welcome, alice!")
+	bobExpect("JOINED|alice|2")
+
+	io.WriteString(stdin, "hello from ssh\r")
+	bobExpect("MSG|alice|hello from ssh")
+
+	bob.Write([]byte("SEND|hi alice\n"))
+	expect("<bob> hi alice")
+
+	io.WriteString(stdin, "/quit\r")
+	bobExpect("LEFT|alice|1")
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+// maxStatusLength bounds a status text, in characters, so it fits next to
+// a name in WHO listings.
+const maxStatusLength = 100
+
+// statusTooLongReason is reported for a STATUS longer than maxStatusLength.
+const statusTooLongReason = "status is too long"
+
+// Statuses returns the users who have set a status text, mapped to it.
+func (s *ChatServer) Statuses() map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	statuses := make(map[string]string)
+	for name, c := range s.clients {
+		if text := c.statusText(); text != "" {
+			statuses[name] = text
+		}
+	}
+	return statuses
+}
+
+// statusText returns the user's status text, or "" if none is set.
+func (c *ConnectedClient) statusText() string {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	return c.status
+}
+
+// setStatus replaces the user's status text and tells everyone if it
+// changed. A status with an Expiry is cleared once it has passed, unless
+// it was replaced first.
+func (c *ConnectedClient) setStatus(st protocol.Status) {
+	c.statusMu.Lock()
+	if c.statusTimer != nil {
+		c.statusTimer.Stop()
+		c.statusTimer = nil
+	}
+	changed := c.status != st.Text
+	c.status = st.Text
+	if st.Text != "" && st.Expiry > 0 {
+		var timer *time.Timer
+		timer = time.AfterFunc(st.Expiry, func() { c.expireStatus(timer) })
+		c.statusTimer = timer
+	}
+	c.statusMu.Unlock()
+
+	if changed {
+		c.broadcastStatus(st.Text)
+	}
+}
+
+// expireStatus clears the status that timer was started for, if it's
+// still the current one and the user is still connected.
+func (c *ConnectedClient) expireStatus(timer *time.Timer) {
+	c.statusMu.Lock()
+	if c.statusTimer != timer {
+		c.statusMu.Unlock()
+		return
+	}
+	c.statusTimer, c.status = nil, ""
+	c.statusMu.Unlock()
+
+	select {
+	case <-c.done:
+	default:
+		c.broadcastStatus("")
+	}
+}
+
+// stopStatus cancels a pending status expiry when the user disconnects.
+func (c *ConnectedClient) stopStatus() {
+	c.statusMu.Lock()
+	defer c.statusMu.Unlock()
+	if c.statusTimer != nil {
+		c.statusTimer.Stop()
+		c.statusTimer = nil
+	}
+}
+
+// broadcastStatus tells every user, including this one, about this
+// user's new status text.
+func (c *ConnectedClient) broadcastStatus(text string) {
+	c.server.broadcast("", protocol.Encode(protocol.Message{
+		Type:     protocol.TypeUserStatus,
+		Username: c.username,
+		Body:     text,
+	}))
+}
+
+// validStatus reports whether text is short enough to be a status.
+func validStatus(text string) bool {
+	return utf8.RuneCountInString(text) <= maxStatusLength
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatus(t *testing.T) {
+	srv := startServer(t)
+	addr := srv.Addr().String()
+	alice := connectClient(t, addr, "alice")
+	defer alice.Close()
+	bob := connectClient(t, addr, "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob|2
+
+	fmt.Fprintf(bob, "STATUS|0|in a meeting\n")
+	for _, conn := range []net.Conn{alice, bob} {
+		if line := readLine(t, conn, 2*time.Second); line != "USERSTATUS|bob|in a meeting" {
+			t.Fatalf("got %q, want USERSTATUS|bob|in a meeting", line)
+		}
+	}
+	if users := srv.Users(); users[1].Status != "in a meeting" {
+		t.Errorf("Users() status = %q, want in a meeting", users[1].Status)
+	}
+
+	carol, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer carol.Close()
+	fmt.Fprintf(carol, "JOIN|carol\n")
+	carol.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(carol)
+	for _, want := range []string{"OK|format commands|3", "USERSTATUS|bob|in a meeting"} {
+		if !scanner.Scan() || scanner.Text() != want {
+			t.Fatalf("carol got %q (%v), want %q", scanner.Text(), scanner.Err(), want)
+		}
+	}
+	for _, conn := range []net.Conn{alice, bob} {
+		readLine(t, conn, 2*time.Second) // JOINED|carol|3
+	}
+
+	fmt.Fprintf(bob, "STATUS|0|%s\n", strings.Repeat("x", maxStatusLength+1))
+	if line := readLine(t, bob, 2*time.Second); line != "ERR|"+statusTooLongReason {
+		t.Errorf("bob got %q, want ERR|%s", line, statusTooLongReason)
+	}
+
+	fmt.Fprintf(bob, "STATUS|1|back in a sec\n")
+	if line := readLine(t, alice, 2*time.Second); line != "USERSTATUS|bob|back in a sec" {
+		t.Fatalf("alice got %q, want USERSTATUS|bob|back in a sec", line)
+	}
+	if line := readLine(t, alice, 3*time.Second); line != "USERSTATUS|bob" {
+		t.Errorf("alice got %q after the status expired, want USERSTATUS|bob", line)
+	}
+	if statuses := srv.Statuses(); len(statuses) != 0 {
+		t.Errorf("Statuses() = %v after expiry, want none", statuses)
+	}
+}
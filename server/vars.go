@@ -0,0 +1,66 @@
+package server
+
+import (
+	"expvar"
+	"runtime"
+	"sync/atomic"
+	"time"
+)
+
+// counters are the live figures published by Vars.
+type counters struct {
+	connections      atomic.Int64  // connections being handled
+	connectionsTotal atomic.Uint64 // connections handled since start
+	dropped          atomic.Uint64 // lines dropped for slow clients
+
+	// Goroutines running each part of the server.
+	acceptLoops atomic.Int64
+	connLoops   atomic.Int64 // handshake and read loop, one per connection
+	writeLoops  atomic.Int64
+
+	rate atomic.Uint64 // messages in the last full second
+}
+
+// Vars returns the server's live counters for publishing with expvar,
+// e.g. expvar.Publish("chat", srv.Vars()). They are read when the map is
+// served, so the map can be published once and left alone.
+func (s *ChatServer) Vars() *expvar.Map {
+	goroutines := new(expvar.Map).Init()
+	goroutines.Set("accept", expvar.Func(func() any { return s.counters.acceptLoops.Load() }))
+	goroutines.Set("connection", expvar.Func(func() any { return s.counters.connLoops.Load() }))
+	goroutines.Set("write", expvar.Func(func() any { return s.counters.writeLoops.Load() }))
+	goroutines.Set("total", expvar.Func(func() any { return runtime.NumGoroutine() }))
+
+	m := new(expvar.Map).Init()
+	m.Set("connections", expvar.Func(func() any { return s.counters.connections.Load() }))
+	m.Set("connections_total", expvar.Func(func() any { return s.counters.connectionsTotal.Load() }))
+	m.Set("users", expvar.Func(func() any {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		return len(s.clients)
+	}))
+	m.Set("messages", expvar.Func(func() any { return s.messages.Load() }))
+	m.Set("messages_per_sec", expvar.Func(func() any { return s.counters.rate.Load() }))
+	m.Set("dropped", expvar.Func(func() any { return s.counters.dropped.Load() }))
+	m.Set("join_throttle", expvar.Func(func() any { return s.JoinThrottleStats() }))
+	m.Set("goroutines", goroutines)
+	return m
+}
+
+// sampleRate records how many messages were sent each second until quit
+// is closed.
+func (s *ChatServer) sampleRate(quit <-chan struct{}) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	last := s.messages.Load()
+	for {
+		select {
+		case <-t.C:
+			n := s.messages.Load()
+			s.counters.rate.Store(n - last)
+			last = n
+		case <-quit:
+			return
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package server
+
+import (
+	"expvar"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestVars(t *testing.T) {
+	srv := startServer(t)
+	vars := srv.Vars()
+	get := func(name string) string { return vars.Get(name).String() }
+
+	alice := connectClient(t, srv.Addr().String(), "alice")
+	defer alice.Close()
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+	readLine(t, alice, 2*time.Second) // JOINED|bob
+
+	fmt.Fprintf(alice, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "hi"}))
+	readLine(t, bob, 2*time.Second)
+
+	for name, want := range map[string]string{
+		"connections":       "2",
+		"connections_total": "2",
+		"users":             "2",
+		"messages":          "1",
+		"dropped":           "0",
+	} {
+		if got := get(name); got != want {
+			t.Errorf("%s = %s, want %s", name, got, want)
+		}
+	}
+	goroutines := vars.Get("goroutines").(*expvar.Map)
+	for name, want := range map[string]string{"accept": "1", "connection": "2", "write": "2"} {
+		if got := goroutines.Get(name).String(); got != want {
+			t.Errorf("goroutines.%s = %s, want %s", name, got, want)
+		}
+	}
+
+	alice.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for get("connections") != "1" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := get("connections"); got != "1" {
+		t.Errorf("connections after a disconnect = %s, want 1", got)
+	}
+}
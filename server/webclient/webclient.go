@@ -0,0 +1,24 @@
+// Package webclient embeds a minimal single-page chat client for
+// browsers. It talks to the server's WebSocket endpoint, which it expects
+// at "ws" relative to the page.
+package webclient
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed index.html
+var page []byte
+
+// Handler serves the web client page.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(page)
+	})
+}
@@ -0,0 +1,33 @@
+package webclient
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET / status = %d", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "new WebSocket(") {
+		t.Error("page does not open a WebSocket")
+	}
+	// The server unescapes a leading "//", so the page sends it as typed
+	// and echoes the single "/" everyone else sees.
+	if !strings.Contains(rec.Body.String(), `text.startsWith("//")) { send("SEND|" + text); show("[" + username + "]: " + text.slice(1)); }`) {
+		t.Error("page does not send // escaped messages")
+	}
+
+	rec = httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/other", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /other status = %d, want 404", rec.Code)
+	}
+}
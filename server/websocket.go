@@ -0,0 +1,36 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/coder/websocket"
+)
+
+// WebSocketHandler returns an HTTP handler that speaks the chat protocol
+// over WebSocket, for browsers and clients behind HTTP-only proxies. Each
+// text message carries one or more newline-terminated protocol lines, as
+// the client's ws:// transport sends them. Connections are otherwise
+// treated like TCP ones: they JOIN, are counted, are subject to the
+// AcceptLimit and are closed by Shutdown. The PROXY protocol setting
+// doesn't apply.
+func (s *ChatServer) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.track() {
+			http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		ws, err := websocket.Accept(w, r, nil)
+		if err != nil {
+			// Accept has already written the error response.
+			s.wg.Done()
+			return
+		}
+		conn := websocket.NetConn(r.Context(), ws, websocket.MessageText)
+		if !s.admit(conn.RemoteAddr()) {
+			ws.Close(websocket.StatusTryAgainLater, "too many connections")
+			s.wg.Done()
+			return
+		}
+		s.handleConnection(conn, false, false)
+	})
+}
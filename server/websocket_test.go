@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coder/websocket"
+	"github.com/pankaj/simple-chat/protocol"
+)
+
+func TestWebSocketHandler(t *testing.T) {
+	srv := startServer(t)
+	web := httptest.NewServer(srv.WebSocketHandler())
+	defer web.Close()
+
+	bob := connectClient(t, srv.Addr().String(), "bob")
+	defer bob.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ws, _, err := websocket.Dial(ctx, "ws"+strings.TrimPrefix(web.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial() error = %v", err)
+	}
+	conn := websocket.NetConn(context.Background(), ws, websocket.MessageText)
+	defer conn.Close()
+
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeJoin, Username: "alice"}))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	scanner := bufio.NewScanner(conn)
+	if !scanner.Scan() || scanner.Text() != "OK|format commands|2" {
+		t.Fatalf("expected OK, got %q (%v)", scanner.Text(), scanner.Err())
+	}
+	if line := readLine(t, bob, 2*time.Second); line != "JOINED|alice|2" {
+		t.Errorf("bob got %q, want JOINED|alice|2", line)
+	}
+
+	fmt.Fprintf(conn, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "from a browser"}))
+	if line := readLine(t, bob, 2*time.Second); line != "MSG|alice|from a browser" {
+		t.Errorf("bob got %q", line)
+	}
+
+	fmt.Fprintf(bob, "%s\n", protocol.Encode(protocol.Message{Type: protocol.TypeSend, Body: "hi alice"}))
+	if !scanner.Scan() || scanner.Text() != "MSG|bob|hi alice" {
+		t.Errorf("alice got %q (%v)", scanner.Text(), scanner.Err())
+	}
+}
+
+func TestWebSocketAcceptLimit(t *testing.T) {
+	srv := New(WithAcceptLimit(AcceptLimit{Rate: 0.001, Burst: 1}))
+	web := httptest.NewServer(srv.WebSocketHandler())
+	defer web.Close()
+	url := "ws" + strings.TrimPrefix(web.URL, "http")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	first, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("websocket.Dial() error = %v", err)
+	}
+	defer first.CloseNow()
+
+	second, _, err := websocket.Dial(ctx, url, nil)
+	if err != nil {
+		t.Fatalf("second websocket.Dial() error = %v", err)
+	}
+	defer second.CloseNow()
+	if _, _, err := second.Read(ctx); websocket.CloseStatus(err) != websocket.StatusTryAgainLater {
+		t.Errorf("second connection read error = %v, want closed as over the limit", err)
+	}
+	if got := srv.AcceptStats().Global; got != 1 {
+		t.Errorf("AcceptStats().Global = %d, want 1", got)
+	}
+}